@@ -0,0 +1,145 @@
+package cmd
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+
+    "github.com/spf13/cobra"
+)
+
+// 将 --dump 得到的数据导入 SQLite 以及对导入结果的常用查询
+// 导入时需要使用和 --dump 时相同的 hook 配置 解析过程和 --parse 一致
+
+var dbCmd = &cobra.Command{
+    Use:   "db",
+    Short: "sqlite database of captures",
+}
+
+var dbImportCmd = &cobra.Command{
+    Use:     "import <dump>",
+    Short:   "import --dump capture into sqlite database",
+    Example: "  ./stackplz db import tmp.bin --db capture.db -n com.sfx.ebpf -s openat --stack",
+    Args:    cobra.ExactArgs(1),
+    PersistentPreRunE: func(command *cobra.Command, args []string) error {
+        gconfig.ParseFile = args[0]
+        if gconfig.DbFile == "" {
+            gconfig.DbFile = "stackplz.db"
+        }
+        // 导入的过程在 --parse 的流程中完成 结束后直接退出
+        return persistentPreRunEFunc(command, args)
+    },
+    Run: func(command *cobra.Command, args []string) {},
+}
+
+var queryCmd = &cobra.Command{
+    Use:   "query <db> <callers|opened|between|sql> [args...]",
+    Short: "canned queries on sqlite database",
+    Example: `  ./stackplz query capture.db callers openat 20
+  ./stackplz query capture.db opened 12345
+  ./stackplz query capture.db between 1000000 2000000 12345
+  ./stackplz query capture.db sql "SELECT point, count(*) FROM events GROUP BY point"`,
+    Args: cobra.MinimumNArgs(2),
+    // 查询不需要检查内核配置
+    PersistentPreRunE: func(command *cobra.Command, args []string) error {
+        return nil
+    },
+    RunE: queryFunc,
+}
+
+func sqlQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func parseQueryInt(value string) (uint64, error) {
+    number, err := strconv.ParseUint(value, 0, 64)
+    if err != nil {
+        return 0, fmt.Errorf("bad number:%s", value)
+    }
+    return number, nil
+}
+
+func buildQuery(name string, args []string) (string, error) {
+    switch name {
+    case "callers":
+        // 调用次数最多的调用者 即堆栈的第二帧
+        if len(args) < 1 {
+            return "", fmt.Errorf("usage: callers <point> [limit]")
+        }
+        limit := uint64(20)
+        if len(args) > 1 {
+            number, err := parseQueryInt(args[1])
+            if err != nil {
+                return "", err
+            }
+            limit = number
+        }
+        return fmt.Sprintf(`SELECT count(*) AS hits, CASE WHEN f.symbol != '' THEN f.symbol ELSE f.lib || printf('+0x%%x', f.rel_pc) END AS caller, f.lib AS lib
+FROM events e JOIN frames f ON f.event_id = e.id AND f.idx = 1
+WHERE e.point = %s GROUP BY caller, lib ORDER BY hits DESC LIMIT %d`, sqlQuote(args[0]), limit), nil
+    case "opened":
+        // 进程打开过的全部路径
+        if len(args) < 1 {
+            return "", fmt.Errorf("usage: opened <pid>")
+        }
+        pid, err := parseQueryInt(args[0])
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf(`SELECT CAST(a.data AS TEXT) AS path, count(*) AS times, min(e.ts) AS first_ts
+FROM events e JOIN args a ON a.event_id = e.id
+WHERE e.pid = %d AND e.type = 'sys_enter' AND (e.point LIKE 'open%%' OR e.point = 'creat') AND (a.name LIKE '%%pathname' OR a.name LIKE '%%filename') AND a.data IS NOT NULL
+GROUP BY path ORDER BY first_ts`, pid), nil
+    case "between":
+        // 两个时间点之间的 syscall
+        if len(args) < 2 {
+            return "", fmt.Errorf("usage: between <start_ts> <end_ts> [pid]")
+        }
+        start, err := parseQueryInt(args[0])
+        if err != nil {
+            return "", err
+        }
+        end, err := parseQueryInt(args[1])
+        if err != nil {
+            return "", err
+        }
+        where := fmt.Sprintf("type IN ('sys_enter', 'sys_exit') AND ts BETWEEN %d AND %d", start, end)
+        if len(args) > 2 {
+            pid, err := parseQueryInt(args[2])
+            if err != nil {
+                return "", err
+            }
+            where += fmt.Sprintf(" AND pid = %d", pid)
+        }
+        return fmt.Sprintf("SELECT ts, pid, tid, comm, type, point, ret, text FROM events WHERE %s ORDER BY ts", where), nil
+    case "sql":
+        if len(args) < 1 {
+            return "", fmt.Errorf("usage: sql <statement>")
+        }
+        return strings.Join(args, " "), nil
+    }
+    return "", fmt.Errorf("unknown query:%s, support callers/opened/between/sql", name)
+}
+
+func queryFunc(command *cobra.Command, args []string) error {
+    db_path := args[0]
+    if _, err := os.Stat(db_path); err != nil {
+        return err
+    }
+    query, err := buildQuery(args[1], args[2:])
+    if err != nil {
+        return err
+    }
+    cmd := exec.Command(gconfig.Sqlite3, "-header", "-column", db_path, query)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    return cmd.Run()
+}
+
+func init() {
+    dbCmd.AddCommand(dbImportCmd)
+    rootCmd.AddCommand(dbCmd)
+    rootCmd.AddCommand(queryCmd)
+}
@@ -19,6 +19,7 @@ import (
     "stackplz/user/config"
     "stackplz/user/event"
     "stackplz/user/event_parser"
+    "stackplz/user/event_processor"
     "stackplz/user/module"
     "stackplz/user/rpc"
     "stackplz/user/util"
@@ -174,7 +175,7 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
         os.Exit(0)
     }
 
-    if gconfig.Rpc {
+    if gconfig.Rpc && gconfig.Flight == "" {
         fmt.Printf("rpc mode, listen path:%s\n", gconfig.RpcPath)
         return nil
     }
@@ -191,8 +192,13 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
     mconfig.Parse_Namelist("TNameBlacklist", gconfig.NoTName)
 
     pis := util.Get_PackageInfos()
+    mconfig.PackageInfos = pis
     // 根据 pid 解析进程架构、获取库文件搜索路径
     for _, process_pid := range mconfig.PidWhitelist {
+        if gconfig.ParseFile != "" {
+            // 解析 dump 文件时 pid 仅用于过滤 对应的进程可能已经不存在了
+            break
+        }
         process_uid := pis.FindUidByPid(process_pid)
         is_find, info := pis.FindPackageByUid(process_uid)
         if !is_find {
@@ -213,7 +219,7 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
     }
     // 根据 uid 解析进程架构、获取库文件搜索路径
     for _, pkg_uid := range mconfig.UidWhitelist {
-        if pkg_uid == 0 || pkg_uid == 1000 || pkg_uid == 2000 {
+        if pkg_uid == 0 || pkg_uid == 1000 || pkg_uid == 2000 || gconfig.ParseFile != "" {
             continue
         }
         is_find, info := pis.FindPackageByUid(pkg_uid)
@@ -265,9 +271,24 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
             mconfig.PkgNamelist = append(mconfig.PkgNamelist, pkg_name)
         }
     }
+    if gconfig.Spawn != "" {
+        if gconfig.ParseFile != "" {
+            return errors.New("--spawn can not be used with --parse")
+        }
+        // 新进程还不存在 只能按 uid 过滤
+        mconfig.SpawnConf, err = config.NewSpawnConfig(gconfig.Spawn)
+        if err != nil {
+            return err
+        }
+        mconfig.UidWhitelist = append(mconfig.UidWhitelist, mconfig.SpawnConf.Uid)
+        addLibPath(mconfig.SpawnConf.Package)
+        mconfig.PkgNamelist = append(mconfig.PkgNamelist, mconfig.SpawnConf.Package)
+    }
     // 后面更新map的时候不影响 列表不去重也行
 
-    mconfig.InitCommonConfig(gconfig)
+    if err = mconfig.InitCommonConfig(gconfig); err != nil {
+        return err
+    }
 
     // 1. load config file
     if len(gconfig.ConfigFiles) == 0 {
@@ -280,21 +301,17 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
     }
 
     mconfig.LoadConfig(gconfig)
+    err = mconfig.CheckDumpMem()
+    if err != nil {
+        return err
+    }
 
     // 2. hook uprobe
-    if len(gconfig.HookPoint) > 0 {
-        err = gconfig.Parse_Libinfo(gconfig.Library, mconfig.StackUprobeConf)
+    if gconfig.Spawn == "" {
+        err = parseUprobeHooks(command)
         if err != nil {
             return err
         }
-        err = mconfig.StackUprobeConf.Parse_HookPoint(gconfig.HookPoint)
-        if err != nil {
-            return err
-        }
-        u_syscall := mconfig.StackUprobeConf.GetSyscall(mconfig)
-        if u_syscall != "" {
-            gconfig.SysCall += "," + u_syscall
-        }
     }
 
     // 3. hook syscall
@@ -367,15 +384,73 @@ func persistentPreRunEFunc(command *cobra.Command, args []string) error {
         }
         logger.Printf("set breakpoint at kernel:%t, addr:0x%x", mconfig.BrkKernel, mconfig.BrkAddr)
     }
+    if len(mconfig.ExecHooks) > 0 {
+        // 进程在 exec 之后停下 需要实时解析事件才能挂载并恢复运行
+        if gconfig.DumpFile != "" || gconfig.Flight != "" {
+            return errors.New("config with exec can not be used with --dump or --flight, process only resumes after its exec event is parsed")
+        }
+        enable_hook = true
+        for _, hook := range mconfig.ExecHooks {
+            logger.Printf("hook on exec, %s", hook.String())
+        }
+    }
+    if gconfig.Spawn != "" {
+        // uprobe 在新进程停下之后才解析和挂载 syscall 模块总是运行
+        if mconfig.BrkAddr > 0 {
+            return errors.New("--spawn can not be used with --brk")
+        }
+        if len(gconfig.StartOn) > 0 || len(gconfig.StopOn) > 0 {
+            return errors.New("--spawn can not be used with --start-on/--stop-on")
+        }
+        if len(gconfig.HookPoint) > 0 || gconfig.Preset != "" {
+            enable_hook = true
+        }
+    }
     if !enable_hook {
-        logger.Fatal("hook nothing, plz set -w/--point or -s/--syscall or --brk")
+        logger.Fatal("hook nothing, plz set -w/--point or -s/--syscall or --preset or --brk")
+    }
+    if len(gconfig.StartOn) > 0 || len(gconfig.StopOn) > 0 {
+        // hook 点全部解析完成后才能确定触发点对应的 key
+        mconfig.TriggerConf, err = config.NewTriggerConfig(gconfig.StartOn, gconfig.StopOn, gconfig.TriggerOn)
+        if err != nil {
+            return err
+        }
+        err = mconfig.TriggerConf.Resolve(mconfig)
+        if err != nil {
+            return err
+        }
+        logger.Printf("trigger enabled, %s", mconfig.TriggerConf.String())
+    }
+    if gconfig.Scope != "" {
+        mconfig.ScopeConf, err = config.NewScopeConfig(gconfig.Scope, gconfig)
+        if err != nil {
+            return err
+        }
+    }
+    if err = event.DbOpen(gconfig.DbFile, gconfig.Sqlite3); err != nil {
+        return err
     }
     if gconfig.ParseFile != "" {
+        mconfig.ReplayConf, err = config.NewReplayConfig(gconfig)
+        if err != nil {
+            return err
+        }
         parser := event_parser.NewEventParser()
         parser.SetLogger(logger)
         parser.SetConf(mconfig)
         parser.ParseDump(gconfig.ParseFile)
     }
+    if gconfig.Flight != "" {
+        if mconfig.KillSignal == uint32(syscall.SIGSTOP) || len(mconfig.DumpMem) > 0 {
+            return errors.New("--flight can not be used with --kill SIGSTOP or --dump-mem, process only resumes after its events are parsed")
+        }
+        flight_conf, err := config.NewFlightConfig(gconfig.Flight, gconfig.FlushOn)
+        if err != nil {
+            return err
+        }
+        mconfig.SignalDeliver = len(flight_conf.Signals) > 0
+        event_processor.SetupFlight(flight_conf, logger)
+    }
     mconfig.DumpOpen(gconfig.DumpFile)
     return nil
 }
@@ -384,7 +459,7 @@ func runFunc(command *cobra.Command, args []string) {
     stopper := make(chan os.Signal, 1)
     signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
     ctx, cancelFun := context.WithCancel(context.TODO())
-    if gconfig.Rpc {
+    if gconfig.Rpc && gconfig.Flight == "" {
         rpc.SetupRpc(ctx, Logger, gconfig)
         rpc.StartRpcServer(stopper, gconfig.RpcPath)
         os.Exit(0)
@@ -394,18 +469,39 @@ func runFunc(command *cobra.Command, args []string) {
     var wg sync.WaitGroup
 
     var modNames []string
-    if mconfig.BrkAddr != 0 {
+    if mconfig.SpawnConf != nil {
+        // 先只运行 syscall 模块 新进程停下之后再挂载 uprobe
+        modNames = append(modNames, module.MODULE_NAME_PERF)
+        modNames = append(modNames, module.MODULE_NAME_SYSCALL)
+    } else if mconfig.TriggerConf != nil || mconfig.ScopeConf != nil {
+        // 触发点和受控制的 hook 可能分属不同模块 需要同时运行
+        // --scope 的进入和返回 hook 在 stack 模块中 即使没有 -w 也要运行
+        enable_stack := len(mconfig.StackUprobeConf.Points) > 0 || mconfig.ScopeConf != nil
+        if mconfig.BrkAddr != 0 {
+            modNames = append(modNames, module.MODULE_NAME_BRK)
+        }
+        if mconfig.SysCallConf.Enable || enable_stack {
+            modNames = append(modNames, module.MODULE_NAME_PERF)
+        }
+        if mconfig.SysCallConf.Enable {
+            modNames = append(modNames, module.MODULE_NAME_SYSCALL)
+        }
+        if enable_stack {
+            modNames = append(modNames, module.MODULE_NAME_STACK)
+        }
+    } else if mconfig.BrkAddr != 0 {
         modNames = append(modNames, module.MODULE_NAME_BRK)
     } else if mconfig.SysCallConf.Enable {
         modNames = append(modNames, module.MODULE_NAME_PERF)
         modNames = append(modNames, module.MODULE_NAME_SYSCALL)
-    } else if len(mconfig.StackUprobeConf.Points) > 0 {
+    } else if len(mconfig.StackUprobeConf.Points) > 0 || len(mconfig.ExecHooks) > 0 {
+        // 只有 exec 的 hook 配置时由 stack 模块跟踪 exec
         modNames = append(modNames, module.MODULE_NAME_PERF)
         modNames = append(modNames, module.MODULE_NAME_STACK)
     } else {
         Logger.Fatal("hook nothing, plz set -w/--point or -s/--syscall or --brk")
     }
-    for _, modName := range modNames {
+    startModule := func(modName string) {
         // 现在合并成只有一个模块了 所以直接通过名字获取
         mod := module.GetModuleByName(modName)
 
@@ -421,10 +517,58 @@ func runFunc(command *cobra.Command, args []string) {
         }
         wg.Add(1)
         runMods++
-
+    }
+    var execModules []module.IModule
+    var execLock sync.Mutex
+    mconfig.OnExec = func(hook *config.ExecHookConfig, path string) error {
+        // 每组 hook 单独运行一个 stack 模块 过滤规则沿用命令行的
+        exec_conf, err := mconfig.NewExecModuleConfig(hook, path, gconfig)
+        if err != nil {
+            return err
+        }
+        mod := module.GetModuleByName(module.MODULE_NAME_STACK)
+        mod.Init(ctx, Logger, exec_conf)
+        err = mod.Run()
+        if err != nil {
+            return err
+        }
+        Logger.Printf("exec %s, hook uprobe, count:%d", path, len(exec_conf.StackUprobeConf.Points))
+        execLock.Lock()
+        execModules = append(execModules, mod)
+        execLock.Unlock()
+        return nil
+    }
+    if mconfig.SpawnConf != nil {
+        spawnPrepare(mconfig.SpawnConf)
+    }
+    for _, modName := range modNames {
+        startModule(modName)
+    }
+    if mconfig.SpawnConf != nil {
+        pid, err := spawnApp(mconfig.SpawnConf)
+        if err != nil {
+            Logger.Fatalf("spawn failed, err:%v", err)
+        }
+        Logger.Printf("%s stopped, pid=%d", mconfig.SpawnConf.Package, pid)
+        spawnResolve(pid)
+        err = parseUprobeHooks(command)
+        if err != nil {
+            syscall.Kill(int(pid), syscall.SIGKILL)
+            Logger.Fatalf("parse hook point failed, err:%v", err)
+        }
+        if len(mconfig.StackUprobeConf.Points) > 0 || mconfig.ScopeConf != nil {
+            Logger.Printf("hook uprobe, count:%d", len(mconfig.StackUprobeConf.Points))
+            startModule(module.MODULE_NAME_STACK)
+        }
+        spawnResume(pid)
     }
     if runMods > 0 {
         Logger.Printf("start %d modules", runMods)
+        if gconfig.Rpc {
+            // 飞行记录模式下 rpc 只用于远程触发输出 退出仍由这里处理
+            rpc.SetupRpc(ctx, Logger, gconfig)
+            go rpc.StartRpcServer(make(chan os.Signal, 1), gconfig.RpcPath)
+        }
         go func() {
             scanner := bufio.NewScanner(os.Stdin)
             for {
@@ -437,6 +581,10 @@ func runFunc(command *cobra.Command, args []string) {
                 input_text := scanner.Text()
                 if input_text == "c" {
                     event.LetItRun()
+                } else if input_text == "f" {
+                    if _, err := event_processor.FlightFlush("console"); err != nil {
+                        Logger.Printf("flush failed, err:%v", err)
+                    }
                 }
             }
         }()
@@ -456,12 +604,63 @@ func runFunc(command *cobra.Command, args []string) {
             Logger.Fatalf("%s:module close failed. error:%+v", mod.Name(), err)
         }
     }
+    execLock.Lock()
+    for _, mod := range execModules {
+        err := mod.Close()
+        if err != nil {
+            Logger.Printf("%s:module close failed. error:%+v", mod.Name(), err)
+        }
+    }
+    execLock.Unlock()
     wg.Wait()
     // 关闭打开的dump文件
     mconfig.DumpClose()
+    if err := event.PcapClose(); err != nil {
+        Logger.Printf("save %s failed, err:%v", gconfig.PcapFile, err)
+    }
+    event.TlsClose()
+    event.SummaryClose()
+    if err := event.FlameClose(); err != nil {
+        Logger.Printf("FlameClose failed, err:%v", err)
+    }
+    if err := event.TraceClose(); err != nil {
+        Logger.Printf("save %s failed, err:%v", gconfig.TraceFile, err)
+    }
+    if err := event.DbClose(); err != nil {
+        Logger.Printf("save %s failed, err:%v", gconfig.DbFile, err)
+    }
     os.Exit(0)
 }
 
+func parseUprobeHooks(command *cobra.Command) (err error) {
+    if len(gconfig.HookPoint) > 0 {
+        err = gconfig.Parse_Libinfo(gconfig.Library, mconfig.StackUprobeConf)
+        if err != nil {
+            return err
+        }
+        err = mconfig.StackUprobeConf.Parse_HookPoint(gconfig.HookPoint)
+        if err != nil {
+            return err
+        }
+        u_syscall := mconfig.StackUprobeConf.GetSyscall(mconfig)
+        if u_syscall != "" {
+            if gconfig.Spawn != "" {
+                // syscall 模块已经运行 不能再追加
+                return fmt.Errorf("hook point bind to syscall %s is not supported with --spawn, use -s/--syscall", u_syscall)
+            }
+            gconfig.SysCall += "," + u_syscall
+        }
+    }
+    if gconfig.Preset != "" {
+        // 内置的 hook 预设 显式指定 -l/--lib 时只 hook 该库
+        err = gconfig.Parse_Preset(mconfig.StackUprobeConf, command.Flags().Changed("lib"))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
 func addLibPath(name string) {
     content, err := util.RunCommand("pm", "path", name)
     if err != nil {
@@ -611,6 +810,7 @@ func init() {
     rootCmd.PersistentFlags().BoolVar(&gconfig.Prepare, "prepare", false, "prepare libs")
     // 过滤设定
     rootCmd.PersistentFlags().StringVarP(&gconfig.Name, "name", "n", "", "must set uid or package name")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Spawn, "spawn", "", "start app and trace from its first syscall, hook uprobes before it resumes, e.g. com.example/.MainActivity")
 
     rootCmd.PersistentFlags().StringVarP(&gconfig.Uid, "uid", "u", "", "uid white list")
     rootCmd.PersistentFlags().StringVarP(&gconfig.Pid, "pid", "p", "", "pid white list")
@@ -628,6 +828,13 @@ func init() {
     rootCmd.PersistentFlags().StringVar(&gconfig.TKillSignal, "tkill", "", "send signal to thread when hit uprobe hook, e.g. SIGSTOP/SIGABRT/SIGTRAP/...")
     rootCmd.PersistentFlags().BoolVar(&gconfig.Rpc, "rpc", false, "enable rpc")
     rootCmd.PersistentFlags().StringVar(&gconfig.RpcPath, "rpc-path", "127.0.0.1:41718", "rpc path, default 127.0.0.1:41718")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Flight, "flight", "", "keep only the last N size or time of events in memory, output when triggered, e.g. 32M or 30s")
+    rootCmd.PersistentFlags().StringVar(&gconfig.FlushOn, "flight-trigger", "", "flush --flight records when hit, point names or signal:SIGSEGV or exit, e.g. openat,signal:SIGABRT,exit")
+    // 触发点命中后才开始或停止输出
+    rootCmd.PersistentFlags().StringArrayVar(&gconfig.StartOn, "start-on", []string{}, "start tracing when hit, e.g. point:JNI_OnLoad syscall:openat brk")
+    rootCmd.PersistentFlags().StringArrayVar(&gconfig.StopOn, "stop-on", []string{}, "stop tracing when hit, e.g. point:JNI_OnLoad:ret")
+    rootCmd.PersistentFlags().StringVar(&gconfig.TriggerOn, "trigger-scope", "thread", "scope of --start-on/--stop-on state, thread or process")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Scope, "scope", "", "only trace events while the thread is inside this function, e.g. libfoo.so!decrypt or decrypt+0x10 with -l")
     // 硬件断点设定
     rootCmd.PersistentFlags().StringVar(&gconfig.BrkAddr, "brk", "", "set hardware breakpoint address")
     rootCmd.PersistentFlags().IntVar(&gconfig.BrkPid, "brk-pid", -1, "set hardware breakpoint pid")
@@ -650,16 +857,39 @@ func init() {
     rootCmd.PersistentFlags().StringVarP(&gconfig.LogFile, "out", "o", "stackplz_tmp.log", "save the log to file")
     // 适合收集大量数据 减少数据丢失
     rootCmd.PersistentFlags().StringVar(&gconfig.DumpFile, "dump", "", "save perf data to file")
+    rootCmd.PersistentFlags().Uint32Var(&gconfig.DumpSize, "dump-size", 0, "rotate --dump file into numbered segments every N MB, e.g. tmp.bin.000")
+    rootCmd.PersistentFlags().Uint32Var(&gconfig.DumpPeriod, "dump-period", 0, "rotate --dump file into numbered segments every N seconds")
     rootCmd.PersistentFlags().StringVar(&gconfig.ParseFile, "parse", "", "parse perf data as json or readable format")
+    // 解析 dump 文件时 --pid/--tid/--uid/--tname/--syscall/--filter 等过滤规则在用户态重新生效
+    rootCmd.PersistentFlags().StringVar(&gconfig.PointName, "point-name", "", "only parse these syscall or uprobe point names, use with --parse, e.g. openat,SSL_write")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Since, "since", "", "only parse events after this time, boot time ns or offset to first event, e.g. 1.5s")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Until, "until", "", "only parse events before this time, boot time ns or offset to first event, e.g. 10s")
+    rootCmd.PersistentFlags().StringVar(&gconfig.DumpDir, "dump-dir", "stackplz_dump", "dir to save buffer args and memory dumps")
+    rootCmd.PersistentFlags().BoolVar(&gconfig.DumpBuf, "dump-buf", false, "save all buf/iovec/msghdr/sockaddr args to files")
+    rootCmd.PersistentFlags().StringVar(&gconfig.PcapFile, "pcap", "", "export network syscalls to pcapng file, e.g. -s %net --pcap net.pcapng")
+    // 类似 strace -c 只统计调用次数 错误和耗时 -c 已经被 --config 占用
+    rootCmd.PersistentFlags().BoolVar(&gconfig.Summary, "summary", false, "count calls, errors and latency of syscalls and uprobes instead of logging every event")
+    rootCmd.PersistentFlags().StringVar(&gconfig.SummaryBy, "summary-by", "", "group summary by pid/tid/comm")
+    rootCmd.PersistentFlags().Uint32Var(&gconfig.SummaryTick, "summary-interval", 0, "print summary every N seconds, default only on exit")
+    // 按 hook 点聚合相同的堆栈 用于生成火焰图
+    rootCmd.PersistentFlags().StringVar(&gconfig.FoldedFile, "folded", "", "aggregate backtraces and save as folded stacks, use with --stack, e.g. --folded out.folded")
+    rootCmd.PersistentFlags().StringVar(&gconfig.PprofFile, "pprof", "", "aggregate backtraces and save as pprof profile, use with --stack, e.g. --pprof out.pb.gz")
+    rootCmd.PersistentFlags().StringVar(&gconfig.TraceFile, "trace", "", "export timeline as chrome json trace for ui.perfetto.dev, e.g. --trace trace.json")
+    rootCmd.PersistentFlags().StringVar(&gconfig.DbFile, "db", "", "save events to sqlite database, e.g. --db capture.db")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Sqlite3, "sqlite3", "sqlite3", "path of sqlite3 executable, used by --db and query")
+    rootCmd.PersistentFlags().StringVar(&gconfig.DumpMem, "dump-mem", "", "dump memory when hit, e.g. x0,x1:0x100,0x7fb1234000:0x1000, use with --kill SIGSTOP")
     // 常规ELF库hook设定
     rootCmd.PersistentFlags().StringVarP(&gconfig.Library, "lib", "l", "libc.so", "lib name or lib full path, default is libc.so")
-    rootCmd.PersistentFlags().StringArrayVarP(&gconfig.HookPoint, "point", "w", []string{}, "hook point config, e.g. strstr+0x0[str,str] write[int,buf:128,int]")
+    rootCmd.PersistentFlags().StringArrayVarP(&gconfig.HookPoint, "point", "w", []string{}, "hook point config, e.g. strstr+0x0[str,str] write[int,buf:128,int] SSL_read[ptr,buf:ret]r")
+    rootCmd.PersistentFlags().StringVar(&gconfig.Preset, "preset", "", "builtin hook preset, support: tls,linker,jni, e.g. --preset linker,jni")
     rootCmd.PersistentFlags().StringVar(&gconfig.RegName, "reg", "", "get the offset of reg")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.DumpRet, "dumpret", "", false, "dump ret offset for symbol")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.DumpHex, "dumphex", "", false, "dump buffer as hex")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.ShowPC, "showpc", "", false, "show origin pc register value")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.ShowTime, "showtime", "", false, "show event boot time info")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.ShowUid, "showuid", "", false, "show process uid info")
+    rootCmd.PersistentFlags().BoolVarP(&gconfig.ShowPkg, "showpkg", "", false, "show package name of process uid")
+    rootCmd.PersistentFlags().BoolVarP(&gconfig.Lifecycle, "lifecycle", "", false, "show fork, comm rename, exit code and library maps of traced processes")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.NoCheck, "nocheck", "", false, "disable check for bpf")
     rootCmd.PersistentFlags().BoolVarP(&gconfig.Btf, "btf", "", false, "declare BTF enabled")
     // syscall hook
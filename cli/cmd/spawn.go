@@ -0,0 +1,79 @@
+package cmd
+
+import (
+    "fmt"
+    "stackplz/user/config"
+    "stackplz/user/event"
+    "stackplz/user/module"
+    "stackplz/user/util"
+    "syscall"
+    "time"
+
+    "golang.org/x/exp/slices"
+)
+
+// --spawn 等待新进程停下的最长时间
+const SPAWN_TIMEOUT = 30 * time.Second
+
+func spawnPrepare(conf *config.SpawnConfig) {
+    // 先结束已经运行的进程 保证停下的是新启动的进程
+    _, err := util.RunCommand("am", "force-stop", conf.Package)
+    if err != nil {
+        Logger.Printf("force-stop %s failed, err:%v", conf.Package, err)
+    }
+}
+
+func spawnApp(conf *config.SpawnConfig) (uint32, error) {
+    component, err := conf.Component()
+    if err != nil {
+        return 0, err
+    }
+    // ebpf 程序在新进程切换到 app uid 后的第一个 syscall 处发送 SIGSTOP 并把 pid 记录到 spawn_state
+    Logger.Printf("spawn %s", component)
+    _, err = util.RunCommand("am", "start", "-n", component)
+    if err != nil {
+        return 0, fmt.Errorf("am start %s failed, err:%v", component, err)
+    }
+    deadline := time.Now().Add(SPAWN_TIMEOUT)
+    for time.Now().Before(deadline) {
+        pid, err := module.ReadSpawnState()
+        if err != nil {
+            return 0, fmt.Errorf("read spawn state failed, err:%v", err)
+        }
+        if pid != 0 {
+            return pid, nil
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    return 0, fmt.Errorf("wait for %s stopped timeout", conf.Package)
+}
+
+func spawnResolve(pid uint32) {
+    // 进程停下之后 根据 maps 补充库搜索路径
+    search_paths, err := event.FindLibPaths(pid)
+    if err != nil {
+        Logger.Printf("find lib paths of pid=%d failed, err:%v", pid, err)
+        return
+    }
+    for _, search_path := range search_paths {
+        if !slices.Contains(gconfig.LibraryDirs, search_path) {
+            if gconfig.Debug {
+                Logger.Printf("add lib_search_path => [%s]", search_path)
+            }
+            gconfig.LibraryDirs = append(gconfig.LibraryDirs, search_path)
+        }
+    }
+}
+
+func spawnResume(pid uint32) {
+    err := syscall.Kill(int(pid), syscall.SIGCONT)
+    if err != nil {
+        Logger.Printf("resume pid=%d failed, err:%v", pid, err)
+        return
+    }
+    // 恢复之后清除记录的 pid 避免之后 pid 被复用时误判
+    if err := module.ClearSpawnState(); err != nil {
+        Logger.Printf("clear spawn state failed, err:%v", err)
+    }
+    Logger.Printf("resume pid=%d", pid)
+}
@@ -7,6 +7,9 @@
 #define MAX_PATH_COMPONENTS   48
 #define MAX_LOOP_COUNT 32
 #define MAX_STRCMP_LEN 256
+#define MAX_BINDER_CMD_COUNT 8
+#define MAX_USTRING_SIZE 512
+#define MAX_TREE_DEPTH 24
 
 #if defined(__MODULE_STACK)
     #define MAX_OP_COUNT 64
@@ -35,6 +38,24 @@
 #define PID_BLACKLIST_START PID_WHITELIST_START + 0x400
 #define TID_WHITELIST_START PID_BLACKLIST_START + 0x400
 #define TID_BLACKLIST_START TID_WHITELIST_START + 0x400
+// --start-on/--stop-on 对应的触发点 key 为 syscall 调用号或者 uprobe 索引加上下面的偏移
+#define TRIGGER_START_START TID_BLACKLIST_START + 0x400
+#define TRIGGER_STOP_START TRIGGER_START_START + 0x400
+
+#define TRIGGER_UPROBE_BASE 0x300
+#define TRIGGER_URETPROBE_BASE 0x380
+// 不检查启用状态 比如 sys_exit 由 sys_enter 决定是否输出
+#define TRIGGER_KEY_NONE 0x3ff
+
+#define TRIGGER_NONE 0
+#define TRIGGER_THREAD 1
+#define TRIGGER_PROCESS 2
+
+#define SCOPE_NONE 0
+#define SCOPE_ENABLE 1
+
+#define SPAWN_STOP_SIGNAL 19
+#define EXEC_STOP_SIGNAL 19
 
 #define THREAD_NAME_WHITELIST 1
 #define THREAD_NAME_BLACKLIST 2
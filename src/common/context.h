@@ -29,6 +29,8 @@ init_context(void *ctx, event_context_t *context, struct task_struct *task)
 
     context->ts = bpf_ktime_get_ns();
     context->argnum = 0;
+    context->scope_depth = 0;
+    context->scope_id = 0;
 
     return 0;
 }
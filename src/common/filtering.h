@@ -5,7 +5,7 @@
 #include "maps.h"
 #include "types.h"
 
-static __always_inline u64 should_trace(program_data_t *p)
+static __always_inline u64 match_task_filter(program_data_t *p)
 {
 
     config_entry_t *config = p->config;
@@ -107,4 +107,89 @@ static __always_inline u64 should_trace(program_data_t *p)
     return 0;
 }
 
+static __always_inline u64 is_trigger_point(u32 trigger_key)
+{
+    u32 start_key = TRIGGER_START_START + trigger_key;
+    if (bpf_map_lookup_elem(&common_list, &start_key) != NULL) {
+        return 1;
+    }
+    u32 stop_key = TRIGGER_STOP_START + trigger_key;
+    if (bpf_map_lookup_elem(&common_list, &stop_key) != NULL) {
+        return 1;
+    }
+    return 0;
+}
+
+static __always_inline u64 match_trigger(program_data_t *p, u32 trigger_key)
+{
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter == NULL) {
+        return 0;
+    }
+    if (filter->trigger_mode == TRIGGER_NONE || trigger_key == TRIGGER_KEY_NONE) {
+        return 1;
+    }
+
+    event_context_t *context = &p->event->context;
+    u32 state_key = context->tid;
+    if (filter->trigger_mode == TRIGGER_PROCESS) {
+        state_key = context->pid;
+    }
+
+    // 触发点本身总是输出 这样在结果中可以看到开启和关闭的位置
+    u32 start_key = TRIGGER_START_START + trigger_key;
+    if (bpf_map_lookup_elem(&common_list, &start_key) != NULL) {
+        u32 armed = 1;
+        bpf_map_update_elem(&trigger_state, &state_key, &armed, BPF_ANY);
+        return 1;
+    }
+    u32 stop_key = TRIGGER_STOP_START + trigger_key;
+    if (bpf_map_lookup_elem(&common_list, &stop_key) != NULL) {
+        u32 armed = 0;
+        bpf_map_update_elem(&trigger_state, &state_key, &armed, BPF_ANY);
+        return 1;
+    }
+
+    u32* armed = bpf_map_lookup_elem(&trigger_state, &state_key);
+    if (armed == NULL) {
+        // 只设置了 --stop-on 时 默认是启用的
+        return filter->trigger_init;
+    }
+    return *armed;
+}
+
+static __always_inline u64 match_scope(program_data_t *p)
+{
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter == NULL) {
+        return 0;
+    }
+    if (filter->scope_mode == SCOPE_NONE) {
+        return 1;
+    }
+    event_context_t *context = &p->event->context;
+    u32 host_tid = context->host_tid;
+    scope_state_t* state = bpf_map_lookup_elem(&scope_state, &host_tid);
+    if (state == NULL || state->depth == 0) {
+        return 0;
+    }
+    // 给事件打上所在调用的标记 用户态据此分组输出
+    context->scope_depth = state->depth > 0xff ? 0xff : state->depth;
+    context->scope_id = state->id;
+    return 1;
+}
+
+static __always_inline u64 should_trace(program_data_t *p, u32 trigger_key)
+{
+    if (!match_task_filter(p)) {
+        return 0;
+    }
+    if (!match_scope(p)) {
+        return 0;
+    }
+    return match_trigger(p, trigger_key);
+}
+
 #endif
\ No newline at end of file
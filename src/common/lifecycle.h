@@ -0,0 +1,125 @@
+#ifndef __LIFECYCLE_H__
+#define __LIFECYCLE_H__
+
+#include "vmlinux_510.h"
+#include "maps.h"
+#include "types.h"
+#include "utils.h"
+#include "common/common.h"
+#include "common/consts.h"
+#include "common/context.h"
+#include "common/filtering.h"
+
+static __always_inline bool match_exec_stop(const char *filename)
+{
+    // 按文件名查找 exec 配置 完整路径的匹配由用户态在挂载时确认
+    u32 zero = 0;
+    exec_path_t *buf = bpf_map_lookup_elem(&exec_path_buf, &zero);
+    if (buf == NULL)
+        return false;
+    long len = bpf_probe_read_str(buf->path, sizeof(buf->path), filename);
+    if (len <= 0)
+        return false;
+    u32 offset = 0;
+    for (u32 i = 0; i < sizeof(buf->path); i++) {
+        if (i >= len)
+            break;
+        if (buf->path[i] == '/')
+            offset = i + 1;
+    }
+    if (offset >= sizeof(buf->path))
+        return false;
+    // key 要比较整个结构体 先清空
+    __builtin_memset(buf->name.name, 0, sizeof(buf->name.name));
+    bpf_probe_read_str(buf->name.name, sizeof(buf->name.name), filename + offset);
+    return bpf_map_lookup_elem(&exec_stop_filter, &buf->name) != NULL;
+}
+
+static __always_inline int trace_process_exec(struct bpf_raw_tracepoint_args *ctx)
+{
+    // sched_process_exec 此时已经切换到新的 mm
+    // args[0] task_struct args[1] old_pid args[2] linux_binprm
+    program_data_t p = {};
+    if (!init_program_data(&p, ctx))
+        return 0;
+
+    if (!match_task_filter(&p))
+        return 0;
+
+    struct linux_binprm *bprm = (struct linux_binprm *) ctx->args[2];
+    const char *filename = READ_KERN(bprm->filename);
+    save_str_to_buf(p.event, (void *) filename, 0);
+
+    // argv 是以 \0 分隔的连续字符串
+    struct mm_struct *mm = READ_KERN(bprm->mm);
+    unsigned long arg_start = READ_KERN(mm->arg_start);
+    unsigned long arg_end = READ_KERN(mm->arg_end);
+    u32 arg_size = 0;
+    if (arg_end > arg_start) {
+        arg_size = arg_end - arg_start;
+    }
+    if (arg_size > MAX_BYTES_ARR_SIZE - 1) {
+        arg_size = MAX_BYTES_ARR_SIZE - 1;
+    }
+    save_bytes_to_buf(p.event, (void *) arg_start, arg_size, 1);
+    // 事件没有送达时用户态不知道要恢复 这种情况不能停下
+    if (events_perf_submit(&p, PROCESS_EXEC) != 0) {
+        return 0;
+    }
+
+    // 有针对新程序的 hook 配置时先停下 用户态挂载好 uprobe 之后再恢复运行
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter != NULL && filter->exec_stop && match_exec_stop(filename)) {
+        bpf_send_signal(EXEC_STOP_SIGNAL);
+    }
+    return 0;
+}
+
+static __always_inline int trace_process_exit(struct bpf_raw_tracepoint_args *ctx)
+{
+    // 开启 --lifecycle 时输出线程和进程的退出 以及退出码
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter == NULL || !filter->lifecycle)
+        return 0;
+
+    program_data_t p = {};
+    if (!init_program_data(&p, ctx))
+        return 0;
+
+    if (!match_task_filter(&p))
+        return 0;
+
+    // args[0] task_struct 此时 exit_code 已经设置好了
+    struct task_struct *task = (struct task_struct *) ctx->args[0];
+    int exit_code = READ_KERN(task->exit_code);
+    save_to_submit_buf(p.event, (void *) &exit_code, sizeof(int), 0);
+    events_perf_submit(&p, PROCESS_EXIT);
+    return 0;
+}
+
+static __always_inline int trace_signal_deliver(struct bpf_raw_tracepoint_args *ctx)
+{
+    // --flight-trigger signal:xxx 需要知道进程收到的信号 包括内核产生的 SIGSEGV SIGABRT 等
+    // signal_deliver 在收到信号的线程上下文中 可以直接按当前进程过滤
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter == NULL || !filter->signal_deliver)
+        return 0;
+
+    program_data_t p = {};
+    if (!init_program_data(&p, ctx))
+        return 0;
+
+    if (!match_task_filter(&p))
+        return 0;
+
+    // args[0] sig args[1] kernel_siginfo args[2] k_sigaction
+    int sig = (int) ctx->args[0];
+    save_to_submit_buf(p.event, (void *) &sig, sizeof(int), 0);
+    events_perf_submit(&p, SIGNAL_DELIVER);
+    return 0;
+}
+
+#endif
@@ -38,15 +38,25 @@ BPF_HASH(common_filter, u32, common_filter_t, 1);
 // 这样它们会在不同范围而不会干扰 那么好几个map就可以简化到一个了
 BPF_HASH(common_list, u32, u32, 1024);
 
+// 每个线程或进程是否处于启用状态 由用户态创建 多个模块共用
+BPF_LRU_HASH(trigger_state, u32, u32, 1024);
+// 每个线程在 --scope 函数中的调用深度 同样由用户态创建
+BPF_LRU_HASH(scope_state, u32, scope_state_t, 1024);
+BPF_ARRAY(scope_counter, u32, 1);
+// --spawn 下已经停下的进程 只处理第一个 用户态恢复运行后写入 0xffffffff
+BPF_ARRAY(spawn_state, u32, 1);
+
 BPF_HASH(thread_filter, thread_name_t, u32, 40);
-BPF_HASH(arg_filter, u64, arg_filter_t, 40);
+BPF_HASH(exec_stop_filter, exec_name_t, u32, 32);
+BPF_PERCPU_ARRAY(exec_path_buf, exec_path_t, 1);
+BPF_HASH(arg_filter, u64, arg_filter_t, 80);
 BPF_HASH(str_buf, str_buf_t, u32, 256);
 BPF_ARRAY(str_buf_gen, str_buf_t, 1);
 BPF_LRU_HASH(str_buf_map, u64, str_buf_t, 256);
 BPF_PERCPU_ARRAY(event_data_map, event_data_t, 1);
 BPF_PERCPU_ARRAY(op_ctx_map, op_ctx_t, 2);
 BPF_HASH(op_list, u32, op_config_t, 256);
-BPF_HASH(uprobe_point_args, u32, point_args_t, 6);
+BPF_HASH(uprobe_point_args, u32, point_args_t, 12);
 BPF_HASH(sysenter_point_args, u32, point_args_t, 512);
 BPF_HASH(sysexit_point_args, u32, point_args_t, 512);
 BPF_ARRAY(base_config, config_entry_t, 1);
@@ -6,6 +6,7 @@
 #include "common/filtering.h"
 
 #include "utils.h"
+#include "common/lifecycle.h"
 
 SEC("raw_tracepoint/sched_process_fork")
 int tracepoint__sched__sched_process_fork(struct bpf_raw_tracepoint_args *ctx)
@@ -34,12 +35,37 @@ int tracepoint__sched__sched_process_fork(struct bpf_raw_tracepoint_args *ctx)
     return 0;
 }
 
-static __always_inline u32 probe_stack_warp(struct pt_regs* ctx, u32 point_key) {
+SEC("raw_tracepoint/sched_process_exec")
+int tracepoint__sched__sched_process_exec(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_process_exec(ctx);
+}
+
+SEC("raw_tracepoint/sched_process_exit")
+int tracepoint__sched__sched_process_exit(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_process_exit(ctx);
+}
+
+SEC("raw_tracepoint/signal_deliver")
+int tracepoint__signal__signal_deliver(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_signal_deliver(ctx);
+}
+
+// 需要在函数返回时读取参数的 hook 点 进入时保存的寄存器按 hook 点区分
+#define UPROBE_ARGS_ID(point_key) ((UPROBE_ENTER << 8) | (point_key))
+
+static __always_inline u32 probe_stack_warp(struct pt_regs* ctx, u32 point_key, args_t* saved_regs) {
     program_data_t p = {};
     if (!init_program_data(&p, ctx))
         return 0;
 
-    if (!should_trace(&p))
+    u32 trigger_key = TRIGGER_UPROBE_BASE + point_key;
+    if (saved_regs != NULL) {
+        trigger_key = TRIGGER_URETPROBE_BASE + point_key;
+    }
+    if (!should_trace(&p, trigger_key))
         return 0;
     point_args_t* point_args = bpf_map_lookup_elem(&uprobe_point_args, &point_key);
     if (unlikely(point_args == NULL)) return 0;
@@ -70,9 +96,24 @@ static __always_inline u32 probe_stack_warp(struct pt_regs* ctx, u32 point_key)
     if (unlikely(op_ctx == NULL)) return 0;
     __builtin_memset((void *)op_ctx, 0, sizeof(op_ctx));
 
-    op_ctx->reg_0 = READ_KERN(ctx->regs[0]);
     op_ctx->save_index = 4;
     op_ctx->op_key_index = 0;
+    if (saved_regs != NULL) {
+        // 函数返回时 x0 是返回值 参数寄存器使用进入时保存的值
+        op_ctx->reg_0 = saved_regs->args[0];
+        op_ctx->use_saved = 1;
+        op_ctx->saved_regs[0] = saved_regs->args[0];
+        op_ctx->saved_regs[1] = saved_regs->args[1];
+        op_ctx->saved_regs[2] = saved_regs->args[2];
+        op_ctx->saved_regs[3] = saved_regs->args[3];
+        op_ctx->saved_regs[4] = saved_regs->args[4];
+        op_ctx->saved_regs[5] = saved_regs->args[5];
+        op_ctx->ret_value = READ_KERN(ctx->regs[0]);
+    } else {
+        op_ctx->reg_0 = READ_KERN(ctx->regs[0]);
+        op_ctx->use_saved = 0;
+        op_ctx->ret_value = 0;
+    }
 
     read_args(&p, point_args, op_ctx, ctx);
 
@@ -81,6 +122,15 @@ static __always_inline u32 probe_stack_warp(struct pt_regs* ctx, u32 point_key)
         return 0;
     }
 
+    if (saved_regs != NULL) {
+        // 和 sys_exit 一样 返回值放在最后
+        u64 ret = op_ctx->ret_value;
+        save_to_submit_buf(p.event, (void *) &ret, sizeof(ret), op_ctx->save_index);
+        // 函数进入的时间 用于计算耗时
+        u64 enter_ts = saved_regs->ts;
+        save_to_submit_buf(p.event, (void *) &enter_ts, sizeof(enter_ts), op_ctx->save_index + 1);
+    }
+
     events_perf_submit(&p, UPROBE_ENTER);
     if (filter->signal > 0) {
         bpf_send_signal(filter->signal);
@@ -94,10 +144,43 @@ static __always_inline u32 probe_stack_warp(struct pt_regs* ctx, u32 point_key)
     return 0;
 }
 
+static __always_inline u32 probe_stack_save(struct pt_regs* ctx, u32 point_key) {
+    program_data_t p = {};
+    if (!init_program_data(&p, ctx))
+        return 0;
+
+    if (!match_task_filter(&p) || !match_scope(&p))
+        return 0;
+
+    // 返回时的触发点需要进入时保存的寄存器 所以即使当前未启用也要保存
+    if (!match_trigger(&p, TRIGGER_UPROBE_BASE + point_key) && !is_trigger_point(TRIGGER_URETPROBE_BASE + point_key))
+        return 0;
+
+    args_t saved_regs = {};
+    saved_regs.args[0] = READ_KERN(ctx->regs[0]);
+    saved_regs.args[1] = READ_KERN(ctx->regs[1]);
+    saved_regs.args[2] = READ_KERN(ctx->regs[2]);
+    saved_regs.args[3] = READ_KERN(ctx->regs[3]);
+    saved_regs.args[4] = READ_KERN(ctx->regs[4]);
+    saved_regs.args[5] = READ_KERN(ctx->regs[5]);
+    saved_regs.ts = bpf_ktime_get_ns();
+    save_args(&saved_regs, UPROBE_ARGS_ID(point_key));
+    return 0;
+}
+
+static __always_inline u32 probe_stack_ret_warp(struct pt_regs* ctx, u32 point_key) {
+    args_t saved_regs;
+    if (load_args(&saved_regs, UPROBE_ARGS_ID(point_key)) != 0) {
+        return 0;
+    }
+    del_args(UPROBE_ARGS_ID(point_key));
+    return probe_stack_warp(ctx, point_key, &saved_regs);
+}
+
 SEC("uprobe/stack_0")
 int probe_stack_0(struct pt_regs* ctx) {
     u32 point_key = 0;
-    return probe_stack_warp(ctx, point_key);
+    return probe_stack_warp(ctx, point_key, NULL);
 }
 
 #define PROBE_STACK(name)                          \
@@ -105,7 +188,7 @@ int probe_stack_0(struct pt_regs* ctx) {
     int probe_stack_##name(struct pt_regs* ctx)    \
     {                                              \
         u32 point_key = name;                       \
-        return probe_stack_warp(ctx, point_key);    \
+        return probe_stack_warp(ctx, point_key, NULL);    \
     }
 
 // PROBE_STACK(0);
@@ -114,12 +197,12 @@ PROBE_STACK(2);
 PROBE_STACK(3);
 PROBE_STACK(4);
 PROBE_STACK(5);
-// PROBE_STACK(6);
-// PROBE_STACK(7);
-// PROBE_STACK(8);
-// PROBE_STACK(9);
-// PROBE_STACK(10);
-// PROBE_STACK(11);
+PROBE_STACK(6);
+PROBE_STACK(7);
+PROBE_STACK(8);
+PROBE_STACK(9);
+PROBE_STACK(10);
+PROBE_STACK(11);
 // PROBE_STACK(12);
 // PROBE_STACK(13);
 // PROBE_STACK(14);
@@ -127,4 +210,63 @@ PROBE_STACK(5);
 // PROBE_STACK(16);
 // PROBE_STACK(17);
 // PROBE_STACK(18);
-// PROBE_STACK(19);
\ No newline at end of file
+// PROBE_STACK(19);
+
+// 在函数返回时读取参数 进入时先保存参数寄存器
+#define PROBE_STACK_RET(name)                                \
+    SEC("uprobe/stack_save_##name")                          \
+    int probe_stack_save_##name(struct pt_regs* ctx)         \
+    {                                                        \
+        return probe_stack_save(ctx, name);                  \
+    }                                                        \
+    SEC("uretprobe/stack_ret_##name")                        \
+    int probe_stack_ret_##name(struct pt_regs* ctx)          \
+    {                                                        \
+        return probe_stack_ret_warp(ctx, name);              \
+    }
+
+PROBE_STACK_RET(0);
+PROBE_STACK_RET(1);
+PROBE_STACK_RET(2);
+PROBE_STACK_RET(3);
+PROBE_STACK_RET(4);
+PROBE_STACK_RET(5);
+PROBE_STACK_RET(6);
+PROBE_STACK_RET(7);
+PROBE_STACK_RET(8);
+PROBE_STACK_RET(9);
+PROBE_STACK_RET(10);
+PROBE_STACK_RET(11);
+
+// --scope 指定的函数 进入和返回时更新当前线程的调用深度
+SEC("uprobe/scope_enter")
+int probe_scope_enter(struct pt_regs* ctx) {
+    u32 host_tid = bpf_get_current_pid_tgid();
+    scope_state_t* state = bpf_map_lookup_elem(&scope_state, &host_tid);
+    if (state != NULL && state->depth > 0) {
+        // 递归调用沿用最外层的编号
+        __sync_fetch_and_add(&state->depth, 1);
+        return 0;
+    }
+    u32 zero = 0;
+    u32* counter = bpf_map_lookup_elem(&scope_counter, &zero);
+    if (unlikely(counter == NULL)) return 0;
+    scope_state_t new_state = {};
+    new_state.depth = 1;
+    new_state.id = __sync_fetch_and_add(counter, 1) + 1;
+    bpf_map_update_elem(&scope_state, &host_tid, &new_state, BPF_ANY);
+    return 0;
+}
+
+SEC("uretprobe/scope_exit")
+int probe_scope_exit(struct pt_regs* ctx) {
+    u32 host_tid = bpf_get_current_pid_tgid();
+    scope_state_t* state = bpf_map_lookup_elem(&scope_state, &host_tid);
+    if (state == NULL || state->depth == 0) return 0;
+    if (state->depth == 1) {
+        bpf_map_delete_elem(&scope_state, &host_tid);
+        return 0;
+    }
+    __sync_fetch_and_add(&state->depth, -1);
+    return 0;
+}
@@ -7,6 +7,7 @@
 #include "common/consts.h"
 #include "common/context.h"
 #include "common/filtering.h"
+#include "common/lifecycle.h"
 
 SEC("raw_tracepoint/sched_process_fork")
 int tracepoint__sched__sched_process_fork(struct bpf_raw_tracepoint_args *ctx)
@@ -58,18 +59,56 @@ int tracepoint__sched__sched_process_fork(struct bpf_raw_tracepoint_args *ctx)
     return 0;
 }
 
+SEC("raw_tracepoint/sched_process_exec")
+int tracepoint__sched__sched_process_exec(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_process_exec(ctx);
+}
+
+SEC("raw_tracepoint/sched_process_exit")
+int tracepoint__sched__sched_process_exit(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_process_exit(ctx);
+}
+
+SEC("raw_tracepoint/signal_deliver")
+int tracepoint__signal__signal_deliver(struct bpf_raw_tracepoint_args *ctx)
+{
+    return trace_signal_deliver(ctx);
+}
+
+static __always_inline void spawn_stop(program_data_t *p)
+{
+    // --spawn 启动的 app 进程在切换到 app uid 之后的第一个 syscall 处停下
+    // 这时还没有加载 app 的代码 用户态挂载好 uprobe 之后再恢复运行
+    u32 filter_key = 0;
+    common_filter_t* filter = bpf_map_lookup_elem(&common_filter, &filter_key);
+    if (filter == NULL || filter->spawn_uid == 0) return;
+    if (p->event->context.uid != filter->spawn_uid) return;
+    u32 zero = 0;
+    u32* stopped_pid = bpf_map_lookup_elem(&spawn_state, &zero);
+    if (stopped_pid == NULL || *stopped_pid != 0) return;
+    u32 pid = p->event->context.pid;
+    bpf_map_update_elem(&spawn_state, &zero, &pid, BPF_ANY);
+    bpf_send_signal(SPAWN_STOP_SIGNAL);
+}
+
 SEC("raw_tracepoint/sys_enter")
 int raw_syscalls_sys_enter(struct bpf_raw_tracepoint_args* ctx) {
     program_data_t p = {};
     if (!init_program_data(&p, ctx))
         return 0;
 
-    if (!should_trace(&p))
-        return 0;
+    spawn_stop(&p);
 
     struct pt_regs *regs = (struct pt_regs *)(ctx->args[0]);
     u64 syscallno = READ_KERN(regs->syscallno);
     u32 sysno = (u32)syscallno;
+
+    // 调用号同时也是 --start-on/--stop-on 的触发点
+    if (!should_trace(&p, sysno))
+        return 0;
+
     // 先根据调用号确定有没有对应的参数获取方案 没有直接结束
     point_args_t* point_args = bpf_map_lookup_elem(&sysenter_point_args, &sysno);
     if (unlikely(point_args == NULL)) return 0;
@@ -130,6 +169,8 @@ int raw_syscalls_sys_enter(struct bpf_raw_tracepoint_args* ctx) {
     op_ctx->reg_0 = saved_regs.args[0];
     op_ctx->save_index = 4;
     op_ctx->op_key_index = 0;
+    // 进入时还没有返回值
+    op_ctx->ret_value = 0;
 
     read_args(&p, point_args, op_ctx, regs);
     
@@ -160,7 +201,8 @@ int raw_syscalls_sys_exit(struct bpf_raw_tracepoint_args* ctx) {
     if (!init_program_data(&p, ctx))
         return 0;
 
-    if (!should_trace(&p))
+    // 是否输出由 sys_enter 决定 没有保存参数的直接跳过
+    if (!should_trace(&p, TRIGGER_KEY_NONE))
         return 0;
 
     struct pt_regs *regs = (struct pt_regs *)(ctx->args[0]);
@@ -206,6 +248,8 @@ int raw_syscalls_sys_exit(struct bpf_raw_tracepoint_args* ctx) {
     op_ctx->reg_0 = saved_regs.args[0];
     op_ctx->save_index = 1;
     op_ctx->op_key_index = 0;
+    // 和 uretprobe 一样 参数中可以用 ret 作为读取大小
+    op_ctx->ret_value = READ_KERN(regs->regs[0]);
 
     read_args(&p, point_args, op_ctx, regs);
 
@@ -215,7 +259,7 @@ int raw_syscalls_sys_exit(struct bpf_raw_tracepoint_args* ctx) {
     }
 
     // 读取返回值
-    u64 ret = READ_KERN(regs->regs[0]);
+    u64 ret = op_ctx->ret_value;
     save_to_submit_buf(p.event, (void *) &ret, sizeof(ret), op_ctx->save_index);
 
     events_perf_submit(&p, SYSCALL_EXIT);
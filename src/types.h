@@ -10,17 +10,40 @@ typedef struct common_filter {
     u32 trace_uid_group;
     u32 signal;
     u32 tsignal;
+    u32 trigger_mode;
+    u32 trigger_init;
+    u32 scope_mode;
+    u32 spawn_uid;
+    u32 exec_stop;
+    u32 lifecycle;
+    u32 signal_deliver;
 } common_filter_t;
 
+typedef struct scope_state {
+    u32 depth;
+    u32 id;
+} scope_state_t;
+
 typedef struct args {
     unsigned long args[6];
     u32 flag;
+    u64 ts;
 } args_t;
 
 typedef struct thread_name {
     char name[16];
 } thread_name_t;
 
+// exec 之后需要停下的程序 按文件名匹配
+typedef struct exec_name {
+    char name[64];
+} exec_name_t;
+
+typedef struct exec_path {
+    char path[256];
+    exec_name_t name;
+} exec_path_t;
+
 
 typedef struct str_buf {
     char str_val[256];
@@ -63,7 +86,11 @@ enum event_id_e
 {
     SYSCALL_ENTER = 456,
     SYSCALL_EXIT,
-    UPROBE_ENTER
+    UPROBE_ENTER,
+    // 用户态使用了 459 作为 HW_BREAKPOINT
+    PROCESS_EXEC = 460,
+    PROCESS_EXIT,
+    SIGNAL_DELIVER
 };
 
 enum op_code_e
@@ -97,7 +124,15 @@ enum op_code_e
     OP_FILTER_STRING,
     OP_SAVE_STRING,
     OP_SAVE_PTR_STRING,
-    OP_READ_STD_STRING
+    OP_READ_STD_STRING,
+    OP_SET_READ_LEN_IOC_SIZE,
+    OP_FIND_BINDER_TXN,
+    OP_SAVE_USTRING,
+    OP_SET_READ_LEN_PTR_RANGE,
+    OP_LIMIT_BREAK_COUNT,
+    OP_SAVE_TREE_NODE,
+    OP_SAVE_LIST_NODE,
+    OP_SAVE_BREAK_COUNT
 };
 
 enum arm64_reg_e
@@ -137,7 +172,9 @@ enum arm64_reg_e
     REG_ARM64_PC,
     REG_ARM64_MAX,
     REG_ARM64_INDEX,
-    REG_ARM64_ABS
+    REG_ARM64_ABS,
+    // 函数返回值 只在 uretprobe 中有意义
+    REG_ARM64_RET
 };
 
 enum arg_type_e
@@ -215,6 +252,10 @@ typedef struct op_ctx {
     // 函数执行后会覆盖第一个寄存器
     // 在函数退出时有可能还要用到
     u64 reg_0;
+    // uretprobe 中 x0-x5 已被覆盖 使用函数进入时保存的值
+    u32 use_saved;
+    u64 saved_regs[6];
+    u64 ret_value;
 } op_ctx_t;
 
 typedef struct op_config {
@@ -240,7 +281,10 @@ typedef struct event_context {
     u32 uid;
     char comm[TASK_COMM_LEN];
     u8 argnum;
-    char padding[7];
+    // --scope 下事件所在的调用层级和调用编号 不在范围内为 0
+    u8 scope_depth;
+    char padding[2];
+    u32 scope_id;
 } event_context_t;
 
 typedef struct event_data {
@@ -74,6 +74,49 @@ static __noinline u32 read_args(program_data_t* p, point_args_t* point_args, op_
                     op_ctx->read_len = op_ctx->pointer_value;
                 }
                 break;
+            case OP_SET_READ_LEN_IOC_SIZE: {
+                // 配合 OP_READ_REG 使用 寄存器的值是 ioctl 的 cmd
+                // 按 _IOC_SIZE 调整读取大小 旧式的 cmd 没有编码大小 那么使用 op->value
+                u32 ioc_size = (op_ctx->reg_value >> 16) & 0x3fff;
+                if (ioc_size == 0) {
+                    ioc_size = op->value;
+                }
+                if (op_ctx->read_len > ioc_size) {
+                    op_ctx->read_len = ioc_size;
+                }
+                break;
+            }
+            case OP_FIND_BINDER_TXN: {
+                // 配合 OP_SAVE_STRUCT 使用 在 binder 的读写缓冲区中找到第一个 transaction/reply
+                // 然后将读取地址和大小设置为其 parcel 的数据 大小不超过 op->value
+                u64 cmd_addr = op_ctx->read_addr & 0xffffffffffff;
+                u64 cmd_end = cmd_addr + op_ctx->read_len;
+                op_ctx->read_len = 0;
+                for (int j = 0; j < MAX_BINDER_CMD_COUNT; j++) {
+                    if (cmd_addr + sizeof(u32) > cmd_end) break;
+                    u32 cmd = 0;
+                    if (bpf_probe_read_user(&cmd, sizeof(cmd), (void*)cmd_addr) != 0) break;
+                    cmd_addr += sizeof(u32);
+                    u32 ioc_type = (cmd >> 8) & 0xff;
+                    u32 ioc_nr = cmd & 0xff;
+                    // BC_TRANSACTION BC_REPLY BC_TRANSACTION_SG BC_REPLY_SG BR_TRANSACTION BR_REPLY
+                    if ((ioc_type == 'c' && (ioc_nr == 0 || ioc_nr == 1 || ioc_nr == 17 || ioc_nr == 18)) || (ioc_type == 'r' && (ioc_nr == 2 || ioc_nr == 3))) {
+                        // binder_transaction_data 中 data_size 的偏移是 32 data.ptr.buffer 的偏移是 48
+                        u64 data_size = 0;
+                        u64 data_buffer = 0;
+                        bpf_probe_read_user(&data_size, sizeof(data_size), (void*)(cmd_addr + 32));
+                        bpf_probe_read_user(&data_buffer, sizeof(data_buffer), (void*)(cmd_addr + 48));
+                        if (data_size > op->value) {
+                            data_size = op->value;
+                        }
+                        op_ctx->read_addr = data_buffer;
+                        op_ctx->read_len = data_size;
+                        break;
+                    }
+                    cmd_addr += (cmd >> 16) & 0x3fff;
+                }
+                break;
+            }
             case OP_SET_READ_COUNT:
                 op_ctx->read_len *= op->value;
                 break;
@@ -140,11 +183,23 @@ static __noinline u32 read_args(program_data_t* p, point_args_t* point_args, op_
                 if (op->pre_code == OP_SET_REG_INDEX) {
                     op_ctx->reg_index = op->value;
                 }
+                if (op_ctx->reg_index == REG_ARM64_RET) {
+                    // 返回值按 int 处理 arm64 上 w0 的高 32 位不保证清零
+                    // 负数视为 0 这样作为读取长度时不会读取数据
+                    if ((s32)op_ctx->ret_value < 0) {
+                        op_ctx->reg_value = 0;
+                    } else {
+                        op_ctx->reg_value = (u32)op_ctx->ret_value;
+                    }
+                    break;
+                }
                 // make ebpf verifier happy
                 if (op_ctx->reg_index >= REG_ARM64_MAX) {
                     return 0;
                 }
-                if (op_ctx->reg_index == 0) {
+                if (op_ctx->use_saved && op_ctx->reg_index < 6) {
+                    op_ctx->reg_value = op_ctx->saved_regs[op_ctx->reg_index];
+                } else if (op_ctx->reg_index == 0) {
                     op_ctx->reg_value = op_ctx->reg_0;
                 } else {
                     op_ctx->reg_value = READ_KERN(regs->regs[op_ctx->reg_index]);
@@ -250,21 +305,173 @@ static __noinline u32 read_args(program_data_t* p, point_args_t* point_args, op_
                 op_ctx->save_index += 1;
                 break;
             }
+            case OP_SAVE_USTRING:
+            {
+                // UTF-16 字符串 无法使用 bpf_probe_read_user_str 那么按 read_len 读取 由用户态找到结尾的 \0\0
+                // op->value 为 1 时地址处是 int32 的字符数 后面紧跟字符串内容 比如 Parcel 中的 String16
+                u64 ustr_addr = op_ctx->read_addr & 0xffffffffffff;
+                u32 ustr_len = op_ctx->read_len;
+                if (op->value == 1) {
+                    s32 char_count = 0;
+                    bpf_probe_read_user(&char_count, sizeof(char_count), (void*) ustr_addr);
+                    ustr_addr += sizeof(char_count);
+                    if (char_count < 0) {
+                        char_count = 0;
+                    }
+                    if (ustr_len > (u32)char_count * 2) {
+                        ustr_len = (u32)char_count * 2;
+                    }
+                }
+                if (ustr_len > MAX_USTRING_SIZE) {
+                    ustr_len = MAX_USTRING_SIZE;
+                }
+                // 字符串过滤从这里开始比较
+                op_ctx->read_addr = ustr_addr;
+                int ustr_status = save_bytes_to_buf(p->event, (void*) ustr_addr, ustr_len, op_ctx->save_index);
+                if (ustr_status == 0) {
+                    // 下一页可能不可读 那么只读取到页尾
+                    u32 page_left = 0x1000 - (ustr_addr & 0xfff);
+                    if (page_left < ustr_len) {
+                        ustr_len = page_left;
+                        ustr_status = save_bytes_to_buf(p->event, (void*) ustr_addr, ustr_len, op_ctx->save_index);
+                    }
+                }
+                if (ustr_status == 0) {
+                    save_bytes_to_buf(p->event, 0, 0, op_ctx->save_index);
+                    ustr_len = 0;
+                }
+                op_ctx->str_len = ustr_len;
+                op_ctx->save_index += 1;
+                break;
+            }
             case OP_READ_STD_STRING:
             {
                 // 搭配 OP_SAVE_STRING 使用 这里仅计算实际的字符串地址
+                // op->value 为 2 时是 std::u16string 搭配 OP_SAVE_USTRING 使用 同时按字符数限制读取大小
                 u64 ptr = op_ctx->read_addr & 0xffffffffffff;
                 u8 value;
                 bpf_probe_read_user(&value, sizeof(value), (void*) ptr);
+                u64 char_count = value >> 1;
                 if ((value & 1) == 0) {
-                    ptr += 1;
+                    if (op->value == 2) {
+                        ptr += 2;
+                    } else {
+                        ptr += 1;
+                    }
                 } else {
+                    bpf_probe_read_user(&char_count, sizeof(char_count), (void*)(ptr + 8));
                     ptr += 8 * 2;
                     bpf_probe_read_user(&ptr, sizeof(ptr), (void*) ptr);
                 }
+                if (op->value == 2 && op_ctx->read_len > char_count * 2) {
+                    op_ctx->read_len = char_count * 2;
+                }
                 op_ctx->read_addr = ptr;
                 break;
             }
+            case OP_SET_READ_LEN_PTR_RANGE:
+            {
+                // 地址处是 begin end 两个指针 比如 std::vector
+                // 读取地址设置为 begin 读取大小不超过 end - begin
+                u64 range[2] = {0, 0};
+                bpf_probe_read_user(&range, sizeof(range), (void*)(op_ctx->read_addr & 0xffffffffffff));
+                range[0] = range[0] & 0xffffffffffff;
+                range[1] = range[1] & 0xffffffffffff;
+                u64 range_len = 0;
+                if (range[1] > range[0]) {
+                    range_len = range[1] - range[0];
+                }
+                if (op_ctx->read_len > range_len) {
+                    op_ctx->read_len = range_len;
+                }
+                op_ctx->read_addr = range[0];
+                break;
+            }
+            case OP_LIMIT_BREAK_COUNT:
+                // 循环体较大时 进一步限制循环次数 避免超出 MAX_OP_COUNT
+                if (op_ctx->break_count > op->value) {
+                    op_ctx->break_count = op->value;
+                }
+                break;
+            case OP_SAVE_BREAK_COUNT:
+            {
+                // 记录接下来的循环实际保存的节点数量 即使为 0 也会保存一个节点
+                // 用户态按这个数量解析 而不是按容器头部的 size
+                u32 node_count = op_ctx->break_count;
+                if (node_count == 0) {
+                    node_count = 1;
+                }
+                save_bytes_to_buf(p->event, (void *)&node_count, sizeof(node_count), op_ctx->save_index);
+                op_ctx->save_index += 1;
+                break;
+            }
+            case OP_SAVE_TREE_NODE:
+            {
+                // std::map 的节点 即 __left_ __right_ __parent_ __is_black_ 之后是 pair<const K, V>
+                // op->value 低 32 位是 pair 的大小 高 32 位是 pair 在节点中的偏移
+                // 保存当前节点的 pair 然后移动到中序遍历的下一个节点
+                u64 node = op_ctx->read_addr & 0xffffffffffff;
+                u32 pair_len = op->value & 0xffffffff;
+                if (pair_len > MAX_BYTES_ARR_SIZE) {
+                    pair_len = MAX_BYTES_ARR_SIZE;
+                }
+                int node_status = save_bytes_to_buf(p->event, (void*)(node + (op->value >> 32)), pair_len, op_ctx->save_index);
+                if (node_status == 0) {
+                    save_bytes_to_buf(p->event, 0, 0, op_ctx->save_index);
+                }
+                op_ctx->save_index += 1;
+                u64 next = 0;
+                bpf_probe_read_user(&next, sizeof(next), (void*)(node + 8));
+                next = next & 0xffffffffffff;
+                if (next != 0) {
+                    // 有右子树 后继是右子树最左边的节点
+                    for (int j = 0; j < MAX_TREE_DEPTH; j++) {
+                        u64 left = 0;
+                        bpf_probe_read_user(&left, sizeof(left), (void*) next);
+                        left = left & 0xffffffffffff;
+                        if (left == 0) break;
+                        next = left;
+                    }
+                } else {
+                    // 否则向上找到第一个 以左孩子身份到达的父节点
+                    // 最大的节点会回到 end_node 后续读取到的数据不会被展示
+                    u64 cur = node;
+                    for (int j = 0; j < MAX_TREE_DEPTH; j++) {
+                        u64 parent = 0;
+                        bpf_probe_read_user(&parent, sizeof(parent), (void*)(cur + 16));
+                        parent = parent & 0xffffffffffff;
+                        if (parent == 0) break;
+                        u64 parent_left = 0;
+                        bpf_probe_read_user(&parent_left, sizeof(parent_left), (void*) parent);
+                        if ((parent_left & 0xffffffffffff) == cur) {
+                            next = parent;
+                            break;
+                        }
+                        cur = parent;
+                    }
+                }
+                op_ctx->read_addr = next;
+                break;
+            }
+            case OP_SAVE_LIST_NODE:
+            {
+                // std::unordered_map 的节点 即 __next_ __hash_ 之后是 pair<const K, V>
+                // 保存当前节点的 pair 然后移动到 __next_
+                u64 node = op_ctx->read_addr & 0xffffffffffff;
+                u32 pair_len = op->value;
+                if (pair_len > MAX_BYTES_ARR_SIZE) {
+                    pair_len = MAX_BYTES_ARR_SIZE;
+                }
+                int node_status = save_bytes_to_buf(p->event, (void*)(node + 16), pair_len, op_ctx->save_index);
+                if (node_status == 0) {
+                    save_bytes_to_buf(p->event, 0, 0, op_ctx->save_index);
+                }
+                op_ctx->save_index += 1;
+                u64 next = 0;
+                bpf_probe_read_user(&next, sizeof(next), (void*) node);
+                op_ctx->read_addr = next;
+                break;
+            }
             default:
                 break;
         }
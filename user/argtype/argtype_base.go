@@ -84,6 +84,47 @@ func (this *ARG_INT) Clone() IArgType {
 	return &ARG_INT{*p}
 }
 
+type ARG_FD struct {
+	ARG_INT
+}
+
+func (this *ARG_FD) Clone() IArgType {
+	p, ok := (this.ARG_INT.Clone()).(*ARG_INT)
+	if !ok {
+		panic("...")
+	}
+	return &ARG_FD{*p}
+}
+
+// 解析参数时事件所属进程的信息 由 event 层在解析每个事件时提供
+// 比如将 fd 转换为 23</path/to/file> 这样的形式
+// 以及读取字符串等内存 将地址转换为 0x7c12345678<libxxx.so + 0x1234> 这样的形式
+// 解析 dump 文件时进程已经不存在了 ReadMemory 为 nil
+type ProcessContext struct {
+	Pid        uint32
+	FormatFd   func(fd int32) string
+	ReadMemory func(addr, size uint64) ([]byte, error)
+	FormatAddr func(addr uint64) string
+}
+
+// 需要按所属进程才能完整解析的类型 没有进程信息时退回到 Parse
+type IParseProcess interface {
+	ParseProcess(proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string
+}
+
+func ParseProcess(at IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) (string, bool) {
+	if proc == nil {
+		return "", false
+	}
+	if p, ok := at.(IParseProcess); ok {
+		return p.ParseProcess(proc, ptr, buf, parse_more), true
+	}
+	if cb := at.GetProcessCB(); cb != nil {
+		return cb(at, proc, ptr, buf, parse_more), true
+	}
+	return "", false
+}
+
 type ARG_UINT struct {
 	ARG_NUM
 }
@@ -242,6 +283,21 @@ func (this *ARG_INT) Parse(ptr uint64, buf *bytes.Buffer, parse_more bool) strin
 		return fmt.Sprintf("%d%s", value_fix, flags_fmt)
 	}
 }
+func (this *ARG_FD) ParseJson(ptr uint64, buf *bytes.Buffer, parse_more bool) any {
+	return this.Parse(ptr, buf, parse_more)
+}
+
+func (this *ARG_FD) Parse(ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	return fmt.Sprintf("%d", int32(ptr))
+}
+
+func (this *ARG_FD) ParseProcess(proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if proc.FormatFd == nil {
+		return this.Parse(ptr, buf, parse_more)
+	}
+	return proc.FormatFd(int32(ptr))
+}
+
 func (this *ARG_UINT) ParseJson(ptr uint64, buf *bytes.Buffer, parse_more bool) any {
 	return this.Parse(ptr, buf, parse_more)
 }
@@ -713,6 +769,7 @@ func init() {
 	Register(&ARG_PTR{}, "ptr", TYPE_POINTER, POINTER, uint32(unsafe.Sizeof(uint64(0))))
 	Register(&ARG_INT{}, "int", TYPE_INT, INT, uint32(unsafe.Sizeof(int32(0))))
 	Register(&ARG_UINT{}, "uint", TYPE_UINT, UINT, uint32(unsafe.Sizeof(uint32(0))))
+	Register(&ARG_FD{}, "fd", TYPE_INT, FD, uint32(unsafe.Sizeof(int32(0))))
 	Register(&ARG_INT8{}, "int8", TYPE_INT8, INT8, uint32(unsafe.Sizeof(int8(0))))
 	Register(&ARG_INT16{}, "int16", TYPE_INT16, INT16, uint32(unsafe.Sizeof(int16(0))))
 	Register(&ARG_INT32{}, "int32", TYPE_INT32, INT32, uint32(unsafe.Sizeof(int32(0))))
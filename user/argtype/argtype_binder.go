@@ -0,0 +1,320 @@
+package argtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	. "stackplz/user/common"
+	"stackplz/user/util"
+	"strings"
+	"unicode/utf16"
+)
+
+// binder 读写缓冲区最多读取的大小
+const BINDER_BUF_READ_LEN = 1024
+
+// parcel 最多读取的大小
+const BINDER_PARCEL_READ_LEN = 2048
+
+// 接口描述符的最大长度 超过认为不是描述符
+const MAX_INTERFACE_TOKEN_LEN = 256
+
+// 参考 include/uapi/linux/android/binder.h
+const (
+	BINDER_TYPE_BC uint32 = 'c'
+	BINDER_TYPE_BR uint32 = 'r'
+)
+
+var BinderCommandNames = map[uint32][]string{
+	BINDER_TYPE_BC: {
+		"BC_TRANSACTION",
+		"BC_REPLY",
+		"BC_ACQUIRE_RESULT",
+		"BC_FREE_BUFFER",
+		"BC_INCREFS",
+		"BC_ACQUIRE",
+		"BC_RELEASE",
+		"BC_DECREFS",
+		"BC_INCREFS_DONE",
+		"BC_ACQUIRE_DONE",
+		"BC_ATTEMPT_ACQUIRE",
+		"BC_REGISTER_LOOPER",
+		"BC_ENTER_LOOPER",
+		"BC_EXIT_LOOPER",
+		"BC_REQUEST_DEATH_NOTIFICATION",
+		"BC_CLEAR_DEATH_NOTIFICATION",
+		"BC_DEAD_BINDER_DONE",
+		"BC_TRANSACTION_SG",
+		"BC_REPLY_SG",
+	},
+	BINDER_TYPE_BR: {
+		"BR_ERROR",
+		"BR_OK",
+		"BR_TRANSACTION",
+		"BR_REPLY",
+		"BR_ACQUIRE_RESULT",
+		"BR_DEAD_REPLY",
+		"BR_TRANSACTION_COMPLETE",
+		"BR_INCREFS",
+		"BR_ACQUIRE",
+		"BR_RELEASE",
+		"BR_DECREFS",
+		"BR_ATTEMPT_ACQUIRE",
+		"BR_NOOP",
+		"BR_SPAWN_LOOPER",
+		"BR_FINISHED",
+		"BR_DEAD_BINDER",
+		"BR_CLEAR_DEATH_NOTIFICATION_DONE",
+		"BR_FAILED_REPLY",
+		"BR_FROZEN_REPLY",
+		"BR_ONEWAY_SPAM_SUSPECT",
+		"BR_TRANSACTION_PENDING_FROZEN",
+	},
+}
+
+func GetBinderCommandName(cmd uint32) string {
+	typ := IOC_TYPE(cmd)
+	nr := IOC_NR(cmd)
+	if typ == BINDER_TYPE_BR && nr == 2 && IOC_SIZE(cmd) != uint32(binary.Size(BinderTransactionData{})) {
+		return "BR_TRANSACTION_SEC_CTX"
+	}
+	names, ok := BinderCommandNames[typ]
+	if !ok || nr >= uint32(len(names)) {
+		return fmt.Sprintf("0x%x", cmd)
+	}
+	return names[nr]
+}
+
+func IsBinderTransaction(cmd uint32) bool {
+	// 和 OP_FIND_BINDER_TXN 的判断保持一致
+	switch IOC_TYPE(cmd) {
+	case BINDER_TYPE_BC:
+		nr := IOC_NR(cmd)
+		return nr == 0 || nr == 1 || nr == 17 || nr == 18
+	case BINDER_TYPE_BR:
+		nr := IOC_NR(cmd)
+		return nr == 2 || nr == 3
+	default:
+		return false
+	}
+}
+
+func IsBinderReply(cmd uint32) bool {
+	switch IOC_TYPE(cmd) {
+	case BINDER_TYPE_BC:
+		return IOC_NR(cmd) == 1 || IOC_NR(cmd) == 18
+	case BINDER_TYPE_BR:
+		return IOC_NR(cmd) == 3
+	default:
+		return false
+	}
+}
+
+const (
+	TF_ONE_WAY     uint32 = 0x01
+	TF_ROOT_OBJECT uint32 = 0x04
+	TF_STATUS_CODE uint32 = 0x08
+	TF_ACCEPT_FDS  uint32 = 0x10
+	TF_CLEAR_BUF   uint32 = 0x20
+	TF_UPDATE_TXN  uint32 = 0x40
+)
+
+var BinderTxnFlags []*FlagOp = []*FlagOp{
+	{"ONEWAY", int32(TF_ONE_WAY)},
+	{"ROOT_OBJECT", int32(TF_ROOT_OBJECT)},
+	{"STATUS_CODE", int32(TF_STATUS_CODE)},
+	{"ACCEPT_FDS", int32(TF_ACCEPT_FDS)},
+	{"CLEAR_BUF", int32(TF_CLEAR_BUF)},
+	{"UPDATE_TXN", int32(TF_UPDATE_TXN)},
+}
+
+func FormatBinderTxnFlags(flags uint32) string {
+	if flags == 0 {
+		return "0"
+	}
+	var info []string
+	for _, op := range BinderTxnFlags {
+		if flags&uint32(op.Value) != 0 {
+			info = append(info, op.Name)
+		}
+	}
+	if len(info) == 0 {
+		return fmt.Sprintf("0x%x", flags)
+	}
+	return strings.Join(info, "|")
+}
+
+type BinderTransactionData struct {
+	// 对于 BC_TRANSACTION 是 handle 对于 BR_TRANSACTION 是 ptr
+	Target      uint64
+	Cookie      uint64
+	Code        uint32
+	Flags       uint32
+	SenderPid   int32
+	SenderEuid  uint32
+	DataSize    uint64
+	OffsetsSize uint64
+	Buffer      uint64
+	Offsets     uint64
+}
+
+func (this *BinderTransactionData) Format(cmd uint32, parcel []byte) string {
+	var fields []string
+	if !IsBinderReply(cmd) && this.Flags&TF_STATUS_CODE == 0 {
+		token := ParseInterfaceToken(parcel)
+		if token != "" {
+			fields = append(fields, token[strings.LastIndex(token, ".")+1:])
+		}
+	}
+	fields = append(fields, fmt.Sprintf("code=%d", this.Code))
+	fields = append(fields, fmt.Sprintf("flags=%s", FormatBinderTxnFlags(this.Flags)))
+	if IOC_TYPE(cmd) == BINDER_TYPE_BC {
+		if !IsBinderReply(cmd) {
+			fields = append(fields, fmt.Sprintf("handle=%d", uint32(this.Target)))
+		}
+	} else {
+		if !IsBinderReply(cmd) {
+			fields = append(fields, fmt.Sprintf("ptr=0x%x", this.Target))
+		}
+		fields = append(fields, fmt.Sprintf("sender_pid=%d", this.SenderPid))
+		fields = append(fields, fmt.Sprintf("sender_euid=%d", this.SenderEuid))
+	}
+	fields = append(fields, fmt.Sprintf("data_size=%d", this.DataSize))
+	fields = append(fields, fmt.Sprintf("offsets_size=%d", this.OffsetsSize))
+	return fmt.Sprintf("%s(%s)", GetBinderCommandName(cmd), strings.Join(fields, " "))
+}
+
+func ParseInterfaceToken(parcel []byte) string {
+	// writeInterfaceToken 在 String16 之前写入的内容随版本变化
+	// strict mode policy | work source uid (10+) | 'SYST'/'VNDR' header (11+)
+	// 所以依次尝试 找到合理的 String16 即可
+	for skip := 0; skip <= 3; skip++ {
+		off := skip * 4
+		if off+4 > len(parcel) {
+			break
+		}
+		str_len := int32(binary.LittleEndian.Uint32(parcel[off:]))
+		if str_len <= 0 || str_len > MAX_INTERFACE_TOKEN_LEN {
+			continue
+		}
+		start := off + 4
+		end := start + int(str_len)*2
+		if end+2 > len(parcel) {
+			continue
+		}
+		chars := make([]uint16, str_len)
+		valid := true
+		for i := range chars {
+			c := binary.LittleEndian.Uint16(parcel[start+i*2:])
+			if c < 0x20 || c > 0x7e {
+				valid = false
+				break
+			}
+			chars[i] = c
+		}
+		if !valid || binary.LittleEndian.Uint16(parcel[end:]) != 0 {
+			continue
+		}
+		return string(utf16.Decode(chars))
+	}
+	return ""
+}
+
+func FormatBinderCommands(data, parcel []byte, dump_hex, color bool) string {
+	var items []string
+	parcel_used := false
+	off := 0
+	for off+4 <= len(data) {
+		cmd := binary.LittleEndian.Uint32(data[off:])
+		off += 4
+		size := int(IOC_SIZE(cmd))
+		if off+size > len(data) {
+			// 读取的数据不完整
+			items = append(items, GetBinderCommandName(cmd)+"(...)")
+			break
+		}
+		payload := data[off : off+size]
+		off += size
+		if !IsBinderTransaction(cmd) {
+			items = append(items, GetBinderCommandName(cmd))
+			continue
+		}
+		var txn BinderTransactionData
+		if !ioctl_read(payload, &txn) {
+			items = append(items, GetBinderCommandName(cmd))
+			continue
+		}
+		// 只有第一个 transaction 读取了 parcel
+		var txn_parcel []byte
+		if !parcel_used {
+			txn_parcel = parcel
+			parcel_used = true
+		}
+		item := txn.Format(cmd, txn_parcel)
+		if len(txn_parcel) > 0 {
+			if dump_hex {
+				if color {
+					item += fmt.Sprintf("(\n%s)", util.HexDumpGreen(txn_parcel))
+				} else {
+					item += fmt.Sprintf("(\n%s)", util.HexDumpPure(txn_parcel))
+				}
+			} else {
+				item += fmt.Sprintf("(%s)", util.PrettyByteSlice(txn_parcel))
+			}
+		}
+		items = append(items, item)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+func parse_BINDER_WRITE_READ(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payloads, err := read_struct_payloads(buf, 5)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	bwr_payload, write_buf, write_parcel, read_buf, read_parcel := payloads[0], payloads[1], payloads[2], payloads[3], payloads[4]
+
+	var bwr BinderWriteRead
+	if !ioctl_read(bwr_payload, &bwr) {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	result := fmt.Sprintf("0x%x%s", ptr, decode_BINDER_WRITE_READ(ptr, bwr_payload))
+	// sys_enter 时 write_consumed 为 0 sys_exit 时写缓冲区已经被内核处理
+	// 所以只在前者展示写缓冲区 read_consumed 不为 0 时展示读缓冲区
+	if bwr.WriteConsumed == 0 && len(write_buf) > 0 {
+		result += FormatBinderCommands(write_buf, write_parcel, ctx.GetDumpHex(), ctx.GetColor())
+	}
+	if bwr.ReadConsumed > 0 && len(read_buf) > 0 {
+		result += FormatBinderCommands(read_buf, read_parcel, ctx.GetDumpHex(), ctx.GetColor())
+	}
+	return result
+}
+
+func r_BINDER_WRITE_READ() IArgType {
+	// 先读取 binder_write_read 然后分别读取写缓冲区 读缓冲区
+	// 以及其中第一个 transaction 的 parcel
+	at := RegisterPre("binder_write_read", BINDER_WRITE_READ, STRUCT)
+	at.SetSize(uint32(binary.Size(BinderWriteRead{})))
+	at.AddOp(SaveStruct(uint64(at.GetSize())))
+	at.AddOp(OPC_SET_TMP_VALUE)
+	// write_size write_buffer
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(BINDER_BUF_READ_LEN)))
+	at.AddOp(BuildReadPtrLen(0))
+	at.AddOp(BuildReadPtrAddr(16))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.AddOp(OPC_FIND_BINDER_TXN.NewValue(uint64(BINDER_PARCEL_READ_LEN)))
+	at.AddOp(OPC_SAVE_STRUCT)
+	// read_consumed read_buffer
+	at.AddOp(OPC_MOVE_TMP_VALUE)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(BINDER_BUF_READ_LEN)))
+	at.AddOp(BuildReadPtrLen(32))
+	at.AddOp(BuildReadPtrAddr(40))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.AddOp(OPC_FIND_BINDER_TXN.NewValue(uint64(BINDER_PARCEL_READ_LEN)))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.SetParseCB(parse_BINDER_WRITE_READ)
+	return at
+}
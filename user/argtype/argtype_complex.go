@@ -47,6 +47,18 @@ func parse_STRING(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool)
 	return fmt.Sprintf("0x%x(%s)", ptr, util.B2STrim(payload))
 }
 
+func ReadStringPayload(type_index uint32, buf *bytes.Buffer) ([]byte, error) {
+	// 对应 OP_SAVE_STRING 保存的数据 末尾的 \0 一并去掉 UTF-16 字符串转换为 UTF-8
+	payload, err := read_struct_payload(buf)
+	if err != nil {
+		return nil, err
+	}
+	if IsUtf16Type(type_index) {
+		return []byte(DecodeUtf16(payload)), nil
+	}
+	return bytes.TrimRight(payload, "\x00"), nil
+}
+
 // func r_STRING() IArgType {
 // 	at := RegisterPre("string", STRING, STRUCT)
 // 	at.AddOp(OPC_SAVE_STRING)
@@ -189,6 +201,7 @@ func r_PRE_ARRAY(p IArgType, type_index, array_len uint32) IArgType {
 	// 直接保存对应的数据 即 元素大小 * 元素个数
 	new_p.AddOp(SaveStruct(uint64(new_p.GetSize())))
 	new_p.SetParseCB(parse_ARRAY)
+	new_p.SetPayloadCB(payload_ARRAY)
 	return new_p
 }
 
@@ -203,9 +216,15 @@ func r_ARRAY(p IArgType, array_len uint32) IArgType {
 	new_i.SetArrayArgType(p)
 	new_p.AddOp(SaveStruct(uint64(p.GetSize() * array_len)))
 	new_p.SetParseCB(parse_ARRAY)
+	new_p.SetPayloadCB(payload_ARRAY)
 	return new_p
 }
 
+func payload_ARRAY(ctx IArgType, buf *bytes.Buffer) ([][]byte, error) {
+	// 数组元素的原始数据 比如 pipe2 返回的两个 fd
+	return read_struct_payloads(buf, 1)
+}
+
 func parse_ITTMERSPEC(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
 	if !parse_more {
 		return fmt.Sprintf("0x%x", ptr)
@@ -385,12 +404,17 @@ func r_STATFS() IArgType {
 // 	return fmt.Sprintf("0x%x%s", ptr, arg.Format(payload))
 // }
 
+func payload_BUFFER(ctx IArgType, buf *bytes.Buffer) ([][]byte, error) {
+	return read_struct_payloads(buf, 1)
+}
+
 func init_BUFFER() IArgType {
 	at := GetArgType(BUFFER)
 	// at := RegisterPre("buffer", BUFFER, STRUCT)
 	at.AddOp(SaveStruct(uint64(MAX_BUF_READ_SIZE)))
 	// at.SetParseCB(parse_BUFFER)
 	(at).(IArgStructSetting).SetParseImpl(&Arg_buffer{})
+	at.SetPayloadCB(payload_BUFFER)
 	return at
 }
 
@@ -424,6 +448,20 @@ func R_BUFFER_LEN(length uint32) IArgType {
 	return at
 }
 
+func R_BUFFER_DECODE(type_index uint32, format string, msg *util.PbMessage) IArgType {
+	// 在已有的 buf 类型基础上 按解码链处理读取到的数据 比如 gzip|json protobuf
+	p := GetArgType(type_index)
+	if _, ok := (p).(*ARG_BUFFER); !ok {
+		panic(fmt.Sprintf("decode format only support buf, provided:%s", p.GetName()))
+	}
+	if err := util.CheckDecoderChain(format); err != nil {
+		panic(err)
+	}
+	at := RegisterNew(p.GetName()+"_"+strings.ReplaceAll(format, "|", "_"), type_index)
+	(at).(IArgStructSetting).SetParseImpl(&Arg_buffer{DataFormat: format, PbMessage: msg})
+	return at
+}
+
 func parse_EPOLLEVENT(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
 	if !parse_more {
 		return fmt.Sprintf("0x%x", ptr)
@@ -554,12 +592,18 @@ func parse_SOCKADDR(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool
 	return fmt.Sprintf("0x%x", ptr)
 }
 
+func payload_SOCKADDR(ctx IArgType, buf *bytes.Buffer) ([][]byte, error) {
+	// 原始的 sockaddr 结构体 读取失败的时候为空
+	return read_struct_payloads(buf, 1)
+}
+
 func r_SOCKADDR() IArgType {
 	at := RegisterNew("sockaddr", STRUCT)
 	at.SetSize(uint32(unsafe.Sizeof(syscall.RawSockaddrUnix{})))
 	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(at.GetSize())))
 	at.AddOp(OPC_SAVE_STRUCT)
 	at.SetParseCB(parse_SOCKADDR)
+	at.SetPayloadCB(payload_SOCKADDR)
 	return at
 }
 
@@ -703,6 +747,40 @@ func parse_IOVEC(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) s
 	return fmt.Sprintf("0x%x(%s)", ptr, iov_dump)
 }
 
+func read_iovec_payloads(buf *bytes.Buffer, iovcnt uint64) ([][]byte, error) {
+	var iov_read_count int = MAX_IOV_COUNT
+	if int(iovcnt) < iov_read_count {
+		iov_read_count = int(iovcnt)
+	}
+	var payloads [][]byte
+	for i := 0; i < iov_read_count; i++ {
+		var arg_iovec Arg_Iovec_Fix
+		if err := binary.Read(buf, binary.LittleEndian, &arg_iovec); err != nil {
+			return nil, fmt.Errorf("read iov_%d failed, err:%v", i, err)
+		}
+		var iov_buf Arg_str
+		if err := binary.Read(buf, binary.LittleEndian, &iov_buf); err != nil {
+			return nil, fmt.Errorf("read iov_%d header failed, err:%v", i, err)
+		}
+		payload := make([]byte, iov_buf.Len)
+		if iov_buf.Len > 0 {
+			if err := binary.Read(buf, binary.LittleEndian, &payload); err != nil {
+				return nil, fmt.Errorf("read iov_%d payload len:%d failed, err:%v", i, iov_buf.Len, err)
+			}
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+func payload_IOVEC(ctx IArgType, buf *bytes.Buffer) ([][]byte, error) {
+	var iovcnt Arg_reg
+	if err := binary.Read(buf, binary.LittleEndian, &iovcnt); err != nil {
+		return nil, fmt.Errorf("read iovcnt failed, err:%v", err)
+	}
+	return read_iovec_payloads(buf, iovcnt.Address)
+}
+
 func r_IOVEC() IArgType {
 	t := syscall.Iovec{}
 	at := RegisterPre("iovec", IOVEC, STRUCT)
@@ -716,6 +794,7 @@ func r_IOVEC() IArgType {
 	at.AddOp(OPC_SAVE_STRUCT)
 	// 这里解析单个 不一样 需要修正
 	at.SetParseCB(parse_IOVEC)
+	at.SetPayloadCB(payload_IOVEC)
 	return at
 }
 
@@ -732,6 +811,7 @@ func r_IOVEC_X2() IArgType {
 	at.AddOp(OPC_ADD_OFFSET.NewValue(uint64(unsafe.Sizeof(t))))
 	at.AddOp(OPC_FOR_BREAK)
 	at.SetParseCB(parse_IOVEC)
+	at.SetPayloadCB(payload_IOVEC)
 	return at
 }
 
@@ -749,6 +829,7 @@ func R_IOVEC_REG(reg_name string) IArgType {
 	at.AddOp(OPC_ADD_OFFSET.NewValue(uint64(unsafe.Sizeof(t))))
 	at.AddOp(OPC_FOR_BREAK)
 	at.SetParseCB(parse_IOVEC)
+	at.SetPayloadCB(payload_IOVEC)
 	return at
 }
 
@@ -801,6 +882,18 @@ func parse_MSGHDR(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool)
 	return fmt.Sprintf("0x%x%s", ptr, arg_msghdr.FormatFull(fmt_str, control_buf.Format()))
 }
 
+func payload_MSGHDR(ctx IArgType, buf *bytes.Buffer) ([][]byte, error) {
+	var arg_msghdr Arg_Msghdr
+	if err := binary.Read(buf, binary.LittleEndian, &arg_msghdr); err != nil {
+		return nil, fmt.Errorf("read msghdr failed, err:%v", err)
+	}
+	// control 部分不是通信数据 跳过即可
+	if _, err := read_struct_payload(buf); err != nil {
+		return nil, err
+	}
+	return read_iovec_payloads(buf, arg_msghdr.Iovlen)
+}
+
 func r_MSGHDR() IArgType {
 	t := syscall.Msghdr{}
 	at := RegisterPre("msghdr", MSGHDR, STRUCT)
@@ -827,5 +920,6 @@ func r_MSGHDR() IArgType {
 	at.AddOp(OPC_ADD_OFFSET.NewValue(uint64(at_iovec.GetSize())))
 	at.AddOp(OPC_FOR_BREAK)
 	at.SetParseCB(parse_MSGHDR)
+	at.SetPayloadCB(payload_MSGHDR)
 	return at
 }
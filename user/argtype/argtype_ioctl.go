@@ -0,0 +1,620 @@
+package argtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	. "stackplz/user/common"
+	"stackplz/user/util"
+	"strings"
+	"syscall"
+)
+
+// ioctl cmd 的编码规则 参考 include/uapi/asm-generic/ioctl.h
+const (
+	IOC_NRBITS    uint32 = 8
+	IOC_TYPEBITS  uint32 = 8
+	IOC_SIZEBITS  uint32 = 14
+	IOC_DIRBITS   uint32 = 2
+	IOC_NRSHIFT   uint32 = 0
+	IOC_TYPESHIFT uint32 = IOC_NRSHIFT + IOC_NRBITS
+	IOC_SIZESHIFT uint32 = IOC_TYPESHIFT + IOC_TYPEBITS
+	IOC_DIRSHIFT  uint32 = IOC_SIZESHIFT + IOC_SIZEBITS
+
+	IOC_NONE  uint32 = 0
+	IOC_WRITE uint32 = 1
+	IOC_READ  uint32 = 2
+)
+
+// ioctl 参数最多读取的大小
+const IOCTL_MAX_READ_LEN = 256
+
+// 旧式的 cmd 没有编码参数大小 比如 TCGETS FIONREAD SIOCGIFCONF 这种情况下读取的大小
+const IOCTL_LEGACY_READ_LEN = 64
+
+func IOC(dir, typ, nr, size uint32) uint32 {
+	return dir<<IOC_DIRSHIFT | typ<<IOC_TYPESHIFT | nr<<IOC_NRSHIFT | size<<IOC_SIZESHIFT
+}
+
+func IO(typ, nr uint32) uint32 {
+	return IOC(IOC_NONE, typ, nr, 0)
+}
+
+func IOR(typ, nr, size uint32) uint32 {
+	return IOC(IOC_READ, typ, nr, size)
+}
+
+func IOW(typ, nr, size uint32) uint32 {
+	return IOC(IOC_WRITE, typ, nr, size)
+}
+
+func IOWR(typ, nr, size uint32) uint32 {
+	return IOC(IOC_READ|IOC_WRITE, typ, nr, size)
+}
+
+func IOC_DIR(cmd uint32) uint32 {
+	return (cmd >> IOC_DIRSHIFT) & (1<<IOC_DIRBITS - 1)
+}
+
+func IOC_TYPE(cmd uint32) uint32 {
+	return (cmd >> IOC_TYPESHIFT) & (1<<IOC_TYPEBITS - 1)
+}
+
+func IOC_NR(cmd uint32) uint32 {
+	return (cmd >> IOC_NRSHIFT) & (1<<IOC_NRBITS - 1)
+}
+
+func IOC_SIZE(cmd uint32) uint32 {
+	return (cmd >> IOC_SIZESHIFT) & (1<<IOC_SIZEBITS - 1)
+}
+
+// arg 是 ioctl 第三个参数的值 payload 是以其为地址读取到的数据
+// 读取失败或者参数本身不是指针的时候 payload 为空
+type IoctlDecodeFN func(arg uint64, payload []byte) string
+
+type IoctlCmd struct {
+	Name   string
+	Cmd    uint32
+	Decode IoctlDecodeFN
+}
+
+// 以 _IOC_TYPE 和 _IOC_NR 作为 key
+// 同一个 key 下可能有多个 cmd 比如 binder 和 dma-buf 都使用 'b' 只是方向和大小不同
+var ioctl_cmds = make(map[uint32][]*IoctlCmd)
+
+func ioctl_key(cmd uint32) uint32 {
+	return IOC_TYPE(cmd)<<IOC_NRBITS | IOC_NR(cmd)
+}
+
+func RegisterIoctl(name string, cmd uint32, decode IoctlDecodeFN) *IoctlCmd {
+	key := ioctl_key(cmd)
+	for _, v := range ioctl_cmds[key] {
+		if v.Cmd == cmd {
+			panic(fmt.Sprintf("duplicate register for ioctl name=%s cmd=0x%x", v.Name, v.Cmd))
+		}
+	}
+	ioctl_cmd := &IoctlCmd{name, cmd, decode}
+	ioctl_cmds[key] = append(ioctl_cmds[key], ioctl_cmd)
+	return ioctl_cmd
+}
+
+func GetIoctlCmd(cmd uint32) *IoctlCmd {
+	for _, v := range ioctl_cmds[ioctl_key(cmd)] {
+		if v.Cmd == cmd {
+			return v
+		}
+	}
+	return nil
+}
+
+func FormatIoctlCmd(cmd uint32) string {
+	ioctl_cmd := GetIoctlCmd(cmd)
+	if ioctl_cmd != nil {
+		return ioctl_cmd.Name
+	}
+	// 未知的 cmd 按 _IOC 的方式展示 与 strace 一致
+	var dirs []string
+	if IOC_DIR(cmd)&IOC_READ != 0 {
+		dirs = append(dirs, "_IOC_READ")
+	}
+	if IOC_DIR(cmd)&IOC_WRITE != 0 {
+		dirs = append(dirs, "_IOC_WRITE")
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, "_IOC_NONE")
+	}
+	typ := IOC_TYPE(cmd)
+	typ_str := fmt.Sprintf("0x%x", typ)
+	if typ >= 32 && typ <= 126 {
+		typ_str = fmt.Sprintf("'%c'", typ)
+	}
+	return fmt.Sprintf("_IOC(%s, %s, 0x%x, 0x%x)", strings.Join(dirs, "|"), typ_str, IOC_NR(cmd), IOC_SIZE(cmd))
+}
+
+func FormatIoctlArg(cmd uint32, arg uint64, payload []byte, dump_hex, color bool) string {
+	ioctl_cmd := GetIoctlCmd(cmd)
+	if ioctl_cmd != nil && ioctl_cmd.Decode != nil {
+		return ioctl_cmd.Decode(arg, payload)
+	}
+	if len(payload) == 0 {
+		return ""
+	}
+	if dump_hex {
+		if color {
+			return fmt.Sprintf("(\n%s)", util.HexDumpGreen(payload))
+		}
+		return fmt.Sprintf("(\n%s)", util.HexDumpPure(payload))
+	}
+	return fmt.Sprintf("(%s)", util.PrettyByteSlice(payload))
+}
+
+func parse_IOCTL_CMD(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	// cmd 不需要额外读取数据 直接根据值转换为名字
+	return fmt.Sprintf("0x%x(%s)", ptr, FormatIoctlCmd(uint32(ptr)))
+}
+
+func r_IOCTL_CMD() IArgType {
+	at := RegisterPre("ioctl_cmd", IOCTL_CMD, STRUCT)
+	at.SetParseCB(parse_IOCTL_CMD)
+	return at
+}
+
+func read_struct_payload(buf *bytes.Buffer) ([]byte, error) {
+	// 对应 OP_SAVE_STRUCT 保存的数据 [index][len][payload]
+	// 数据不完整时返回错误 解析回调中只输出地址 多读的部分由 ParsePadding 检查出来
+	var arg Arg_struct
+	if err := binary.Read(buf, binary.LittleEndian, &arg); err != nil {
+		return nil, fmt.Errorf("read struct header failed, err:%v", err)
+	}
+	payload := make([]byte, arg.Len)
+	if arg.Len > 0 {
+		if err := binary.Read(buf, binary.LittleEndian, &payload); err != nil {
+			return nil, fmt.Errorf("read struct payload len:%d failed, err:%v", arg.Len, err)
+		}
+	}
+	return payload, nil
+}
+
+func read_struct_payloads(buf *bytes.Buffer, count int) ([][]byte, error) {
+	// 连续读取多个 OP_SAVE_STRUCT 保存的数据
+	var payloads [][]byte
+	for i := 0; i < count; i++ {
+		payload, err := read_struct_payload(buf)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+func parse_IOCTL_ARG(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	var cmd Arg_reg
+	if err := binary.Read(buf, binary.LittleEndian, &cmd); err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payload, err := read_struct_payload(buf)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	return fmt.Sprintf("0x%x%s", ptr, FormatIoctlArg(uint32(cmd.Address), ptr, payload, ctx.GetDumpHex(), ctx.GetColor()))
+}
+
+func r_IOCTL_ARG() IArgType {
+	// 参数的结构取决于 cmd 所以先把 cmd 也保存下来
+	// 然后按照 cmd 中编码的大小读取参数 在 sys_enter/sys_exit 都可以读取
+	at := RegisterPre("ioctl_arg", IOCTL_ARG, STRUCT)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(IOCTL_MAX_READ_LEN)))
+	at.AddOp(Add_READ_SAVE_REG(uint64(REG_ARM64_X1)))
+	at.AddOp(OPC_SET_READ_LEN_IOC_SIZE.NewValue(uint64(IOCTL_LEGACY_READ_LEN)))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.SetParseCB(parse_IOCTL_ARG)
+	return at
+}
+
+// 各类 ioctl 参数的解析
+
+func ioctl_read(payload []byte, data any) bool {
+	if len(payload) < binary.Size(data) {
+		return false
+	}
+	if err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, data); err != nil {
+		return false
+	}
+	return true
+}
+
+func decode_VALUE(arg uint64, payload []byte) string {
+	// 参数本身就是值 不是指针
+	return ""
+}
+
+func decode_INT(arg uint64, payload []byte) string {
+	var value int32
+	if !ioctl_read(payload, &value) {
+		return ""
+	}
+	return fmt.Sprintf("(%d)", value)
+}
+
+func decode_UINT64(arg uint64, payload []byte) string {
+	var value uint64
+	if !ioctl_read(payload, &value) {
+		return ""
+	}
+	return fmt.Sprintf("(0x%x)", value)
+}
+
+func decode_CHAR_ARRAY(arg uint64, payload []byte) string {
+	if len(payload) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("(%s)", util.B2STrim(payload))
+}
+
+// binder
+
+type BinderWriteRead struct {
+	WriteSize     uint64
+	WriteConsumed uint64
+	WriteBuffer   uint64
+	ReadSize      uint64
+	ReadConsumed  uint64
+	ReadBuffer    uint64
+}
+
+type BinderNodeInfoForRef struct {
+	Handle      uint32
+	StrongCount uint32
+	WeakCount   uint32
+	Reserved    [3]uint32
+}
+
+type BinderFreezeInfo struct {
+	Pid       uint32
+	Enable    uint32
+	TimeoutMs uint32
+}
+
+type BinderFrozenStatusInfo struct {
+	Pid       uint32
+	SyncRecv  uint32
+	AsyncRecv uint32
+}
+
+func decode_BINDER_WRITE_READ(arg uint64, payload []byte) string {
+	var bwr BinderWriteRead
+	if !ioctl_read(payload, &bwr) {
+		return ""
+	}
+	var fields []string
+	fields = append(fields, fmt.Sprintf("write_size=%d", bwr.WriteSize))
+	fields = append(fields, fmt.Sprintf("write_consumed=%d", bwr.WriteConsumed))
+	fields = append(fields, fmt.Sprintf("write_buffer=0x%x", bwr.WriteBuffer))
+	fields = append(fields, fmt.Sprintf("read_size=%d", bwr.ReadSize))
+	fields = append(fields, fmt.Sprintf("read_consumed=%d", bwr.ReadConsumed))
+	fields = append(fields, fmt.Sprintf("read_buffer=0x%x", bwr.ReadBuffer))
+	return fmt.Sprintf("{%s}", strings.Join(fields, ", "))
+}
+
+func decode_BINDER_VERSION(arg uint64, payload []byte) string {
+	var version int32
+	if !ioctl_read(payload, &version) {
+		return ""
+	}
+	return fmt.Sprintf("{protocol_version=%d}", version)
+}
+
+func decode_BINDER_GET_NODE_INFO_FOR_REF(arg uint64, payload []byte) string {
+	var info BinderNodeInfoForRef
+	if !ioctl_read(payload, &info) {
+		return ""
+	}
+	return fmt.Sprintf("{handle=%d, strong_count=%d, weak_count=%d}", info.Handle, info.StrongCount, info.WeakCount)
+}
+
+func decode_BINDER_FREEZE(arg uint64, payload []byte) string {
+	var info BinderFreezeInfo
+	if !ioctl_read(payload, &info) {
+		return ""
+	}
+	return fmt.Sprintf("{pid=%d, enable=%d, timeout_ms=%d}", info.Pid, info.Enable, info.TimeoutMs)
+}
+
+func decode_BINDER_GET_FROZEN_INFO(arg uint64, payload []byte) string {
+	var info BinderFrozenStatusInfo
+	if !ioctl_read(payload, &info) {
+		return ""
+	}
+	return fmt.Sprintf("{pid=%d, sync_recv=%d, async_recv=%d}", info.Pid, info.SyncRecv, info.AsyncRecv)
+}
+
+// ashmem
+
+const ASHMEM_NAME_LEN = 256
+
+type AshmemPin struct {
+	Offset uint32
+	Len    uint32
+}
+
+func decode_ASHMEM_PIN(arg uint64, payload []byte) string {
+	var pin AshmemPin
+	if !ioctl_read(payload, &pin) {
+		return ""
+	}
+	return fmt.Sprintf("{offset=0x%x, len=0x%x}", pin.Offset, pin.Len)
+}
+
+// sync_file
+
+type SyncMergeData struct {
+	Name  [32]byte
+	Fd2   int32
+	Fence int32
+	Flags uint32
+	Pad   uint32
+}
+
+type SyncFileInfo struct {
+	Name          [32]byte
+	Status        int32
+	Flags         uint32
+	NumFences     uint32
+	Pad           uint32
+	SyncFenceInfo uint64
+}
+
+func decode_SYNC_IOC_MERGE(arg uint64, payload []byte) string {
+	var data SyncMergeData
+	if !ioctl_read(payload, &data) {
+		return ""
+	}
+	return fmt.Sprintf("{name=%s, fd2=%d, fence=%d, flags=0x%x}", util.B2STrim(data.Name[:]), data.Fd2, data.Fence, data.Flags)
+}
+
+func decode_SYNC_IOC_FILE_INFO(arg uint64, payload []byte) string {
+	var info SyncFileInfo
+	if !ioctl_read(payload, &info) {
+		return ""
+	}
+	var fields []string
+	fields = append(fields, fmt.Sprintf("name=%s", util.B2STrim(info.Name[:])))
+	fields = append(fields, fmt.Sprintf("status=%d", info.Status))
+	fields = append(fields, fmt.Sprintf("flags=0x%x", info.Flags))
+	fields = append(fields, fmt.Sprintf("num_fences=%d", info.NumFences))
+	fields = append(fields, fmt.Sprintf("sync_fence_info=0x%x", info.SyncFenceInfo))
+	return fmt.Sprintf("{%s}", strings.Join(fields, ", "))
+}
+
+// dma-buf
+
+var DmaBufSyncFlags []*FlagOp = []*FlagOp{
+	{"DMA_BUF_SYNC_READ", int32(1 << 0)},
+	{"DMA_BUF_SYNC_WRITE", int32(2 << 0)},
+	{"DMA_BUF_SYNC_END", int32(1 << 2)},
+}
+
+var DmaBufSyncFlagsConfig = &FlagsConfig{"dma_buf_sync", FORMAT_HEX, DmaBufSyncFlags}
+
+type DmaBufExportSyncFile struct {
+	Flags uint32
+	Fd    int32
+}
+
+func decode_DMA_BUF_IOCTL_SYNC(arg uint64, payload []byte) string {
+	var flags uint64
+	if !ioctl_read(payload, &flags) {
+		return ""
+	}
+	return fmt.Sprintf("{flags=0x%x%s}", flags, DmaBufSyncFlagsConfig.Parse(int32(flags)))
+}
+
+func decode_DMA_BUF_SYNC_FILE(arg uint64, payload []byte) string {
+	var data DmaBufExportSyncFile
+	if !ioctl_read(payload, &data) {
+		return ""
+	}
+	return fmt.Sprintf("{flags=0x%x%s, fd=%d}", data.Flags, DmaBufSyncFlagsConfig.Parse(int32(data.Flags)), data.Fd)
+}
+
+// tty
+
+type Termios struct {
+	Iflag uint32
+	Oflag uint32
+	Cflag uint32
+	Lflag uint32
+	Line  uint8
+	Cc    [19]uint8
+}
+
+type Winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func decode_TERMIOS(arg uint64, payload []byte) string {
+	var t Termios
+	if !ioctl_read(payload, &t) {
+		return ""
+	}
+	return fmt.Sprintf("{c_iflag=0x%x, c_oflag=0x%x, c_cflag=0x%x, c_lflag=0x%x, c_line=%d}", t.Iflag, t.Oflag, t.Cflag, t.Lflag, t.Line)
+}
+
+func decode_WINSIZE(arg uint64, payload []byte) string {
+	var ws Winsize
+	if !ioctl_read(payload, &ws) {
+		return ""
+	}
+	return fmt.Sprintf("{ws_row=%d, ws_col=%d, ws_xpixel=%d, ws_ypixel=%d}", ws.Row, ws.Col, ws.Xpixel, ws.Ypixel)
+}
+
+// socket
+
+const IFNAMSIZ = 16
+
+type Ifreq struct {
+	Name [IFNAMSIZ]byte
+	Data [24]byte
+}
+
+type Ifconf struct {
+	Len int32
+	Pad uint32
+	Buf uint64
+}
+
+func format_ifreq_sockaddr(data []byte) string {
+	family := binary.LittleEndian.Uint16(data[0:2])
+	switch family {
+	case syscall.AF_INET:
+		return fmt.Sprintf("{family=AF_INET, addr=%s}", net.IP(data[4:8]).String())
+	case syscall.AF_UNSPEC:
+		return "{family=AF_UNSPEC}"
+	default:
+		return fmt.Sprintf("{family=%d}", family)
+	}
+}
+
+func decode_IFREQ_ADDR(field string) IoctlDecodeFN {
+	return func(arg uint64, payload []byte) string {
+		var ifr Ifreq
+		if !ioctl_read(payload, &ifr) {
+			return ""
+		}
+		return fmt.Sprintf("{ifr_name=%s, %s=%s}", util.B2STrim(ifr.Name[:]), field, format_ifreq_sockaddr(ifr.Data[:]))
+	}
+}
+
+func decode_IFREQ_INT(field string) IoctlDecodeFN {
+	return func(arg uint64, payload []byte) string {
+		var ifr Ifreq
+		if !ioctl_read(payload, &ifr) {
+			return ""
+		}
+		value := int32(binary.LittleEndian.Uint32(ifr.Data[0:4]))
+		return fmt.Sprintf("{ifr_name=%s, %s=%d}", util.B2STrim(ifr.Name[:]), field, value)
+	}
+}
+
+func decode_IFREQ_FLAGS(arg uint64, payload []byte) string {
+	var ifr Ifreq
+	if !ioctl_read(payload, &ifr) {
+		return ""
+	}
+	flags := binary.LittleEndian.Uint16(ifr.Data[0:2])
+	return fmt.Sprintf("{ifr_name=%s, ifr_flags=0x%x%s}", util.B2STrim(ifr.Name[:]), flags, IfFlagsConfig.Parse(int32(flags)))
+}
+
+func decode_IFREQ_HWADDR(arg uint64, payload []byte) string {
+	var ifr Ifreq
+	if !ioctl_read(payload, &ifr) {
+		return ""
+	}
+	family := binary.LittleEndian.Uint16(ifr.Data[0:2])
+	hwaddr := net.HardwareAddr(ifr.Data[2:8]).String()
+	return fmt.Sprintf("{ifr_name=%s, ifr_hwaddr={family=%d, addr=%s}}", util.B2STrim(ifr.Name[:]), family, hwaddr)
+}
+
+func decode_SIOCGIFCONF(arg uint64, payload []byte) string {
+	var ifc Ifconf
+	if !ioctl_read(payload, &ifc) {
+		return ""
+	}
+	return fmt.Sprintf("{ifc_len=%d, ifc_buf=0x%x}", ifc.Len, ifc.Buf)
+}
+
+var IfFlags []*FlagOp = []*FlagOp{
+	{"IFF_UP", int32(0x1)},
+	{"IFF_BROADCAST", int32(0x2)},
+	{"IFF_DEBUG", int32(0x4)},
+	{"IFF_LOOPBACK", int32(0x8)},
+	{"IFF_POINTOPOINT", int32(0x10)},
+	{"IFF_NOTRAILERS", int32(0x20)},
+	{"IFF_RUNNING", int32(0x40)},
+	{"IFF_NOARP", int32(0x80)},
+	{"IFF_PROMISC", int32(0x100)},
+	{"IFF_ALLMULTI", int32(0x200)},
+	{"IFF_MULTICAST", int32(0x1000)},
+}
+
+var IfFlagsConfig = &FlagsConfig{"if", FORMAT_HEX, IfFlags}
+
+func init() {
+	// binder
+	RegisterIoctl("BINDER_WRITE_READ", IOWR('b', 1, 48), decode_BINDER_WRITE_READ)
+	RegisterIoctl("BINDER_SET_IDLE_TIMEOUT", IOW('b', 3, 8), decode_UINT64)
+	RegisterIoctl("BINDER_SET_MAX_THREADS", IOW('b', 5, 4), decode_INT)
+	RegisterIoctl("BINDER_SET_IDLE_PRIORITY", IOW('b', 6, 4), decode_INT)
+	RegisterIoctl("BINDER_SET_CONTEXT_MGR", IOW('b', 7, 4), decode_INT)
+	RegisterIoctl("BINDER_THREAD_EXIT", IOW('b', 8, 4), decode_INT)
+	RegisterIoctl("BINDER_VERSION", IOWR('b', 9, 4), decode_BINDER_VERSION)
+	RegisterIoctl("BINDER_GET_NODE_INFO_FOR_REF", IOWR('b', 12, 24), decode_BINDER_GET_NODE_INFO_FOR_REF)
+	RegisterIoctl("BINDER_SET_CONTEXT_MGR_EXT", IOW('b', 13, 24), nil)
+	RegisterIoctl("BINDER_FREEZE", IOW('b', 14, 12), decode_BINDER_FREEZE)
+	RegisterIoctl("BINDER_GET_FROZEN_INFO", IOWR('b', 15, 12), decode_BINDER_GET_FROZEN_INFO)
+	RegisterIoctl("BINDER_ENABLE_ONEWAY_SPAM_DETECTION", IOW('b', 16, 4), decode_INT)
+	// ashmem
+	RegisterIoctl("ASHMEM_SET_NAME", IOW(0x77, 1, ASHMEM_NAME_LEN), decode_CHAR_ARRAY)
+	RegisterIoctl("ASHMEM_GET_NAME", IOR(0x77, 2, ASHMEM_NAME_LEN), decode_CHAR_ARRAY)
+	RegisterIoctl("ASHMEM_SET_SIZE", IOW(0x77, 3, 8), decode_VALUE)
+	RegisterIoctl("ASHMEM_GET_SIZE", IO(0x77, 4), decode_VALUE)
+	RegisterIoctl("ASHMEM_SET_PROT_MASK", IOW(0x77, 5, 8), decode_VALUE)
+	RegisterIoctl("ASHMEM_GET_PROT_MASK", IO(0x77, 6), decode_VALUE)
+	RegisterIoctl("ASHMEM_PIN", IOW(0x77, 7, 8), decode_ASHMEM_PIN)
+	RegisterIoctl("ASHMEM_UNPIN", IOW(0x77, 8, 8), decode_ASHMEM_PIN)
+	RegisterIoctl("ASHMEM_GET_PIN_STATUS", IO(0x77, 9), decode_VALUE)
+	RegisterIoctl("ASHMEM_PURGE_ALL_CACHES", IO(0x77, 10), decode_VALUE)
+	RegisterIoctl("ASHMEM_GET_FILE_ID", IOR(0x77, 11, 8), decode_UINT64)
+	// sync_file
+	RegisterIoctl("SYNC_IOC_MERGE", IOWR('>', 3, 48), decode_SYNC_IOC_MERGE)
+	RegisterIoctl("SYNC_IOC_FILE_INFO", IOWR('>', 4, 56), decode_SYNC_IOC_FILE_INFO)
+	RegisterIoctl("SYNC_IOC_SET_DEADLINE", IOW('>', 5, 16), nil)
+	// dma-buf
+	RegisterIoctl("DMA_BUF_IOCTL_SYNC", IOW('b', 0, 8), decode_DMA_BUF_IOCTL_SYNC)
+	RegisterIoctl("DMA_BUF_SET_NAME_A", IOW('b', 1, 4), decode_VALUE)
+	RegisterIoctl("DMA_BUF_SET_NAME_B", IOW('b', 1, 8), decode_VALUE)
+	RegisterIoctl("DMA_BUF_IOCTL_EXPORT_SYNC_FILE", IOWR('b', 2, 8), decode_DMA_BUF_SYNC_FILE)
+	RegisterIoctl("DMA_BUF_IOCTL_IMPORT_SYNC_FILE", IOW('b', 3, 8), decode_DMA_BUF_SYNC_FILE)
+	// tty
+	RegisterIoctl("TCGETS", 0x5401, decode_TERMIOS)
+	RegisterIoctl("TCSETS", 0x5402, decode_TERMIOS)
+	RegisterIoctl("TCSETSW", 0x5403, decode_TERMIOS)
+	RegisterIoctl("TCSETSF", 0x5404, decode_TERMIOS)
+	RegisterIoctl("TIOCSCTTY", 0x540E, decode_VALUE)
+	RegisterIoctl("TIOCGPGRP", 0x540F, decode_INT)
+	RegisterIoctl("TIOCSPGRP", 0x5410, decode_INT)
+	RegisterIoctl("TIOCOUTQ", 0x5411, decode_INT)
+	RegisterIoctl("TIOCGWINSZ", 0x5413, decode_WINSIZE)
+	RegisterIoctl("TIOCSWINSZ", 0x5414, decode_WINSIZE)
+	RegisterIoctl("FIONREAD", 0x541B, decode_INT)
+	RegisterIoctl("FIONBIO", 0x5421, decode_INT)
+	RegisterIoctl("TIOCNOTTY", 0x5422, decode_VALUE)
+	RegisterIoctl("TIOCGPTN", IOR('T', 0x30, 4), decode_INT)
+	RegisterIoctl("TIOCSPTLCK", IOW('T', 0x31, 4), decode_INT)
+	RegisterIoctl("FIONCLEX", 0x5450, decode_VALUE)
+	RegisterIoctl("FIOCLEX", 0x5451, decode_VALUE)
+	RegisterIoctl("FIOASYNC", 0x5452, decode_INT)
+	// socket
+	RegisterIoctl("SIOCATMARK", 0x8905, decode_INT)
+	RegisterIoctl("SIOCGIFNAME", 0x8910, decode_IFREQ_INT("ifr_ifindex"))
+	RegisterIoctl("SIOCGIFCONF", 0x8912, decode_SIOCGIFCONF)
+	RegisterIoctl("SIOCGIFFLAGS", 0x8913, decode_IFREQ_FLAGS)
+	RegisterIoctl("SIOCSIFFLAGS", 0x8914, decode_IFREQ_FLAGS)
+	RegisterIoctl("SIOCGIFADDR", 0x8915, decode_IFREQ_ADDR("ifr_addr"))
+	RegisterIoctl("SIOCSIFADDR", 0x8916, decode_IFREQ_ADDR("ifr_addr"))
+	RegisterIoctl("SIOCGIFDSTADDR", 0x8917, decode_IFREQ_ADDR("ifr_dstaddr"))
+	RegisterIoctl("SIOCGIFBRDADDR", 0x8919, decode_IFREQ_ADDR("ifr_broadaddr"))
+	RegisterIoctl("SIOCGIFNETMASK", 0x891b, decode_IFREQ_ADDR("ifr_netmask"))
+	RegisterIoctl("SIOCGIFMTU", 0x8921, decode_IFREQ_INT("ifr_mtu"))
+	RegisterIoctl("SIOCSIFMTU", 0x8922, decode_IFREQ_INT("ifr_mtu"))
+	RegisterIoctl("SIOCGIFHWADDR", 0x8927, decode_IFREQ_HWADDR)
+	RegisterIoctl("SIOCGIFINDEX", 0x8933, decode_IFREQ_INT("ifr_ifindex"))
+}
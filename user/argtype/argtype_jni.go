@@ -0,0 +1,108 @@
+package argtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	. "stackplz/user/common"
+	"strings"
+)
+
+// JNINativeMethod 即 { const char* name; const char* signature; void* fnPtr; }
+const JNI_NATIVE_METHOD_SIZE = 24
+
+// 单次最多读取的 JNINativeMethod 数量 超过的部分不展示
+const MAX_JNI_METHOD_COUNT = 64
+
+// 读取 C 字符串时的上限
+const MAX_JNI_NAME_LEN = 256
+
+func read_c_string(proc *ProcessContext, addr uint64) (string, bool) {
+	// name/signature 字符串需要从事件所属进程的内存中读取
+	if proc == nil || proc.ReadMemory == nil || addr == 0 {
+		return "", false
+	}
+	// 跨页读取可能因为下一页不可读而失败 先读到页尾
+	size := uint64(MAX_JNI_NAME_LEN)
+	if page_left := 0x1000 - addr&0xfff; page_left < size {
+		size = page_left
+	}
+	for {
+		data, err := proc.ReadMemory(addr, size)
+		if err != nil {
+			return "", false
+		}
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return string(data[:i]), true
+		}
+		if size >= MAX_JNI_NAME_LEN {
+			return string(data), true
+		}
+		size = MAX_JNI_NAME_LEN
+	}
+}
+
+func format_addr(proc *ProcessContext, addr uint64) string {
+	if proc == nil || proc.FormatAddr == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	return proc.FormatAddr(addr)
+}
+
+func FormatJniMethods(proc *ProcessContext, payload []byte) []string {
+	var results []string
+	for i := 0; i+JNI_NATIVE_METHOD_SIZE <= len(payload); i += JNI_NATIVE_METHOD_SIZE {
+		name_ptr := binary.LittleEndian.Uint64(payload[i:])
+		sig_ptr := binary.LittleEndian.Uint64(payload[i+8:])
+		fn_ptr := binary.LittleEndian.Uint64(payload[i+16:])
+		name, ok := read_c_string(proc, name_ptr)
+		if !ok {
+			name = fmt.Sprintf("0x%x", name_ptr)
+		}
+		sig, ok := read_c_string(proc, sig_ptr)
+		if !ok {
+			sig = fmt.Sprintf("0x%x", sig_ptr)
+		}
+		results = append(results, fmt.Sprintf("%s%s => %s", name, sig, format_addr(proc, fn_ptr)))
+	}
+	return results
+}
+
+func parse_JNI_METHODS(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	return parse_JNI_METHODS_PROCESS(ctx, nil, ptr, buf, parse_more)
+}
+
+func parse_JNI_METHODS_PROCESS(ctx IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payload, err := read_struct_payload(buf)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	if len(payload) == 0 {
+		return fmt.Sprintf("0x%x[]", ptr)
+	}
+	results := FormatJniMethods(proc, payload)
+	return fmt.Sprintf("0x%x[%s]", ptr, "\n\t"+strings.Join(results, ",\n\t")+"\n")
+}
+
+func init_JNI_METHODS(at IArgType, reg_index uint32) IArgType {
+	// 按 nMethods 所在寄存器的值读取 JNINativeMethod 数组
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_JNI_METHOD_COUNT)))
+	at.AddOp(BuildReadRegLen(uint64(reg_index)))
+	at.AddOp(OPC_SET_READ_COUNT.NewValue(uint64(JNI_NATIVE_METHOD_SIZE)))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.SetParseCB(parse_JNI_METHODS)
+	at.SetProcessCB(parse_JNI_METHODS_PROCESS)
+	return at
+}
+
+func R_JNI_METHODS_REG(reg_index uint32) IArgType {
+	return init_JNI_METHODS(RegisterNew(fmt.Sprintf("jni_methods_reg_%d", reg_index), STRUCT), reg_index)
+}
+
+func r_JNI_METHODS() IArgType {
+	// RegisterNatives(JNIEnv* env, jclass clazz, const JNINativeMethod* methods, jint nMethods)
+	return init_JNI_METHODS(RegisterPre("jni_methods", JNI_NATIVE_METHODS, STRUCT), REG_ARM64_X3)
+}
@@ -0,0 +1,403 @@
+package argtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "stackplz/user/common"
+	"stackplz/user/util"
+	"strings"
+)
+
+// 以下布局均按 arm64 上 libc++ 的默认 ABI 即 std::__ndk1
+
+// std::vector 最多读取的元素数据大小
+const MAX_STL_READ_SIZE = 1024
+
+// std::map/std::unordered_map 最多遍历的节点数量
+// 每个节点需要两个 op 受 MAX_OP_COUNT 的限制 生成 hook 点配置时会按剩余的 op 数量进一步缩小
+const MAX_STL_NODE_COUNT = 16
+
+// std::string_view 最多读取的字符数
+const MAX_STRING_VIEW_SIZE = 512
+
+// 容器元素的类型 仅支持定长的基础类型以及 std::string
+type StlElem struct {
+	Name  string
+	Size  uint32
+	Align uint32
+	Hex   bool
+}
+
+func GetStlElem(name string) (*StlElem, error) {
+	elem := &StlElem{Name: name}
+	if name != "ptr" && name != "std" && name != "str" && strings.HasSuffix(name, "x") {
+		elem.Hex = true
+		elem.Name = name[:len(name)-1]
+	}
+	switch elem.Name {
+	case "int8", "uint8":
+		elem.Size = 1
+	case "int16", "uint16":
+		elem.Size = 2
+	case "int", "uint", "int32", "uint32":
+		elem.Size = 4
+	case "int64", "uint64", "ptr", "str":
+		elem.Size = 8
+	case "std":
+		elem.Size = 24
+		elem.Align = 8
+		return elem, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported stl element type:%s", name))
+	}
+	elem.Align = elem.Size
+	return elem, nil
+}
+
+func align_up(value, align uint32) uint32 {
+	return (value + align - 1) / align * align
+}
+
+func read_std_string(proc *ProcessContext, data []byte) string {
+	// 短字符串直接在对象内 长字符串需要再从进程内存中读取
+	if data[0]&1 == 0 {
+		size := int(data[0] >> 1)
+		if size > len(data)-1 {
+			size = len(data) - 1
+		}
+		return string(data[1 : 1+size])
+	}
+	size := binary.LittleEndian.Uint64(data[8:])
+	addr := binary.LittleEndian.Uint64(data[16:]) & 0xffffffffffff
+	if size > MAX_JNI_NAME_LEN {
+		size = MAX_JNI_NAME_LEN
+	}
+	if proc == nil || proc.ReadMemory == nil || addr == 0 {
+		return fmt.Sprintf("<0x%x>", addr)
+	}
+	content, err := proc.ReadMemory(addr, size)
+	if err != nil {
+		return fmt.Sprintf("<0x%x>", addr)
+	}
+	return string(content)
+}
+
+func (this *StlElem) Format(proc *ProcessContext, data []byte) string {
+	if uint32(len(data)) < this.Size {
+		return "?"
+	}
+	var value uint64
+	switch this.Size {
+	case 1:
+		value = uint64(data[0])
+	case 2:
+		value = uint64(binary.LittleEndian.Uint16(data))
+	case 4:
+		value = uint64(binary.LittleEndian.Uint32(data))
+	case 8:
+		value = binary.LittleEndian.Uint64(data)
+	}
+	switch this.Name {
+	case "std":
+		return fmt.Sprintf("%q", read_std_string(proc, data))
+	case "str":
+		if text, ok := read_c_string(proc, value&0xffffffffffff); ok {
+			return fmt.Sprintf("%q", text)
+		}
+		return fmt.Sprintf("0x%x", value)
+	case "ptr":
+		return fmt.Sprintf("0x%x", value)
+	}
+	if this.Hex {
+		return fmt.Sprintf("0x%x", value)
+	}
+	switch this.Name {
+	case "int8":
+		return fmt.Sprintf("%d", int8(value))
+	case "int16":
+		return fmt.Sprintf("%d", int16(value))
+	case "int", "int32":
+		return fmt.Sprintf("%d", int32(value))
+	case "int64":
+		return fmt.Sprintf("%d", int64(value))
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+type stl_pair struct {
+	key       *StlElem
+	value     *StlElem
+	value_off uint32
+	size      uint32
+	max_align uint32
+}
+
+func new_stl_pair(key, value *StlElem) *stl_pair {
+	// pair<const K, V> 的布局
+	p := &stl_pair{key: key, value: value}
+	p.max_align = key.Align
+	if value.Align > p.max_align {
+		p.max_align = value.Align
+	}
+	p.value_off = align_up(key.Size, value.Align)
+	p.size = align_up(p.value_off+value.Size, p.max_align)
+	return p
+}
+
+func (this *stl_pair) Format(proc *ProcessContext, data []byte) string {
+	if uint32(len(data)) < this.size {
+		return "?"
+	}
+	return this.key.Format(proc, data) + ": " + this.value.Format(proc, data[this.value_off:])
+}
+
+func read_u64_at(payload []byte, offset int) uint64 {
+	if len(payload) < offset+8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(payload[offset:])
+}
+
+func parse_STD_VECTOR(elem *StlElem, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payloads, err := read_struct_payloads(buf, 2)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	header, payload := payloads[0], payloads[1]
+	begin := read_u64_at(header, 0) & 0xffffffffffff
+	end := read_u64_at(header, 8) & 0xffffffffffff
+	var count uint64
+	if end > begin {
+		count = (end - begin) / uint64(elem.Size)
+	}
+	if elem.Size == 1 && !elem.Hex {
+		return fmt.Sprintf("0x%x(size=%d)[%s]", ptr, count, util.PrettyByteSlice(payload))
+	}
+	var results []string
+	for i := 0; i+int(elem.Size) <= len(payload); i += int(elem.Size) {
+		results = append(results, elem.Format(proc, payload[i:]))
+	}
+	if uint64(len(results)) < count {
+		results = append(results, "...")
+	}
+	return fmt.Sprintf("0x%x(size=%d)[%s]", ptr, count, strings.Join(results, ", "))
+}
+
+func parse_STD_MAP(pair *stl_pair, proc *ProcessContext, size_off int, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payloads, err := read_struct_payloads(buf, 2)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	header, count_payload := payloads[0], payloads[1]
+	size := read_u64_at(header, size_off)
+	// ebpf 记录了实际保存的节点数量 至少会保存一个节点
+	var count uint64
+	if len(count_payload) >= 4 {
+		count = uint64(binary.LittleEndian.Uint32(count_payload))
+	}
+	var results []string
+	for i := uint64(0); i < count; i++ {
+		payload, err := read_struct_payload(buf)
+		if err != nil {
+			return fmt.Sprintf("0x%x", ptr)
+		}
+		if i < size {
+			results = append(results, pair.Format(proc, payload))
+		}
+	}
+	if size > count {
+		results = append(results, "...")
+	}
+	return fmt.Sprintf("0x%x(size=%d){%s}", ptr, size, strings.Join(results, ", "))
+}
+
+func R_STD_VECTOR(elem *StlElem) IArgType {
+	// std::vector<T> 即 __begin_ __end_ __end_cap_
+	// 先保存这三个指针 再保存 begin 处的元素数据
+	at := RegisterNew(fmt.Sprintf("vector_%s", elem.Name), STRUCT)
+	at.AddOp(SaveStruct(24))
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_STL_READ_SIZE / elem.Size * elem.Size)))
+	at.AddOp(OPC_SET_READ_LEN_PTR_RANGE)
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.SetParseCB(func(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_VECTOR(elem, nil, ptr, buf, parse_more)
+	})
+	at.SetProcessCB(func(ctx IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_VECTOR(elem, proc, ptr, buf, parse_more)
+	})
+	return at
+}
+
+func R_STD_MAP(key, value *StlElem) IArgType {
+	// std::map<K, V> 即 __begin_node_ __end_node_ __size_ 从 __begin_node_ 开始按中序遍历节点
+	at := RegisterNew(fmt.Sprintf("map_%s_%s", key.Name, value.Name), STRUCT)
+	pair := new_stl_pair(key, value)
+	// 节点是 __left_ __right_ __parent_ __is_black_ 然后是按 pair 对齐的 __value_
+	pair_off := align_up(25, pair.max_align)
+	at.AddOp(SaveStruct(24))
+	at.AddOp(OPM.AddOp(BuildReadPtrBreakCount(16)))
+	at.AddOp(OPC_LIMIT_BREAK_COUNT.NewValue(uint64(MAX_STL_NODE_COUNT)))
+	at.AddOp(OPC_SAVE_BREAK_COUNT)
+	at.AddOp(OPM.AddOp(BuildReadPtrAddr(0)))
+	at.AddOp(OPC_FOR_BREAK)
+	at.AddOp(OPC_SAVE_TREE_NODE.NewValue(uint64(pair_off)<<32 | uint64(pair.size)))
+	at.AddOp(OPC_FOR_BREAK)
+	at.SetParseCB(func(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_MAP(pair, nil, 16, ptr, buf, parse_more)
+	})
+	at.SetProcessCB(func(ctx IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_MAP(pair, proc, 16, ptr, buf, parse_more)
+	})
+	return at
+}
+
+func R_STD_UNORDERED_MAP(key, value *StlElem) IArgType {
+	// std::unordered_map<K, V> 即 __bucket_list_ __bucket_count_ __first_node_ __size_
+	// 从 __first_node_ 开始沿 __next_ 遍历节点
+	at := RegisterNew(fmt.Sprintf("unordered_map_%s_%s", key.Name, value.Name), STRUCT)
+	pair := new_stl_pair(key, value)
+	at.AddOp(SaveStruct(32))
+	at.AddOp(OPM.AddOp(BuildReadPtrBreakCount(24)))
+	at.AddOp(OPC_LIMIT_BREAK_COUNT.NewValue(uint64(MAX_STL_NODE_COUNT)))
+	at.AddOp(OPC_SAVE_BREAK_COUNT)
+	at.AddOp(OPM.AddOp(BuildReadPtrAddr(16)))
+	at.AddOp(OPC_FOR_BREAK)
+	at.AddOp(OPC_SAVE_LIST_NODE.NewValue(uint64(pair.size)))
+	at.AddOp(OPC_FOR_BREAK)
+	at.SetParseCB(func(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_MAP(pair, nil, 24, ptr, buf, parse_more)
+	})
+	at.SetProcessCB(func(ctx IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_MAP(pair, proc, 24, ptr, buf, parse_more)
+	})
+	return at
+}
+
+func StlElemCount(kind string) int {
+	// 容器需要指定的元素类型数量
+	switch kind {
+	case "vector", "shared_ptr":
+		return 1
+	case "map", "umap":
+		return 2
+	}
+	return 0
+}
+
+func R_STL_CONTAINER(kind string, elem_names []string) (IArgType, error) {
+	// vector:int map:std:int umap:std:ptr shared_ptr:int
+	if len(elem_names) != StlElemCount(kind) {
+		return nil, errors.New(fmt.Sprintf("parse %s element types:%s failed", kind, strings.Join(elem_names, ":")))
+	}
+	var elems []*StlElem
+	for _, elem_name := range elem_names {
+		elem, err := GetStlElem(elem_name)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	switch kind {
+	case "vector":
+		return R_STD_VECTOR(elems[0]), nil
+	case "map":
+		return R_STD_MAP(elems[0], elems[1]), nil
+	case "umap":
+		return R_STD_UNORDERED_MAP(elems[0], elems[1]), nil
+	}
+	return R_STD_SHARED_PTR(elems[0]), nil
+}
+
+func r_STD_U16STRING() IArgType {
+	// std::u16string 与 std::string 布局相同 但短字符串内容从偏移 2 开始
+	at := RegisterPre("u16string", STD_U16STRING, STRUCT)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_USTRING_SIZE)))
+	at.AddOp(OPC_READ_STD_STRING.NewValue(2))
+	at.AddOp(OPC_SAVE_USTRING)
+	at.SetParseCB(parse_USTRING)
+	return at
+}
+
+func parse_STD_STRING_VIEW(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payload, err := read_struct_payload(buf)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	return fmt.Sprintf("0x%x(%s)", ptr, util.PrettyByteSlice(payload))
+}
+
+func r_STD_STRING_VIEW() IArgType {
+	// std::string_view 即 __data_ __size_ 内容不一定以 \0 结尾
+	at := RegisterPre("string_view", STD_STRING_VIEW, STRUCT)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_STRING_VIEW_SIZE)))
+	at.AddOp(OPM.AddOp(BuildReadPtrLen(8)))
+	at.AddOp(OPM.AddOp(BuildReadPtrAddr(0)))
+	at.AddOp(OPC_SAVE_STRUCT)
+	at.SetParseCB(parse_STD_STRING_VIEW)
+	return at
+}
+
+func parse_STD_SHARED_PTR(elem *StlElem, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	count := 2
+	if elem != nil {
+		count = 3
+	}
+	payloads, err := read_struct_payloads(buf, count)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	var fields []string
+	fields = append(fields, fmt.Sprintf("ptr=0x%x", read_u64_at(payloads[0], 0)))
+	if elem != nil {
+		fields = append(fields, fmt.Sprintf("value=%s", elem.Format(proc, payloads[1])))
+	}
+	// __cntrl_ 即 vptr __shared_owners_ __shared_weak_owners_ 计数都是从 0 开始
+	cntrl := payloads[count-1]
+	if len(cntrl) >= 24 {
+		fields = append(fields, fmt.Sprintf("use_count=%d", int64(read_u64_at(cntrl, 8))+1))
+		fields = append(fields, fmt.Sprintf("weak_count=%d", int64(read_u64_at(cntrl, 16))))
+	}
+	return fmt.Sprintf("0x%x(%s)", ptr, strings.Join(fields, ", "))
+}
+
+func init_STD_SHARED_PTR(at IArgType, elem *StlElem) IArgType {
+	// std::shared_ptr<T> 即 __ptr_ __cntrl_ 指定了 T 的时候额外读取 __ptr_ 处的值
+	at.AddOp(OPC_SET_TMP_VALUE)
+	at.AddOp(SaveStruct(16))
+	if elem != nil {
+		at.AddOp(OPM.AddOp(BuildReadPtrAddr(0)))
+		at.AddOp(SaveStruct(uint64(elem.Size)))
+		at.AddOp(OPC_MOVE_TMP_VALUE)
+	}
+	at.AddOp(OPM.AddOp(BuildReadPtrAddr(8)))
+	at.AddOp(SaveStruct(24))
+	at.SetParseCB(func(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_SHARED_PTR(elem, nil, ptr, buf, parse_more)
+	})
+	at.SetProcessCB(func(ctx IArgType, proc *ProcessContext, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+		return parse_STD_SHARED_PTR(elem, proc, ptr, buf, parse_more)
+	})
+	return at
+}
+
+func R_STD_SHARED_PTR(elem *StlElem) IArgType {
+	return init_STD_SHARED_PTR(RegisterNew(fmt.Sprintf("shared_ptr_%s", elem.Name), STRUCT), elem)
+}
+
+func r_STD_SHARED_PTR() IArgType {
+	return init_STD_SHARED_PTR(RegisterPre("shared_ptr", STD_SHARED_PTR, STRUCT), nil)
+}
@@ -0,0 +1,77 @@
+package argtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	. "stackplz/user/common"
+	"unicode/utf16"
+)
+
+// 与 ebpf 中的 MAX_USTRING_SIZE 一致 即最多读取 256 个 UTF-16 字符
+const MAX_USTRING_SIZE = 512
+
+func IsUtf16Type(type_index uint32) bool {
+	return type_index == USTRING || type_index == USTRING_LEN || type_index == STRING16 || type_index == STD_U16STRING
+}
+
+func DecodeUtf16(payload []byte) string {
+	// UTF-16LE 解码为 UTF-8 遇到 \0\0 结束 落单的代理项会被替换为 U+FFFD
+	units := make([]uint16, 0, len(payload)/2)
+	for i := 0; i+2 <= len(payload); i += 2 {
+		unit := binary.LittleEndian.Uint16(payload[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
+
+func EncodeUtf16(text string) []byte {
+	var buf bytes.Buffer
+	for _, unit := range utf16.Encode([]rune(text)) {
+		binary.Write(&buf, binary.LittleEndian, unit)
+	}
+	return buf.Bytes()
+}
+
+func parse_USTRING(ctx IArgType, ptr uint64, buf *bytes.Buffer, parse_more bool) string {
+	if !parse_more {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	payload, err := read_struct_payload(buf)
+	if err != nil {
+		return fmt.Sprintf("0x%x", ptr)
+	}
+	return fmt.Sprintf("0x%x(%s)", ptr, DecodeUtf16(payload))
+}
+
+func r_USTRING() IArgType {
+	// 以 \0\0 结尾的 UTF-16 字符串 比如 jchar* 或者 String16 中的 mString
+	at := RegisterPre("ustr", USTRING, STRUCT)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_USTRING_SIZE)))
+	at.AddOp(OPC_SAVE_USTRING)
+	at.SetParseCB(parse_USTRING)
+	return at
+}
+
+func r_USTRING_LEN() IArgType {
+	// 前面有 int32 字符数的 UTF-16 字符串 比如 Parcel 中序列化的 String16
+	at := RegisterPre("ustr_len", USTRING_LEN, STRUCT)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_USTRING_SIZE)))
+	at.AddOp(OPC_SAVE_USTRING.NewValue(1))
+	at.SetParseCB(parse_USTRING)
+	return at
+}
+
+func r_STRING16() IArgType {
+	// android::String16 对象 其首个成员即 const char16_t* mString
+	at := RegisterPre("String16", STRING16, STRUCT)
+	at.AddOp(OPC_READ_POINTER)
+	at.AddOp(OPC_MOVE_POINTER_VALUE)
+	at.AddOp(OPC_SET_READ_LEN.NewValue(uint64(MAX_USTRING_SIZE)))
+	at.AddOp(OPC_SAVE_USTRING)
+	at.SetParseCB(parse_USTRING)
+	return at
+}
@@ -80,11 +80,33 @@ type IArgBuffer interface {
 type Arg_buffer struct {
 	Arg_struct
 	ArgPayload []byte
+	// 解码链 比如 gzip|json 其中 protobuf 有 schema 时输出字段名
+	DataFormat string
+	PbMessage  *util.PbMessage
 }
 
 func (this *Arg_buffer) Clone() IParseStruct {
-	// 这里返回一个空的
-	return &Arg_buffer{}
+	// 这里返回一个空的 但是保留解析的设置
+	return &Arg_buffer{DataFormat: this.DataFormat, PbMessage: this.PbMessage}
+}
+
+func (this *Arg_buffer) Decode() (string, string, bool) {
+	if this.DataFormat == "" {
+		return "", "", false
+	}
+	return util.DecodeBuffer(this.ArgPayload, this.DataFormat, this.PbMessage)
+}
+
+func (this *Arg_buffer) DecodeFormat() (string, bool) {
+	// 解码失败时按原样输出
+	result, steps, ok := this.Decode()
+	if !ok {
+		return "", false
+	}
+	if strings.Contains(result, "\n") {
+		return fmt.Sprintf("<%s>(\n%s)", steps, result), true
+	}
+	return fmt.Sprintf("<%s>(%s)", steps, result), true
 }
 
 func (this *Arg_buffer) GetStruct() any {
@@ -96,11 +118,17 @@ func (this *Arg_buffer) SetArgPayload(payload []byte) {
 }
 
 func (this *Arg_buffer) Format() string {
+	if result, ok := this.DecodeFormat(); ok {
+		return result
+	}
 	hexdump := util.PrettyByteSlice(this.ArgPayload)
 	return fmt.Sprintf("(%s)", hexdump)
 }
 
 func (this *Arg_buffer) HexFormat(color bool) string {
+	if result, ok := this.DecodeFormat(); ok {
+		return result
+	}
 	var hexdump string
 	if color {
 		hexdump = util.HexDumpGreen(this.ArgPayload)
@@ -112,12 +140,17 @@ func (this *Arg_buffer) HexFormat(color bool) string {
 
 func (this *Arg_buffer) MarshalJSON() ([]byte, error) {
 	type ArgStructAlias Arg_struct
+	decoded, decoders, _ := this.Decode()
 	return json.Marshal(&struct {
 		*ArgStructAlias
-		Buffer string `json:"buffer"`
+		Buffer   string `json:"buffer"`
+		Decoders string `json:"decoders,omitempty"`
+		Decoded  string `json:"decoded,omitempty"`
 	}{
 		ArgStructAlias: (*ArgStructAlias)(&this.Arg_struct),
 		Buffer:         util.PrettyByteSlice(this.ArgPayload),
+		Decoders:       decoders,
+		Decoded:        decoded,
 	})
 }
 
@@ -32,10 +32,21 @@ type IArgType interface {
 	GetSize() uint32
 	AddOpList(p IArgType)
 	GetOpList() []uint32
+	SetPayloadCB(PayloadFN)
+	SetProcessCB(ProcessFN)
+	GetProcessCB() ProcessFN
+	HasPayload() bool
+	ReadPayloads(*bytes.Buffer) ([][]byte, error)
 }
 
 type ParseFN func(IArgType, uint64, *bytes.Buffer, bool) string
 
+// 从读取结果中取出 buffer 类数据的原始内容 用于保存到文件
+type PayloadFN func(IArgType, *bytes.Buffer) ([][]byte, error)
+
+// 需要读取进程内存才能完整解析的类型 比如 JNINativeMethod 中的字符串
+type ProcessFN func(IArgType, *ProcessContext, uint64, *bytes.Buffer, bool) string
+
 type ArgType struct {
 	// 类型的名称
 	Name string
@@ -53,6 +64,8 @@ type ArgType struct {
 	AliaNames []string
 	ParseCB   ParseFN
 	ParseImpl IParseStruct
+	PayloadCB PayloadFN
+	ProcessCB ProcessFN
 	DumpHex   bool
 	Color     bool
 }
@@ -76,6 +89,8 @@ func (this *ArgType) Clone() IArgType {
 	at.AliaNames = append(at.AliaNames, this.AliaNames...)
 	at.ParseCB = this.ParseCB
 	at.ParseImpl = this.ParseImpl
+	at.PayloadCB = this.PayloadCB
+	at.ProcessCB = this.ProcessCB
 	at.DumpHex = this.DumpHex
 	at.Color = this.Color
 	return &at
@@ -129,6 +144,30 @@ func (this *ArgType) SetParseImpl(impl IParseStruct) {
 	this.ParseImpl = impl
 }
 
+func (this *ArgType) SetPayloadCB(fn PayloadFN) {
+	this.PayloadCB = fn
+}
+
+func (this *ArgType) SetProcessCB(fn ProcessFN) {
+	this.ProcessCB = fn
+}
+
+func (this *ArgType) GetProcessCB() ProcessFN {
+	return this.ProcessCB
+}
+
+func (this *ArgType) HasPayload() bool {
+	// 只有 buffer iovec msghdr 这类参数有可以保存的内容
+	return this.PayloadCB != nil
+}
+
+func (this *ArgType) ReadPayloads(buf *bytes.Buffer) ([][]byte, error) {
+	if this.PayloadCB == nil {
+		return nil, fmt.Errorf("type %s has no payload", this.Name)
+	}
+	return this.PayloadCB(this, buf)
+}
+
 func (this *ArgType) AddOpList(p IArgType) {
 	this.OpList = append(this.OpList, p.GetOpList()...)
 }
@@ -274,6 +313,26 @@ func LazyRegister(type_index uint32) IArgType {
 		return r_SOCKADDR()
 	case BUFFER_X2:
 		return r_BUFFER_X2()
+	case IOCTL_CMD:
+		return r_IOCTL_CMD()
+	case IOCTL_ARG:
+		return r_IOCTL_ARG()
+	case BINDER_WRITE_READ:
+		return r_BINDER_WRITE_READ()
+	case JNI_NATIVE_METHODS:
+		return r_JNI_METHODS()
+	case USTRING:
+		return r_USTRING()
+	case USTRING_LEN:
+		return r_USTRING_LEN()
+	case STRING16:
+		return r_STRING16()
+	case STD_U16STRING:
+		return r_STD_U16STRING()
+	case STD_STRING_VIEW:
+		return r_STD_STRING_VIEW()
+	case STD_SHARED_PTR:
+		return r_STD_SHARED_PTR()
 	default:
 		panic(fmt.Sprintf("LazyRegister for type_index:%d failed", type_index))
 	}
@@ -309,6 +368,16 @@ func PreRegister() {
 	r_MSGHDR()
 	r_SOCKADDR()
 	r_BUFFER_X2()
+	r_IOCTL_CMD()
+	r_IOCTL_ARG()
+	r_BINDER_WRITE_READ()
+	r_JNI_METHODS()
+	r_USTRING()
+	r_USTRING_LEN()
+	r_STRING16()
+	r_STD_U16STRING()
+	r_STD_STRING_VIEW()
+	r_STD_SHARED_PTR()
 }
 
 func Register(p IArgType, name string, base, index, size uint32) {
@@ -36,6 +36,14 @@ const (
 	OP_SAVE_STRING
 	OP_SAVE_PTR_STRING
 	OP_READ_STD_STRING
+	OP_SET_READ_LEN_IOC_SIZE
+	OP_FIND_BINDER_TXN
+	OP_SAVE_USTRING
+	OP_SET_READ_LEN_PTR_RANGE
+	OP_LIMIT_BREAK_COUNT
+	OP_SAVE_TREE_NODE
+	OP_SAVE_LIST_NODE
+	OP_SAVE_BREAK_COUNT
 )
 
 type BaseOpConfig struct {
@@ -291,6 +299,81 @@ var OPC_FILTER_VALUE = ROP("FILTER_VALUE", OP_FILTER_VALUE)
 var OPC_FILTER_STRING = ROP("FILTER_STRING", OP_FILTER_STRING)
 var OPC_SAVE_PTR_STRING = ROP("SAVE_PTR_STRING", OP_SAVE_PTR_STRING)
 var OPC_READ_STD_STRING = ROP("READ_STD_STRING", OP_READ_STD_STRING)
+var OPC_SET_READ_LEN_IOC_SIZE = ROP("SET_READ_LEN_IOC_SIZE", OP_SET_READ_LEN_IOC_SIZE)
+var OPC_FIND_BINDER_TXN = ROP("FIND_BINDER_TXN", OP_FIND_BINDER_TXN)
+var OPC_SAVE_USTRING = ROP("SAVE_USTRING", OP_SAVE_USTRING)
+var OPC_SET_READ_LEN_PTR_RANGE = ROP("SET_READ_LEN_PTR_RANGE", OP_SET_READ_LEN_PTR_RANGE)
+var OPC_LIMIT_BREAK_COUNT = ROP("LIMIT_BREAK_COUNT", OP_LIMIT_BREAK_COUNT)
+var OPC_SAVE_TREE_NODE = ROP("SAVE_TREE_NODE", OP_SAVE_TREE_NODE)
+var OPC_SAVE_LIST_NODE = ROP("SAVE_LIST_NODE", OP_SAVE_LIST_NODE)
+var OPC_SAVE_BREAK_COUNT = ROP("SAVE_BREAK_COUNT", OP_SAVE_BREAK_COUNT)
+
+func op_cost(op *OpConfig) uint32 {
+	// 带有 post_code 的操作在 ebpf 中会占用两次循环
+	if op.PostCode != OP_SKIP {
+		return 2
+	}
+	return 1
+}
+
+func LimitLoopCount(op_keys []uint32, max_op_count uint32) {
+	// ebpf 中每执行一个操作都计入 MAX_OP_COUNT 循环体重复执行的部分也一样
+	// 超出之后后面的参数都不会被读取 所以按剩余的数量缩小 LIMIT_BREAK_COUNT 的值
+	// 这里只考虑 LIMIT_BREAK_COUNT 限制的循环 即 std::map 这类容器
+	var total uint32
+	for _, op_key := range op_keys {
+		total += op_cost(OPM.GetOp(op_key))
+	}
+	type loop_info struct {
+		index     int
+		max_count uint32
+		body_cost uint32
+	}
+	var loops []loop_info
+	for i, op_key := range op_keys {
+		op := OPM.GetOp(op_key)
+		if op.Code != OP_LIMIT_BREAK_COUNT {
+			continue
+		}
+		// 循环体即 LIMIT 之后第一个 FOR_BREAK 到下一个 FOR_BREAK 之间的操作 含后一个 FOR_BREAK
+		var body_cost uint32
+		breaks := 0
+		for _, body_key := range op_keys[i+1:] {
+			body_op := OPM.GetOp(body_key)
+			if breaks > 0 {
+				body_cost += op_cost(body_op)
+			}
+			if body_op.Code == OP_FOR_BREAK {
+				breaks += 1
+				if breaks == 2 {
+					break
+				}
+			}
+		}
+		if breaks == 2 && op.Value > 1 {
+			loops = append(loops, loop_info{i, uint32(op.Value), body_cost})
+		}
+	}
+	if len(loops) == 0 {
+		return
+	}
+	// 每个循环的第一次已经计算在内 剩余的数量平均分给各个循环
+	var left uint32
+	if total < max_op_count {
+		left = max_op_count - total
+	}
+	for i, loop := range loops {
+		share := left / uint32(len(loops)-i)
+		extra := share / loop.body_cost
+		if extra > loop.max_count-1 {
+			extra = loop.max_count - 1
+		}
+		left -= extra * loop.body_cost
+		if extra+1 < loop.max_count {
+			op_keys[loop.index] = OPC_LIMIT_BREAK_COUNT.NewValue(uint64(extra + 1)).Index
+		}
+	}
+}
 
 func BuildReadRegBreakCount(reg_index uint64) *OpConfig {
 	op := OpConfig{}
@@ -9,6 +9,7 @@ const SYSCALL_MAX_OP_COUNT = 256
 const STACK_MAX_OP_COUNT = 64
 const MAX_STRCMP_LEN = 256
 const MAX_BUF_READ_SIZE = 4096
+const MAX_UPROBE_POINT_COUNT = 12
 
 const (
 	REG_ARM64_X0 uint32 = iota
@@ -47,6 +48,8 @@ const (
 	REG_ARM64_MAX
 	REG_ARM64_INDEX
 	REG_ARM64_ABS
+	// 函数返回值 只在返回时读取的 hook 点中有意义
+	REG_ARM64_RET
 )
 
 var RegsMagicMap map[string]uint32 = map[string]uint32{
@@ -83,6 +86,7 @@ var RegsMagicMap map[string]uint32 = map[string]uint32{
 	"lr":  REG_ARM64_LR,
 	"sp":  REG_ARM64_SP,
 	"pc":  REG_ARM64_PC,
+	"ret": REG_ARM64_RET,
 }
 
 func GetRegIndex(reg string) uint32 {
@@ -158,6 +162,17 @@ const (
 	INT_SOCKET_FLAGS
 	INT_FILE_FLAGS
 	INT16_PERM_FLAGS
+	IOCTL_CMD
+	IOCTL_ARG
+	BINDER_WRITE_READ
+	FD
+	JNI_NATIVE_METHODS
+	USTRING
+	USTRING_LEN
+	STRING16
+	STD_U16STRING
+	STD_STRING_VIEW
+	STD_SHARED_PTR
 	CONST_ARGTYPE_END
 )
 
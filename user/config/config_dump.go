@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	. "stackplz/user/common"
+	"strconv"
+	"strings"
+)
+
+// 单次内存段转储最大大小 避免把整个堆都读出来
+const MAX_DUMP_MEM_SIZE = 64 * 1024 * 1024
+
+// 命中 hook 点时要读取的一段内存
+// x0       -> 读取 x0 所在的整个内存段
+// x0:0x100 -> 从 x0 开始读取 0x100 字节
+// 0x7fb1234000:0x1000 -> 从绝对地址开始读取 0x1000 字节
+type MemDumpConfig struct {
+	Spec     string
+	RegIndex uint32
+	Addr     uint64
+	Size     uint64
+}
+
+func (this *MemDumpConfig) IsAbsolute() bool {
+	return this.RegIndex == REG_ARM64_MAX
+}
+
+func (this *MemDumpConfig) GetAddr(regs [33]uint64) uint64 {
+	if this.IsAbsolute() {
+		return this.Addr
+	}
+	return regs[this.RegIndex]
+}
+
+func ParseMemDump(spec string) (*MemDumpConfig, error) {
+	items := strings.SplitN(spec, ":", 2)
+	mem_dump := &MemDumpConfig{}
+	mem_dump.Spec = spec
+	mem_dump.RegIndex = REG_ARM64_MAX
+	if strings.HasPrefix(items[0], "0x") {
+		addr, err := strconv.ParseUint(items[0], 0, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("parse dump mem addr:%s failed, err:%v", items[0], err))
+		}
+		mem_dump.Addr = addr
+	} else {
+		reg_index := GetRegIndex(items[0])
+		if reg_index >= REG_ARM64_MAX {
+			return nil, errors.New(fmt.Sprintf("dump mem reg:%s not supported", items[0]))
+		}
+		mem_dump.RegIndex = reg_index
+	}
+	if len(items) == 2 {
+		size, err := strconv.ParseUint(items[1], 0, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("parse dump mem size:%s failed, err:%v", items[1], err))
+		}
+		mem_dump.Size = size
+	}
+	if mem_dump.IsAbsolute() && mem_dump.Size == 0 {
+		return nil, errors.New(fmt.Sprintf("dump mem %s must set size", spec))
+	}
+	if mem_dump.Size > MAX_DUMP_MEM_SIZE {
+		return nil, errors.New(fmt.Sprintf("dump mem %s size bigger than 0x%x", spec, MAX_DUMP_MEM_SIZE))
+	}
+	return mem_dump, nil
+}
+
+func ParseMemDumpList(specs []string) ([]*MemDumpConfig, error) {
+	var results []*MemDumpConfig
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		mem_dump, err := ParseMemDump(spec)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, mem_dump)
+	}
+	return results, nil
+}
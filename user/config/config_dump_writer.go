@@ -0,0 +1,128 @@
+package config
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const DUMP_GZIP_SUFFIX = ".gz"
+
+// 按大小或时间切分时的分段文件名 tmp.bin -> tmp.bin.000 tmp.bin.gz -> tmp.bin.000.gz
+func DumpSegmentPath(dump_path string, index int) string {
+	if strings.HasSuffix(dump_path, DUMP_GZIP_SUFFIX) {
+		return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(dump_path, DUMP_GZIP_SUFFIX), index, DUMP_GZIP_SUFFIX)
+	}
+	return fmt.Sprintf("%s.%03d", dump_path, index)
+}
+
+type countWriter struct {
+	f *os.File
+	n uint64
+}
+
+func (this *countWriter) Write(p []byte) (int, error) {
+	n, err := this.f.Write(p)
+	this.n += uint64(n)
+	return n, err
+}
+
+// --dump 的输出 文件名以 .gz 结尾时边采集边压缩 设置了大小或时间时切分为多个分段
+// 切分只发生在两条记录之间 每个分段都可以单独解析
+type DumpWriter struct {
+	path      string
+	compress  bool
+	max_size  uint64
+	interval  time.Duration
+	index     int
+	cw        *countWriter
+	gw        *gzip.Writer
+	open_time time.Time
+}
+
+func NewDumpWriter(dump_path string, max_size uint64, interval time.Duration) (*DumpWriter, error) {
+	writer := &DumpWriter{}
+	writer.path = dump_path
+	writer.compress = strings.HasSuffix(dump_path, DUMP_GZIP_SUFFIX)
+	writer.max_size = max_size
+	writer.interval = interval
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (this *DumpWriter) IsRotate() bool {
+	return this.max_size > 0 || this.interval > 0
+}
+
+func (this *DumpWriter) CurrentPath() string {
+	if !this.IsRotate() {
+		return this.path
+	}
+	return DumpSegmentPath(this.path, this.index)
+}
+
+func (this *DumpWriter) open() error {
+	f, err := os.Create(this.CurrentPath())
+	if err != nil {
+		return err
+	}
+	this.cw = &countWriter{f: f}
+	if this.compress {
+		this.gw = gzip.NewWriter(this.cw)
+	}
+	this.open_time = time.Now()
+	return nil
+}
+
+func (this *DumpWriter) closeSegment() error {
+	if this.gw != nil {
+		if err := this.gw.Close(); err != nil {
+			return err
+		}
+		this.gw = nil
+	}
+	return this.cw.f.Close()
+}
+
+func (this *DumpWriter) needRotate() bool {
+	if this.max_size > 0 {
+		// 压缩时 gzip 内部还有未写出的数据 按已经落盘的大小计算即可
+		if this.cw.n >= this.max_size {
+			return true
+		}
+	}
+	if this.interval > 0 && time.Since(this.open_time) >= this.interval {
+		return true
+	}
+	return false
+}
+
+func (this *DumpWriter) Rotate() error {
+	if err := this.closeSegment(); err != nil {
+		return err
+	}
+	this.index += 1
+	return this.open()
+}
+
+func (this *DumpWriter) WriteRecord(data []byte) error {
+	if this.needRotate() {
+		if err := this.Rotate(); err != nil {
+			return err
+		}
+	}
+	if this.gw != nil {
+		_, err := this.gw.Write(data)
+		return err
+	}
+	_, err := this.cw.Write(data)
+	return err
+}
+
+func (this *DumpWriter) Close() error {
+	return this.closeSegment()
+}
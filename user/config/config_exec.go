@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// 带有 exec 字段的 uprobe 配置文件 启动时不挂载
+// 被追踪的进程 exec 到对应的程序时再挂载 此时进程停在新程序运行之前
+type ExecHookConfig struct {
+	Exec     string
+	Config   *UprobeFileConfig
+	Attached bool
+}
+
+var exec_lock sync.Mutex
+
+func NewExecHookConfig(config *UprobeFileConfig) *ExecHookConfig {
+	hook := &ExecHookConfig{}
+	hook.Exec = config.Exec
+	hook.Config = config
+	return hook
+}
+
+func (this *ExecHookConfig) Match(path string) bool {
+	// 只写了文件名的时候按文件名匹配 否则 path 需要是 ExecEvent 中补全过的绝对路径
+	if strings.Contains(this.Exec, "/") {
+		return this.Exec == path
+	}
+	return this.Exec == filepath.Base(path)
+}
+
+// 与 ebpf 中 exec_name_t 的大小一致 末尾留一个 \0
+const EXEC_NAME_MAX_LEN = 63
+
+func ExecStopName(path string) string {
+	// ebpf 中只按文件名判断是否需要停下
+	name := filepath.Base(path)
+	if len(name) > EXEC_NAME_MAX_LEN {
+		name = name[:EXEC_NAME_MAX_LEN]
+	}
+	return name
+}
+
+func (this *ExecHookConfig) String() string {
+	return fmt.Sprintf("exec:%s library:%s points:%d", this.Exec, this.Config.Library, len(this.Config.Points))
+}
+
+func (this *ModuleConfig) ExecStop() bool {
+	return len(this.ExecHooks) > 0
+}
+
+func (this *ModuleConfig) ExecStopNames() []string {
+	var names []string
+	for _, hook := range this.ExecHooks {
+		name := ExecStopName(hook.Exec)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (this *ModuleConfig) MatchExecStop(path string) bool {
+	// 与 ebpf 中的判断一致 匹配的进程在 exec 之后已经停下
+	return slices.Contains(this.ExecStopNames(), ExecStopName(path))
+}
+
+func (this *ModuleConfig) MatchExecHook(path string) bool {
+	for _, hook := range this.ExecHooks {
+		if hook.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *ModuleConfig) AttachExecHooks(path string) error {
+	if this.OnExec == nil {
+		return nil
+	}
+	// 同一时间只挂载一组 其他 exec 到同一程序的进程等待挂载完成
+	exec_lock.Lock()
+	defer exec_lock.Unlock()
+	for _, hook := range this.ExecHooks {
+		if hook.Attached || !hook.Match(path) {
+			continue
+		}
+		// uprobe 按文件挂载 对之后 exec 的进程同样有效 失败了也不再重试
+		hook.Attached = true
+		if err := this.OnExec(hook, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *ModuleConfig) NewExecModuleConfig(hook *ExecHookConfig, path string, gconfig *GlobalConfig) (*ModuleConfig, error) {
+	// 过滤规则和输出设定都沿用 只替换 uprobe 配置
+	conf := *this
+	conf.StackUprobeConf = &StackUprobeConfig{}
+	conf.StackUprobeConf.DumpHex = this.StackUprobeConf.DumpHex
+	conf.StackUprobeConf.Color = this.StackUprobeConf.Color
+	conf.StackUprobeConf.logger = this.StackUprobeConf.logger
+	// 没有指定 library 时 hook 新程序本身
+	library := hook.Config.Library
+	if library == "" {
+		library = path
+	}
+	if err := gconfig.Parse_Libinfo(library, conf.StackUprobeConf); err != nil {
+		return nil, err
+	}
+	if err := conf.StackUprobeConf.Parse_FileConfig(hook.Config); err != nil {
+		return nil, err
+	}
+	for _, point := range conf.StackUprobeConf.Points {
+		if len(point.DumpMem) > 0 {
+			conf.ShowRegs = true
+		}
+	}
+	if err := conf.CheckDumpMem(); err != nil {
+		return nil, err
+	}
+	if this.TriggerConf != nil {
+		conf.TriggerConf = this.TriggerConf.ForExec()
+	}
+	conf.ExecHooks = nil
+	conf.OnExec = nil
+	conf.SpawnConf = nil
+	return &conf, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"stackplz/user/argtype"
 	. "stackplz/user/common"
+	"stackplz/user/util"
 	"strconv"
 	"strings"
 )
@@ -17,12 +18,44 @@ type ParamConfig struct {
 	Filter []string `json:"filter"`
 	Reg    string   `json:"reg"`
 	ReadOp string   `json:"read_op"`
+	Dump   bool     `json:"dump"`
+	// format 为 protobuf 时 可以指定 protoc --descriptor_set_out 生成的文件以及消息的完整名称
+	Proto   string `json:"proto"`
+	Message string `json:"message"`
+}
+
+// 同一个 descriptor set 文件只加载一次
+var pb_schemas = make(map[string]*util.PbSchema)
+
+func (this *ParamConfig) GetPbMessage() *util.PbMessage {
+	if this.Proto == "" {
+		return nil
+	}
+	schema, ok := pb_schemas[this.Proto]
+	if !ok {
+		var err error
+		schema, err = util.LoadPbSchema(this.Proto)
+		if err != nil {
+			panic(err)
+		}
+		pb_schemas[this.Proto] = schema
+	}
+	if this.Message == "" {
+		panic(fmt.Sprintf("message is required for proto:%s", this.Proto))
+	}
+	msg, err := schema.FindMessage(this.Message)
+	if err != nil {
+		panic(err)
+	}
+	return msg
 }
 
 type PointConfig struct {
-	Name   string        `json:"name"`
-	Signal string        `json:"signal"`
-	Params []ParamConfig `json:"params"`
+	Name    string        `json:"name"`
+	Signal  string        `json:"signal"`
+	DumpMem []string      `json:"dump_mem"`
+	Return  bool          `json:"return"`
+	Params  []ParamConfig `json:"params"`
 }
 
 type SyscallPointConfig struct {
@@ -36,12 +69,31 @@ type IFileConfig interface {
 
 type FileConfig struct {
 	Type string `json:"type"`
+	// 与 --start-on/--stop-on/--trigger-scope 相同 会和命令行的合并
+	StartOn      []string `json:"start_on"`
+	StopOn       []string `json:"stop_on"`
+	TriggerScope string   `json:"trigger_scope"`
 }
 
 func (this *FileConfig) GetType() string {
 	return this.Type
 }
 
+func (this *ParamConfig) UseRet() bool {
+	// 是否用到了返回值 只有函数或者 syscall 返回时才有
+	if this.Reg == "ret" || this.Size == "ret" {
+		return true
+	}
+	for _, token := range strings.FieldsFunc(this.ReadOp, func(r rune) bool {
+		return r == '+' || r == '-' || r == '.'
+	}) {
+		if token == "ret" {
+			return true
+		}
+	}
+	return false
+}
+
 func (this *ParamConfig) GetPointArg(arg_index, point_type uint32) *PointArg {
 	// 参数名省略时 以 a{index} 这样的形式作为名字
 	arg_name := fmt.Sprintf("a%d", arg_index)
@@ -110,6 +162,29 @@ func (this *ParamConfig) GetPointArg(arg_index, point_type uint32) *PointArg {
 		}
 		point_arg.SetTypeIndex(at.GetTypeIndex())
 		point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+	case "ustr", "String16":
+		// size 为 len 表示前面有 int32 的字符数
+		if type_name == "ustr" && this.Size == "len" {
+			point_arg.SetTypeIndex(USTRING_LEN)
+		} else {
+			point_arg.SetTypeByName(type_name)
+		}
+		point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+	case "u16string", "string_view":
+		point_arg.SetTypeByName(type_name)
+		point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+	case "vector", "map", "umap", "shared_ptr":
+		// 元素类型写在 size 中 比如 "type": "map", "size": "std:int"
+		if type_name == "shared_ptr" && this.Size == "" {
+			point_arg.SetTypeByName(type_name)
+		} else {
+			at, err := argtype.R_STL_CONTAINER(type_name, strings.Split(this.Size, ":"))
+			if err != nil {
+				panic(err)
+			}
+			point_arg.SetTypeIndex(at.GetTypeIndex())
+		}
+		point_arg.SetGroupType(EBPF_UPROBE_ENTER)
 	case "str", "std":
 		// 根据名称指定类型
 		// 支持自定义类型 但是需要提前在配置文件中写好
@@ -139,7 +214,11 @@ func (this *ParamConfig) GetPointArg(arg_index, point_type uint32) *PointArg {
 		// 没设置就默认方式处理
 		break
 	default:
-		panic(fmt.Sprintf("unsupported format type:%s", this.Format))
+		// buf 类型的解码链 比如 gzip|json auto protobuf
+		if !util.IsDecoderChain(this.Format) {
+			panic(fmt.Sprintf("unsupported format type:%s", this.Format))
+		}
+		point_arg.SetDecodeFormat(this.Format, this.GetPbMessage())
 	}
 
 	// 设置过滤规则 先解析规则 然后取到规则索引
@@ -147,6 +226,11 @@ func (this *ParamConfig) GetPointArg(arg_index, point_type uint32) *PointArg {
 		point_arg.AddFilterIndex(AddFilter(v))
 	}
 
+	// 将读取到的数据保存到文件 仅对 buf iovec msghdr 这类参数有效
+	if this.Dump {
+		point_arg.SetDumpFile(true)
+	}
+
 	// ./stackplz -n com.termux -l libtest.so -w 0x16254[buf:64:sp+0x20-0x8.+8.-4+0x16]
 	// read_op_str -> "sp+0x20-0x8.+8.-4+0x16"
 	// 该命令含义为
@@ -213,6 +297,8 @@ type UprobeFileConfig struct {
 	FileConfig
 	Library string        `json:"library"`
 	Points  []PointConfig `json:"points"`
+	// 设置后在被追踪的进程 exec 到该程序时才挂载
+	Exec string `json:"exec"`
 }
 
 type SyscallFileConfig struct {
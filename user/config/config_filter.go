@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"stackplz/user/argtype"
 	"stackplz/user/common"
 	"stackplz/user/util"
 	"strings"
@@ -18,12 +19,23 @@ type CommonFilter struct {
 	trace_uid_group uint32
 	signal          uint32
 	tsignal         uint32
+	trigger_mode    uint32
+	trigger_init    uint32
+	scope_mode      uint32
+	spawn_uid       uint32
+	exec_stop       uint32
+	lifecycle       uint32
+	signal_deliver  uint32
 }
 
 type ThreadFilter struct {
 	ThreadName [16]byte
 }
 
+type ExecFilter struct {
+	Name [EXEC_NAME_MAX_LEN + 1]byte
+}
+
 const (
 	UNKNOWN_FILTER uint32 = iota
 	EQUAL_FILTER
@@ -70,12 +82,30 @@ type EArgFilter struct {
 	Num_val     uint64
 }
 
+// UTF-16 字符串参数使用的规则索引 与原规则的索引相差这个值
+const UTF16_FILTER_OFFSET = 0x100
+
 type FilterHelper struct {
-	filters []ArgFilter
+	filters       []ArgFilter
+	utf16_filters []ArgFilter
 }
 
 func (this *FilterHelper) GetFilters() []ArgFilter {
-	return this.filters
+	return append(append([]ArgFilter{}, this.filters...), this.utf16_filters...)
+}
+
+func (this *ArgFilter) ToUtf16() ArgFilter {
+	// 字符串规则的内容转换为 UTF-16LE 超出长度的部分截断
+	arg_filter := *this
+	arg_filter.Filter_index = this.Filter_index + UTF16_FILTER_OFFSET
+	str_val := argtype.EncodeUtf16(string(this.Str_val[:this.Str_len]))
+	if len(str_val) > len(arg_filter.Str_val) {
+		str_val = str_val[:len(arg_filter.Str_val)]
+	}
+	arg_filter.Str_val = [256]byte{}
+	copy(arg_filter.Str_val[:], str_val)
+	arg_filter.Str_len = uint32(len(str_val))
+	return arg_filter
 }
 
 func (this *FilterHelper) GetFilterByName(filter_name string) ArgFilter {
@@ -87,6 +117,13 @@ func (this *FilterHelper) GetFilterByName(filter_name string) ArgFilter {
 	panic(fmt.Sprintf("%s not match any filter", filter_name))
 }
 
+func (this *FilterHelper) GetFilterByIndex(filter_index uint32) *ArgFilter {
+	if filter_index == 0 || filter_index > uint32(len(this.filters)) {
+		return nil
+	}
+	return &this.filters[filter_index-1]
+}
+
 func (this *FilterHelper) GetFilterIndex(filter string) uint32 {
 	arg_filter := this.GetFilterByName(filter)
 	return arg_filter.Filter_index
@@ -134,6 +171,10 @@ func (this *FilterHelper) AddFilter(filter string) uint32 {
 	}
 	arg_filter.Filter_index = uint32(len(this.filters) + 1)
 	this.filters = append(this.filters, arg_filter)
+	// 同时生成 UTF-16 版本的规则 这样在加载之前就确定了全部的规则
+	if arg_filter.IsStr() {
+		this.utf16_filters = append(this.utf16_filters, arg_filter.ToUtf16())
+	}
 	return arg_filter.Filter_index
 }
 
@@ -152,10 +193,18 @@ func AddFilter(filter string) uint32 {
 	return filter_helper.AddFilter(filter)
 }
 
+func GetUtf16FilterIndex(filter_index uint32) uint32 {
+	return filter_index + UTF16_FILTER_OFFSET
+}
+
 func GetFilters() []ArgFilter {
 	return filter_helper.GetFilters()
 }
 
+func GetFilterByIndex(filter_index uint32) *ArgFilter {
+	return filter_helper.GetFilterByIndex(filter_index)
+}
+
 func GetFilterByName(name string) ArgFilter {
 	return filter_helper.GetFilterByName(name)
 }
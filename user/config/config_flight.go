@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"stackplz/user/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	FLIGHT_TRIGGER_SIGNAL = "signal:"
+	FLIGHT_TRIGGER_EXIT   = "exit"
+)
+
+// 飞行记录模式 最近一段时间或一定大小的事件只保存在内存中 触发时才输出
+type FlightConfig struct {
+	MaxSize uint64
+	MaxAge  time.Duration
+	Points  []string
+	Signals []uint32
+	OnExit  bool
+}
+
+func parseFlightSize(text string) (uint64, error) {
+	// 32M 512K 1G 不带单位时按 MB 处理
+	unit := uint64(1024 * 1024)
+	value := strings.ToUpper(text)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		unit = 1024
+	case strings.HasSuffix(value, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(value, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	value = strings.TrimRight(value, "KMG")
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("parse flight size %s failed, e.g. 32M or 30s", text)
+	}
+	return size * unit, nil
+}
+
+func NewFlightConfig(text, triggers string) (*FlightConfig, error) {
+	var err error
+	config := &FlightConfig{}
+	if age, age_err := time.ParseDuration(text); age_err == nil {
+		if age <= 0 {
+			return nil, fmt.Errorf("parse flight duration %s failed", text)
+		}
+		config.MaxAge = age
+	} else if config.MaxSize, err = parseFlightSize(text); err != nil {
+		return nil, err
+	}
+	for _, trigger := range strings.Split(triggers, ",") {
+		switch {
+		case trigger == "":
+		case trigger == FLIGHT_TRIGGER_EXIT:
+			config.OnExit = true
+		case strings.HasPrefix(trigger, FLIGHT_TRIGGER_SIGNAL):
+			signal := util.ParseSignal(strings.TrimPrefix(trigger, FLIGHT_TRIGGER_SIGNAL))
+			if signal == 0 {
+				return nil, fmt.Errorf("unknown signal in flight trigger %s", trigger)
+			}
+			config.Signals = append(config.Signals, signal)
+		default:
+			config.Points = append(config.Points, trigger)
+		}
+	}
+	return config, nil
+}
+
+func (this *FlightConfig) MatchPoint(name string) bool {
+	return slices.Contains(this.Points, name)
+}
+
+func (this *FlightConfig) MatchSignal(signal uint32) bool {
+	return slices.Contains(this.Signals, signal)
+}
+
+func (this *FlightConfig) String() string {
+	var limit string
+	if this.MaxAge > 0 {
+		limit = this.MaxAge.String()
+	} else {
+		limit = fmt.Sprintf("%dKB", this.MaxSize/1024)
+	}
+	return fmt.Sprintf("keep last %s, trigger points:%v signals:%v exit:%t", limit, this.Points, this.Signals, this.OnExit)
+}
@@ -8,16 +8,18 @@ import (
 
 // BPF_ 与c的结构体一一对应
 type ContextFields struct {
-	Ts      uint64   `json:"ts"`
-	EventId uint32   `json:"event_id"`
-	HostTid uint32   `json:"host_tid"`
-	HostPid uint32   `json:"host_pid"`
-	Tid     uint32   `json:"tid"`
-	Pid     uint32   `json:"pid"`
-	Uid     uint32   `json:"uid"`
-	Comm    [16]byte `json:"comm"`
-	Argnum  uint8    `json:"arg_num"`
-	Padding [7]byte  `json:"-"`
+	Ts         uint64   `json:"ts"`
+	EventId    uint32   `json:"event_id"`
+	HostTid    uint32   `json:"host_tid"`
+	HostPid    uint32   `json:"host_pid"`
+	Tid        uint32   `json:"tid"`
+	Pid        uint32   `json:"pid"`
+	Uid        uint32   `json:"uid"`
+	Comm       [16]byte `json:"comm"`
+	Argnum     uint8    `json:"arg_num"`
+	ScopeDepth uint8    `json:"scope_depth,omitempty"`
+	Padding    [2]byte  `json:"-"`
+	ScopeId    uint32   `json:"scope_id,omitempty"`
 }
 
 func (this *ContextFields) MarshalJSON() ([]byte, error) {
@@ -140,3 +142,8 @@ func (this *CommFields) MarshalJSON() ([]byte, error) {
 		Alias: (*Alias)(this),
 	})
 }
+
+type ExecFields struct {
+	Path string   `json:"path"`
+	Argv []string `json:"argv"`
+}
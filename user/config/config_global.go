@@ -37,6 +37,13 @@ type GlobalConfig struct {
     TKillSignal string
     Rpc         bool
     RpcPath     string
+    Flight      string
+    FlushOn     string
+    StartOn     []string
+    StopOn      []string
+    TriggerOn   string
+    Scope       string
+    Spawn       string
     Debug       bool
     Quiet       bool
     Buffer      uint32
@@ -47,7 +54,25 @@ type GlobalConfig struct {
     BrkLen      uint64
     LogFile     string
     DumpFile    string
+    DumpSize    uint32
+    DumpPeriod  uint32
+    DumpDir     string
+    DumpBuf     bool
+    DumpMem     string
+    PcapFile    string
+    Summary     bool
+    SummaryBy   string
+    SummaryTick uint32
+    FoldedFile  string
+    PprofFile   string
+    TraceFile   string
+    DbFile      string
+    Sqlite3     string
+    Preset      string
     ParseFile   string
+    PointName   string
+    Since       string
+    Until       string
     DataDir     string
     LibraryDirs []string
     HookPoint   []string
@@ -58,6 +83,8 @@ type GlobalConfig struct {
     ShowPC      bool
     ShowTime    bool
     ShowUid     bool
+    ShowPkg     bool
+    Lifecycle   bool
     NoCheck     bool
     Btf         bool
     ExternalBTF string
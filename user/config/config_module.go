@@ -8,6 +8,7 @@ import (
     "io/ioutil"
     "log"
     "os"
+    "path/filepath"
     "regexp"
     "stackplz/user/argtype"
     . "stackplz/user/common"
@@ -15,12 +16,15 @@ import (
     "strconv"
     "strings"
     "sync"
+    "syscall"
+    "time"
 
     "github.com/cilium/ebpf/perf"
     "golang.org/x/exp/slices"
 )
 
 type StackUprobeConfig struct {
+    logger       *log.Logger
     LibName      string
     LibPath      string
     RealFilePath string
@@ -74,6 +78,11 @@ func (this *StackUprobeConfig) ParseArgType(arg_str string, point_arg *PointArg)
         type_name = filter_items[0]
         filter_names := strings.Split(filter_items[1], ".")
         for _, filter_name := range filter_names {
+            if filter_name == "dump" {
+                // buf.dump:64 表示将读取到的数据保存到文件
+                point_arg.SetDumpFile(true)
+                continue
+            }
             if filter_name != "" {
                 point_arg.AddFilterIndex(GetFilterIndex(filter_name))
             }
@@ -113,6 +122,19 @@ func (this *StackUprobeConfig) ParseArgType(arg_str string, point_arg *PointArg)
             point_arg.SetTypeIndex(STD_STRING)
         }
         point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+    case "ustr", "String16":
+        // ustr 以 \0\0 结尾的 UTF-16 字符串
+        // ustr:len 前面有 int32 字符数的 UTF-16 字符串 比如 ustr:len:x1+8
+        // String16 即 android::String16 对象
+        if type_name == "String16" {
+            point_arg.SetTypeIndex(STRING16)
+        } else if read_op_str == "len" || strings.HasPrefix(read_op_str, "len:") {
+            point_arg.SetTypeIndex(USTRING_LEN)
+            read_op_str = strings.TrimPrefix(strings.TrimPrefix(read_op_str, "len"), ":")
+        } else {
+            point_arg.SetTypeIndex(USTRING)
+        }
+        point_arg.SetGroupType(EBPF_UPROBE_ENTER)
     case "ptr":
         point_arg.SetTypeIndex(POINTER)
     case "ptr_arr", "uint_arr", "int_arr":
@@ -141,7 +163,9 @@ func (this *StackUprobeConfig) ParseArgType(arg_str string, point_arg *PointArg)
         }
         point_arg.SetTypeIndex(at.GetTypeIndex())
         point_arg.SetGroupType(EBPF_UPROBE_ENTER)
-    case "buf":
+    case "buf", "pb", "buf_auto":
+        // pb 即按 protobuf 解析的 buf 用法与 buf 一致 比如 0x89ab[pb:x2:x1]
+        // buf_auto 即自动识别 gzip/zlib/base64/json 并解码的 buf
         // 对于 buf 类型 其参数读取索引位于最后
         // 0x89ab[buf:64,int] 命中hook点时读取 x0 处64字节数据 读取 x1 值
         // 0x89ab[buf:64:sp+0x20-0x8] 命中hook点时读取 sp+0x20-0x8 处64字节数据
@@ -170,11 +194,66 @@ func (this *StackUprobeConfig) ParseArgType(arg_str string, point_arg *PointArg)
                 at = argtype.R_BUFFER_REG(GetRegIndex(size_str))
             }
         }
+        if type_name == "pb" {
+            at = argtype.R_BUFFER_DECODE(at.GetTypeIndex(), util.DECODER_PROTOBUF, nil)
+        } else if type_name == "buf_auto" {
+            at = argtype.R_BUFFER_DECODE(at.GetTypeIndex(), util.DECODER_AUTO, nil)
+        }
         at.SetDumpHex(this.DumpHex)
         at.SetColor(this.Color)
         point_arg.SetTypeIndex(at.GetTypeIndex())
         // 这个设定用于指示是否进一步读取和解析
         point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+    case "jni_methods":
+        // 0x89ab[ptr,ptr,jni_methods,int] 按 x3 的值读取 JNINativeMethod 数组
+        // 0x89ab[jni_methods:x20:x21] 按 x20 的值读取 x21 处的 JNINativeMethod 数组
+        at := argtype.GetArgType(JNI_NATIVE_METHODS)
+        jni_items := strings.SplitN(read_op_str, ":", 2)
+        if jni_items[0] != "" {
+            at = argtype.R_JNI_METHODS_REG(GetRegIndex(jni_items[0]))
+        }
+        if len(jni_items) == 2 {
+            read_op_str = jni_items[1]
+        } else {
+            read_op_str = ""
+        }
+        point_arg.SetTypeIndex(at.GetTypeIndex())
+        point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+    case "u16string", "string_view":
+        // std::u16string std::string_view
+        point_arg.SetTypeByName(type_name)
+        point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+    case "vector", "map", "umap", "shared_ptr":
+        // 元素类型紧跟在后面 参数读取索引位于最后
+        // 0x89ab[vector:int] 读取 x0 处的 std::vector<int>
+        // 0x89ab[ptr,map:std:int] 读取 x1 处的 std::map<std::string, int>
+        // 0x89ab[umap:int:ptr:x20] 读取 x20 处的 std::unordered_map<int, void*>
+        // 0x89ab[shared_ptr,shared_ptr:std] shared_ptr 的元素类型可以省略
+        elem_count := argtype.StlElemCount(type_name)
+        stl_items := strings.SplitN(read_op_str, ":", elem_count+1)
+        if type_name == "shared_ptr" {
+            if _, e := argtype.GetStlElem(stl_items[0]); e != nil {
+                point_arg.SetTypeIndex(STD_SHARED_PTR)
+                point_arg.SetGroupType(EBPF_UPROBE_ENTER)
+                break
+            }
+        }
+        if len(stl_items) < elem_count {
+            err = errors.New(fmt.Sprintf("parse %s arg_str:%s failed", type_name, arg_str))
+            break
+        }
+        var at argtype.IArgType
+        at, err = argtype.R_STL_CONTAINER(type_name, stl_items[:elem_count])
+        if err != nil {
+            break
+        }
+        if len(stl_items) > elem_count {
+            read_op_str = stl_items[elem_count]
+        } else {
+            read_op_str = ""
+        }
+        point_arg.SetTypeIndex(at.GetTypeIndex())
+        point_arg.SetGroupType(EBPF_UPROBE_ENTER)
     default:
         err = errors.New(fmt.Sprintf("unsupported type:%s", items[0]))
     }
@@ -263,6 +342,10 @@ func (this *StackUprobeConfig) SetColor(color bool) {
     this.Color = color
 }
 
+func (this *StackUprobeConfig) SetLogger(logger *log.Logger) {
+    this.logger = logger
+}
+
 func (this *StackUprobeConfig) GetSyscall(mconfig *ModuleConfig) string {
     results := []string{}
     var new_points []*UprobeArgs
@@ -278,11 +361,15 @@ func (this *StackUprobeConfig) GetSyscall(mconfig *ModuleConfig) string {
 }
 
 func (this *StackUprobeConfig) Parse_FileConfig(config *UprobeFileConfig) (err error) {
-    for index, point_config := range config.Points {
+    if len(this.Points)+len(config.Points) > MAX_UPROBE_POINT_COUNT {
+        return errors.New(fmt.Sprintf("max uprobe hook point count is %d", MAX_UPROBE_POINT_COUNT))
+    }
+    for _, point_config := range config.Points {
         hook_point := &UprobeArgs{}
         hook_point.BindSyscall = false
         hook_point.ExitRead = false
-        hook_point.Index = uint32(index)
+        // 可能有多个配置文件 索引要接着已有的 hook 点
+        hook_point.Index = uint32(len(this.Points))
         hook_point.LibPath = this.LibPath
         hook_point.RealFilePath = this.RealFilePath
         hook_point.NonElfOffset = this.NonElfOffset
@@ -290,6 +377,10 @@ func (this *StackUprobeConfig) Parse_FileConfig(config *UprobeFileConfig) (err e
         if point_config.Signal != "" {
             hook_point.KillSignal = util.ParseSignal(point_config.Signal)
         }
+        hook_point.DumpMem, err = ParseMemDumpList(point_config.DumpMem)
+        if err != nil {
+            return err
+        }
 
         // strstr / strstr+0x4 / 0xA94E8
         items := strings.Split(point_config.Name, "+")
@@ -318,9 +409,15 @@ func (this *StackUprobeConfig) Parse_FileConfig(config *UprobeFileConfig) (err e
         }
 
         for arg_index, param := range point_config.Params {
+            if param.UseRet() && !point_config.Return {
+                return errors.New(fmt.Sprintf("ret of %s is only available with return: true", point_config.Name))
+            }
             point_arg := param.GetPointArg(uint32(arg_index), EBPF_UPROBE_ENTER)
             hook_point.PointArgs = append(hook_point.PointArgs, point_arg)
         }
+        if point_config.Return {
+            hook_point.SetReturn()
+        }
         this.Points = append(this.Points, hook_point)
     }
     return nil
@@ -330,15 +427,21 @@ func (this *StackUprobeConfig) Parse_HookPoint(configs []string) (err error) {
     if this.LibPath == "" {
         return errors.New("library is empty, plz set with -l/--lib")
     }
-    if len(configs) > 6 {
-        return errors.New("max uprobe hook point count is 6")
+    if len(this.Points)+len(configs) > MAX_UPROBE_POINT_COUNT {
+        return errors.New(fmt.Sprintf("max uprobe hook point count is %d", MAX_UPROBE_POINT_COUNT))
     }
 
     // strstr+0x0[str,str] 命中 strstr + 0x0 时将x0和x1读取为字符串
     // write[int,buf:128,int] 命中 write 时将x0读取为int、x1读取为字节数组、x2读取为int
-    for point_index, config_str := range configs {
+    // SSL_read[ptr,buf:ret]r 在函数返回时读取 x1 处返回值大小的数据 并追加返回值
+    for _, config_str := range configs {
         exit_read := false
         bind_syscall := false
+        is_ret := false
+        if strings.HasSuffix(config_str, "]r") {
+            config_str = config_str[:len(config_str)-1]
+            is_ret = true
+        }
         if strings.HasSuffix(config_str, "]s") {
             // 临时方案 将 uprobe 用法绑定到 syscall 上
             config_str = config_str[:len(config_str)-1]
@@ -357,7 +460,7 @@ func (this *StackUprobeConfig) Parse_HookPoint(configs []string) (err error) {
             hook_point := &UprobeArgs{}
             hook_point.BindSyscall = bind_syscall
             hook_point.ExitRead = exit_read
-            hook_point.Index = uint32(point_index)
+            hook_point.Index = uint32(len(this.Points))
             hook_point.Offset = 0x0
             hook_point.LibPath = this.LibPath
             hook_point.RealFilePath = this.RealFilePath
@@ -396,6 +499,9 @@ func (this *StackUprobeConfig) Parse_HookPoint(configs []string) (err error) {
                     hook_point.PointArgs = append(hook_point.PointArgs, point_arg)
                 }
             }
+            if is_ret {
+                hook_point.SetReturn()
+            }
             this.Points = append(this.Points, hook_point)
         } else {
             return errors.New(fmt.Sprintf("parse for %s failed", config_str))
@@ -426,13 +532,21 @@ func (this *SyscallConfig) SetLogger(logger *log.Logger) {
     this.logger = logger
 }
 
-func (this *SyscallConfig) GetSyscallPointByNR(nr uint32) *SyscallPoint {
+func (this *SyscallConfig) FindSyscallPointByNR(nr uint32) *SyscallPoint {
     for _, point_arg := range this.PointArgs {
         if point_arg.Nr == nr {
             return point_arg
         }
     }
-    panic(fmt.Sprintf("unknown syscall nr:%d", nr))
+    return nil
+}
+
+func (this *SyscallConfig) GetSyscallPointByNR(nr uint32) *SyscallPoint {
+    point := this.FindSyscallPointByNR(nr)
+    if point == nil {
+        panic(fmt.Sprintf("unknown syscall nr:%d", nr))
+    }
+    return point
 }
 
 func (this *SyscallConfig) GetSyscallPointByName(name string) *SyscallPoint {
@@ -500,6 +614,9 @@ func (this *SyscallConfig) Parse_FileConfig(config *SyscallFileConfig) (err erro
             default:
                 panic(fmt.Sprintf("unknown point_type:%s", param.More))
             }
+            if param.UseRet() && point_type != EBPF_SYS_EXIT {
+                return errors.New(fmt.Sprintf("ret of %s is only available on sys_exit, plz set more to exit", point_config.Name))
+            }
             point_arg := param.GetPointArg(uint32(arg_index), point_type)
 
             a_p := point_arg.Clone()
@@ -733,16 +850,42 @@ type ModuleConfig struct {
     BrkType     uint32
     BrkKernel   bool
     Color       bool
-    DumpHandle  *os.File
+    DumpWriter  *DumpWriter
+    DumpSize    uint64
+    DumpPeriod  time.Duration
     FmtJson     bool
     DumpHex     bool
     ShowPC      bool
     ShowTime    bool
     ShowUid     bool
+    ShowPkg     bool
+    Lifecycle   bool
+    DumpDir     string
+    DumpBuf     bool
+    DumpMem     []*MemDumpConfig
+    PcapFile    string
+    Summary     bool
+    SummaryBy   string
+    SummaryTick uint32
+    FoldedFile  string
+    PprofFile   string
+    TraceFile   string
+    DbFile      string
+    Sqlite3     string
 
     Name            string
     StackUprobeConf *StackUprobeConfig
     SysCallConf     *SyscallConfig
+    ReplayConf      *ReplayConfig
+    TriggerConf     *TriggerConfig
+    ScopeConf       *ScopeConfig
+    SpawnConf       *SpawnConfig
+    ExecHooks       []*ExecHookConfig
+    PackageInfos    *util.PackageInfos
+    // 飞行记录按信号触发时 由 ebpf 输出进程收到的信号
+    SignalDeliver bool
+    // 由命令行设置 用于在 exec 之后挂载新程序的 hook
+    OnExec func(hook *ExecHookConfig, path string) error
 }
 
 func NewModuleConfig() *ModuleConfig {
@@ -753,7 +896,7 @@ func NewModuleConfig() *ModuleConfig {
     return config
 }
 
-func (this *ModuleConfig) InitCommonConfig(gconfig *GlobalConfig) {
+func (this *ModuleConfig) InitCommonConfig(gconfig *GlobalConfig) error {
 
     this.MaxOp = gconfig.MaxOp
     this.Buffer = gconfig.Buffer
@@ -774,6 +917,42 @@ func (this *ModuleConfig) InitCommonConfig(gconfig *GlobalConfig) {
     this.ShowPC = gconfig.ShowPC
     this.ShowTime = gconfig.ShowTime
     this.ShowUid = gconfig.ShowUid
+    this.ShowPkg = gconfig.ShowPkg
+    this.Lifecycle = gconfig.Lifecycle
+    this.DumpDir = gconfig.DumpDir
+    // buf 参数 内存转储以及 tls 明文都保存在这里 运行中途才发现无法创建就晚了
+    if err := os.MkdirAll(this.DumpDir, 0755); err != nil {
+        return fmt.Errorf("create dump dir %s failed, err:%v", this.DumpDir, err)
+    }
+    this.DumpBuf = gconfig.DumpBuf
+    this.PcapFile = gconfig.PcapFile
+    switch gconfig.SummaryBy {
+    case "", "pid", "tid", "comm":
+    default:
+        panic(fmt.Sprintf("unsupported summary key:%s, support pid/tid/comm", gconfig.SummaryBy))
+    }
+    this.Summary = gconfig.Summary
+    this.SummaryBy = gconfig.SummaryBy
+    this.SummaryTick = gconfig.SummaryTick
+    this.FoldedFile = gconfig.FoldedFile
+    this.PprofFile = gconfig.PprofFile
+    this.TraceFile = gconfig.TraceFile
+    this.DbFile = gconfig.DbFile
+    this.Sqlite3 = gconfig.Sqlite3
+    this.DumpSize = uint64(gconfig.DumpSize) * 1024 * 1024
+    this.DumpPeriod = time.Duration(gconfig.DumpPeriod) * time.Second
+    if (this.FoldedFile != "" || this.PprofFile != "") && !this.UnwindStack {
+        panic("--folded/--pprof need --stack")
+    }
+    if gconfig.DumpMem != "" {
+        dump_mem, err := ParseMemDumpList(strings.Split(gconfig.DumpMem, ","))
+        if err != nil {
+            panic(err)
+        }
+        this.DumpMem = dump_mem
+        // 计算地址需要完整的寄存器数据
+        this.ShowRegs = true
+    }
 
     this.AutoResume = gconfig.AutoResume
     this.KillSignal = util.ParseSignal(gconfig.KillSignal)
@@ -782,10 +961,26 @@ func (this *ModuleConfig) InitCommonConfig(gconfig *GlobalConfig) {
     this.StackUprobeConf = &StackUprobeConfig{}
     this.StackUprobeConf.SetDumpHex(this.DumpHex)
     this.StackUprobeConf.SetColor(this.Color)
+    this.StackUprobeConf.SetLogger(this.logger)
 
     this.SysCallConf = &SyscallConfig{}
     this.SysCallConf.SetDebug(this.Debug)
     this.SysCallConf.SetLogger(this.logger)
+    return nil
+}
+
+func (this *ModuleConfig) CheckDumpMem() error {
+    // 只有进程处于暂停状态 读取到的内存才是命中时的样子
+    stopped := this.KillSignal == uint32(syscall.SIGSTOP)
+    if len(this.DumpMem) > 0 && !stopped {
+        return errors.New("--dump-mem only works on stopped process, plz use with --kill SIGSTOP")
+    }
+    for _, point := range this.StackUprobeConf.Points {
+        if len(point.DumpMem) > 0 && !stopped && point.KillSignal != uint32(syscall.SIGSTOP) {
+            return fmt.Errorf("dump_mem of %s only works on stopped process, plz set signal SIGSTOP for it or use with --kill SIGSTOP", point.Name)
+        }
+    }
+    return nil
 }
 
 func (this *ModuleConfig) LoadConfig(gconfig *GlobalConfig) {
@@ -805,6 +1000,11 @@ func (this *ModuleConfig) LoadConfig(gconfig *GlobalConfig) {
         if err != nil {
             panic(err)
         }
+        gconfig.StartOn = append(gconfig.StartOn, base_config.StartOn...)
+        gconfig.StopOn = append(gconfig.StopOn, base_config.StopOn...)
+        if base_config.TriggerScope != "" {
+            gconfig.TriggerOn = base_config.TriggerScope
+        }
         switch base_config.Type {
         case "uprobe":
             config := &UprobeFileConfig{}
@@ -812,6 +1012,11 @@ func (this *ModuleConfig) LoadConfig(gconfig *GlobalConfig) {
             if err != nil {
                 panic(err)
             }
+            if config.Exec != "" {
+                // 等到 exec 之后才挂载
+                this.ExecHooks = append(this.ExecHooks, NewExecHookConfig(config))
+                continue
+            }
             err = gconfig.Parse_Libinfo(config.Library, this.StackUprobeConf)
             if err != nil {
                 panic(err)
@@ -820,6 +1025,12 @@ func (this *ModuleConfig) LoadConfig(gconfig *GlobalConfig) {
             if err != nil {
                 panic(err)
             }
+            for _, point := range this.StackUprobeConf.Points {
+                if len(point.DumpMem) > 0 {
+                    // 计算地址需要完整的寄存器数据
+                    this.ShowRegs = true
+                }
+            }
         case "syscall":
             config := &SyscallFileConfig{}
             err = json.Unmarshal(content, config)
@@ -908,9 +1119,40 @@ func (this *ModuleConfig) GetCommonFilter() CommonFilter {
     filter.trace_uid_group = this.TraceGroup
     filter.signal = this.KillSignal
     filter.tsignal = this.TKillSignal
+    if this.TriggerConf != nil {
+        filter.trigger_mode = this.TriggerConf.Scope
+        filter.trigger_init = this.TriggerConf.InitArmed()
+    }
+    if this.ScopeConf != nil {
+        filter.scope_mode = SCOPE_ENABLE
+    }
+    if this.SpawnConf != nil {
+        filter.spawn_uid = this.SpawnConf.Uid
+    }
+    if this.ExecStop() {
+        filter.exec_stop = 1
+    }
+    if this.Lifecycle {
+        filter.lifecycle = 1
+    }
+    if this.SignalDeliver {
+        filter.signal_deliver = 1
+    }
     return filter
 }
 
+func (this *ModuleConfig) FindPackageName(uid uint32) string {
+    if this.PackageInfos == nil {
+        return ""
+    }
+    // 多用户下 uid = user_id * 100000 + app_id 其他用户的进程也能对应到包名
+    is_find, info := this.PackageInfos.FindPackageByUid(uid % 100000)
+    if !is_find {
+        return ""
+    }
+    return info.Name
+}
+
 func (this *ModuleConfig) GetConfigMap() ConfigMap {
     config := ConfigMap{}
     config.stackplz_pid = this.SelfPid
@@ -921,6 +1163,11 @@ func (this *ModuleConfig) GetConfigMap() ConfigMap {
     return config
 }
 
+func (this *ModuleConfig) SkipEventLog() bool {
+    // 统计和聚合堆栈的模式下 不逐条输出事件
+    return this.Summary || this.FoldedFile != "" || this.PprofFile != ""
+}
+
 func (this *ModuleConfig) DumpOpen(dump_name string) {
     if dump_name == "" {
         return
@@ -928,17 +1175,19 @@ func (this *ModuleConfig) DumpOpen(dump_name string) {
     dir, _ := os.Getwd()
     dump_path := dir + "/" + dump_name
     // 提前打开文件
-    f, err := os.Create(dump_path)
+    writer, err := NewDumpWriter(dump_path, this.DumpSize, this.DumpPeriod)
     if err != nil {
-        panic("create dump file failed...")
+        panic(fmt.Sprintf("create dump file failed, err:%v", err))
     }
-    this.DumpHandle = f
+    this.DumpWriter = writer
 }
 
 func (this *ModuleConfig) DumpClose() {
     // 关闭文件
-    if this.DumpHandle != nil {
-        err := this.DumpHandle.Close()
+    if this.DumpWriter != nil {
+        file_lock.Lock()
+        defer file_lock.Unlock()
+        err := this.DumpWriter.Close()
         if err != nil {
             panic(err)
         }
@@ -949,7 +1198,7 @@ var file_lock sync.Mutex
 
 func (this *ModuleConfig) DumpRecord(event_index uint8, rec *perf.Record) bool {
     // 返回  是否需要dump
-    if this.DumpHandle == nil {
+    if this.DumpWriter == nil {
         return false
     }
     // 将采集的数据按下面的格式进行记录
@@ -957,25 +1206,31 @@ func (this *ModuleConfig) DumpRecord(event_index uint8, rec *perf.Record) bool {
     total_len := uint32(1)
     rec_len := uint32(len(rec.RawSample))
     total_len += 4 + 4 + rec_len
+    // 整条记录一次写入 切分分段时不会把一条记录拆开
+    data := make([]byte, 4+total_len)
+    binary.LittleEndian.PutUint32(data[0:], total_len)
+    data[4] = event_index
+    binary.LittleEndian.PutUint32(data[5:], rec.RecordType)
+    binary.LittleEndian.PutUint32(data[9:], rec_len)
+    copy(data[13:], rec.RawSample)
+
     file_lock.Lock()
     defer file_lock.Unlock()
-
-    var err error
-    if err = binary.Write(this.DumpHandle, binary.LittleEndian, total_len); err != nil {
+    if err := this.DumpWriter.WriteRecord(data); err != nil {
         panic(err)
     }
-    if err = binary.Write(this.DumpHandle, binary.LittleEndian, event_index); err != nil {
-        panic(err)
-    }
-    if err = binary.Write(this.DumpHandle, binary.LittleEndian, rec.RecordType); err != nil {
-        panic(err)
-    }
-    if err = binary.Write(this.DumpHandle, binary.LittleEndian, rec_len); err != nil {
-        panic(err)
-    }
-    if err = binary.Write(this.DumpHandle, binary.LittleEndian, rec.RawSample); err != nil {
-        panic(err)
-    }
-
     return true
 }
+
+func (this *ModuleConfig) GetDumpPath(file_name string) string {
+    // 目录在 InitCommonConfig 中已经创建
+    return filepath.Join(this.DumpDir, file_name)
+}
+
+func (this *ModuleConfig) SaveDumpFile(file_name string, data []byte) {
+    // 保存 buf 参数或者内存转储的数据 每次命中单独一个文件
+    file_path := this.GetDumpPath(file_name)
+    if err := ioutil.WriteFile(file_path, data, 0644); err != nil {
+        this.logger.Printf("save %s failed, err:%v", file_path, err)
+    }
+}
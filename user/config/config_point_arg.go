@@ -2,8 +2,12 @@ package config
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"stackplz/user/argtype"
 	. "stackplz/user/common"
+	"stackplz/user/util"
+	"strings"
 )
 
 type PointArg struct {
@@ -14,12 +18,17 @@ type PointArg struct {
 	FilterIndexList []uint32 `json:"-"`
 	PointType       uint32   `json:"-"`
 	GroupType       uint32   `json:"-"`
+	DumpFile        bool     `json:"-"`
 }
 
 func (this *PointArg) GetTypeName() string {
 	return argtype.GetArgType(this.TypeIndex).GetName()
 }
 
+func (this *PointArg) IsString() bool {
+	return this.TypeIndex == STRING || this.TypeIndex == STD_STRING || argtype.IsUtf16Type(this.TypeIndex)
+}
+
 func (this *PointArg) SetRegIndex(reg_index uint32) {
 	this.RegIndex = reg_index
 }
@@ -73,6 +82,10 @@ func (this *PointArg) SetHexFormat() {
 	this.TypeIndex = argtype.R_NUM_HEX(this.TypeIndex).GetTypeIndex()
 }
 
+func (this *PointArg) SetDecodeFormat(format string, msg *util.PbMessage) {
+	this.TypeIndex = argtype.R_BUFFER_DECODE(this.TypeIndex, format, msg).GetTypeIndex()
+}
+
 func (this *PointArg) ToPointerType() {
 	// 暂时仅限数字类型
 	at := argtype.GetArgType(this.TypeIndex)
@@ -92,6 +105,10 @@ func (this *PointArg) SetGroupType(group_type uint32) {
 	this.GroupType = group_type
 }
 
+func (this *PointArg) SetDumpFile(dump_file bool) {
+	this.DumpFile = dump_file
+}
+
 func (this *PointArg) SetPointType(point_type uint32) {
 	this.PointType = point_type
 }
@@ -100,20 +117,134 @@ func (this *PointArg) ReadMore() bool {
 	return this.PointType == EBPF_SYS_ALL || this.PointType == this.GroupType
 }
 
-func (this *PointArg) Parse(ptr uint64, buf *bytes.Buffer, point_type uint32) string {
+func (this *PointArg) Parse(proc *argtype.ProcessContext, ptr uint64, buf *bytes.Buffer, point_type uint32) string {
+	// proc 为 nil 时只是跳过参数的数据 或者不需要按进程解析
 	parse_more := false
 	if this.PointType == EBPF_SYS_ALL || this.PointType == point_type {
 		parse_more = true
 	}
-	return argtype.GetArgType(this.TypeIndex).Parse(ptr, buf, parse_more)
+	at := argtype.GetArgType(this.TypeIndex)
+	if result, ok := argtype.ParseProcess(at, proc, ptr, buf, parse_more); ok {
+		return result
+	}
+	return at.Parse(ptr, buf, parse_more)
 }
 
-func (this *PointArg) ParseJson(ptr uint64, buf *bytes.Buffer, point_type uint32) any {
+func (this *PointArg) ParseJson(proc *argtype.ProcessContext, ptr uint64, buf *bytes.Buffer, point_type uint32) any {
 	parse_more := false
 	if this.PointType == EBPF_SYS_ALL || this.PointType == point_type {
 		parse_more = true
 	}
-	return argtype.GetArgType(this.TypeIndex).ParseJson(ptr, buf, parse_more)
+	at := argtype.GetArgType(this.TypeIndex)
+	if result, ok := argtype.ParseProcess(at, proc, ptr, buf, parse_more); ok {
+		return result
+	}
+	return at.ParseJson(ptr, buf, parse_more)
+}
+
+func (this *PointArg) HasPayload(point_type uint32) bool {
+	// 只有读取了数据 并且类型支持的时候才有原始数据
+	if this.PointType != EBPF_SYS_ALL && this.PointType != point_type {
+		return false
+	}
+	return argtype.GetArgType(this.TypeIndex).HasPayload()
+}
+
+func (this *PointArg) CanDump(point_type uint32, dump_all bool) bool {
+	if !this.DumpFile && !dump_all {
+		return false
+	}
+	return this.HasPayload(point_type)
+}
+
+type ArgPayload struct {
+	Name     string
+	Payloads [][]byte
+}
+
+func ReadArgPayloads(point_args []*PointArg, buf *bytes.Buffer, point_type uint32, dump_all bool) ([]ArgPayload, error) {
+	var results []ArgPayload
+	need_dump := false
+	for _, point_arg := range point_args {
+		if point_arg.CanDump(point_type, dump_all) {
+			need_dump = true
+			break
+		}
+	}
+	if !need_dump {
+		return results, nil
+	}
+	// 在副本上预先过一遍 这样不影响后面正常的解析
+	tmp_buf := bytes.NewBuffer(buf.Bytes())
+	for _, point_arg := range point_args {
+		var ptr argtype.Arg_reg
+		if err := binary.Read(tmp_buf, binary.LittleEndian, &ptr); err != nil {
+			return nil, fmt.Errorf("read %s failed, err:%v", point_arg.Name, err)
+		}
+		if point_arg.CanDump(point_type, dump_all) {
+			payloads, err := argtype.GetArgType(point_arg.TypeIndex).ReadPayloads(tmp_buf)
+			if err != nil {
+				return nil, fmt.Errorf("read %s payloads failed, err:%v", point_arg.Name, err)
+			}
+			results = append(results, ArgPayload{point_arg.Name, payloads})
+		} else {
+			point_arg.Parse(nil, ptr.Address, tmp_buf, point_type)
+		}
+	}
+	return results, nil
+}
+
+type ArgValue struct {
+	Name      string
+	TypeIndex uint32
+	Value     uint64
+	Payloads  [][]byte
+	Text      string
+}
+
+func (this *ArgValue) GetTypeName() string {
+	return argtype.GetArgType(this.TypeIndex).GetName()
+}
+
+func ReadArgValues(point_args []*PointArg, buf *bytes.Buffer, point_type uint32) ([]ArgValue, error) {
+	// 与 ReadArgPayloads 一样在副本上过一遍 取寄存器的值 原始数据以及格式化后的文本
+	var results []ArgValue
+	tmp_buf := bytes.NewBuffer(buf.Bytes())
+	for _, point_arg := range point_args {
+		var ptr argtype.Arg_reg
+		if err := binary.Read(tmp_buf, binary.LittleEndian, &ptr); err != nil {
+			return nil, fmt.Errorf("read %s failed, err:%v", point_arg.Name, err)
+		}
+		// 先格式化 再按格式化消耗的长度取出原始数据
+		rest := tmp_buf.Bytes()
+		text_buf := bytes.NewBuffer(rest)
+		text := point_arg.Parse(nil, ptr.Address, text_buf, point_type)
+		arg_data := bytes.NewBuffer(tmp_buf.Next(len(rest) - text_buf.Len()))
+		var payloads [][]byte
+		var err error
+		if point_arg.HasPayload(point_type) {
+			payloads, err = argtype.GetArgType(point_arg.TypeIndex).ReadPayloads(arg_data)
+		} else if point_arg.IsString() && (point_arg.PointType == EBPF_SYS_ALL || point_arg.PointType == point_type) {
+			// 字符串的内容也作为原始数据 方便预设使用
+			var payload []byte
+			payload, err = argtype.ReadStringPayload(point_arg.TypeIndex, arg_data)
+			payloads = [][]byte{payload}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s payloads failed, err:%v", point_arg.Name, err)
+		}
+		results = append(results, ArgValue{point_arg.Name, point_arg.TypeIndex, ptr.Address, payloads, text})
+	}
+	return results, nil
+}
+
+func FormatArgValues(arg_values []ArgValue) string {
+	// 与 ParseEnterPoint/ParseExitPoint 的输出格式保持一致
+	var results []string
+	for _, arg_value := range arg_values {
+		results = append(results, fmt.Sprintf("%s=%s", arg_value.Name, arg_value.Text))
+	}
+	return "(" + strings.Join(results, ", ") + ")"
 }
 
 func (this *PointArg) GetOpList() []uint32 {
@@ -131,7 +262,7 @@ func (this *PointArg) GetOpList() []uint32 {
 		op_list = append(op_list, argtype.OPC_MOVE_REG_VALUE.Index)
 	}
 
-	if this.TypeIndex != STRING && this.TypeIndex != STD_STRING {
+	if !this.IsString() {
 		for _, v := range this.FilterIndexList {
 			filter_op := argtype.OPC_FILTER_VALUE.NewValue(uint64(v))
 			op_list = append(op_list, filter_op.Index)
@@ -139,13 +270,15 @@ func (this *PointArg) GetOpList() []uint32 {
 	}
 
 	if this.ReadMore() {
-		for _, op_key := range argtype.GetOpKeyList(this.TypeIndex) {
-			op_list = append(op_list, op_key)
-			if this.TypeIndex == STRING || this.TypeIndex == STD_STRING {
-				for _, v := range this.FilterIndexList {
-					filter_op := argtype.OPC_FILTER_STRING.NewValue(uint64(v))
-					op_list = append(op_list, filter_op.Index)
+		op_list = append(op_list, argtype.GetOpKeyList(this.TypeIndex)...)
+		// 字符串读取完成之后再比较 UTF-16 字符串使用转换后的规则
+		if this.IsString() {
+			for _, v := range this.FilterIndexList {
+				if argtype.IsUtf16Type(this.TypeIndex) {
+					v = GetUtf16FilterIndex(v)
 				}
+				filter_op := argtype.OPC_FILTER_STRING.NewValue(uint64(v))
+				op_list = append(op_list, filter_op.Index)
 			}
 		}
 	}
@@ -159,6 +292,7 @@ func (this *PointArg) Clone() *PointArg {
 	p.TypeIndex = this.TypeIndex
 	p.PointType = this.PointType
 	p.FilterIndexList = this.FilterIndexList
+	p.DumpFile = this.DumpFile
 	return &p
 }
 
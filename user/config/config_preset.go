@@ -0,0 +1,579 @@
+package config
+
+import (
+	"archive/zip"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"stackplz/user/argtype"
+	. "stackplz/user/common"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const PRESET_TLS = "tls"
+const PRESET_LINKER = "linker"
+const PRESET_JNI = "jni"
+
+// 应用自带的 BoringSSL/OpenSSL/Conscrypt 常见的库名
+var TlsAppLibraries = []string{
+	"libssl.so",
+	"libboringssl.so",
+	"libssl.so.1.1",
+	"libssl.so.3",
+	"libconscrypt_jni.so",
+	"libconscrypt_openjdk_jni.so",
+}
+
+// Conscrypt 模块的 libssl.so 即 Java 层 HTTPS 使用的库 其次是 native 使用的系统库
+var TlsSystemLibraries = []string{
+	"/apex/com.android.conscrypt/lib64/libssl.so",
+	"/system/lib64/libssl.so",
+}
+
+// 不同版本 libart.so 所在的位置
+var JniLibraries = []string{
+	"/apex/com.android.art/lib64/libart.so",
+	"/apex/com.android.runtime/lib64/libart.so",
+	"/system/lib64/libart.so",
+}
+
+var SystemLibPrefixes = []string{"/system/", "/system_ext/", "/apex/", "/vendor/", "/product/"}
+
+// 每个库最多使用的 hook 点 按优先级排列 至少要有 SSL_read SSL_write
+var TlsSymbols = []string{"SSL_read", "SSL_write", "SSL_set_fd", "SSL_get_fd", "SSL_free"}
+
+func IsSystemLib(path string) bool {
+	for _, prefix := range SystemLibPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func FindElfSymbols(path string, symbols []string) []string {
+	// 返回库中存在的符号 只关心导出的符号 去除了符号表的库也能用
+	var results []string
+	f, err := elf.Open(path)
+	if err != nil {
+		return results
+	}
+	defer f.Close()
+	dynsyms, err := f.DynamicSymbols()
+	if err != nil {
+		return results
+	}
+	for _, sym := range dynsyms {
+		if sym.Section == elf.SHN_UNDEF || elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
+			continue
+		}
+		if slices.Contains(symbols, sym.Name) && !slices.Contains(results, sym.Name) {
+			results = append(results, sym.Name)
+		}
+	}
+	return results
+}
+
+func (this *GlobalConfig) FindTlsLibraries() []*StackUprobeConfig {
+	// 按 应用自带的库 -> apk 中未解压的库 -> 系统库 的顺序查找
+	var libs []*StackUprobeConfig
+	var real_paths []string
+	add_lib := func(lib *StackUprobeConfig) {
+		key := fmt.Sprintf("%s(0x%x)", lib.RealFilePath, lib.NonElfOffset)
+		if slices.Contains(real_paths, key) {
+			return
+		}
+		real_paths = append(real_paths, key)
+		libs = append(libs, lib)
+	}
+	for _, lib_name := range TlsAppLibraries {
+		for _, search_path := range this.LibraryDirs {
+			if strings.HasSuffix(search_path, ".apk") || IsSystemLib(search_path) {
+				continue
+			}
+			real_path, err := filepath.EvalSymlinks(filepath.Join(search_path, lib_name))
+			if err != nil {
+				continue
+			}
+			lib := &StackUprobeConfig{}
+			lib.LibPath = real_path
+			lib.RealFilePath = real_path
+			add_lib(lib)
+		}
+		lib := &StackUprobeConfig{}
+		if err := this.FindLibInApk(lib_name, lib); err == nil {
+			add_lib(lib)
+		}
+	}
+	for _, lib_path := range TlsSystemLibraries {
+		real_path, err := filepath.EvalSymlinks(lib_path)
+		if err != nil {
+			continue
+		}
+		lib := &StackUprobeConfig{}
+		lib.LibPath = real_path
+		lib.RealFilePath = real_path
+		add_lib(lib)
+	}
+	return libs
+}
+
+func (this *GlobalConfig) Parse_Preset(sconfig *StackUprobeConfig, use_library bool) error {
+	// 多个预设用 , 隔开 use_library 表示通过 -l/--lib 指定了要 hook 的库 那么只处理这一个
+	for _, preset := range strings.Split(this.Preset, ",") {
+		var libs []*StackUprobeConfig
+		if use_library {
+			lib := &StackUprobeConfig{}
+			if err := this.Parse_Libinfo(this.Library, lib); err != nil {
+				return err
+			}
+			libs = append(libs, lib)
+		}
+		switch preset {
+		case PRESET_TLS:
+			if !use_library {
+				libs = this.FindTlsLibraries()
+			}
+			if err := sconfig.Parse_TlsPreset(libs); err != nil {
+				return err
+			}
+		case PRESET_LINKER:
+			if !use_library {
+				lib := &StackUprobeConfig{}
+				if err := this.Parse_Libinfo("linker64", lib); err != nil {
+					return err
+				}
+				libs = append(libs, lib)
+			}
+			if err := sconfig.Parse_LinkerPreset(libs[0]); err != nil {
+				return err
+			}
+		case PRESET_JNI:
+			if !use_library {
+				for _, lib_path := range JniLibraries {
+					real_path, err := filepath.EvalSymlinks(lib_path)
+					if err != nil {
+						continue
+					}
+					lib := &StackUprobeConfig{}
+					lib.LibPath = real_path
+					lib.RealFilePath = real_path
+					libs = append(libs, lib)
+					break
+				}
+				if len(libs) == 0 {
+					return errors.New("can not find libart.so, plz set with -l/--lib")
+				}
+			}
+			if err := sconfig.Parse_JniPreset(libs[0]); err != nil {
+				return err
+			}
+		default:
+			return errors.New(fmt.Sprintf("unsupported preset:%s, support: %s", preset, strings.Join([]string{PRESET_TLS, PRESET_LINKER, PRESET_JNI}, ",")))
+		}
+	}
+	return nil
+}
+
+func (this *StackUprobeConfig) newPresetPoint(lib *StackUprobeConfig, symbol, args_str string) *UprobeArgs {
+	hook_point := &UprobeArgs{}
+	hook_point.Index = uint32(len(this.Points))
+	hook_point.LibPath = lib.LibPath
+	hook_point.RealFilePath = lib.RealFilePath
+	hook_point.NonElfOffset = lib.NonElfOffset
+	hook_point.Name = symbol
+	hook_point.Symbol = symbol
+	hook_point.ArgsStr = args_str
+	return hook_point
+}
+
+func (this *StackUprobeConfig) addPresetArg(hook_point *UprobeArgs, arg_name, arg_str string) error {
+	// 参数写法和命令行一致 默认按参数顺序使用寄存器
+	point_arg := NewUprobePointArg(arg_name, POINTER, uint32(len(hook_point.PointArgs)))
+	if err := this.ParseArgType(arg_str, point_arg); err != nil {
+		return err
+	}
+	hook_point.PointArgs = append(hook_point.PointArgs, point_arg)
+	if hook_point.ArgsStr != "" {
+		hook_point.ArgsStr += ","
+	}
+	hook_point.ArgsStr += arg_str
+	return nil
+}
+
+func (this *StackUprobeConfig) addPresetPoint(lib *StackUprobeConfig, name, symbol, preset string, is_ret bool, args [][2]string) error {
+	// linker 中的符号比较长 输出时使用 name 代替
+	hook_point := this.newPresetPoint(lib, symbol, "")
+	hook_point.Name = name
+	for _, arg := range args {
+		if err := this.addPresetArg(hook_point, arg[0], arg[1]); err != nil {
+			return err
+		}
+	}
+	if is_ret {
+		hook_point.SetReturn()
+	}
+	hook_point.Preset = preset
+	this.Points = append(this.Points, hook_point)
+	return nil
+}
+
+func (this *StackUprobeConfig) Parse_TlsPreset(libs []*StackUprobeConfig) error {
+	// SSL_read/SSL_write 都在返回时读取 x1 处返回值大小的数据 即实际收发的明文
+	// SSL_get_fd/SSL_set_fd 用于关联 SSL 对象与 socket fd SSL_free 表示连接结束
+	buf_type := argtype.R_BUFFER_REG(REG_ARM64_RET)
+	buf_type.SetDumpHex(this.DumpHex)
+	buf_type.SetColor(this.Color)
+	var skipped []string
+	point_count := len(this.Points)
+	for _, lib := range libs {
+		symbols := FindElfSymbols(lib.LibPath, TlsSymbols)
+		if !slices.Contains(symbols, "SSL_read") || !slices.Contains(symbols, "SSL_write") {
+			continue
+		}
+		// hook 点数量有限 放不下的库跳过 放不下的辅助符号也跳过
+		if len(this.Points)+2 > MAX_UPROBE_POINT_COUNT {
+			skipped = append(skipped, lib.LibPath)
+			continue
+		}
+		var missing []string
+		for _, symbol := range TlsSymbols {
+			if !slices.Contains(symbols, symbol) {
+				continue
+			}
+			if len(this.Points) >= MAX_UPROBE_POINT_COUNT {
+				missing = append(missing, symbol)
+				continue
+			}
+			this.addTlsPoint(lib, symbol, buf_type)
+		}
+		if len(missing) > 0 && this.logger != nil {
+			this.logger.Printf("warn, max uprobe hook point count is %d, skip %s of %s", MAX_UPROBE_POINT_COUNT, strings.Join(missing, ","), lib.LibPath)
+		}
+	}
+	if len(this.Points) == point_count {
+		if len(skipped) > 0 {
+			return errors.New(fmt.Sprintf("max uprobe hook point count is %d, plz choose one with -l/--lib from\n\t%s", MAX_UPROBE_POINT_COUNT, strings.Join(skipped, "\n\t")))
+		}
+		return errors.New("can not find any library exporting SSL_read/SSL_write, plz set with -l/--lib")
+	}
+	if len(skipped) > 0 && this.logger != nil {
+		this.logger.Printf("warn, max uprobe hook point count is %d, skip\n\t%s", MAX_UPROBE_POINT_COUNT, strings.Join(skipped, "\n\t"))
+	}
+	return nil
+}
+
+func (this *StackUprobeConfig) addTlsPoint(lib *StackUprobeConfig, symbol string, buf_type argtype.IArgType) {
+	hook_point := this.newPresetPoint(lib, symbol, "ptr")
+	hook_point.PointArgs = append(hook_point.PointArgs, NewUprobePointArg("ssl", POINTER, REG_ARM64_X0))
+	switch symbol {
+	case "SSL_read", "SSL_write":
+		hook_point.ArgsStr = "ptr,buf:ret"
+		buf_arg := NewUprobePointArg("buf", POINTER, REG_ARM64_X1)
+		buf_arg.SetTypeIndex(buf_type.GetTypeIndex())
+		buf_arg.SetGroupType(EBPF_UPROBE_ENTER)
+		hook_point.PointArgs = append(hook_point.PointArgs, buf_arg)
+		hook_point.SetReturn()
+	case "SSL_get_fd":
+		hook_point.SetReturn()
+		// 返回值就是 fd
+		hook_point.PointArgs[len(hook_point.PointArgs)-1].SetTypeIndex(FD)
+	case "SSL_set_fd":
+		hook_point.ArgsStr = "ptr,fd"
+		hook_point.PointArgs = append(hook_point.PointArgs, NewUprobePointArg("fd", FD, REG_ARM64_X1))
+	}
+	hook_point.Preset = PRESET_TLS
+	this.Points = append(this.Points, hook_point)
+}
+
+// soinfo 中字段的偏移 不同版本的 linker 不一样
+type SoinfoOffsets struct {
+	Realpath      uint64
+	RealpathIsStd bool
+	Soname        uint64
+	SonameIsStd   bool
+}
+
+// 64 位下 soinfo 起始依次是 phdr phnum base size 这几个字段一直没有变化
+const SOINFO_BASE_OFFSET = 16
+
+func FindElfSymbol(f *elf.File, prefix, contains string) (elf.Symbol, bool) {
+	// linker64 带有完整的符号表 函数名以 __dl_ 开头
+	syms, err := f.Symbols()
+	if err != nil {
+		return elf.Symbol{}, false
+	}
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		if strings.HasPrefix(sym.Name, prefix) && strings.Contains(sym.Name, contains) {
+			return sym, true
+		}
+	}
+	return elf.Symbol{}, false
+}
+
+func ReadElfSymbolCode(f *elf.File, sym elf.Symbol) []byte {
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sym.Value < sec.Addr || sym.Value >= sec.Addr+sec.Size {
+			continue
+		}
+		size := sym.Size
+		if size == 0 || size > 0x100 {
+			size = 0x100
+		}
+		code := make([]byte, size)
+		n, _ := sec.ReadAt(code, int64(sym.Value-sec.Addr))
+		return code[:n-n%4]
+	}
+	return nil
+}
+
+func ParseFieldAccess(code []byte) (uint64, bool, bool) {
+	// 解析形如 soinfo::get_realpath 这样的访问函数 找到相对于 x0 即 this 的最小偏移
+	// std::string (libc++) 会先用 ldrb 读取首字节判断长短字符串 const char* 则是直接 ldr
+	// 返回 偏移 是否为 std::string 是否找到
+	var offset uint64 = 0
+	found := false
+	is_std := false
+	update := func(off uint64) {
+		if !found || off < offset {
+			offset = off
+		}
+		found = true
+	}
+	for i := 0; i+4 <= len(code); i += 4 {
+		ins := binary.LittleEndian.Uint32(code[i:])
+		if ins == 0xD65F03C0 {
+			// ret
+			break
+		}
+		rn := (ins >> 5) & 0x1f
+		if rn != 0 {
+			continue
+		}
+		imm12 := uint64((ins >> 10) & 0xfff)
+		switch {
+		case ins&0xFFC00000 == 0x39400000:
+			// ldrb wt, [x0, #imm]
+			update(imm12)
+			is_std = true
+		case ins&0xFFC00000 == 0xF9400000:
+			// ldr xt, [x0, #imm]
+			update(imm12 * 8)
+		case ins&0xFF800000 == 0x91000000:
+			// add xd, x0, #imm{, lsl #12}
+			if (ins>>22)&1 == 1 {
+				imm12 <<= 12
+			}
+			update(imm12)
+		}
+	}
+	return offset, is_std, found
+}
+
+func DetectSoinfoOffsets(f *elf.File) (*SoinfoOffsets, error) {
+	offsets := &SoinfoOffsets{}
+	sym, ok := FindElfSymbol(f, "__dl__ZNK6soinfo12get_realpathEv", "")
+	if !ok {
+		return nil, errors.New("can not find soinfo::get_realpath")
+	}
+	var found bool
+	offsets.Realpath, offsets.RealpathIsStd, found = ParseFieldAccess(ReadElfSymbolCode(f, sym))
+	if !found {
+		return nil, errors.New("parse soinfo::get_realpath failed")
+	}
+	sym, ok = FindElfSymbol(f, "__dl__ZNK6soinfo10get_sonameEv", "")
+	if !ok {
+		return nil, errors.New("can not find soinfo::get_soname")
+	}
+	offsets.Soname, offsets.SonameIsStd, found = ParseFieldAccess(ReadElfSymbolCode(f, sym))
+	if !found {
+		return nil, errors.New("parse soinfo::get_soname failed")
+	}
+	return offsets, nil
+}
+
+func soinfoStringArg(offset uint64, is_std bool) string {
+	if is_std {
+		return fmt.Sprintf("std:x0+%d", offset)
+	}
+	return fmt.Sprintf("str:x0+%d.", offset)
+}
+
+func (this *StackUprobeConfig) Parse_LinkerPreset(lib *StackUprobeConfig) error {
+	// do_dlopen 覆盖了 dlopen 和 android_dlopen_ext
+	// do_dlsym 在返回时读取 x4 即 void** symbol 得到解析结果
+	// call_constructors 读取 soinfo 中的路径、名称和基址
+	// call_array 读取 init_array 的地址和数量 每个函数的地址在解析事件时读取
+	f, err := elf.Open(lib.LibPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(this.Points)+4 > MAX_UPROBE_POINT_COUNT {
+		return errors.New(fmt.Sprintf("max uprobe hook point count is %d", MAX_UPROBE_POINT_COUNT))
+	}
+	point_count := len(this.Points)
+	if sym, ok := FindElfSymbol(f, "__dl__Z9do_dlopen", ""); ok {
+		args := [][2]string{{"name", "str"}, {"flags", "intx"}, {"extinfo", "ptr"}, {"caller", "ptr"}}
+		if err := this.addPresetPoint(lib, "do_dlopen", sym.Name, PRESET_LINKER, false, args); err != nil {
+			return err
+		}
+	}
+	if sym, ok := FindElfSymbol(f, "__dl__Z8do_dlsym", ""); ok {
+		args := [][2]string{{"handle", "ptr"}, {"symbol", "str"}, {"version", "str"}, {"caller", "ptr"}, {"sym", "*ptr"}}
+		if err := this.addPresetPoint(lib, "do_dlsym", sym.Name, PRESET_LINKER, true, args); err != nil {
+			return err
+		}
+	}
+	if sym, ok := FindElfSymbol(f, "__dl__ZN6soinfo17call_constructorsEv", ""); ok {
+		args := [][2]string{{"soinfo", "ptr"}, {"base", fmt.Sprintf("*uint64x:x0+%d", SOINFO_BASE_OFFSET)}}
+		offsets, err := DetectSoinfoOffsets(f)
+		if err == nil {
+			args = append(args, [2]string{"realpath", soinfoStringArg(offsets.Realpath, offsets.RealpathIsStd)})
+			args = append(args, [2]string{"soname", soinfoStringArg(offsets.Soname, offsets.SonameIsStd)})
+		}
+		if err := this.addPresetPoint(lib, "call_constructors", sym.Name, PRESET_LINKER, false, args); err != nil {
+			return err
+		}
+	}
+	// 只关心构造函数 即 linker_ctor_function_t 对应的模板实例
+	if sym, ok := FindElfSymbol(f, "__dl__ZL10call_array", "PFviPPcS1_E"); ok {
+		args := [][2]string{{"array_name", "str"}, {"functions", "ptr"}, {"count", "int"}, {"reverse", "int"}, {"realpath", "str"}}
+		if err := this.addPresetPoint(lib, "call_array", sym.Name, PRESET_LINKER, false, args); err != nil {
+			return err
+		}
+	} else if sym, ok := FindElfSymbol(f, "__dl__ZN6soinfo10call_array", ""); ok {
+		// 早期版本 call_array 是 soinfo 的成员函数
+		args := [][2]string{{"soinfo", "ptr"}, {"array_name", "str"}, {"functions", "ptr"}, {"count", "int"}, {"reverse", "int"}}
+		if err := this.addPresetPoint(lib, "call_array", sym.Name, PRESET_LINKER, false, args); err != nil {
+			return err
+		}
+	}
+	if len(this.Points) == point_count {
+		return errors.New(fmt.Sprintf("can not find any linker symbols in %s", lib.LibPath))
+	}
+	return nil
+}
+
+func FindElfSymbolNames(f *elf.File, prefix, contains string) []string {
+	// 导出符号和符号表都查找 libart.so 通常只有前者
+	var results []string
+	dynsyms, _ := f.DynamicSymbols()
+	syms, _ := f.Symbols()
+	for _, sym := range append(dynsyms, syms...) {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		if strings.HasPrefix(sym.Name, prefix) && strings.Contains(sym.Name, contains) && !slices.Contains(results, sym.Name) {
+			results = append(results, sym.Name)
+		}
+	}
+	return results
+}
+
+func FindSymbolFileOffset(lib_path, symbol string) (string, uint64, error) {
+	// 返回库在 maps 中对应的路径 以及符号在该文件中的偏移
+	// 对于 base.apk!/lib/arm64-v8a/libxxx.so 这样未解压的库 路径是 apk 偏移要加上库在 apk 中的偏移
+	var reader io.ReaderAt
+	var map_path = lib_path
+	var data_offset uint64 = 0
+	if items := strings.SplitN(lib_path, "!/", 2); len(items) == 2 {
+		map_path = items[0]
+		zf, err := zip.OpenReader(map_path)
+		if err != nil {
+			return "", 0, err
+		}
+		defer zf.Close()
+		for _, zip_file := range zf.File {
+			if zip_file.Name != items[1] {
+				continue
+			}
+			if zip_file.Method != zip.Store {
+				return "", 0, errors.New(fmt.Sprintf("%s is compressed", lib_path))
+			}
+			offset, err := zip_file.DataOffset()
+			if err != nil {
+				return "", 0, err
+			}
+			data_offset = uint64(offset)
+			apk_file, err := os.Open(map_path)
+			if err != nil {
+				return "", 0, err
+			}
+			defer apk_file.Close()
+			reader = io.NewSectionReader(apk_file, offset, int64(zip_file.UncompressedSize64))
+			break
+		}
+		if reader == nil {
+			return "", 0, errors.New(fmt.Sprintf("can not find %s", lib_path))
+		}
+	} else {
+		lib_file, err := os.Open(lib_path)
+		if err != nil {
+			return "", 0, err
+		}
+		defer lib_file.Close()
+		reader = lib_file
+	}
+	f, err := elf.NewFile(reader)
+	if err != nil {
+		return "", 0, err
+	}
+	dynsyms, err := f.DynamicSymbols()
+	if err != nil {
+		return "", 0, err
+	}
+	for _, sym := range dynsyms {
+		if sym.Name != symbol || sym.Section == elf.SHN_UNDEF {
+			continue
+		}
+		// 虚拟地址转换为文件偏移
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_LOAD && sym.Value >= prog.Vaddr && sym.Value < prog.Vaddr+prog.Memsz {
+				return map_path, data_offset + sym.Value - prog.Vaddr + prog.Off, nil
+			}
+		}
+	}
+	return "", 0, errors.New(fmt.Sprintf("can not find %s in %s", symbol, lib_path))
+}
+
+func (this *StackUprobeConfig) Parse_JniPreset(lib *StackUprobeConfig) error {
+	// RegisterNatives 读取 JNINativeMethod 数组 不同版本中 JNI 可能是模板类 所以会有多个实例
+	// LoadNativeLibrary 在返回时 即 JNI_OnLoad 已经执行完成之后 补充 JNI_OnLoad 的地址
+	f, err := elf.Open(lib.LibPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	point_count := len(this.Points)
+	register_symbols := FindElfSymbolNames(f, "_ZN3art3JNI", "15RegisterNativesEP7_JNIEnvP7_jclassPK15JNINativeMethodi")
+	load_symbols := FindElfSymbolNames(f, "_ZN3art9JavaVMExt17LoadNativeLibraryE", "")
+	if len(this.Points)+len(register_symbols)+len(load_symbols) > MAX_UPROBE_POINT_COUNT {
+		return errors.New(fmt.Sprintf("max uprobe hook point count is %d", MAX_UPROBE_POINT_COUNT))
+	}
+	for _, symbol := range register_symbols {
+		args := [][2]string{{"env", "ptr"}, {"clazz", "ptr"}, {"methods", "jni_methods"}, {"count", "int"}}
+		if err := this.addPresetPoint(lib, "RegisterNatives", symbol, PRESET_JNI, false, args); err != nil {
+			return err
+		}
+	}
+	for _, symbol := range load_symbols {
+		args := [][2]string{{"vm", "ptr"}, {"env", "ptr"}, {"path", "std"}, {"class_loader", "ptr"}}
+		if err := this.addPresetPoint(lib, "LoadNativeLibrary", symbol, PRESET_JNI, true, args); err != nil {
+			return err
+		}
+	}
+	if len(this.Points) == point_count {
+		return errors.New(fmt.Sprintf("can not find RegisterNatives in %s", lib.LibPath))
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"stackplz/user/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// 解析 dump 文件时 ebpf 程序中的过滤都不会生效 所以在用户态按相同的逻辑重新过滤一遍
+type ReplayConfig struct {
+	PointNames []string
+	since      replayTime
+	until      replayTime
+	first_ts   uint64
+	fork_list  map[uint32]bool
+	skip_enter map[uint32]bool
+}
+
+type replayTime struct {
+	enable bool
+	ts     uint64
+	// 相对于 dump 中第一个事件的时间 而不是开机时间
+	offset time.Duration
+	is_rel bool
+}
+
+func parseReplayTime(text string) (replayTime, error) {
+	// 纯数字认为是开机以来的纳秒时间 即 --showtime 输出的时间 否则按 1.5s 300ms 这样的相对时间解析
+	t := replayTime{}
+	if text == "" {
+		return t, nil
+	}
+	t.enable = true
+	ts, err := strconv.ParseUint(text, 10, 64)
+	if err == nil {
+		t.ts = ts
+		return t, nil
+	}
+	offset, err := time.ParseDuration(text)
+	if err != nil {
+		return t, fmt.Errorf("parse time %s failed, e.g. 1.5s or boot time ns, err:%v", text, err)
+	}
+	t.offset = offset
+	t.is_rel = true
+	return t, nil
+}
+
+func (this *replayTime) Value(first_ts uint64) uint64 {
+	if this.is_rel {
+		return first_ts + uint64(this.offset.Nanoseconds())
+	}
+	return this.ts
+}
+
+func NewReplayConfig(gconfig *GlobalConfig) (*ReplayConfig, error) {
+	var err error
+	config := &ReplayConfig{}
+	config.fork_list = make(map[uint32]bool)
+	config.skip_enter = make(map[uint32]bool)
+	for _, name := range strings.Split(gconfig.PointName, ",") {
+		if name != "" {
+			config.PointNames = append(config.PointNames, name)
+		}
+	}
+	if config.since, err = parseReplayTime(gconfig.Since); err != nil {
+		return nil, err
+	}
+	if config.until, err = parseReplayTime(gconfig.Until); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (this *ReplayConfig) MatchTime(ts uint64) bool {
+	if this.first_ts == 0 {
+		this.first_ts = ts
+	}
+	if this.since.enable && ts < this.since.Value(this.first_ts) {
+		return false
+	}
+	if this.until.enable && ts > this.until.Value(this.first_ts) {
+		return false
+	}
+	return true
+}
+
+func (this *ReplayConfig) MatchPoint(name string) bool {
+	if len(this.PointNames) == 0 {
+		return true
+	}
+	return slices.Contains(this.PointNames, name)
+}
+
+func (this *ArgFilter) MatchNum(value uint64) bool {
+	// 与 OP_FILTER_VALUE 保持一致
+	switch this.Filter_type {
+	case EQUAL_FILTER:
+		return this.Num_val == value
+	case GREATER_FILTER:
+		return this.Num_val > value
+	case LESS_FILTER:
+		return this.Num_val < value
+	}
+	return true
+}
+
+func (this *ArgFilter) MatchStr(value []byte) bool {
+	// 与 strcmp_by_map 保持一致 即按规则的长度比较前缀
+	return bytes.HasPrefix(value, this.Str_val[:this.Str_len])
+}
+
+func MatchArgFilters(point_args []*PointArg, buf *bytes.Buffer, point_type uint32) bool {
+	has_filter := false
+	for _, point_arg := range point_args {
+		if len(point_arg.FilterIndexList) > 0 {
+			has_filter = true
+			break
+		}
+	}
+	if !has_filter {
+		return true
+	}
+	// 白名单规则只要有一个参数命中即可 黑名单规则命中任何一个都跳过
+	apply_whitelist := false
+	match_whitelist := false
+	arg_values, err := ReadArgValues(point_args, buf, point_type)
+	if err != nil {
+		// 数据不完整 交给后面的解析报告错误
+		return true
+	}
+	for index, point_arg := range point_args {
+		arg_value := arg_values[index]
+		for _, filter_index := range point_arg.FilterIndexList {
+			filter := GetFilterByIndex(filter_index)
+			if filter == nil {
+				continue
+			}
+			if !point_arg.IsString() {
+				if !filter.MatchNum(arg_value.Value) {
+					return false
+				}
+				continue
+			}
+			if !point_arg.ReadMore() || len(arg_value.Payloads) == 0 {
+				continue
+			}
+			is_match := filter.MatchStr(arg_value.Payloads[0])
+			if filter.Filter_type == WHITELIST_FILTER {
+				apply_whitelist = true
+				if is_match {
+					match_whitelist = true
+				}
+			} else if filter.Filter_type == BLACKLIST_FILTER && is_match {
+				return false
+			}
+		}
+	}
+	return !apply_whitelist || match_whitelist
+}
+
+func (this *ModuleConfig) IsReplay() bool {
+	return this.ReplayConf != nil
+}
+
+func (this *ModuleConfig) AddReplayFork(ppid, pid uint32) {
+	// 对应 child_parent_map 被追踪进程 fork 出来的子进程同样追踪
+	if !this.IsReplay() {
+		return
+	}
+	if slices.Contains(this.PidWhitelist, ppid) || this.ReplayConf.fork_list[ppid] {
+		this.ReplayConf.fork_list[pid] = true
+	}
+}
+
+func (this *ModuleConfig) MatchReplayContext(ts uint64, uid, pid, tid uint32, comm string) bool {
+	if !this.ReplayConf.MatchTime(ts) {
+		return false
+	}
+	// 顺序与 should_trace 一致 黑名单优先 依次检查 thread_name tid pid uid trace_uid_group
+	if slices.Contains(this.TNameBlacklist, comm) {
+		return false
+	}
+	if slices.Contains(this.TNameWhitelist, comm) {
+		return true
+	}
+	if len(this.TNameWhitelist) > 0 {
+		return false
+	}
+	if slices.Contains(this.TidBlacklist, tid) {
+		return false
+	}
+	if slices.Contains(this.TidWhitelist, tid) {
+		return true
+	}
+	if slices.Contains(this.PidBlacklist, pid) {
+		return false
+	}
+	if slices.Contains(this.PidWhitelist, pid) || this.ReplayConf.fork_list[pid] {
+		return true
+	}
+	if slices.Contains(this.UidBlacklist, uid) {
+		return false
+	}
+	if slices.Contains(this.UidWhitelist, uid) {
+		return true
+	}
+	if this.TraceGroup&util.GROUP_ROOT != 0 && uid == 0 {
+		return true
+	}
+	if this.TraceGroup&util.GROUP_SYSTEM != 0 && uid == 1000 {
+		return true
+	}
+	if this.TraceGroup&util.GROUP_SHELL != 0 && uid == 2000 {
+		return true
+	}
+	if this.TraceGroup&util.GROUP_APP != 0 && uid >= 10000 && uid <= 19999 {
+		return true
+	}
+	if this.TraceGroup&util.GROUP_ISO != 0 && uid >= 99000 && uid <= 99999 {
+		return true
+	}
+	// 采集时已经过滤过一次 没有设置任何白名单的时候不再限制
+	no_whitelist := len(this.TidWhitelist) == 0 && len(this.PidWhitelist) == 0 && len(this.UidWhitelist) == 0
+	return no_whitelist && this.TraceGroup == util.GROUP_NONE
+}
+
+func (this *ModuleConfig) MatchReplaySyscall(tid uint32, point *SyscallPoint, point_type uint32, buf *bytes.Buffer) bool {
+	sconf := this.SysCallConf
+	if slices.Contains(sconf.SysBlacklist, point.Nr) {
+		return false
+	}
+	if len(sconf.SysWhitelist) > 0 && !slices.Contains(sconf.SysWhitelist, point.Nr) {
+		return false
+	}
+	if !this.ReplayConf.MatchPoint(point.Name) {
+		return false
+	}
+	if point_type == EBPF_SYS_EXIT {
+		// 进入时被参数规则过滤的调用 返回时同样跳过
+		skip := this.ReplayConf.skip_enter[tid]
+		delete(this.ReplayConf.skip_enter, tid)
+		return !skip
+	}
+	if !MatchArgFilters(point.EnterPointArgs, buf, point_type) {
+		this.ReplayConf.skip_enter[tid] = true
+		return false
+	}
+	delete(this.ReplayConf.skip_enter, tid)
+	return true
+}
+
+func (this *ModuleConfig) MatchReplayUprobe(point *UprobeArgs, buf *bytes.Buffer) bool {
+	if !this.ReplayConf.MatchPoint(point.Name) {
+		return false
+	}
+	return MatchArgFilters(point.PointArgs, buf, EBPF_UPROBE_ENTER)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 与 ebpf 中的定义保持一致
+const (
+	SCOPE_NONE uint32 = iota
+	SCOPE_ENABLE
+)
+
+// --scope 只输出线程处于指定函数调用期间的事件
+// 进入和返回时分别更新调用深度 事件会带上所在调用的编号
+type ScopeConfig struct {
+	Name  string
+	Point *UprobeArgs
+}
+
+func NewScopeConfig(text string, gconfig *GlobalConfig) (*ScopeConfig, error) {
+	// libfoo.so!decrypt decrypt+0x10 0x1234 不指定库时使用 -l/--lib
+	library := gconfig.Library
+	sym_str := text
+	if index := strings.LastIndex(text, "!"); index != -1 {
+		library = text[:index]
+		sym_str = text[index+1:]
+	}
+	reg := regexp.MustCompile(`^(\w+)(\+0x[[:xdigit:]]+)?$`)
+	match := reg.FindStringSubmatch(sym_str)
+	if len(match) == 0 {
+		return nil, fmt.Errorf("parse scope %s failed, e.g. libfoo.so!decrypt or decrypt+0x10 or 0x1234", text)
+	}
+	lib_conf := &StackUprobeConfig{}
+	if err := gconfig.Parse_Libinfo(library, lib_conf); err != nil {
+		return nil, err
+	}
+	point := &UprobeArgs{}
+	point.Name = match[1]
+	point.LibPath = lib_conf.LibPath
+	point.RealFilePath = lib_conf.RealFilePath
+	point.NonElfOffset = lib_conf.NonElfOffset
+	if strings.HasPrefix(match[1], "0x") {
+		offset, err := strconv.ParseUint(strings.TrimPrefix(match[1], "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse scope %s failed, err:%v", text, err)
+		}
+		point.Offset = offset
+	} else {
+		point.Symbol = match[1]
+	}
+	if match[2] != "" {
+		offset, err := strconv.ParseUint(strings.TrimPrefix(match[2], "+0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse scope %s failed, err:%v", text, err)
+		}
+		point.Offset = offset
+	}
+	config := &ScopeConfig{}
+	config.Name = sym_str
+	config.Point = point
+	return config, nil
+}
+
+func (this *ScopeConfig) String() string {
+	return fmt.Sprintf("scope %s %s", this.Name, this.Point.String())
+}
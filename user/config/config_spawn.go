@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"stackplz/user/util"
+	"strings"
+)
+
+// --spawn 由 stackplz 启动 app 从第一个 syscall 开始追踪
+type SpawnConfig struct {
+	Package  string
+	Activity string
+	Uid      uint32
+}
+
+func NewSpawnConfig(text string) (*SpawnConfig, error) {
+	// com.example/.MainActivity 或者只有包名 此时使用启动器入口
+	config := &SpawnConfig{}
+	items := strings.SplitN(text, "/", 2)
+	config.Package = items[0]
+	if len(items) == 2 {
+		config.Activity = items[1]
+	}
+	if config.Package == "" {
+		return nil, fmt.Errorf("parse spawn %s failed, e.g. com.example or com.example/.MainActivity", text)
+	}
+	is_find, info := util.Get_PackageInfos().FindPackageByName(config.Package)
+	if !is_find {
+		return nil, fmt.Errorf("can not find pkg_name=%s", config.Package)
+	}
+	config.Uid = info.Uid
+	return config, nil
+}
+
+func (this *SpawnConfig) Component() (string, error) {
+	if this.Activity != "" {
+		return this.Package + "/" + this.Activity, nil
+	}
+	content, err := util.RunCommand("cmd", "package", "resolve-activity", "--brief", "-c", "android.intent.category.LAUNCHER", this.Package)
+	if err != nil {
+		return "", err
+	}
+	// 最后一行是 com.example/.MainActivity
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	component := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.Contains(component, "/") {
+		return "", fmt.Errorf("can not resolve launcher activity of %s, plz set as %s/.MainActivity", this.Package, this.Package)
+	}
+	return component, nil
+}
+
+func (this *SpawnConfig) String() string {
+	return fmt.Sprintf("spawn %s uid:%d activity:%s", this.Package, this.Uid, this.Activity)
+}
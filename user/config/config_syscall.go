@@ -31,6 +31,7 @@ func (this *SyscallPoint) GetEnterConfig() SyscallPointOpKeyConfig {
 	for _, point_arg := range this.EnterPointArgs {
 		config.AddPointArg(point_arg)
 	}
+	config.LimitLoopCount()
 	// this.DumpOpList("enter", config.OpKeyList[:])
 	return config
 }
@@ -41,24 +42,25 @@ func (this *SyscallPoint) GetExitConfig() SyscallPointOpKeyConfig {
 	for _, point_arg := range this.ExitPointArgs {
 		config.AddPointArg(point_arg)
 	}
+	config.LimitLoopCount()
 	// this.DumpOpList("exit", config.OpKeyList[:])
 	return config
 }
 
-func (this *SyscallPoint) ParseEnterPoint(buf *bytes.Buffer) string {
+func (this *SyscallPoint) ParseEnterPoint(proc *argtype.ProcessContext, buf *bytes.Buffer) string {
 	var results []string
 	for _, point_arg := range this.EnterPointArgs {
 		var ptr argtype.Arg_reg
 		if err := binary.Read(buf, binary.LittleEndian, &ptr); err != nil {
 			panic(err)
 		}
-		arg_fmt := point_arg.Parse(ptr.Address, buf, EBPF_SYS_ENTER)
+		arg_fmt := point_arg.Parse(proc, ptr.Address, buf, EBPF_SYS_ENTER)
 		results = append(results, fmt.Sprintf("%s=%s", point_arg.Name, arg_fmt))
 	}
 	return "(" + strings.Join(results, ", ") + ")"
 }
 
-func (this *SyscallPoint) ParsePointJson(buf *bytes.Buffer, point_type uint32) any {
+func (this *SyscallPoint) ParsePointJson(proc *argtype.ProcessContext, buf *bytes.Buffer, point_type uint32) any {
 	var results []any
 	var point_args []*PointArg
 	if point_type == EBPF_SYS_ENTER {
@@ -84,7 +86,7 @@ func (this *SyscallPoint) ParsePointJson(buf *bytes.Buffer, point_type uint32) a
 			ArgRegAlias:   (*ArgRegAlias)(&ptr),
 			Address:       fmt.Sprintf("0x%x", ptr.Address),
 			ArgType:       point_arg.GetTypeName(),
-			ArgValue:      point_arg.ParseJson(ptr.Address, buf, point_type),
+			ArgValue:      point_arg.ParseJson(proc, ptr.Address, buf, point_type),
 		}
 		results = append(results, result)
 	}
@@ -92,14 +94,14 @@ func (this *SyscallPoint) ParsePointJson(buf *bytes.Buffer, point_type uint32) a
 
 }
 
-func (this *SyscallPoint) ParseExitPoint(buf *bytes.Buffer) string {
+func (this *SyscallPoint) ParseExitPoint(proc *argtype.ProcessContext, buf *bytes.Buffer) string {
 	var results []string
 	for _, point_arg := range this.ExitPointArgs {
 		var ptr argtype.Arg_reg
 		if err := binary.Read(buf, binary.LittleEndian, &ptr); err != nil {
 			panic(err)
 		}
-		arg_fmt := point_arg.Parse(ptr.Address, buf, EBPF_SYS_EXIT)
+		arg_fmt := point_arg.Parse(proc, ptr.Address, buf, EBPF_SYS_EXIT)
 		results = append(results, fmt.Sprintf("%s=%s", point_arg.Name, arg_fmt))
 	}
 	return "(" + strings.Join(results, ", ") + ")"
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// 与 ebpf 中的定义保持一致
+const (
+	TRIGGER_NONE uint32 = iota
+	TRIGGER_THREAD
+	TRIGGER_PROCESS
+)
+
+// 触发点的 key syscall 直接使用调用号
+const (
+	TRIGGER_UPROBE_BASE    uint32 = 0x300
+	TRIGGER_URETPROBE_BASE uint32 = 0x380
+)
+
+const (
+	TRIGGER_POINT   = "point:"
+	TRIGGER_SYSCALL = "syscall:"
+	TRIGGER_BRK     = "brk"
+)
+
+// --start-on/--stop-on 命中时开启或关闭其他 hook 点的输出
+// point:JNI_OnLoad       uprobe 进入时
+// point:JNI_OnLoad:ret   uprobe 返回时 需要以 ]r 的方式 hook
+// syscall:openat         进入 syscall 时
+// brk                    命中 --brk 设置的断点时 由用户态更新状态
+type TriggerConfig struct {
+	Scope     uint32
+	StartOn   []string
+	StopOn    []string
+	StartKeys []uint32
+	StopKeys  []uint32
+	BrkStart  bool
+	BrkStop   bool
+	// 由模块设置 用于在用户态更新共享的启用状态
+	UpdateState func(state_key uint32, armed bool) error
+}
+
+func NewTriggerConfig(start_on, stop_on []string, scope string) (*TriggerConfig, error) {
+	config := &TriggerConfig{}
+	switch scope {
+	case "", "thread":
+		config.Scope = TRIGGER_THREAD
+	case "process":
+		config.Scope = TRIGGER_PROCESS
+	default:
+		return nil, fmt.Errorf("unsupported trigger scope:%s, support thread/process", scope)
+	}
+	for _, spec := range start_on {
+		if spec != "" && !slices.Contains(config.StartOn, spec) {
+			config.StartOn = append(config.StartOn, spec)
+		}
+	}
+	for _, spec := range stop_on {
+		if spec != "" && !slices.Contains(config.StopOn, spec) {
+			config.StopOn = append(config.StopOn, spec)
+		}
+	}
+	if len(config.StartOn) == 0 && len(config.StopOn) == 0 {
+		return nil, errors.New("trigger scope is set but no --start-on/--stop-on")
+	}
+	return config, nil
+}
+
+func (this *TriggerConfig) InitArmed() uint32 {
+	// 只设置了 --stop-on 的时候 一开始就是启用的
+	if len(this.StartOn) == 0 {
+		return 1
+	}
+	return 0
+}
+
+func (this *TriggerConfig) HasBrk() bool {
+	return this.BrkStart || this.BrkStop
+}
+
+func (this *TriggerConfig) resolveKey(spec string, mconf *ModuleConfig) (uint32, bool, error) {
+	if spec == TRIGGER_BRK {
+		if mconf.BrkAddr == 0 {
+			return 0, false, errors.New("brk trigger requires --brk")
+		}
+		return 0, true, nil
+	}
+	if strings.HasPrefix(spec, TRIGGER_SYSCALL) {
+		name := strings.TrimPrefix(spec, TRIGGER_SYSCALL)
+		sconf := mconf.SysCallConf
+		for _, point := range sconf.PointArgs {
+			if point.Name != name {
+				continue
+			}
+			// 触发点同样受 syscall 白名单的限制 所以必须是已经追踪的
+			if sconf.TraceMode != TRACE_ALL && !slices.Contains(sconf.SysWhitelist, point.Nr) {
+				return 0, false, fmt.Errorf("trigger syscall %s is not traced, add it to -s/--syscall", name)
+			}
+			return point.Nr, false, nil
+		}
+		return 0, false, fmt.Errorf("unknown trigger syscall:%s", name)
+	}
+	if strings.HasPrefix(spec, TRIGGER_POINT) {
+		name := strings.TrimPrefix(spec, TRIGGER_POINT)
+		on_ret := false
+		if strings.HasSuffix(name, ":ret") {
+			name = strings.TrimSuffix(name, ":ret")
+			on_ret = true
+		}
+		found := false
+		for _, point := range mconf.StackUprobeConf.Points {
+			if point.Name != name {
+				continue
+			}
+			found = true
+			// 同一个函数可能同时 hook 了进入和返回
+			if on_ret && !point.IsRet {
+				continue
+			}
+			if on_ret {
+				return TRIGGER_URETPROBE_BASE + point.Index, false, nil
+			}
+			return TRIGGER_UPROBE_BASE + point.Index, false, nil
+		}
+		if found {
+			return 0, false, fmt.Errorf("trigger %s requires hook point %s on return, e.g. %s[...]r", spec, name, name)
+		}
+		return 0, false, fmt.Errorf("trigger point %s is not hooked, add it to -w/--point", name)
+	}
+	return 0, false, fmt.Errorf("unsupported trigger %s, e.g. point:JNI_OnLoad point:JNI_OnLoad:ret syscall:openat brk", spec)
+}
+
+func (this *TriggerConfig) Resolve(mconf *ModuleConfig) error {
+	// 所有 hook 点解析完成之后再把名字转换为 ebpf 中使用的 key
+	for _, spec := range this.StartOn {
+		key, is_brk, err := this.resolveKey(spec, mconf)
+		if err != nil {
+			return err
+		}
+		if is_brk {
+			this.BrkStart = true
+		} else {
+			this.StartKeys = append(this.StartKeys, key)
+		}
+	}
+	for _, spec := range this.StopOn {
+		key, is_brk, err := this.resolveKey(spec, mconf)
+		if err != nil {
+			return err
+		}
+		if is_brk {
+			this.BrkStop = true
+		} else {
+			this.StopKeys = append(this.StopKeys, key)
+		}
+	}
+	return nil
+}
+
+func (this *TriggerConfig) ForExec() *TriggerConfig {
+	// exec 之后挂载的 hook 点索引重新从 0 开始 只保留 syscall 触发点
+	config := *this
+	config.StartKeys = nil
+	config.StopKeys = nil
+	for _, key := range this.StartKeys {
+		if key < TRIGGER_UPROBE_BASE {
+			config.StartKeys = append(config.StartKeys, key)
+		}
+	}
+	for _, key := range this.StopKeys {
+		if key < TRIGGER_UPROBE_BASE {
+			config.StopKeys = append(config.StopKeys, key)
+		}
+	}
+	return &config
+}
+
+func (this *TriggerConfig) HitBrk(pid, tid uint32) error {
+	if !this.HasBrk() || this.UpdateState == nil {
+		return nil
+	}
+	state_key := tid
+	if this.Scope == TRIGGER_PROCESS {
+		state_key = pid
+	}
+	// 同时设置时与 ebpf 中一致 开启优先
+	return this.UpdateState(state_key, this.BrkStart)
+}
+
+func (this *TriggerConfig) String() string {
+	scope := "thread"
+	if this.Scope == TRIGGER_PROCESS {
+		scope = "process"
+	}
+	return fmt.Sprintf("scope:%s start_on:%v stop_on:%v init_armed:%d", scope, this.StartOn, this.StopOn, this.InitArmed())
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"stackplz/user/argtype"
+	. "stackplz/user/common"
 	"strings"
 )
 
@@ -20,6 +21,17 @@ type UprobeArgs struct {
 	BindSyscall  bool
 	ExitRead     bool
 	KillSignal   uint32
+	DumpMem      []*MemDumpConfig
+	// 在函数返回时读取参数 返回值追加在参数最后
+	IsRet bool
+	// 由内置预设生成的 hook 点 解析时需要额外处理
+	Preset string
+}
+
+func (this *UprobeArgs) SetReturn() {
+	// 和 sys_exit 一样 返回值由 ebpf 程序单独保存 不需要读取操作
+	this.IsRet = true
+	this.PointArgs = append(this.PointArgs, NewUprobePointArg("ret", INT, REG_ARM64_MAX))
 }
 
 func (this *UprobeArgs) GetConfig() UprobePointOpKeyConfig {
@@ -28,6 +40,7 @@ func (this *UprobeArgs) GetConfig() UprobePointOpKeyConfig {
 	for _, point_arg := range this.PointArgs {
 		config.AddPointArg(point_arg)
 	}
+	config.LimitLoopCount()
 	// this.DumpOpList("uprobe_"+this.Name, config.OpKeyList[:])
 	return config
 }
@@ -53,9 +66,13 @@ func (this *UprobeArgs) GetPath() string {
 }
 
 func (this *UprobeArgs) String() string {
+	args_str := this.ArgsStr
+	if this.IsRet {
+		args_str += " (on return)"
+	}
 	if this.Symbol == "" {
-		return fmt.Sprintf("[%s + 0x%x] %s", this.GetPath(), this.Offset, this.ArgsStr)
+		return fmt.Sprintf("[%s + 0x%x] %s", this.GetPath(), this.Offset, args_str)
 	} else {
-		return fmt.Sprintf("[%s] -> sym:%s off:0x%x %s", this.GetPath(), this.Symbol, this.Offset, this.ArgsStr)
+		return fmt.Sprintf("[%s] -> sym:%s off:0x%x %s", this.GetPath(), this.Symbol, this.Offset, args_str)
 	}
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"stackplz/user/argtype"
 	. "stackplz/user/common"
 )
 
@@ -52,6 +53,14 @@ func (this *SyscallPointOpKeyConfig) AddPointArg(point_arg *PointArg) {
 		}
 	}
 }
+func (this *SyscallPointOpKeyConfig) LimitLoopCount() {
+	argtype.LimitLoopCount(this.OpKeyList[:this.OpCount], SYSCALL_MAX_OP_COUNT)
+}
+
+func (this *UprobePointOpKeyConfig) LimitLoopCount() {
+	argtype.LimitLoopCount(this.OpKeyList[:this.OpCount], STACK_MAX_OP_COUNT)
+}
+
 func (this *UprobePointOpKeyConfig) AddPointArg(point_arg *PointArg) {
 	for _, op_key := range point_arg.GetOpList() {
 		this.OpKeyList[this.OpCount] = op_key
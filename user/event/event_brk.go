@@ -6,6 +6,9 @@ import (
     "fmt"
     "stackplz/user/common"
     "stackplz/user/util"
+
+    "github.com/cilium/ebpf/perf"
+    "golang.org/x/sys/unix"
 )
 
 var hit_count uint32 = 0
@@ -16,6 +19,19 @@ type BrkEvent struct {
     UUID      string
 }
 
+func (this *BrkEvent) SetRecord(rec perf.Record) {
+    this.ContextEvent.SetRecord(rec)
+    // 断点的 sample 中没有时间戳 在读取线程取出记录时打上 与 bpf_ktime_get_ns 同为 CLOCK_MONOTONIC
+    // 回放时无从得知原始时间 保持为 0
+    if this.mconf == nil || this.mconf.IsReplay() {
+        return
+    }
+    var now unix.Timespec
+    if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err == nil {
+        this.Ts = uint64(now.Nano())
+    }
+}
+
 func (this *BrkEvent) String() (s string) {
     s = fmt.Sprintf("[%s] event_addr:0x%x hit_count:%d", this.GetUUID(), this.EventAddr, hit_count)
     s = this.GetStackTrace(s)
@@ -51,12 +67,22 @@ func (this *BrkEvent) DumpRecord() bool {
     return this.mconf.DumpRecord(common.BRK_EVENT, &this.rec)
 }
 
-func (this *BrkEvent) ParseEvent() (IEventStruct, error) {
+func (this *BrkEvent) ParseEvent() (data_e IEventStruct, err error) {
+    defer this.RecoverParse(&data_e, &err)
     // 直接调用 ParseContext 即可 不需要先调用一遍 this.ContextEvent.ParseEvent()
     if err := this.ParseContext(); err != nil {
-        panic(fmt.Sprintf("SyscallEvent.ParseContext() err:%v", err))
+        return nil, fmt.Errorf("BrkEvent.ParseContext() err:%v", err)
+    }
+    if this.Check() {
+        trace_helper.AddBrkEvent(this)
+        db_helper.AddBrkEvent(this)
+        if this.mconf.TriggerConf != nil {
+            // 断点作为触发点时 在用户态更新启用状态
+            if err := this.mconf.TriggerConf.HitBrk(this.Pid, this.Tid); err != nil {
+                this.logger.Printf("update trigger state failed, err:%v", err)
+            }
+        }
     }
-    this.Check()
     return this, nil
 }
 
@@ -75,9 +101,7 @@ func (this *BrkEvent) ParseContext() (err error) {
     if err = binary.Read(this.buf, binary.LittleEndian, &this.EventAddr); err != nil {
         return err
     }
-    this.ParseContextStack()
-
-    return nil
+    return this.ParseContextStack()
 }
 
 func (this *BrkEvent) Clone() IEventStruct {
@@ -95,14 +119,14 @@ func (this *BrkEvent) GetPid() uint32 {
     return uint32(this.mconf.BrkPid)
 }
 
-func (this *BrkEvent) ParseContextStack() {
+func (this *BrkEvent) ParseContextStack() error {
     this.Stackinfo = ""
     if this.rec.ExtraOptions.UnwindStack {
         // 读取完整的栈数据和寄存器数据 并解析为 UnwindBuf 结构体
         this.UnwindBuffer = &UnwindBuf{}
         err := this.UnwindBuffer.ParseContext(this.buf)
         if err != nil {
-            panic(fmt.Sprintf("UnwindStack ParseContext failed, err:%v", err))
+            return fmt.Errorf("UnwindStack ParseContext failed, err:%v", err)
         }
         // 立刻获取堆栈信息 对于某些hook点前后可能导致maps发生变化的 堆栈可能不准确
         // 这里后续可以调整为只dlopen一次 拿到要调用函数的handle 不要重复dlopen
@@ -117,7 +141,7 @@ func (this *BrkEvent) ParseContextStack() {
             } else {
                 this.Stackinfo = info
             }
-            return
+            return nil
         }
         opt := &UnwindOption{}
         opt.RegMask = (1 << 33) - 1
@@ -126,8 +150,8 @@ func (this *BrkEvent) ParseContextStack() {
     } else if this.rec.ExtraOptions.ShowRegs {
         err := this.RegsBuffer.ParseContext(this.buf)
         if err != nil {
-            panic(fmt.Sprintf("UnwindStack ParseContext failed, err:%v", err))
+            return fmt.Errorf("RegsBuffer ParseContext failed, err:%v", err)
         }
     }
-    return
+    return nil
 }
@@ -12,6 +12,7 @@ import (
 type CommEvent struct {
     CommonEvent
     config.CommFields
+    pkg string
 }
 
 func (this *CommEvent) String() string {
@@ -23,7 +24,7 @@ func (this *CommEvent) String() string {
         return string(data)
     }
     var s string
-    s = fmt.Sprintf("[CommEvent] %s", this.GetUUID())
+    s = fmt.Sprintf("[CommEvent] %s%s", this.pkg, this.GetUUID())
     return s
 }
 
@@ -51,5 +52,7 @@ func (this *CommEvent) ParseContext() (err error) {
     if this.mconf.Debug {
         this.logger.Printf(this.String())
     }
+    trace_helper.AddCommEvent(this)
+    db_helper.AddCommEvent(this)
     return nil
 }
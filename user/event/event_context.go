@@ -56,6 +56,9 @@ func (this *UnwindBuf) ParseContext(buf *bytes.Buffer) (err error) {
         return err
     }
 
+    if this.StackSize > uint64(buf.Len()) {
+        return fmt.Errorf("stack size %d exceeds remaining %d bytes", this.StackSize, buf.Len())
+    }
     stack_data := make([]byte, this.StackSize)
     if err = binary.Read(buf, binary.LittleEndian, &stack_data); err != nil {
         return err
@@ -107,6 +110,39 @@ func (this *ContextEvent) GetUUID() string {
     return fmt.Sprintf("%d_%d", this.Pid, this.Tid)
 }
 
+func (this *ContextEvent) GetPackage() string {
+    // 找不到包名时 比如 shell 和系统进程 使用 uid 代替
+    name := this.mconf.FindPackageName(this.Uid)
+    if name == "" {
+        return strconv.FormatUint(uint64(this.Uid), 10)
+    }
+    return name
+}
+
+func (this *ContextEvent) GetPackageJson() string {
+    // json 中已经有 uid 找不到包名时不输出
+    if !this.mconf.ShowPkg {
+        return ""
+    }
+    return this.mconf.FindPackageName(this.Uid)
+}
+
+func (this *ContextEvent) GetScope() string {
+    // 按 --scope 函数的调用分组 递归调用时按深度缩进
+    if this.ScopeId == 0 {
+        return ""
+    }
+    name := "scope"
+    if this.mconf.ScopeConf != nil {
+        name = this.mconf.ScopeConf.Name
+    }
+    return fmt.Sprintf("%s<%s#%d> ", strings.Repeat("  ", int(this.ScopeDepth)-1), name, this.ScopeId)
+}
+
+func (this *ContextEvent) MatchReplay() bool {
+    return this.mconf.MatchReplayContext(this.Ts, this.Uid, this.Pid, this.Tid, util.B2STrim(this.Comm[:]))
+}
+
 func (this *ContextEvent) GetEventId() uint32 {
     return this.EventId
 }
@@ -114,14 +150,16 @@ func (this *ContextEvent) GetEventId() uint32 {
 func (this *ContextEvent) ParsePadding() (err error) {
     // 好在 SampleSize 是明确的 这样我们可以正确计算下一部分 perf 数据起始位置
     // ebpf库改为全部读取之后 这里的 4 是 PERF_SAMPLE_RAW 的 size
-    padding_size := this.rec.SampleSize + 4 - uint32(this.buf.Cap()-this.buf.Len())
-    if padding_size > 0 {
-        payload := make([]byte, padding_size)
-        if err = binary.Read(this.buf, binary.LittleEndian, &payload); err != nil {
-            this.logger.Printf("ContextEvent EventId:%d RawSample:\n%s", this.EventId, util.HexDump(this.rec.RawSample, util.COLORRED))
-            panic(err)
-        }
+    read_size := uint32(this.buf.Cap() - this.buf.Len())
+    if this.rec.SampleSize+4 < read_size {
+        // 解析的长度超过了 SampleSize 说明数据已经损坏 不能继续解析后面的部分
+        return fmt.Errorf("EventId:%d read %d bytes beyond sample size %d", this.EventId, read_size, this.rec.SampleSize)
+    }
+    padding_size := this.rec.SampleSize + 4 - read_size
+    if padding_size > uint32(this.buf.Len()) {
+        return fmt.Errorf("EventId:%d padding size %d exceeds remaining %d bytes", this.EventId, padding_size, this.buf.Len())
     }
+    this.buf.Next(int(padding_size))
     return nil
 }
 
@@ -131,7 +169,7 @@ func (this *ContextEvent) ParseEvent() (IEventStruct, error) {
         // 先把需要的基础信息解析出来
         err := this.ParseContext()
         if err != nil {
-            panic(fmt.Sprintf("ContextEvent.ParseContext() err:%v", err))
+            return nil, fmt.Errorf("ContextEvent.ParseContext() err:%v", err)
         }
 
         EventId := this.GetEventId()
@@ -140,6 +178,12 @@ func (this *ContextEvent) ParseEvent() (IEventStruct, error) {
             return nil, nil
         case UPROBE_ENTER:
             return nil, nil
+        case PROCESS_EXEC:
+            return this.NewExecEvent()
+        case PROCESS_EXIT:
+            return this.NewTaskExitEvent()
+        case SIGNAL_DELIVER:
+            return this.NewSignalEvent()
         default:
             this.logger.Printf("ContextEvent.ParseEvent() unsupported EventId:%d\n", EventId)
             this.logger.Printf("ContextEvent.ParseEvent() PERF_RECORD_SAMPLE RawSample:\n" + util.HexDump(this.rec.RawSample, util.COLORRED))
@@ -177,7 +221,7 @@ func (this *ContextEvent) ParseContext() (err error) {
     if err = binary.Read(this.buf, binary.LittleEndian, &this.Pid); err != nil {
         return err
     }
-    if this.mconf.KillSignal == uint32(syscall.SIGSTOP) && this.Pid != 0 {
+    if this.mconf.KillSignal == uint32(syscall.SIGSTOP) && this.Pid != 0 && !this.mconf.IsReplay() {
         AddStopped(this.Pid)
     }
     if err = binary.Read(this.buf, binary.LittleEndian, &this.Uid); err != nil {
@@ -189,11 +233,19 @@ func (this *ContextEvent) ParseContext() (err error) {
     if err = binary.Read(this.buf, binary.LittleEndian, &this.Argnum); err != nil {
         return err
     }
+    if err = binary.Read(this.buf, binary.LittleEndian, &this.ScopeDepth); err != nil {
+        return err
+    }
     if err = binary.Read(this.buf, binary.LittleEndian, &this.Padding); err != nil {
         return err
     }
+    if err = binary.Read(this.buf, binary.LittleEndian, &this.ScopeId); err != nil {
+        return err
+    }
     // 这一类的说明都是要关注的
     maps_helper.UpdatePidList(this.Pid)
+    // perf 的 fork/comm/mmap2 记录中没有 uid 以事件中的为准
+    lifecycle_helper.AddSample(this.Pid, this.Uid)
     return nil
 }
 
@@ -274,7 +326,7 @@ func (this *ContextEvent) ParseContextStack() (err error) {
         this.UnwindBuffer = &UnwindBuf{}
         err = this.UnwindBuffer.ParseContext(this.buf)
         if err != nil {
-            panic(fmt.Sprintf("UnwindStack ParseContext failed, err:%v", err))
+            return fmt.Errorf("UnwindStack ParseContext failed, err:%v", err)
         }
         // 立刻获取堆栈信息 对于某些hook点前后可能导致maps发生变化的 堆栈可能不准确
         // 这里后续可以调整为只dlopen一次 拿到要调用函数的handle 不要重复dlopen
@@ -299,7 +351,7 @@ func (this *ContextEvent) ParseContextStack() (err error) {
     } else if this.rec.ExtraOptions.ShowRegs {
         err = this.RegsBuffer.ParseContext(this.buf)
         if err != nil {
-            panic(fmt.Sprintf("UnwindStack ParseContext failed, err:%v", err))
+            return fmt.Errorf("RegsBuffer ParseContext failed, err:%v", err)
         }
     }
     return nil
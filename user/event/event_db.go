@@ -0,0 +1,317 @@
+package event
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strings"
+    "sync"
+)
+
+// 将事件写入 SQLite 数据库 没有引入额外的依赖 而是通过管道交给 sqlite3 命令执行
+// 文件名以 .sql 结尾时直接保存为 SQL 语句 之后用 sqlite3 capture.db < capture.sql 导入
+
+const DB_SCHEMA = `
+CREATE TABLE events(id INTEGER PRIMARY KEY, ts INTEGER, type TEXT, point TEXT, pid INTEGER, tid INTEGER, uid INTEGER, comm TEXT, lr INTEGER, pc INTEGER, sp INTEGER, ret INTEGER, text TEXT, stack TEXT);
+CREATE TABLE args(event_id INTEGER, idx INTEGER, name TEXT, type TEXT, raw INTEGER, value TEXT, data BLOB);
+CREATE TABLE frames(event_id INTEGER, idx INTEGER, lib TEXT, rel_pc INTEGER, symbol TEXT);
+CREATE TABLE processes(pid INTEGER PRIMARY KEY, ppid INTEGER, name TEXT, fork_ts INTEGER, exit_ts INTEGER);
+CREATE TABLE threads(tid INTEGER PRIMARY KEY, pid INTEGER, name TEXT, fork_ts INTEGER, exit_ts INTEGER);
+CREATE TABLE mappings(pid INTEGER, start INTEGER, end INTEGER, off INTEGER, path TEXT);
+CREATE INDEX events_point ON events(point, pid);
+CREATE INDEX events_ts ON events(ts);
+CREATE INDEX args_event ON args(event_id);
+CREATE INDEX frames_event ON frames(event_id);
+`
+
+// 每写入这么多条语句提交一次事务
+const DB_BATCH_SIZE = 4096
+
+type DbProcess struct {
+    Pid    uint32
+    Ppid   uint32
+    Name   string
+    ForkTs uint64
+    ExitTs uint64
+}
+
+type DbThread struct {
+    Tid    uint32
+    Pid    uint32
+    Name   string
+    ForkTs uint64
+    ExitTs uint64
+}
+
+type DbHelper struct {
+    cmd       *exec.Cmd
+    output    io.WriteCloser
+    writer    *bufio.Writer
+    err       error
+    event_id  uint64
+    count     uint64
+    processes map[uint32]*DbProcess
+    threads   map[uint32]*DbThread
+}
+
+func NewDbHelper() *DbHelper {
+    helper := &DbHelper{}
+    helper.processes = make(map[uint32]*DbProcess)
+    helper.threads = make(map[uint32]*DbThread)
+    return helper
+}
+
+var db_lock sync.Mutex
+var db_helper = NewDbHelper()
+
+func DbOpen(db_file, sqlite3 string) error {
+    // 在开始采集之前打开 找不到 sqlite3 时直接报错 不要等到写入时才失败
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if db_file == "" || db_helper.writer != nil {
+        return nil
+    }
+    // 每次都是新的数据库
+    os.Remove(db_file)
+    if strings.HasSuffix(db_file, ".sql") {
+        f, err := os.Create(db_file)
+        if err != nil {
+            return fmt.Errorf("create %s failed, err:%v", db_file, err)
+        }
+        db_helper.output = f
+    } else {
+        sqlite3_path, err := exec.LookPath(sqlite3)
+        if err != nil {
+            return fmt.Errorf("can not find %s, set --sqlite3 or use --db xxx.sql to save sql statements instead, err:%v", sqlite3, err)
+        }
+        cmd := exec.Command(sqlite3_path, db_file)
+        // PRAGMA 等语句的输出不需要
+        cmd.Stderr = os.Stderr
+        stdin, err := cmd.StdinPipe()
+        if err != nil {
+            return err
+        }
+        if err := cmd.Start(); err != nil {
+            return fmt.Errorf("start %s failed, err:%v", sqlite3_path, err)
+        }
+        db_helper.cmd = cmd
+        db_helper.output = stdin
+    }
+    db_helper.writer = bufio.NewWriterSize(db_helper.output, 0x10000)
+    db_helper.writer.WriteString("PRAGMA journal_mode=OFF;\nPRAGMA synchronous=OFF;\n")
+    db_helper.writer.WriteString(DB_SCHEMA)
+    db_helper.writer.WriteString("BEGIN;\n")
+    return nil
+}
+
+func DbClose() error {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if db_helper.writer == nil {
+        return nil
+    }
+    // 进程和线程的信息在退出时一次性写入
+    for _, p := range db_helper.processes {
+        db_helper.exec(fmt.Sprintf("INSERT INTO processes VALUES(%d, %d, %s, %d, %d)", p.Pid, p.Ppid, sql_str(p.Name), p.ForkTs, p.ExitTs))
+    }
+    for _, t := range db_helper.threads {
+        db_helper.exec(fmt.Sprintf("INSERT INTO threads VALUES(%d, %d, %s, %d, %d)", t.Tid, t.Pid, sql_str(t.Name), t.ForkTs, t.ExitTs))
+    }
+    db_helper.write("COMMIT;\n")
+    if err := db_helper.writer.Flush(); err != nil && db_helper.err == nil {
+        db_helper.err = err
+    }
+    db_helper.output.Close()
+    if db_helper.cmd != nil {
+        if err := db_helper.cmd.Wait(); err != nil && db_helper.err == nil {
+            db_helper.err = fmt.Errorf("sqlite3 exit with err:%v", err)
+        }
+    }
+    db_helper.cmd = nil
+    db_helper.writer = nil
+    return db_helper.err
+}
+
+func sql_str(s string) string {
+    return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "\x00", ""), "'", "''") + "'"
+}
+
+func sql_blob(data []byte) string {
+    if len(data) == 0 {
+        return "NULL"
+    }
+    return "X'" + hex.EncodeToString(data) + "'"
+}
+
+func (this *DbHelper) opened() bool {
+    // 写入失败之后不再继续 错误在关闭时返回
+    return this.writer != nil && this.err == nil
+}
+
+func (this *DbHelper) write(s string) {
+    if this.err != nil {
+        return
+    }
+    if _, err := this.writer.WriteString(s); err != nil {
+        this.err = fmt.Errorf("write db failed, err:%v", err)
+    }
+}
+
+func (this *DbHelper) exec(stmt string) {
+    this.write(stmt)
+    this.write(";\n")
+    this.count += 1
+    if this.count%DB_BATCH_SIZE == 0 {
+        this.write("COMMIT;\nBEGIN;\n")
+    }
+}
+
+func (this *DbHelper) touch(pid, tid uint32, comm string) {
+    if _, ok := this.processes[pid]; !ok {
+        name, err := ReadProcNameByPid(pid)
+        if err != nil || name == "" {
+            name = comm
+        }
+        this.processes[pid] = &DbProcess{Pid: pid, Name: name}
+        // 首次出现的进程 记录下当前的内存布局
+        if pid_maps, err := maps_helper.FindLib(pid); err == nil {
+            for path, lib_infos := range pid_maps {
+                for _, lib_info := range lib_infos {
+                    this.exec(fmt.Sprintf("INSERT INTO mappings VALUES(%d, %d, %d, %d, %s)", pid, lib_info.BaseAddr, lib_info.EndAddr, lib_info.Off, sql_str(path)))
+                }
+            }
+        }
+    }
+    thread, ok := this.threads[tid]
+    if !ok {
+        thread = &DbThread{Tid: tid, Pid: pid}
+        this.threads[tid] = thread
+    }
+    if comm != "" {
+        thread.Name = comm
+    }
+}
+
+func (this *DbHelper) addEvent(event *ContextEvent, event_type, point string, lr, pc, sp uint64, ret string, text string, arg_values []config.ArgValue) {
+    comm := util.B2STrim(event.Comm[:])
+    this.touch(event.Pid, event.Tid, comm)
+    this.event_id += 1
+    frames := ParseStackFrames(event.Stackinfo)
+    var names []string
+    for i := len(frames) - 1; i >= 0; i-- {
+        names = append(names, frames[i].Name())
+    }
+    // 回放时断点事件没有时间
+    ts := "NULL"
+    if event.Ts > 0 {
+        ts = fmt.Sprintf("%d", event.Ts)
+    }
+    this.exec(fmt.Sprintf("INSERT INTO events VALUES(%d, %s, %s, %s, %d, %d, %d, %s, %d, %d, %d, %s, %s, %s)",
+        this.event_id, ts, sql_str(event_type), sql_str(point), event.Pid, event.Tid, event.Uid, sql_str(comm),
+        int64(lr), int64(pc), int64(sp), ret, sql_str(text), sql_str(strings.Join(names, ";"))))
+    for i, arg_value := range arg_values {
+        var data []byte
+        if len(arg_value.Payloads) > 0 {
+            data = bytes.Join(arg_value.Payloads, nil)
+        }
+        this.exec(fmt.Sprintf("INSERT INTO args VALUES(%d, %d, %s, %s, %d, %s, %s)",
+            this.event_id, i, sql_str(arg_value.Name), sql_str(arg_value.GetTypeName()), int64(arg_value.Value), sql_str(arg_value.Text), sql_blob(bytes.TrimRight(data, "\x00"))))
+    }
+    for i, frame := range frames {
+        this.exec(fmt.Sprintf("INSERT INTO frames VALUES(%d, %d, %s, %d, %s)", this.event_id, i, sql_str(frame.LibPath), frame.RelPc, sql_str(frame.Symbol)))
+    }
+}
+
+func (this *DbHelper) AddSyscallEvent(event *SyscallEvent, arg_values []config.ArgValue) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    // PointStr 在 --json/--summary 下为空 所以文本从 arg_values 格式化
+    text := config.FormatArgValues(arg_values)
+    if event.EventId == SYSCALL_ENTER {
+        this.addEvent(&event.ContextEvent, "sys_enter", event.PointName, event.LR, event.PC, event.SP, "NULL", text, arg_values)
+        return
+    }
+    ret := "NULL"
+    if value, ok := FindArgValue(arg_values, "ret"); ok {
+        ret = fmt.Sprintf("%d", int64(value))
+    }
+    this.addEvent(&event.ContextEvent, "sys_exit", event.PointName, 0, 0, 0, ret, text, arg_values)
+}
+
+func (this *DbHelper) AddUprobeEvent(event *UprobeEvent, arg_values []config.ArgValue) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    ret := "NULL"
+    if event.uprobe_point.IsRet {
+        if value, ok := FindArgValue(arg_values, "ret"); ok {
+            ret = fmt.Sprintf("%d", int32(value))
+        }
+    }
+    this.addEvent(&event.ContextEvent, "uprobe", event.uprobe_point.Name, event.LR, event.PC, event.SP, ret, event.ArgStr, arg_values)
+}
+
+func (this *DbHelper) AddBrkEvent(event *BrkEvent) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    this.addEvent(&event.ContextEvent, "brk", fmt.Sprintf("0x%x", event.EventAddr), 0, event.EventAddr, 0, "NULL", "", nil)
+}
+
+func (this *DbHelper) AddForkEvent(event *ForkEvent) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    parent, ok := this.processes[event.Ppid]
+    if !ok {
+        return
+    }
+    if _, ok := this.processes[event.Pid]; !ok {
+        this.processes[event.Pid] = &DbProcess{Pid: event.Pid, Ppid: event.Ppid, Name: parent.Name, ForkTs: event.Time}
+    }
+    var name string
+    if thread, ok := this.threads[event.Ptid]; ok {
+        name = thread.Name
+    }
+    this.threads[event.Tid] = &DbThread{Tid: event.Tid, Pid: event.Pid, Name: name, ForkTs: event.Time}
+}
+
+func (this *DbHelper) AddExitEvent(event *ExitEvent) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    if thread, ok := this.threads[event.Tid]; ok {
+        thread.ExitTs = event.Time
+    }
+    if process, ok := this.processes[event.Pid]; ok && event.Pid == event.Tid {
+        process.ExitTs = event.Time
+    }
+}
+
+func (this *DbHelper) AddCommEvent(event *CommEvent) {
+    db_lock.Lock()
+    defer db_lock.Unlock()
+    if !this.opened() {
+        return
+    }
+    if thread, ok := this.threads[event.Tid]; ok {
+        thread.Name = event.Comm
+    }
+}
@@ -0,0 +1,72 @@
+package event
+
+import (
+    "bytes"
+    "fmt"
+    "stackplz/user/config"
+    "stackplz/user/util"
+)
+
+func (this *ContextEvent) GetDumpPrefix(point_name string) string {
+    // 按 时间戳_pid_tid_hook点 命名 方便和日志对应
+    return fmt.Sprintf("%d_%d_%d_%s", this.Ts, this.Pid, this.Tid, point_name)
+}
+
+func (this *ContextEvent) DumpArgPayloads(point_name string, arg_payloads []config.ArgPayload) {
+    for _, arg_payload := range arg_payloads {
+        // iovec msghdr 可能有多段数据 按顺序拼接起来即可
+        data := bytes.Join(arg_payload.Payloads, []byte{})
+        file_name := fmt.Sprintf("%s_%s.bin", this.GetDumpPrefix(point_name), arg_payload.Name)
+        this.mconf.SaveDumpFile(file_name, data)
+        if this.mconf.Debug {
+            this.logger.Printf("dump %s len:%d -> %s", arg_payload.Name, len(data), file_name)
+        }
+    }
+}
+
+func (this *ContextEvent) GetRegs() [33]uint64 {
+    if this.rec.ExtraOptions.UnwindStack {
+        return this.UnwindBuffer.Regs
+    }
+    return this.RegsBuffer.Regs
+}
+
+func (this *ContextEvent) DumpMemory(point_name string, mem_dumps []*config.MemDumpConfig) {
+    // 解析 dump 文件时进程早已不是命中时的状态 甚至不存在
+    if len(mem_dumps) == 0 || this.mconf.IsReplay() {
+        return
+    }
+    // 只有进程处于暂停状态 读取到的内存才是命中时的样子
+    if !IsStopped(this.Pid) {
+        return
+    }
+    regs := this.GetRegs()
+    for _, mem_dump := range mem_dumps {
+        addr := mem_dump.GetAddr(regs)
+        size := mem_dump.Size
+        seg_path := ""
+        if size == 0 {
+            // 没有指定大小 那么读取地址所在的整个内存段
+            seg_start, seg_end, path, err := util.FindMapByAddr(this.Pid, addr)
+            if err != nil {
+                this.logger.Printf("dump mem %s failed, err:%v", mem_dump.Spec, err)
+                continue
+            }
+            addr = seg_start
+            size = seg_end - seg_start
+            seg_path = path
+            if size > config.MAX_DUMP_MEM_SIZE {
+                this.logger.Printf("dump mem %s segment size 0x%x too big, truncate to 0x%x", mem_dump.Spec, size, config.MAX_DUMP_MEM_SIZE)
+                size = config.MAX_DUMP_MEM_SIZE
+            }
+        }
+        data, err := util.ReadProcessMemory(this.Pid, addr, size)
+        if err != nil {
+            this.logger.Printf("dump mem %s at 0x%x failed, err:%v", mem_dump.Spec, addr, err)
+            continue
+        }
+        file_name := fmt.Sprintf("%s_mem_%x-%x.bin", this.GetDumpPrefix(point_name), addr, addr+uint64(len(data)))
+        this.mconf.SaveDumpFile(file_name, data)
+        this.logger.Printf("dump mem %s [0x%x-0x%x]%s -> %s", mem_dump.Spec, addr, addr+uint64(len(data)), seg_path, file_name)
+    }
+}
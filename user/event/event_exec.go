@@ -0,0 +1,151 @@
+package event
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strings"
+    "syscall"
+)
+
+type ExecEvent struct {
+    ContextEvent
+    config.ExecFields
+    skip bool
+}
+
+func (this *ContextEvent) NewExecEvent() (IEventStruct, error) {
+    event := &ExecEvent{ContextEvent: *this}
+    err := event.ParseContext()
+    if err != nil {
+        return nil, fmt.Errorf("ExecEvent.ParseContext() err:%v", err)
+    }
+    if event.skip || event.mconf.SkipEventLog() {
+        return nil, nil
+    }
+    return event, nil
+}
+
+func (this *ExecEvent) ParseContext() (err error) {
+    parsed := false
+    if this.mconf.ExecStop() && !this.mconf.IsReplay() {
+        // 不论挂载是否成功 解析是否出错 停下的进程都要恢复运行
+        // 只有文件名匹配的进程被停下 解析失败时不知道路径 同样发送 SIGCONT
+        defer func() {
+            if !parsed || this.mconf.MatchExecStop(this.Path) {
+                this.resume()
+            }
+        }()
+    }
+    // 路径和 argv 的格式都是 save_index|size|data
+    for i := uint8(0); i < this.Argnum; i++ {
+        var index uint8
+        var size int32
+        if err = this.ReadValues(&index, &size); err != nil {
+            return err
+        }
+        if size < 0 || int(size) > this.buf.Len() {
+            return fmt.Errorf("exec arg size %d exceeds remaining %d bytes", size, this.buf.Len())
+        }
+        data := this.buf.Next(int(size))
+        switch index {
+        case 0:
+            this.Path = util.B2STrim(data)
+        case 1:
+            // argv 之间以 \0 分隔
+            for _, arg := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+                this.Argv = append(this.Argv, string(arg))
+            }
+        }
+    }
+    if err = this.ParsePadding(); err != nil {
+        return err
+    }
+    if this.mconf.IsReplay() {
+        this.skip = !this.MatchReplay()
+        return nil
+    }
+    // 旧程序的 maps 已经失效
+    maps_helper.UpdateExecEvent(this)
+    parsed = true
+    if this.mconf.MatchExecStop(this.Path) {
+        // 进程在 exec 之后已经停下 挂载新程序的 hook 之后再恢复运行
+        exec_path := this.execPath()
+        if !this.mconf.MatchExecHook(exec_path) {
+            this.logger.Printf("pid=%d exec %s stopped but no exec hook matched, resume it", this.Pid, exec_path)
+        } else if err := this.mconf.AttachExecHooks(exec_path); err != nil {
+            this.logger.Printf("attach exec hooks for %s failed, err:%v", exec_path, err)
+        }
+    }
+    return nil
+}
+
+func (this *ExecEvent) execPath() string {
+    // execve 传入的可能是相对路径 进程停着 按它的工作目录补全
+    if filepath.IsAbs(this.Path) {
+        return filepath.Clean(this.Path)
+    }
+    cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", this.Pid))
+    if err != nil {
+        this.logger.Printf("read cwd of pid=%d failed, err:%v", this.Pid, err)
+        return this.Path
+    }
+    return filepath.Join(cwd, this.Path)
+}
+
+func (this *ExecEvent) resume() {
+    if err := syscall.Kill(int(this.Pid), syscall.SIGCONT); err != nil {
+        this.logger.Printf("resume pid=%d failed, err:%v", this.Pid, err)
+    }
+}
+
+func (this *ExecEvent) GetUUID() string {
+    s := fmt.Sprintf("%d|%d|%s", this.Pid, this.Tid, util.B2STrim(this.Comm[:]))
+    if this.mconf.ShowTime {
+        s = fmt.Sprintf("%d|%s", this.Ts, s)
+    }
+    if this.mconf.ShowUid {
+        s = fmt.Sprintf("%d|%s", this.Uid, s)
+    }
+    if this.mconf.ShowPkg {
+        s = fmt.Sprintf("%s|%s", this.GetPackage(), s)
+    }
+    return s
+}
+
+func (this *ExecEvent) MarshalJSON() ([]byte, error) {
+    type ContextAlias config.ContextFields
+    return json.Marshal(&struct {
+        Event   string `json:"event"`
+        Comm    string `json:"comm"`
+        Package string `json:"package,omitempty"`
+        *ContextAlias
+        *config.ExecFields
+    }{
+        Event:        "exec",
+        Comm:         util.B2STrim(this.Comm[:]),
+        Package:      this.GetPackageJson(),
+        ContextAlias: (*ContextAlias)(&this.ContextFields),
+        ExecFields:   &this.ExecFields,
+    })
+}
+
+func (this *ExecEvent) String() string {
+    if this.mconf.FmtJson {
+        data, err := json.Marshal(this)
+        if err != nil {
+            panic(err)
+        }
+        return string(data)
+    }
+    return fmt.Sprintf("[ExecEvent] %s path=%s argv=[%s]", this.GetUUID(), this.Path, strings.Join(this.Argv, " "))
+}
+
+func (this *ExecEvent) Clone() IEventStruct {
+    event := new(ExecEvent)
+    return event
+}
@@ -46,12 +46,13 @@ func (this *ExitEvent) ParseContext() (err error) {
     if this.mconf.SelfPid == this.Pid {
         return nil
     }
-    this.ReadValue(&this.Ppid)
-    this.ReadValue(&this.Tid)
-    this.ReadValue(&this.Ptid)
-    this.ReadValue(&this.Time)
+    if err = this.ReadValues(&this.Ppid, &this.Tid, &this.Ptid, &this.Time); err != nil {
+        return err
+    }
     if this.mconf.Debug {
         this.logger.Printf(this.String())
     }
+    trace_helper.AddExitEvent(this)
+    db_helper.AddExitEvent(this)
     return nil
 }
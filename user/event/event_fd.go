@@ -0,0 +1,585 @@
+package event
+
+import (
+    "bufio"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "net/netip"
+    "os"
+    "path"
+    "stackplz/user/argtype"
+    "stackplz/user/common"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+
+    "golang.org/x/exp/slices"
+)
+
+const AT_FDCWD = -100
+
+type FdInfo struct {
+    Path string
+    // socket 的协议和两端地址 由 socket/connect/bind/accept 等 syscall 的参数得到
+    Sock *SocketInfo
+}
+
+func (this *FdInfo) String() string {
+    if this.Sock != nil {
+        return this.Sock.String()
+    }
+    return this.Path
+}
+
+func (this *FdInfo) Clone() *FdInfo {
+    info := &FdInfo{Path: this.Path}
+    if this.Sock != nil {
+        sock := *this.Sock
+        info.Sock = &sock
+    }
+    return info
+}
+
+type ProcFds map[int32]*FdInfo
+
+func (this *ProcFds) Clone() ProcFds {
+    fds := ProcFds{}
+    for fd, info := range *this {
+        fds[fd] = info.Clone()
+    }
+    return fds
+}
+
+// 只有 sys_enter 时才能取到的参数 比如 openat 的路径 按 tid 暂存到 sys_exit 再结合返回值
+type FdPending struct {
+    Name string
+    Path string
+    Addr []byte
+}
+
+type FdHelper struct {
+    pid_fds map[uint32]*ProcFds
+    pending map[uint32]*FdPending
+}
+
+func NewFdHelper() *FdHelper {
+    helper := &FdHelper{}
+    helper.pid_fds = make(map[uint32]*ProcFds)
+    helper.pending = make(map[uint32]*FdPending)
+    return helper
+}
+
+var fd_helper = NewFdHelper()
+var fds_lock sync.Mutex
+
+// 会改变 fd 表的 syscall 其中 sys_enter 时需要记录参数的
+var fd_enter_syscalls = []string{"close", "openat", "openat2", "memfd_create", "connect", "bind"}
+var fd_exit_syscalls = []string{"openat", "openat2", "memfd_create", "connect", "bind", "dup", "dup3", "fcntl", "socket", "socketpair", "pipe2", "accept", "accept4", "getsockname", "getpeername"}
+
+// 返回值是匿名 fd 的 syscall 与 /proc/pid/fd 中的名字保持一致
+var fd_anon_names = map[string]string{
+    "eventfd2":        "anon_inode:[eventfd]",
+    "epoll_create1":   "anon_inode:[eventpoll]",
+    "timerfd_create":  "anon_inode:[timerfd]",
+    "signalfd4":       "anon_inode:[signalfd]",
+    "inotify_init1":   "anon_inode:inotify",
+    "pidfd_open":      "anon_inode:[pidfd]",
+    "perf_event_open": "anon_inode:[perf_event]",
+    "userfaultfd":     "anon_inode:[userfaultfd]",
+}
+
+func (this *FdHelper) ParseFds(pid uint32) error {
+    // 和 maps 一样 首次遇到进程的时候从 /proc/pid/fd 获取追踪开始之前已经打开的 fd
+    dirname := fmt.Sprintf("/proc/%d/fd", pid)
+    entries, err := os.ReadDir(dirname)
+    if err != nil {
+        return fmt.Errorf("Error when reading dir:%v", err)
+    }
+    pid_fds := ProcFds{}
+    for _, entry := range entries {
+        fd, err := strconv.ParseInt(entry.Name(), 10, 32)
+        if err != nil {
+            continue
+        }
+        info, err := ReadFdInfo(pid, int32(fd))
+        if err != nil {
+            continue
+        }
+        pid_fds[int32(fd)] = info
+    }
+    this.pid_fds[pid] = &pid_fds
+    return nil
+}
+
+func (this *FdHelper) getFds(pid uint32) *ProcFds {
+    pid_fds, ok := this.pid_fds[pid]
+    if !ok {
+        pid_fds = &ProcFds{}
+        this.pid_fds[pid] = pid_fds
+    }
+    return pid_fds
+}
+
+func (this *FdHelper) InitFds(pid uint32, replay bool) {
+    // 之后的变化都从 syscall 的参数得到 只有这里会读取 /proc
+    // 解析 dump 文件时读取的是当前设备的状态 没有意义
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    if _, ok := this.pid_fds[pid]; ok {
+        return
+    }
+    if replay || this.ParseFds(pid) != nil {
+        // 进程可能已经结束了 那么只记录后续的变化
+        this.pid_fds[pid] = &ProcFds{}
+    }
+}
+
+func (this *FdHelper) GetInfo(pid uint32, fd int32) *FdInfo {
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    pid_fds, ok := this.pid_fds[pid]
+    if !ok {
+        return nil
+    }
+    info, ok := (*pid_fds)[fd]
+    if !ok {
+        return nil
+    }
+    return info.Clone()
+}
+
+func (this *FdHelper) GetPath(pid uint32, fd int32) string {
+    info := this.GetInfo(pid, fd)
+    if info == nil {
+        return ""
+    }
+    return info.String()
+}
+
+func (this *FdHelper) GetSocket(pid uint32, fd int32) *SocketInfo {
+    info := this.GetInfo(pid, fd)
+    if info == nil {
+        return nil
+    }
+    return info.Sock
+}
+
+func (this *FdHelper) setFd(pid uint32, fd int32, info *FdInfo) {
+    pid_fds := this.getFds(pid)
+    if info == nil {
+        delete(*pid_fds, fd)
+        return
+    }
+    (*pid_fds)[fd] = info
+}
+
+func (this *FdHelper) copyFd(pid uint32, old_fd, new_fd int32) {
+    pid_fds := this.getFds(pid)
+    info, ok := (*pid_fds)[old_fd]
+    if !ok {
+        delete(*pid_fds, new_fd)
+        return
+    }
+    (*pid_fds)[new_fd] = info.Clone()
+}
+
+func (this *FdHelper) getSocketFd(pid uint32, fd int32, family uint16) *SocketInfo {
+    // 追踪开始前创建的 socket 可能没有记录 那么按地址的类型补上
+    pid_fds := this.getFds(pid)
+    info, ok := (*pid_fds)[fd]
+    if !ok || info.Sock == nil {
+        info = &FdInfo{Sock: NewSocketInfo(family, 0)}
+        (*pid_fds)[fd] = info
+    }
+    return info.Sock
+}
+
+func (this *FdHelper) Remove(pid uint32, fd int32) {
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    delete(*this.getFds(pid), fd)
+}
+
+func (this *FdHelper) RemovePid(pid uint32) {
+    // 进程结束后 pid 可能被复用
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    delete(this.pid_fds, pid)
+}
+
+func (this *FdHelper) UpdateForkEvent(event *ForkEvent) {
+    // 线程共享 fd 表 不需要处理
+    if event.Pid == event.Ppid {
+        return
+    }
+    // 子进程继承父进程的 fd 父进程没有记录的 等子进程有事件时再处理
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    pid_fds, ok := this.pid_fds[event.Ppid]
+    if !ok {
+        return
+    }
+    copied_fds := pid_fds.Clone()
+    this.pid_fds[event.Pid] = &copied_fds
+}
+
+func (this *FdHelper) UpdateSyscallEnter(event *SyscallEvent, arg_values []config.ArgValue) {
+    // 在参数解析完成之后调用 这样 close 的时候还能展示路径
+    if len(arg_values) == 0 {
+        return
+    }
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    delete(this.pending, event.Tid)
+    switch event.PointName {
+    case "close":
+        delete(*this.getFds(event.Pid), int32(arg_values[0].Value))
+    case "openat", "openat2":
+        if len(arg_values) > 1 && len(arg_values[1].Payloads) > 0 {
+            file_path := util.B2STrim(arg_values[1].Payloads[0])
+            dirfd := int32(arg_values[0].Value)
+            if !path.IsAbs(file_path) && dirfd != AT_FDCWD {
+                // 相对于 dirfd 的路径 当前目录则无从得知
+                if dir, ok := (*this.getFds(event.Pid))[dirfd]; ok && dir.Sock == nil {
+                    file_path = path.Join(dir.Path, file_path)
+                }
+            }
+            this.pending[event.Tid] = &FdPending{Name: event.PointName, Path: file_path}
+        }
+    case "memfd_create":
+        if len(arg_values[0].Payloads) > 0 {
+            file_path := "/memfd:" + util.B2STrim(arg_values[0].Payloads[0])
+            this.pending[event.Tid] = &FdPending{Name: event.PointName, Path: file_path}
+        }
+    case "connect", "bind":
+        if addr := fd_sockaddr(arg_values); addr != nil {
+            this.pending[event.Tid] = &FdPending{Name: event.PointName, Addr: addr}
+        }
+    }
+}
+
+func (this *FdHelper) UpdateSyscallExit(event *SyscallEvent, arg_values []config.ArgValue) {
+    // 在参数解析之前调用 这样返回值的 fd 能够展示新的路径
+    if len(arg_values) == 0 {
+        return
+    }
+    fds_lock.Lock()
+    defer fds_lock.Unlock()
+    pending, ok := this.pending[event.Tid]
+    if ok {
+        delete(this.pending, event.Tid)
+        if pending.Name != event.PointName {
+            pending = nil
+        }
+    }
+    ret, ok := FindArgValue(arg_values, "ret")
+    if !ok || (int32(ret) < 0 && !(event.PointName == "connect" && int32(ret) == -int32(syscall.EINPROGRESS))) {
+        return
+    }
+    pid := event.Pid
+    fd := int32(ret)
+    arg0 := int32(arg_values[0].Value)
+    switch event.PointName {
+    case "openat", "openat2", "memfd_create":
+        if pending != nil {
+            this.setFd(pid, fd, &FdInfo{Path: pending.Path})
+        } else {
+            this.setFd(pid, fd, nil)
+        }
+    case "dup":
+        this.copyFd(pid, arg0, fd)
+    case "dup3":
+        if len(arg_values) > 1 {
+            this.copyFd(pid, arg0, int32(arg_values[1].Value))
+        }
+    case "fcntl":
+        // F_DUPFD 和 F_DUPFD_CLOEXEC
+        if len(arg_values) > 1 && (arg_values[1].Value == syscall.F_DUPFD || arg_values[1].Value == syscall.F_DUPFD_CLOEXEC) {
+            this.copyFd(pid, arg0, fd)
+        }
+    case "socket":
+        if len(arg_values) > 1 {
+            sock := NewSocketInfo(uint16(arg_values[0].Value), uint32(arg_values[1].Value))
+            this.setFd(pid, fd, &FdInfo{Sock: sock})
+        }
+    case "socketpair":
+        if fds := fd_array(arg_values, 3); len(fds) == 2 {
+            sock := NewSocketInfo(uint16(arg_values[0].Value), uint32(arg_values[1].Value))
+            pair_sock := *sock
+            this.setFd(pid, fds[0], &FdInfo{Sock: sock})
+            this.setFd(pid, fds[1], &FdInfo{Sock: &pair_sock})
+        }
+    case "pipe2":
+        // 与 /proc/pid/fd 的 pipe:[inode] 不同 这里展示另一端的 fd
+        if fds := fd_array(arg_values, 0); len(fds) == 2 {
+            pipe_name := fmt.Sprintf("pipe:[%d->%d]", fds[1], fds[0])
+            this.setFd(pid, fds[0], &FdInfo{Path: pipe_name})
+            this.setFd(pid, fds[1], &FdInfo{Path: pipe_name})
+        }
+    case "accept", "accept4":
+        // 新的 socket 沿用监听 socket 的协议和本端地址
+        sock := &SocketInfo{}
+        if listen_sock := this.getSocketFd(pid, arg0, 0); listen_sock != nil {
+            *sock = *listen_sock
+            sock.Remote = netip.AddrPort{}
+        }
+        if addr := fd_sockaddr(arg_values); addr != nil {
+            sock.SetAddr(addr, true)
+        }
+        this.setFd(pid, fd, &FdInfo{Sock: sock})
+    case "connect", "bind":
+        if pending != nil {
+            sock := this.getSocketFd(pid, arg0, binary.LittleEndian.Uint16(pending.Addr))
+            sock.SetAddr(pending.Addr, event.PointName == "connect")
+        }
+    case "getsockname", "getpeername":
+        if addr := fd_sockaddr(arg_values); addr != nil {
+            sock := this.getSocketFd(pid, arg0, binary.LittleEndian.Uint16(addr))
+            sock.SetAddr(addr, event.PointName == "getpeername")
+        }
+    default:
+        if name, ok := fd_anon_names[event.PointName]; ok {
+            this.setFd(pid, fd, &FdInfo{Path: name})
+            return
+        }
+        // 其他返回 fd 的 syscall 不清楚是什么 至少不要展示之前的路径
+        for _, arg_value := range arg_values {
+            if arg_value.Name == "ret" && arg_value.TypeIndex == common.FD {
+                this.setFd(pid, fd, nil)
+            }
+        }
+    }
+}
+
+func fd_sockaddr(arg_values []config.ArgValue) []byte {
+    for _, arg_value := range arg_values {
+        if arg_value.GetTypeName() == "sockaddr" && len(arg_value.Payloads) > 0 && len(arg_value.Payloads[0]) >= 2 {
+            return arg_value.Payloads[0]
+        }
+    }
+    return nil
+}
+
+func fd_array(arg_values []config.ArgValue, index int) []int32 {
+    // pipe2 和 socketpair 返回的两个 fd
+    if index >= len(arg_values) || len(arg_values[index].Payloads) == 0 {
+        return nil
+    }
+    data := arg_values[index].Payloads[0]
+    var fds []int32
+    for i := 0; i+4 <= len(data); i += 4 {
+        fds = append(fds, int32(binary.LittleEndian.Uint32(data[i:])))
+    }
+    return fds
+}
+
+func (this *FdHelper) FormatFd(pid uint32, fd int32) string {
+    if fd == AT_FDCWD {
+        return "AT_FDCWD"
+    }
+    if fd < 0 {
+        return fmt.Sprintf("%d", fd)
+    }
+    path := this.GetPath(pid, fd)
+    if path == "" {
+        return fmt.Sprintf("%d", fd)
+    }
+    return fmt.Sprintf("%d<%s>", fd, path)
+}
+
+func (this *FdHelper) WatchSyscall(point *config.SyscallPoint, point_type uint32) bool {
+    // 只有会改变 fd 表的 syscall 才需要额外读取参数的值
+    if point_type == config.EBPF_SYS_ENTER {
+        return slices.Contains(fd_enter_syscalls, point.Name)
+    }
+    if slices.Contains(fd_exit_syscalls, point.Name) {
+        return true
+    }
+    for _, point_arg := range point.ExitPointArgs {
+        if point_arg.Name == "ret" && point_arg.TypeIndex == common.FD {
+            return true
+        }
+    }
+    return false
+}
+
+func (this *ContextEvent) GetProcessContext() *argtype.ProcessContext {
+    // 参数按事件所属的进程解析
+    pid := this.Pid
+    proc := &argtype.ProcessContext{Pid: pid}
+    proc.FormatFd = func(fd int32) string {
+        return fd_helper.FormatFd(pid, fd)
+    }
+    proc.FormatAddr = func(addr uint64) string {
+        return FormatProcessAddr(pid, addr)
+    }
+    // 解析 dump 文件时读到的不是事件发生时的内存 宁可不展示
+    if !this.mconf.IsReplay() {
+        proc.ReadMemory = func(addr, size uint64) ([]byte, error) {
+            return util.ReadProcessMemory(pid, addr, size)
+        }
+    }
+    return proc
+}
+
+func ReadFdInfo(pid uint32, fd int32) (*FdInfo, error) {
+    path, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, fd))
+    if err != nil {
+        return nil, err
+    }
+    if strings.HasPrefix(path, "socket:[") {
+        inode := strings.TrimSuffix(strings.TrimPrefix(path, "socket:["), "]")
+        if info := FindSocketByInode(pid, inode); info != nil {
+            return &FdInfo{Sock: info}, nil
+        }
+    }
+    return &FdInfo{Path: path}, nil
+}
+
+type SocketInfo struct {
+    Proto  string
+    Local  netip.AddrPort
+    Remote netip.AddrPort
+    Path   string
+}
+
+func NewSocketInfo(domain uint16, sock_type uint32) *SocketInfo {
+    // type 的高位是 SOCK_NONBLOCK SOCK_CLOEXEC 这些标志
+    info := &SocketInfo{}
+    switch domain {
+    case syscall.AF_UNIX:
+        info.Proto = "unix"
+    case syscall.AF_INET, syscall.AF_INET6:
+        switch sock_type & 0xf {
+        case syscall.SOCK_STREAM:
+            info.Proto = "tcp"
+        case syscall.SOCK_DGRAM:
+            info.Proto = "udp"
+        case 0:
+            // 追踪开始前创建的 类型未知
+            info.Proto = "inet"
+        default:
+            info.Proto = "raw"
+        }
+    case syscall.AF_NETLINK:
+        info.Proto = "netlink"
+    default:
+        info.Proto = fmt.Sprintf("socket(%d)", domain)
+    }
+    return info
+}
+
+func (this *SocketInfo) SetAddr(data []byte, remote bool) {
+    // 原始的 sockaddr 结构体
+    if len(data) >= 2 && binary.LittleEndian.Uint16(data) == syscall.AF_UNIX {
+        sun_path := data[2:]
+        if len(sun_path) > 0 && sun_path[0] == 0 {
+            // 抽象地址
+            this.Path = "@" + util.B2STrim(sun_path[1:])
+        } else {
+            this.Path = util.B2STrim(sun_path)
+        }
+        return
+    }
+    addr, ok := ParseSockaddr(data)
+    if !ok {
+        return
+    }
+    if remote {
+        this.Remote = addr
+    } else {
+        this.Local = addr
+    }
+}
+
+func (this *SocketInfo) String() string {
+    if this.Proto == "unix" {
+        if this.Path == "" {
+            return "unix"
+        }
+        return fmt.Sprintf("unix:%s", this.Path)
+    }
+    if this.Remote.IsValid() {
+        return fmt.Sprintf("%s:%s", this.Proto, this.Remote.String())
+    }
+    if this.Local.IsValid() {
+        return fmt.Sprintf("%s:%s", this.Proto, this.Local.String())
+    }
+    return this.Proto
+}
+
+func FindSocketByInode(pid uint32, inode string) *SocketInfo {
+    // 参考 /proc/net/tcp 等文件的格式
+    // sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+    for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+        fields := findProcNetLine(fmt.Sprintf("/proc/%d/net/%s", pid, proto), 9, inode)
+        if fields == nil {
+            continue
+        }
+        info := &SocketInfo{}
+        info.Proto = strings.TrimSuffix(proto, "6")
+        info.Local = parseProcNetAddr(fields[1])
+        info.Remote = parseProcNetAddr(fields[2])
+        return info
+    }
+    // Num RefCount Protocol Flags Type St Inode Path
+    fields := findProcNetLine(fmt.Sprintf("/proc/%d/net/unix", pid), 6, inode)
+    if fields != nil {
+        info := &SocketInfo{}
+        info.Proto = "unix"
+        if len(fields) > 7 {
+            info.Path = fields[7]
+        } else {
+            info.Path = fmt.Sprintf("[%s]", inode)
+        }
+        return info
+    }
+    return nil
+}
+
+func findProcNetLine(filename string, inode_index int, inode string) []string {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) > inode_index && fields[inode_index] == inode {
+            return fields
+        }
+    }
+    return nil
+}
+
+func parseProcNetAddr(text string) netip.AddrPort {
+    // 形如 0100007F:1F90 地址按 32 位小端序存储 端口是大端序
+    // 未指定的地址返回无效值
+    items := strings.Split(text, ":")
+    if len(items) != 2 {
+        return netip.AddrPort{}
+    }
+    raw, err := hex.DecodeString(items[0])
+    if err != nil || (len(raw) != 4 && len(raw) != 16) {
+        return netip.AddrPort{}
+    }
+    port, err := strconv.ParseUint(items[1], 16, 16)
+    if err != nil {
+        return netip.AddrPort{}
+    }
+    ip := make([]byte, len(raw))
+    for i := 0; i < len(raw); i += 4 {
+        binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+    }
+    addr, _ := netip.AddrFromSlice(ip)
+    addr = addr.Unmap()
+    if addr.IsUnspecified() && port == 0 {
+        return netip.AddrPort{}
+    }
+    return netip.AddrPortFrom(addr, uint16(port))
+}
@@ -0,0 +1,230 @@
+package event
+
+import (
+    "fmt"
+    "os"
+    "regexp"
+    "sort"
+    "stackplz/user/util"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// 按 hook 点聚合相同的堆栈 输出 folded stack 文本和 pprof 格式的 profile 用于生成火焰图
+
+type StackFrame struct {
+    LibPath string
+    // 相对于库的偏移 即 unwindstack 中的 rel_pc
+    RelPc  uint64
+    Symbol string
+}
+
+func (this *StackFrame) Name() string {
+    if this.Symbol != "" {
+        return this.Symbol
+    }
+    items := strings.Split(this.LibPath, "/")
+    return fmt.Sprintf("%s+0x%x", items[len(items)-1], this.RelPc)
+}
+
+// unwindstack 的格式 #00 pc 000000000004f8a4  /apex/com.android.runtime/lib64/bionic/libc.so (openat+4)
+var unwind_frame_regex = regexp.MustCompile(`#\d+\s+pc\s+([0-9a-fA-F]+)\s+(\S+)(.*)`)
+var unwind_extra_regex = regexp.MustCompile(`\s*\((offset 0x[0-9a-fA-F]+|BuildId: [^)]*)\)`)
+var unwind_symbol_regex = regexp.MustCompile(`^\s*\((.+)\+(\d+)\)`)
+
+// GetStack 的格式 0x7fb1234567 <libc.so + 0x4f8a4>
+var region_frame_regex = regexp.MustCompile(`0x[0-9a-fA-F]+ <(.+) \+ 0x([0-9a-fA-F]+)>`)
+
+func ParseStackFrames(stack_info string) []StackFrame {
+    var frames []StackFrame
+    for _, line := range strings.Split(stack_info, "\n") {
+        if m := unwind_frame_regex.FindStringSubmatch(line); m != nil {
+            rel_pc, err := strconv.ParseUint(m[1], 16, 64)
+            if err != nil {
+                continue
+            }
+            frame := StackFrame{LibPath: m[2], RelPc: rel_pc}
+            if sym := unwind_symbol_regex.FindStringSubmatch(unwind_extra_regex.ReplaceAllString(m[3], "")); sym != nil {
+                frame.Symbol = sym[1]
+            }
+            frames = append(frames, frame)
+        } else if m := region_frame_regex.FindStringSubmatch(line); m != nil {
+            rel_pc, err := strconv.ParseUint(m[2], 16, 64)
+            if err != nil {
+                continue
+            }
+            frames = append(frames, StackFrame{LibPath: m[1], RelPc: rel_pc})
+        }
+    }
+    return frames
+}
+
+type FlameStack struct {
+    Point string
+    Pid   uint32
+    // 第一个是栈顶 即 hook 点所在的位置
+    Frames []StackFrame
+    Count  uint64
+}
+
+type FlameHelper struct {
+    folded_path string
+    pprof_path  string
+    stacks      map[string]*FlameStack
+    start       time.Time
+}
+
+func NewFlameHelper() *FlameHelper {
+    helper := &FlameHelper{}
+    helper.stacks = make(map[string]*FlameStack)
+    helper.start = time.Now()
+    return helper
+}
+
+var flame_lock sync.Mutex
+var flame_helper = NewFlameHelper()
+
+func (this *FlameHelper) AddStack(event *ContextEvent, point string) {
+    if event.Stackinfo == "" {
+        return
+    }
+    frames := ParseStackFrames(event.Stackinfo)
+    if len(frames) == 0 {
+        return
+    }
+    var names []string
+    for _, frame := range frames {
+        names = append(names, fmt.Sprintf("%s@%x", frame.LibPath, frame.RelPc))
+    }
+    key := point + "|" + strings.Join(names, "|")
+    flame_lock.Lock()
+    defer flame_lock.Unlock()
+    this.folded_path = event.mconf.FoldedFile
+    this.pprof_path = event.mconf.PprofFile
+    stack, ok := this.stacks[key]
+    if !ok {
+        stack = &FlameStack{Point: point, Pid: event.Pid, Frames: frames}
+        this.stacks[key] = stack
+    }
+    stack.Count += 1
+}
+
+func (this *FlameHelper) sortedStacks() []*FlameStack {
+    var stacks []*FlameStack
+    for _, stack := range this.stacks {
+        stacks = append(stacks, stack)
+    }
+    sort.Slice(stacks, func(i, j int) bool {
+        if stacks[i].Point != stacks[j].Point {
+            return stacks[i].Point < stacks[j].Point
+        }
+        return stacks[i].Count > stacks[j].Count
+    })
+    return stacks
+}
+
+func (this *FlameHelper) WriteFolded(path string) error {
+    // 每行 hook点;最外层调用;...;栈顶 次数 可以直接交给 flamegraph.pl
+    var lines []string
+    for _, stack := range this.sortedStacks() {
+        names := []string{stack.Point}
+        for i := len(stack.Frames) - 1; i >= 0; i-- {
+            names = append(names, strings.ReplaceAll(stack.Frames[i].Name(), ";", ":"))
+        }
+        lines = append(lines, fmt.Sprintf("%s %d", strings.Join(names, ";"), stack.Count))
+    }
+    return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func (this *FlameHelper) WritePprof(path string) error {
+    profile := util.NewPprofProfile("hits", "count")
+    profile.TimeNanos = this.start.UnixNano()
+    profile.DurationNanos = int64(time.Since(this.start))
+    mappings := make(map[string]*util.PprofMapping)
+    functions := make(map[string]*util.PprofFunction)
+    locations := make(map[string]*util.PprofLocation)
+    get_function := func(name, file string) *util.PprofFunction {
+        key := file + "|" + name
+        function, ok := functions[key]
+        if !ok {
+            function = profile.AddFunction(name, file)
+            functions[key] = function
+        }
+        return function
+    }
+    get_mapping := func(pid uint32, lib_path string) *util.PprofMapping {
+        // 同一个库在不同进程中的基址可能不同 以首次遇到时的 maps 为准
+        mapping, ok := mappings[lib_path]
+        if ok {
+            return mapping
+        }
+        pid_maps, err := maps_helper.FindLib(pid)
+        if err == nil {
+            for _, lib_info := range pid_maps[lib_path] {
+                if mapping == nil {
+                    mapping = &util.PprofMapping{Start: lib_info.BaseAddr, Limit: lib_info.EndAddr, Offset: lib_info.Off}
+                    continue
+                }
+                if lib_info.BaseAddr < mapping.Start {
+                    mapping.Start = lib_info.BaseAddr
+                    mapping.Offset = lib_info.Off
+                }
+                if lib_info.EndAddr > mapping.Limit {
+                    mapping.Limit = lib_info.EndAddr
+                }
+            }
+        }
+        if mapping != nil {
+            mapping = profile.AddMapping(mapping.Start, mapping.Limit, mapping.Offset, lib_path)
+        }
+        mappings[lib_path] = mapping
+        return mapping
+    }
+    for _, stack := range this.sortedStacks() {
+        var location_ids []uint64
+        for _, frame := range stack.Frames {
+            key := fmt.Sprintf("%s@%x", frame.LibPath, frame.RelPc)
+            location, ok := locations[key]
+            if !ok {
+                var mapping_id, address uint64
+                if mapping := get_mapping(stack.Pid, frame.LibPath); mapping != nil {
+                    mapping_id = mapping.Id
+                    address = mapping.Start + frame.RelPc
+                }
+                location = profile.AddLocation(mapping_id, address, get_function(frame.Name(), frame.LibPath).Id)
+                locations[key] = location
+            }
+            location_ids = append(location_ids, location.Id)
+        }
+        // hook 点作为最外层 这样火焰图按 hook 点分开
+        key := "point|" + stack.Point
+        location, ok := locations[key]
+        if !ok {
+            location = profile.AddLocation(0, 0, get_function(stack.Point, "").Id)
+            locations[key] = location
+        }
+        location_ids = append(location_ids, location.Id)
+        profile.AddSample(location_ids, int64(stack.Count), map[string]string{"point": stack.Point})
+    }
+    return profile.Write(path)
+}
+
+func FlameClose() error {
+    // 两种格式互不影响 都尝试写入 返回第一个错误
+    flame_lock.Lock()
+    defer flame_lock.Unlock()
+    var result error
+    if flame_helper.folded_path != "" {
+        if err := flame_helper.WriteFolded(flame_helper.folded_path); err != nil {
+            result = fmt.Errorf("write folded stack to %s failed, err:%v", flame_helper.folded_path, err)
+        }
+    }
+    if flame_helper.pprof_path != "" {
+        if err := flame_helper.WritePprof(flame_helper.pprof_path); err != nil && result == nil {
+            result = fmt.Errorf("write pprof to %s failed, err:%v", flame_helper.pprof_path, err)
+        }
+    }
+    return result
+}
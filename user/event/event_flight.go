@@ -0,0 +1,119 @@
+package event
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "stackplz/user/config"
+
+    "golang.org/x/sys/unix"
+)
+
+// 飞行记录模式下样本不做完整解析 只取出判断是否触发需要的信息
+type FlightPeek struct {
+    Pid    uint32
+    Point  string
+    Signal uint32
+    Exit   bool
+    Size   uint64
+}
+
+func (this *CommonEvent) PeekFlight() (peek FlightPeek, err error) {
+    peek.Size = uint64(len(this.rec.RawSample))
+    if this.rec.RecordType != unix.PERF_RECORD_EXIT {
+        return peek, nil
+    }
+    // pid|ppid|tid|ptid|time
+    if len(this.rec.RawSample) < 12 {
+        return peek, fmt.Errorf("exit record too short, len:%d", len(this.rec.RawSample))
+    }
+    peek.Pid = binary.LittleEndian.Uint32(this.rec.RawSample[0:])
+    tid := binary.LittleEndian.Uint32(this.rec.RawSample[8:])
+    // 主线程退出即进程退出
+    peek.Exit = peek.Pid == tid
+    return peek, nil
+}
+
+func (this *ContextEvent) peekContext(fields *config.ContextFields) (*bytes.Buffer, error) {
+    buf := bytes.NewBuffer(this.rec.RawSample)
+    var sample_size uint32
+    if err := binary.Read(buf, binary.LittleEndian, &sample_size); err != nil {
+        return nil, err
+    }
+    if err := binary.Read(buf, binary.LittleEndian, fields); err != nil {
+        return nil, err
+    }
+    return buf, nil
+}
+
+func peekSignal(peek *FlightPeek, buf *bytes.Buffer) error {
+    // 信号来自 signal_deliver 内核产生的 SIGSEGV SIGABRT 以及其他进程发来的都能看到
+    peek_event := &ContextEvent{}
+    peek_event.buf = buf
+    var sig int32
+    if err := peek_event.ReadArg(&sig); err != nil {
+        return err
+    }
+    peek.Signal = uint32(sig)
+    peek.Point = "signal_deliver"
+    return nil
+}
+
+func (this *SyscallEvent) PeekFlight() (peek FlightPeek, err error) {
+    if this.rec.RecordType != unix.PERF_RECORD_SAMPLE {
+        return this.CommonEvent.PeekFlight()
+    }
+    peek.Size = uint64(len(this.rec.RawSample))
+    var fields config.ContextFields
+    buf, err := this.peekContext(&fields)
+    if err != nil {
+        return peek, err
+    }
+    peek.Pid = fields.Pid
+    if fields.EventId == SIGNAL_DELIVER {
+        return peek, peekSignal(&peek, buf)
+    }
+    if fields.EventId != SYSCALL_ENTER {
+        return peek, nil
+    }
+    peek_event := &SyscallEvent{}
+    peek_event.buf = buf
+    if err = peek_event.ReadArg(&peek_event.NR); err != nil {
+        return peek, err
+    }
+    point := this.mconf.SysCallConf.FindSyscallPointByNR(peek_event.NR)
+    if point == nil {
+        return peek, fmt.Errorf("unknown syscall nr:%d", peek_event.NR)
+    }
+    peek.Point = point.Name
+    return peek, nil
+}
+
+func (this *UprobeEvent) PeekFlight() (peek FlightPeek, err error) {
+    if this.rec.RecordType != unix.PERF_RECORD_SAMPLE {
+        return this.CommonEvent.PeekFlight()
+    }
+    peek.Size = uint64(len(this.rec.RawSample))
+    var fields config.ContextFields
+    buf, err := this.peekContext(&fields)
+    if err != nil {
+        return peek, err
+    }
+    peek.Pid = fields.Pid
+    if fields.EventId == SIGNAL_DELIVER {
+        return peek, peekSignal(&peek, buf)
+    }
+    if fields.EventId != UPROBE_ENTER {
+        return peek, nil
+    }
+    peek_event := &UprobeEvent{}
+    peek_event.buf = buf
+    if err = peek_event.ReadArg(&peek_event.ProbeIndex); err != nil {
+        return peek, err
+    }
+    if peek_event.ProbeIndex >= uint32(len(this.mconf.StackUprobeConf.Points)) {
+        return peek, fmt.Errorf("probe_index %d bigger than points", peek_event.ProbeIndex)
+    }
+    peek.Point = this.mconf.StackUprobeConf.Points[peek_event.ProbeIndex].Name
+    return peek, nil
+}
@@ -15,6 +15,7 @@ import (
 type ForkEvent struct {
     CommonEvent
     config.ForkFields
+    pkg string
 }
 
 func (this *ForkEvent) String() string {
@@ -27,7 +28,7 @@ func (this *ForkEvent) String() string {
     }
 
     var s string
-    s = fmt.Sprintf("[ForkEvent] %s ppid=%d ptid=%d time=%d", this.GetUUID(), this.Ppid, this.Ptid, this.Time)
+    s = fmt.Sprintf("[ForkEvent] %s%s ppid=%d ptid=%d time=%d", this.pkg, this.GetUUID(), this.Ppid, this.Ptid, this.Time)
     return s
 }
 
@@ -44,13 +45,17 @@ func (this *ForkEvent) ParseContext() (err error) {
     if this.mconf.SelfPid == this.Pid {
         return nil
     }
-    this.ReadValue(&this.Ppid)
-    this.ReadValue(&this.Tid)
-    this.ReadValue(&this.Ptid)
-    this.ReadValue(&this.Time)
+    if err = this.ReadValues(&this.Ppid, &this.Tid, &this.Ptid, &this.Time); err != nil {
+        return err
+    }
     if this.mconf.Debug {
         this.logger.Printf(this.String())
     }
+    trace_helper.AddForkEvent(this)
+    db_helper.AddForkEvent(this)
+    this.mconf.AddReplayFork(this.Ppid, this.Pid)
+    // 父进程有记录时才会复制 不需要按进程过滤
+    fd_helper.UpdateForkEvent(this)
 
     if slices.Contains(this.mconf.PidWhitelist, this.Pid) {
         maps_helper.UpdateForkEvent(this)
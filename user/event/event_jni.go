@@ -0,0 +1,73 @@
+package event
+
+import (
+    "fmt"
+    "stackplz/user/config"
+    "sync"
+)
+
+// 每个进程中已经加载过的库 重复调用 LoadNativeLibrary 不会再次执行 JNI_OnLoad
+var jni_loaded = make(map[uint32][]string)
+var jni_lock sync.Mutex
+
+func FormatProcessAddr(pid uint32, addr uint64) string {
+    // 刚加载的库可能还没有记录 先确认一次
+    if addr == 0 || maps_helper.FindLibByAddr(pid, addr) == nil {
+        return fmt.Sprintf("0x%x", addr)
+    }
+    return fmt.Sprintf("0x%x<%s>", addr, maps_helper.GetOffset(pid, addr))
+}
+
+func (this *MapsHelper) FindAddrByOffset(pid uint32, path string, offset uint64) (uint64, bool) {
+    // 根据文件偏移找到其在进程中的地址 找不到的时候重新读取一次 maps
+    maps_lock.Lock()
+    defer maps_lock.Unlock()
+    for i := 0; i < 2; i++ {
+        pid_maps, ok := this.pid_maps[pid]
+        if ok {
+            for _, lib_info := range (*pid_maps)[path] {
+                if offset >= lib_info.Off && offset < lib_info.Off+(lib_info.EndAddr-lib_info.BaseAddr) {
+                    return lib_info.BaseAddr + (offset - lib_info.Off), true
+                }
+            }
+        }
+        if i == 0 && this.ParseMaps(pid, false) != nil {
+            break
+        }
+    }
+    return 0, false
+}
+
+func AnnotateJniEvent(event *UprobeEvent, arg_values []config.ArgValue) string {
+    if event.uprobe_point.Name != "LoadNativeLibrary" {
+        return ""
+    }
+    var lib_path string
+    for _, arg_value := range arg_values {
+        if arg_value.Name == "path" && len(arg_value.Payloads) > 0 {
+            lib_path = string(arg_value.Payloads[0])
+        }
+    }
+    ret, _ := FindArgValue(arg_values, "ret")
+    if lib_path == "" || ret&0xff == 0 {
+        // 加载失败
+        return ""
+    }
+    jni_lock.Lock()
+    defer jni_lock.Unlock()
+    for _, loaded_path := range jni_loaded[event.Pid] {
+        if loaded_path == lib_path {
+            return " JNI_OnLoad=<already loaded>"
+        }
+    }
+    jni_loaded[event.Pid] = append(jni_loaded[event.Pid], lib_path)
+    map_path, offset, err := config.FindSymbolFileOffset(lib_path, "JNI_OnLoad")
+    if err != nil {
+        return " JNI_OnLoad=<none>"
+    }
+    addr, ok := maps_helper.FindAddrByOffset(event.Pid, map_path, offset)
+    if !ok {
+        return fmt.Sprintf(" JNI_OnLoad=<%s + 0x%x>", map_path, offset)
+    }
+    return " JNI_OnLoad=" + FormatProcessAddr(event.Pid, addr)
+}
@@ -0,0 +1,301 @@
+package event
+
+import (
+    "encoding/json"
+    "fmt"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strconv"
+    "sync"
+    "syscall"
+
+    "golang.org/x/exp/slices"
+    "golang.org/x/sys/unix"
+)
+
+// --lifecycle 输出被追踪进程的 fork comm 修改 退出以及库的映射
+// perf 的 fork/comm/mmap2 记录来自所有进程 这里按 pid 记录是否被追踪以及 uid
+// uid 取自 ebpf 事件 不读 /proc 短命的进程和解析 dump 时都一样可用
+type LifecycleInfo struct {
+    Traced bool
+    Uid    uint32
+}
+
+type LifecycleHelper struct {
+    pids map[uint32]*LifecycleInfo
+}
+
+func NewLifecycleHelper() *LifecycleHelper {
+    helper := &LifecycleHelper{}
+    helper.pids = make(map[uint32]*LifecycleInfo)
+    return helper
+}
+
+var lifecycle_helper = NewLifecycleHelper()
+var lifecycle_lock sync.Mutex
+
+func (this *LifecycleHelper) matchFilter(mconf *config.ModuleConfig, pid, uid uint32) bool {
+    // 与 ebpf 中的规则一致 黑名单优先
+    if pid == mconf.SelfPid {
+        return false
+    }
+    if slices.Contains(mconf.PidBlacklist, pid) || slices.Contains(mconf.UidBlacklist, uid) {
+        return false
+    }
+    if slices.Contains(mconf.PidWhitelist, pid) || slices.Contains(mconf.UidWhitelist, uid) {
+        return true
+    }
+    // 其他过滤方式下 以已经有事件输出的进程为准
+    maps_lock.Lock()
+    defer maps_lock.Unlock()
+    return slices.Contains(pid_list, pid)
+}
+
+func (this *LifecycleHelper) Lookup(mconf *config.ModuleConfig, pid uint32) LifecycleInfo {
+    lifecycle_lock.Lock()
+    defer lifecycle_lock.Unlock()
+    info, ok := this.pids[pid]
+    if ok {
+        if !info.Traced {
+            // 之前不满足的 可能在之后才有事件输出
+            info.Traced = this.matchFilter(mconf, pid, info.Uid)
+        }
+        return *info
+    }
+    // 还没有见过这个进程的事件 不知道 uid 先不输出
+    return LifecycleInfo{}
+}
+
+func (this *LifecycleHelper) AddSample(pid, uid uint32) {
+    // 通过了 ebpf 过滤的事件 所属进程就是被追踪的
+    lifecycle_lock.Lock()
+    defer lifecycle_lock.Unlock()
+    info, ok := this.pids[pid]
+    if !ok {
+        info = &LifecycleInfo{}
+        this.pids[pid] = info
+    }
+    info.Uid = uid
+    info.Traced = true
+}
+
+func (this *LifecycleHelper) AddFork(mconf *config.ModuleConfig, ppid, pid uint32) LifecycleInfo {
+    if ppid == pid {
+        // 创建线程
+        return this.Lookup(mconf, pid)
+    }
+    // 子进程跟随父进程 fork 时 uid 也相同
+    parent := this.Lookup(mconf, ppid)
+    lifecycle_lock.Lock()
+    defer lifecycle_lock.Unlock()
+    info := parent
+    this.pids[pid] = &info
+    return info
+}
+
+func (this *LifecycleHelper) Remove(pid uint32) {
+    // 进程结束后 pid 可能被复用
+    lifecycle_lock.Lock()
+    defer lifecycle_lock.Unlock()
+    delete(this.pids, pid)
+}
+
+func GetLifecyclePackage(mconf *config.ModuleConfig, info LifecycleInfo) string {
+    if !mconf.ShowPkg {
+        return ""
+    }
+    name := mconf.FindPackageName(info.Uid)
+    if name == "" {
+        name = strconv.FormatUint(uint64(info.Uid), 10)
+    }
+    return name + "|"
+}
+
+// 进程和线程退出 由 ebpf 在 sched_process_exit 时输出 perf 的 exit 记录没有退出码
+type TaskExitEvent struct {
+    ContextEvent
+    ExitCode int32
+    skip     bool
+}
+
+func (this *ContextEvent) NewTaskExitEvent() (IEventStruct, error) {
+    event := &TaskExitEvent{ContextEvent: *this}
+    err := event.ParseContext()
+    if err != nil {
+        return nil, fmt.Errorf("TaskExitEvent.ParseContext() err:%v", err)
+    }
+    if event.skip || event.mconf.SkipEventLog() {
+        return nil, nil
+    }
+    return event, nil
+}
+
+func (this *TaskExitEvent) ParseContext() (err error) {
+    if err = this.ReadArg(&this.ExitCode); err != nil {
+        return err
+    }
+    if err = this.ParsePadding(); err != nil {
+        return err
+    }
+    if this.mconf.IsReplay() {
+        this.skip = !this.MatchReplay()
+    }
+    return nil
+}
+
+func (this *TaskExitEvent) GetCode() int32 {
+    // exit_code 的低 7 位是导致退出的信号 之后的 8 位是退出码
+    return (this.ExitCode >> 8) & 0xff
+}
+
+func (this *TaskExitEvent) GetSignal() string {
+    sig := this.ExitCode & 0x7f
+    if sig == 0 {
+        return ""
+    }
+    name := unix.SignalName(syscall.Signal(sig))
+    if name == "" {
+        name = fmt.Sprintf("%d", sig)
+    }
+    return name
+}
+
+func (this *TaskExitEvent) GetUUID() string {
+    s := fmt.Sprintf("%d|%d|%s", this.Pid, this.Tid, util.B2STrim(this.Comm[:]))
+    if this.mconf.ShowTime {
+        s = fmt.Sprintf("%d|%s", this.Ts, s)
+    }
+    if this.mconf.ShowUid {
+        s = fmt.Sprintf("%d|%s", this.Uid, s)
+    }
+    if this.mconf.ShowPkg {
+        s = fmt.Sprintf("%s|%s", this.GetPackage(), s)
+    }
+    return s
+}
+
+func (this *TaskExitEvent) MarshalJSON() ([]byte, error) {
+    type ContextAlias config.ContextFields
+    return json.Marshal(&struct {
+        Event   string `json:"event"`
+        Comm    string `json:"comm"`
+        Package string `json:"package,omitempty"`
+        *ContextAlias
+        Code   int32  `json:"code"`
+        Signal string `json:"signal,omitempty"`
+    }{
+        Event:        "exit",
+        Comm:         util.B2STrim(this.Comm[:]),
+        Package:      this.GetPackageJson(),
+        ContextAlias: (*ContextAlias)(&this.ContextFields),
+        Code:         this.GetCode(),
+        Signal:       this.GetSignal(),
+    })
+}
+
+func (this *TaskExitEvent) String() string {
+    if this.mconf.FmtJson {
+        data, err := json.Marshal(this)
+        if err != nil {
+            panic(err)
+        }
+        return string(data)
+    }
+    s := fmt.Sprintf("[ExitEvent] %s code=%d", this.GetUUID(), this.GetCode())
+    if sig := this.GetSignal(); sig != "" {
+        s += fmt.Sprintf(" signal=%s", sig)
+    }
+    return s
+}
+
+func (this *TaskExitEvent) Clone() IEventStruct {
+    event := new(TaskExitEvent)
+    return event
+}
+
+// 进程收到的信号 由 ebpf 在 signal_deliver 时输出 用于触发飞行记录
+type SignalEvent struct {
+    ContextEvent
+    Signal int32
+    skip   bool
+}
+
+func (this *ContextEvent) NewSignalEvent() (IEventStruct, error) {
+    event := &SignalEvent{ContextEvent: *this}
+    err := event.ParseContext()
+    if err != nil {
+        return nil, fmt.Errorf("SignalEvent.ParseContext() err:%v", err)
+    }
+    if event.skip || event.mconf.SkipEventLog() {
+        return nil, nil
+    }
+    return event, nil
+}
+
+func (this *SignalEvent) ParseContext() (err error) {
+    if err = this.ReadArg(&this.Signal); err != nil {
+        return err
+    }
+    if err = this.ParsePadding(); err != nil {
+        return err
+    }
+    if this.mconf.IsReplay() {
+        this.skip = !this.MatchReplay()
+    }
+    return nil
+}
+
+func (this *SignalEvent) GetSignal() string {
+    name := unix.SignalName(syscall.Signal(this.Signal))
+    if name == "" {
+        name = fmt.Sprintf("%d", this.Signal)
+    }
+    return name
+}
+
+func (this *SignalEvent) GetUUID() string {
+    s := fmt.Sprintf("%d|%d|%s", this.Pid, this.Tid, util.B2STrim(this.Comm[:]))
+    if this.mconf.ShowTime {
+        s = fmt.Sprintf("%d|%s", this.Ts, s)
+    }
+    if this.mconf.ShowUid {
+        s = fmt.Sprintf("%d|%s", this.Uid, s)
+    }
+    if this.mconf.ShowPkg {
+        s = fmt.Sprintf("%s|%s", this.GetPackage(), s)
+    }
+    return s
+}
+
+func (this *SignalEvent) MarshalJSON() ([]byte, error) {
+    type ContextAlias config.ContextFields
+    return json.Marshal(&struct {
+        Event   string `json:"event"`
+        Comm    string `json:"comm"`
+        Package string `json:"package,omitempty"`
+        *ContextAlias
+        Signal string `json:"signal"`
+    }{
+        Event:        "signal",
+        Comm:         util.B2STrim(this.Comm[:]),
+        Package:      this.GetPackageJson(),
+        ContextAlias: (*ContextAlias)(&this.ContextFields),
+        Signal:       this.GetSignal(),
+    })
+}
+
+func (this *SignalEvent) String() string {
+    if this.mconf.FmtJson {
+        data, err := json.Marshal(this)
+        if err != nil {
+            panic(err)
+        }
+        return string(data)
+    }
+    return fmt.Sprintf("[SignalEvent] %s signal=%s", this.GetUUID(), this.GetSignal())
+}
+
+func (this *SignalEvent) Clone() IEventStruct {
+    event := new(SignalEvent)
+    return event
+}
@@ -0,0 +1,64 @@
+package event
+
+import (
+    "encoding/binary"
+    "fmt"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strings"
+)
+
+const MAX_INIT_ARRAY_COUNT = 256
+
+func FindArgValue(arg_values []config.ArgValue, name string) (uint64, bool) {
+    for _, arg_value := range arg_values {
+        if arg_value.Name == name {
+            return arg_value.Value, true
+        }
+    }
+    return 0, false
+}
+
+func (this *UprobeEvent) FormatAddr(addr uint64) string {
+    return FormatProcessAddr(this.Pid, addr)
+}
+
+func AnnotateLinkerEvent(event *UprobeEvent, arg_values []config.ArgValue) string {
+    // 补充 linker 预设中需要结合进程内存布局才能得到的信息
+    var results []string
+    switch event.uprobe_point.Name {
+    case "do_dlopen", "do_dlsym":
+        // 发起调用的位置
+        caller, ok := FindArgValue(arg_values, "caller")
+        if ok && caller != 0 {
+            results = append(results, "caller="+event.FormatAddr(caller))
+        }
+    case "call_array":
+        // 此时库已经完成重定位 init_array 中就是函数的实际地址 直接从进程内存中读取
+        functions, _ := FindArgValue(arg_values, "functions")
+        count, _ := FindArgValue(arg_values, "count")
+        if functions == 0 || count == 0 {
+            break
+        }
+        if count > MAX_INIT_ARRAY_COUNT {
+            count = MAX_INIT_ARRAY_COUNT
+        }
+        data, err := util.ReadProcessMemory(event.Pid, functions, count*8)
+        if err != nil {
+            break
+        }
+        var funcs []string
+        for i := 0; i+8 <= len(data); i += 8 {
+            funcs = append(funcs, event.FormatAddr(binary.LittleEndian.Uint64(data[i:])))
+        }
+        lib_info := maps_helper.FindLibByAddr(event.Pid, functions)
+        if lib_info != nil {
+            results = append(results, fmt.Sprintf("base=0x%x", lib_info.BaseAddr-lib_info.Off))
+        }
+        results = append(results, fmt.Sprintf("init_array=[%s]", strings.Join(funcs, ", ")))
+    }
+    if len(results) == 0 {
+        return ""
+    }
+    return " " + strings.Join(results, " ")
+}
@@ -160,6 +160,17 @@ func (this *MapsHelper) UpdateForkEvent(event *ForkEvent) {
     }
 }
 
+func (this *MapsHelper) UpdateExecEvent(event *ExecEvent) {
+    // exec 之后旧程序的映射全部失效 按新程序重新读取 maps
+    maps_lock.Lock()
+    defer maps_lock.Unlock()
+    err := this.ParseMaps(event.Pid, true)
+    if err != nil {
+        // 进程可能已经结束 删除之后下次用到时再读取
+        delete(this.pid_maps, event.Pid)
+    }
+}
+
 func (this *MapsHelper) CloneMaps(pid, parent_pid uint32) (err error) {
     // 为子进程复制一份maps信息
     maps_lock.Lock()
@@ -398,12 +409,32 @@ func (this *MapsHelper) GetOffset(pid uint32, addr uint64) (info string) {
     return strings.Join(off_list[:], ",")
 }
 
+func (this *MapsHelper) FindLibByAddr(pid uint32, addr uint64) *LibInfo {
+    // 刚加载的库可能还没有对应的 mmap 事件 找不到的时候重新读取一次 maps
+    maps_lock.Lock()
+    defer maps_lock.Unlock()
+    for i := 0; i < 2; i++ {
+        pid_maps, ok := this.pid_maps[pid]
+        if ok {
+            region := this.GetRegion(pid_maps, addr)
+            if region.LibPath != "" {
+                return region
+            }
+        }
+        if i == 0 && this.ParseMaps(pid, false) != nil {
+            break
+        }
+    }
+    return nil
+}
+
 var maps_helper = NewMapsHelper()
 var maps_lock sync.Mutex
 
 type Mmap2Event struct {
     CommonEvent
     config.Mmap2Fields
+    pkg string
 }
 
 func (this *Mmap2Event) String() string {
@@ -416,7 +447,7 @@ func (this *Mmap2Event) String() string {
     }
 
     var s string
-    s += fmt.Sprintf("[Mmap2Event] %s addr=0x%x len=0x%x pgoff=0x%x mag=%d min=%d ino=%d ino_generation=%d prot=0x%x flags=0x%x <%s>", this.GetUUID(), this.Addr, this.Len, this.Pgoff, this.Maj, this.Min, this.Ino, this.Ino_generation, this.Prot, this.Flags, this.Filename)
+    s += fmt.Sprintf("[Mmap2Event] %s%s addr=0x%x len=0x%x pgoff=0x%x mag=%d min=%d ino=%d ino_generation=%d prot=0x%x flags=0x%x <%s>", this.pkg, this.GetUUID(), this.Addr, this.Len, this.Pgoff, this.Maj, this.Min, this.Ino, this.Ino_generation, this.Prot, this.Flags, this.Filename)
     return s
 }
 
@@ -434,16 +465,10 @@ func (this *Mmap2Event) ParseContext() (err error) {
         return nil
     }
 
-    this.ReadValue(&this.Tid)
-    this.ReadValue(&this.Addr)
-    this.ReadValue(&this.Len)
-    this.ReadValue(&this.Pgoff)
-    this.ReadValue(&this.Maj)
-    this.ReadValue(&this.Min)
-    this.ReadValue(&this.Ino)
-    this.ReadValue(&this.Ino_generation)
-    this.ReadValue(&this.Prot)
-    this.ReadValue(&this.Flags)
+    err = this.ReadValues(&this.Tid, &this.Addr, &this.Len, &this.Pgoff, &this.Maj, &this.Min, &this.Ino, &this.Ino_generation, &this.Prot, &this.Flags)
+    if err != nil {
+        return err
+    }
 
     var tmp = make([]byte, this.buf.Len())
     if err = binary.Read(this.buf, binary.LittleEndian, &tmp); err != nil {
@@ -0,0 +1,563 @@
+package event
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "net/netip"
+    "os"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "sync"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// pcapng 格式参考 https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+const (
+    PCAPNG_BLOCK_SHB    uint32 = 0x0A0D0D0A
+    PCAPNG_BLOCK_IDB    uint32 = 0x00000001
+    PCAPNG_BLOCK_EPB    uint32 = 0x00000006
+    PCAPNG_BYTE_ORDER   uint32 = 0x1A2B3C4D
+    PCAPNG_OPT_END      uint16 = 0
+    PCAPNG_OPT_COMMENT  uint16 = 1
+    PCAPNG_OPT_IF_NAME  uint16 = 2
+    PCAPNG_OPT_USERAPPL uint16 = 4
+    PCAPNG_OPT_TSRESOL  uint16 = 9
+    // 直接是 IP 包 不需要链路层的头部
+    LINKTYPE_RAW uint16 = 101
+)
+
+const (
+    TCP_FIN uint8 = 0x01
+    TCP_SYN uint8 = 0x02
+    TCP_PSH uint8 = 0x08
+    TCP_ACK uint8 = 0x10
+)
+
+type PcapWriter struct {
+    f         *os.File
+    ts_offset int64
+}
+
+func NewPcapWriter(file_path string) (*PcapWriter, error) {
+    f, err := os.Create(file_path)
+    if err != nil {
+        return nil, err
+    }
+    writer := &PcapWriter{}
+    writer.f = f
+    // 事件的时间来自 bpf_ktime_get_ns 也就是 CLOCK_MONOTONIC 这里换算成实际时间
+    var ts unix.Timespec
+    if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err == nil {
+        writer.ts_offset = time.Now().UnixNano() - ts.Nano()
+    }
+    shb := &bytes.Buffer{}
+    binary.Write(shb, binary.LittleEndian, PCAPNG_BYTE_ORDER)
+    binary.Write(shb, binary.LittleEndian, uint16(1))
+    binary.Write(shb, binary.LittleEndian, uint16(0))
+    // section 长度未知
+    binary.Write(shb, binary.LittleEndian, int64(-1))
+    pcapng_option(shb, PCAPNG_OPT_USERAPPL, []byte("stackplz"))
+    pcapng_option(shb, PCAPNG_OPT_END, nil)
+    if err := writer.WriteBlock(PCAPNG_BLOCK_SHB, shb.Bytes()); err != nil {
+        return nil, err
+    }
+    idb := &bytes.Buffer{}
+    binary.Write(idb, binary.LittleEndian, LINKTYPE_RAW)
+    binary.Write(idb, binary.LittleEndian, uint16(0))
+    binary.Write(idb, binary.LittleEndian, uint32(0))
+    pcapng_option(idb, PCAPNG_OPT_IF_NAME, []byte("stackplz"))
+    // 时间精度为纳秒
+    pcapng_option(idb, PCAPNG_OPT_TSRESOL, []byte{9})
+    pcapng_option(idb, PCAPNG_OPT_END, nil)
+    if err := writer.WriteBlock(PCAPNG_BLOCK_IDB, idb.Bytes()); err != nil {
+        return nil, err
+    }
+    return writer, nil
+}
+
+func pcapng_pad(buf *bytes.Buffer) {
+    for buf.Len()%4 != 0 {
+        buf.WriteByte(0)
+    }
+}
+
+func pcapng_option(buf *bytes.Buffer, code uint16, value []byte) {
+    if len(value) > 0xffff {
+        value = value[:0xffff]
+    }
+    binary.Write(buf, binary.LittleEndian, code)
+    binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+    buf.Write(value)
+    pcapng_pad(buf)
+}
+
+func (this *PcapWriter) WriteBlock(block_type uint32, body []byte) error {
+    total_len := uint32(12 + len(body))
+    buf := &bytes.Buffer{}
+    binary.Write(buf, binary.LittleEndian, block_type)
+    binary.Write(buf, binary.LittleEndian, total_len)
+    buf.Write(body)
+    binary.Write(buf, binary.LittleEndian, total_len)
+    // 每个 block 单独写入 这样即使中途退出文件也是完整的
+    _, err := this.f.Write(buf.Bytes())
+    return err
+}
+
+func (this *PcapWriter) WritePacket(ts uint64, packet []byte, orig_len int, comment string) error {
+    body := &bytes.Buffer{}
+    t := uint64(int64(ts) + this.ts_offset)
+    binary.Write(body, binary.LittleEndian, uint32(0))
+    binary.Write(body, binary.LittleEndian, uint32(t>>32))
+    binary.Write(body, binary.LittleEndian, uint32(t))
+    binary.Write(body, binary.LittleEndian, uint32(len(packet)))
+    binary.Write(body, binary.LittleEndian, uint32(orig_len))
+    body.Write(packet)
+    pcapng_pad(body)
+    if comment != "" {
+        pcapng_option(body, PCAPNG_OPT_COMMENT, []byte(comment))
+    }
+    pcapng_option(body, PCAPNG_OPT_END, nil)
+    return this.WriteBlock(PCAPNG_BLOCK_EPB, body.Bytes())
+}
+
+func (this *PcapWriter) Close() error {
+    return this.f.Close()
+}
+
+func inet_checksum(data []byte, sum uint32) uint16 {
+    for i := 0; i+1 < len(data); i += 2 {
+        sum += uint32(data[i])<<8 | uint32(data[i+1])
+    }
+    if len(data)%2 == 1 {
+        sum += uint32(data[len(data)-1]) << 8
+    }
+    for sum>>16 != 0 {
+        sum = sum&0xffff + sum>>16
+    }
+    return ^uint16(sum)
+}
+
+func BuildPacket(proto string, src, dst netip.AddrPort, seq, ack uint32, flags uint8, payload []byte, payload_len int) ([]byte, int) {
+    // 合成 IP + TCP/UDP 头部 payload 可能是截断的 payload_len 才是实际长度
+    // 返回构造好的包和包的实际长度
+    src_addr := src.Addr()
+    dst_addr := dst.Addr()
+    if src_addr.Is4() != dst_addr.Is4() {
+        src_addr = netip.AddrFrom16(src_addr.As16())
+        dst_addr = netip.AddrFrom16(dst_addr.As16())
+    }
+    segment := &bytes.Buffer{}
+    var ip_proto uint8
+    if proto == "udp" {
+        ip_proto = syscall.IPPROTO_UDP
+        binary.Write(segment, binary.BigEndian, src.Port())
+        binary.Write(segment, binary.BigEndian, dst.Port())
+        binary.Write(segment, binary.BigEndian, uint16(8+payload_len))
+        binary.Write(segment, binary.BigEndian, uint16(0))
+    } else {
+        ip_proto = syscall.IPPROTO_TCP
+        binary.Write(segment, binary.BigEndian, src.Port())
+        binary.Write(segment, binary.BigEndian, dst.Port())
+        binary.Write(segment, binary.BigEndian, seq)
+        binary.Write(segment, binary.BigEndian, ack)
+        segment.WriteByte(5 << 4)
+        segment.WriteByte(flags)
+        binary.Write(segment, binary.BigEndian, uint16(0xffff))
+        binary.Write(segment, binary.BigEndian, uint16(0))
+        binary.Write(segment, binary.BigEndian, uint16(0))
+    }
+    header_len := segment.Len()
+    segment.Write(payload)
+    seg_len := header_len + payload_len
+
+    pseudo := &bytes.Buffer{}
+    ip_header := &bytes.Buffer{}
+    if src_addr.Is4() {
+        src_ip := src_addr.As4()
+        dst_ip := dst_addr.As4()
+        pseudo.Write(src_ip[:])
+        pseudo.Write(dst_ip[:])
+        pseudo.WriteByte(0)
+        pseudo.WriteByte(ip_proto)
+        binary.Write(pseudo, binary.BigEndian, uint16(seg_len))
+
+        ip_header.WriteByte(0x45)
+        ip_header.WriteByte(0)
+        binary.Write(ip_header, binary.BigEndian, uint16(20+seg_len))
+        binary.Write(ip_header, binary.BigEndian, uint16(0))
+        binary.Write(ip_header, binary.BigEndian, uint16(0x4000))
+        ip_header.WriteByte(64)
+        ip_header.WriteByte(ip_proto)
+        binary.Write(ip_header, binary.BigEndian, uint16(0))
+        ip_header.Write(src_ip[:])
+        ip_header.Write(dst_ip[:])
+        header := ip_header.Bytes()
+        binary.BigEndian.PutUint16(header[10:], inet_checksum(header, 0))
+    } else {
+        src_ip := src_addr.As16()
+        dst_ip := dst_addr.As16()
+        pseudo.Write(src_ip[:])
+        pseudo.Write(dst_ip[:])
+        binary.Write(pseudo, binary.BigEndian, uint32(seg_len))
+        pseudo.Write([]byte{0, 0, 0, ip_proto})
+
+        binary.Write(ip_header, binary.BigEndian, uint32(0x60000000))
+        binary.Write(ip_header, binary.BigEndian, uint16(seg_len))
+        ip_header.WriteByte(ip_proto)
+        ip_header.WriteByte(64)
+        ip_header.Write(src_ip[:])
+        ip_header.Write(dst_ip[:])
+    }
+    // 截断的时候校验和本来就不可能正确 这里只按抓到的数据计算
+    seg := segment.Bytes()
+    checksum := inet_checksum(append(pseudo.Bytes(), seg...), 0)
+    if proto == "udp" {
+        binary.BigEndian.PutUint16(seg[6:], checksum)
+    } else {
+        binary.BigEndian.PutUint16(seg[16:], checksum)
+    }
+    packet := append(ip_header.Bytes(), seg...)
+    return packet, ip_header.Len() + seg_len
+}
+
+func ParseSockaddr(data []byte) (netip.AddrPort, bool) {
+    // 原始的 sockaddr 结构体 仅支持 AF_INET 和 AF_INET6
+    if len(data) < 4 {
+        return netip.AddrPort{}, false
+    }
+    family := binary.LittleEndian.Uint16(data[0:2])
+    port := binary.BigEndian.Uint16(data[2:4])
+    switch family {
+    case syscall.AF_INET:
+        if len(data) < 8 {
+            return netip.AddrPort{}, false
+        }
+        addr, _ := netip.AddrFromSlice(data[4:8])
+        return netip.AddrPortFrom(addr, port), true
+    case syscall.AF_INET6:
+        if len(data) < 24 {
+            return netip.AddrPort{}, false
+        }
+        addr, _ := netip.AddrFromSlice(data[8:24])
+        return netip.AddrPortFrom(addr.Unmap(), port), true
+    }
+    return netip.AddrPort{}, false
+}
+
+type PcapFlow struct {
+    Proto  string
+    Local  netip.AddrPort
+    Remote netip.AddrPort
+    // 本端和对端下一个数据的序号
+    Seq uint32
+    Ack uint32
+}
+
+type PcapKey struct {
+    Pid uint32
+    Fd  int32
+}
+
+// 发送的数据在 sys_enter 时读取 要等 sys_exit 的返回值确定实际发送了多少
+type PcapSend struct {
+    Name       string
+    Key        PcapKey
+    Peer       netip.AddrPort
+    HasPeer    bool
+    MustSocket bool
+    Payload    []byte
+}
+
+type PcapHelper struct {
+    writer  *PcapWriter
+    flows   map[PcapKey]*PcapFlow
+    sends   map[uint32]*PcapSend
+    ignored map[PcapKey]bool
+    // 第一次出错后不再写入 退出时返回给调用方
+    err error
+}
+
+func NewPcapHelper() *PcapHelper {
+    helper := &PcapHelper{}
+    helper.flows = make(map[PcapKey]*PcapFlow)
+    helper.sends = make(map[uint32]*PcapSend)
+    helper.ignored = make(map[PcapKey]bool)
+    return helper
+}
+
+var pcap_helper = NewPcapHelper()
+var pcap_lock sync.Mutex
+
+func PcapClose() error {
+    pcap_lock.Lock()
+    defer pcap_lock.Unlock()
+    if pcap_helper.writer != nil {
+        if err := pcap_helper.writer.Close(); err != nil && pcap_helper.err == nil {
+            pcap_helper.err = err
+        }
+        pcap_helper.writer = nil
+    }
+    return pcap_helper.err
+}
+
+func (this *PcapHelper) WatchSyscall(name string) bool {
+    switch name {
+    case "connect", "accept", "accept4", "close":
+        return true
+    case "sendto", "sendmsg", "write", "writev":
+        return true
+    case "recvfrom", "recvmsg", "read", "readv":
+        return true
+    }
+    return false
+}
+
+func (this *PcapHelper) forget(key PcapKey) {
+    delete(this.flows, key)
+    delete(this.ignored, key)
+}
+
+func (this *PcapHelper) getFlow(event *SyscallEvent, key PcapKey, hint netip.AddrPort, has_hint, must_socket bool) *PcapFlow {
+    if flow, ok := this.flows[key]; ok {
+        return flow
+    }
+    if this.ignored[key] {
+        return nil
+    }
+    flow := &PcapFlow{}
+    if info := fd_helper.GetSocket(key.Pid, key.Fd); info != nil {
+        // 地址来自 fd 表 也就是 socket connect bind accept 等 syscall 的参数
+        switch info.Proto {
+        case "tcp", "udp":
+            flow.Proto = info.Proto
+        case "inet":
+            // 追踪开始前创建的 socket 类型未知
+            flow.Proto = "tcp"
+            if has_hint {
+                flow.Proto = "udp"
+            }
+        default:
+            this.ignored[key] = true
+            return nil
+        }
+        flow.Local = info.Local
+        flow.Remote = info.Remote
+    } else if must_socket {
+        // 解析 --dump 数据的时候 没有别的信息可用
+        flow.Proto = "tcp"
+        if has_hint {
+            flow.Proto = "udp"
+        }
+    } else {
+        this.ignored[key] = true
+        return nil
+    }
+    if !flow.Remote.IsValid() {
+        if has_hint {
+            flow.Remote = hint
+        } else {
+            // 对端未知 使用文档保留地址
+            flow.Remote = netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 0, 2, 1}), 0)
+        }
+    }
+    if !flow.Local.IsValid() || flow.Local.Addr().IsUnspecified() {
+        // 本端地址按 pid 合成 这样不同进程的相同 fd 不会混在一起
+        port := uint16(10000 + key.Fd)
+        if flow.Local.IsValid() && flow.Local.Port() != 0 {
+            port = flow.Local.Port()
+        }
+        pid := key.Pid
+        var addr netip.Addr
+        if flow.Remote.Addr().Is4() {
+            addr = netip.AddrFrom4([4]byte{10, byte(pid >> 16), byte(pid >> 8), byte(pid)})
+        } else {
+            addr = netip.AddrFrom16([16]byte{0xfd, 0x00, 12: byte(pid >> 24), 13: byte(pid >> 16), 14: byte(pid >> 8), 15: byte(pid)})
+        }
+        flow.Local = netip.AddrPortFrom(addr, port)
+    }
+    flow.Seq = 1
+    flow.Ack = 1
+    this.flows[key] = flow
+    return flow
+}
+
+func (this *PcapHelper) writePacket(event *SyscallEvent, key PcapKey, src, dst netip.AddrPort, proto string, seq, ack uint32, flags uint8, payload []byte, payload_len int) {
+    if this.err != nil {
+        return
+    }
+    packet, orig_len := BuildPacket(proto, src, dst, seq, ack, flags, payload, payload_len)
+    comment := fmt.Sprintf("pid=%d tid=%d comm=%s %s fd=%d", event.Pid, event.Tid, util.B2STrim(event.Comm[:]), event.PointName, key.Fd)
+    if event.Stackinfo != "" {
+        comment += "\n" + event.Stackinfo
+    }
+    if err := this.writer.WritePacket(event.Ts, packet, orig_len, comment); err != nil {
+        this.err = fmt.Errorf("write pcap failed, err:%v", err)
+    }
+}
+
+func (this *PcapHelper) handshake(event *SyscallEvent, key PcapKey, flow *PcapFlow, incoming bool) {
+    // 补上三次握手 否则 wireshark 无法正确地跟踪 tcp 流
+    if flow.Proto != "tcp" {
+        return
+    }
+    if incoming {
+        this.writePacket(event, key, flow.Remote, flow.Local, flow.Proto, flow.Ack-1, 0, TCP_SYN, nil, 0)
+        this.writePacket(event, key, flow.Local, flow.Remote, flow.Proto, flow.Seq-1, flow.Ack, TCP_SYN|TCP_ACK, nil, 0)
+        this.writePacket(event, key, flow.Remote, flow.Local, flow.Proto, flow.Ack, flow.Seq, TCP_ACK, nil, 0)
+    } else {
+        this.writePacket(event, key, flow.Local, flow.Remote, flow.Proto, flow.Seq-1, 0, TCP_SYN, nil, 0)
+        this.writePacket(event, key, flow.Remote, flow.Local, flow.Proto, flow.Ack-1, flow.Seq, TCP_SYN|TCP_ACK, nil, 0)
+        this.writePacket(event, key, flow.Local, flow.Remote, flow.Proto, flow.Seq, flow.Ack, TCP_ACK, nil, 0)
+    }
+}
+
+func (this *PcapHelper) newFlow(event *SyscallEvent, key PcapKey, hint netip.AddrPort, has_hint, must_socket, incoming bool) *PcapFlow {
+    _, exists := this.flows[key]
+    flow := this.getFlow(event, key, hint, has_hint, must_socket)
+    if flow != nil && !exists {
+        this.handshake(event, key, flow, incoming)
+    }
+    return flow
+}
+
+func (this *PcapHelper) closeFlow(event *SyscallEvent, key PcapKey) {
+    flow, ok := this.flows[key]
+    if ok && flow.Proto == "tcp" {
+        this.writePacket(event, key, flow.Local, flow.Remote, flow.Proto, flow.Seq, flow.Ack, TCP_FIN|TCP_ACK, nil, 0)
+        this.writePacket(event, key, flow.Remote, flow.Local, flow.Proto, flow.Ack, flow.Seq+1, TCP_FIN|TCP_ACK, nil, 0)
+        this.writePacket(event, key, flow.Local, flow.Remote, flow.Proto, flow.Seq+1, flow.Ack+1, TCP_ACK, nil, 0)
+    }
+    this.forget(key)
+}
+
+func (this *PcapHelper) sendData(event *SyscallEvent, key PcapKey, flow *PcapFlow, outgoing bool, peer netip.AddrPort, has_peer bool, payload []byte, payload_len int) {
+    if payload_len <= 0 {
+        return
+    }
+    if len(payload) > payload_len {
+        payload = payload[:payload_len]
+    }
+    remote := flow.Remote
+    if flow.Proto == "udp" && has_peer {
+        remote = peer
+    }
+    if outgoing {
+        this.writePacket(event, key, flow.Local, remote, flow.Proto, flow.Seq, flow.Ack, TCP_PSH|TCP_ACK, payload, payload_len)
+        flow.Seq += uint32(payload_len)
+    } else {
+        this.writePacket(event, key, remote, flow.Local, flow.Proto, flow.Ack, flow.Seq, TCP_PSH|TCP_ACK, payload, payload_len)
+        flow.Ack += uint32(payload_len)
+    }
+}
+
+func pcap_sockaddr(arg_values []config.ArgValue) (netip.AddrPort, bool) {
+    for _, arg_value := range arg_values {
+        if arg_value.GetTypeName() != "sockaddr" {
+            continue
+        }
+        for _, payload := range arg_value.Payloads {
+            if addr, ok := ParseSockaddr(payload); ok {
+                return addr, true
+            }
+        }
+    }
+    return netip.AddrPort{}, false
+}
+
+func pcap_payload(arg_values []config.ArgValue) []byte {
+    // buf iovec msghdr 的数据拼接起来就是实际发送或者接收的内容
+    var payload []byte
+    for _, arg_value := range arg_values {
+        if arg_value.GetTypeName() == "sockaddr" {
+            continue
+        }
+        for _, data := range arg_value.Payloads {
+            payload = append(payload, data...)
+        }
+    }
+    return payload
+}
+
+func pcap_ret(arg_values []config.ArgValue) int64 {
+    for _, arg_value := range arg_values {
+        if arg_value.Name == "ret" {
+            return int64(arg_value.Value)
+        }
+    }
+    return -1
+}
+
+func (this *PcapHelper) AddSyscallEvent(event *SyscallEvent, arg_values []config.ArgValue) {
+    if event.mconf.PcapFile == "" || len(arg_values) == 0 {
+        return
+    }
+    pcap_lock.Lock()
+    defer pcap_lock.Unlock()
+    if this.err != nil {
+        return
+    }
+    if this.writer == nil {
+        writer, err := NewPcapWriter(event.mconf.PcapFile)
+        if err != nil {
+            this.err = fmt.Errorf("create pcap file failed, err:%v", err)
+            return
+        }
+        this.writer = writer
+    }
+    key := PcapKey{event.Pid, int32(arg_values[0].Value)}
+    addr, has_addr := pcap_sockaddr(arg_values)
+    if event.EventId == SYSCALL_ENTER {
+        delete(this.sends, event.Tid)
+        switch event.PointName {
+        case "close":
+            this.closeFlow(event, key)
+        case "sendto", "sendmsg":
+            this.sends[event.Tid] = &PcapSend{event.PointName, key, addr, has_addr, true, pcap_payload(arg_values)}
+        case "write", "writev":
+            this.sends[event.Tid] = &PcapSend{event.PointName, key, addr, has_addr, false, pcap_payload(arg_values)}
+        }
+        return
+    }
+    ret := pcap_ret(arg_values)
+    switch event.PointName {
+    case "sendto", "sendmsg", "write", "writev":
+        // 按返回值记录实际发送的部分 失败的不记录
+        send, ok := this.sends[event.Tid]
+        delete(this.sends, event.Tid)
+        if !ok || send.Name != event.PointName || ret <= 0 {
+            break
+        }
+        if flow := this.newFlow(event, send.Key, send.Peer, send.HasPeer, send.MustSocket, false); flow != nil {
+            this.sendData(event, send.Key, flow, true, send.Peer, send.HasPeer, send.Payload, int(ret))
+        }
+    case "connect":
+        // 对端地址已经由 fd 表记录
+        if ret == 0 || ret == -int64(syscall.EINPROGRESS) {
+            delete(this.flows, key)
+            delete(this.ignored, key)
+            this.newFlow(event, key, netip.AddrPort{}, false, true, false)
+        }
+    case "accept", "accept4":
+        if ret >= 0 {
+            new_key := PcapKey{event.Pid, int32(ret)}
+            this.forget(new_key)
+            this.newFlow(event, new_key, netip.AddrPort{}, false, true, true)
+        }
+    case "recvfrom", "recvmsg":
+        if ret > 0 {
+            if flow := this.newFlow(event, key, addr, has_addr, true, false); flow != nil {
+                this.sendData(event, key, flow, false, addr, has_addr, pcap_payload(arg_values), int(ret))
+            }
+        }
+    case "read", "readv":
+        if ret > 0 {
+            if flow := this.newFlow(event, key, addr, has_addr, false, false); flow != nil {
+                this.sendData(event, key, flow, false, addr, has_addr, pcap_payload(arg_values), int(ret))
+            }
+        }
+    }
+}
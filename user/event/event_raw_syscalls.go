@@ -14,6 +14,7 @@ type SyscallEvent struct {
     RegsBuffer   RegsBuf
     UnwindBuffer UnwindBuf
     nr_point     *config.SyscallPoint
+    skip         bool
     config.SyscallFields
     Stack_str string
 }
@@ -22,14 +23,19 @@ func (this *SyscallEvent) DumpRecord() bool {
     return this.mconf.DumpRecord(common.SYSCALL_EVENT, &this.rec)
 }
 
-func (this *SyscallEvent) ParseEvent() (IEventStruct, error) {
-    data_e, err := this.ContextEvent.ParseEvent()
+func (this *SyscallEvent) ParseEvent() (data_e IEventStruct, err error) {
+    defer this.RecoverParse(&data_e, &err)
+    data_e, err = this.ContextEvent.ParseEvent()
     if err != nil {
-        panic("...")
+        return nil, err
     }
     if data_e == nil {
         if err := this.ParseContext(); err != nil {
-            panic(fmt.Sprintf("SyscallEvent.ParseContext() err:%v", err))
+            return nil, fmt.Errorf("SyscallEvent.ParseContext() err:%v", err)
+        }
+        if this.skip || this.mconf.SkipEventLog() {
+            // 统计和聚合堆栈的模式下不逐条输出 解析 dump 时不满足过滤规则的也不输出
+            return nil, nil
         }
         return this, nil
     }
@@ -37,44 +43,126 @@ func (this *SyscallEvent) ParseEvent() (IEventStruct, error) {
 }
 
 func (this *SyscallEvent) ParseContext() (err error) {
-    this.ReadArg(&this.NR)
+    if err = this.ReadArg(&this.NR); err != nil {
+        return err
+    }
 
-    this.nr_point = this.mconf.SysCallConf.GetSyscallPointByNR(this.NR)
+    this.nr_point = this.mconf.SysCallConf.FindSyscallPointByNR(this.NR)
+    if this.nr_point == nil {
+        return fmt.Errorf("unknown syscall nr:%d", this.NR)
+    }
     // this.nr_point = config.GetSyscallPointByNR(this.NR)
     this.PointName = this.nr_point.Name
 
     // this.logger.Printf("ParseContext EventId:%d RawSample:\n%s", this.EventId, util.HexDump(this.rec.RawSample, util.COLORRED))
     this.PointValue = nil
     this.PointStr = ""
+    fd_helper.InitFds(this.Pid, this.mconf.IsReplay())
+    proc := this.GetProcessContext()
+    var arg_values []config.ArgValue
     if this.EventId == SYSCALL_ENTER {
-        this.ReadArg(&this.LR)
-        this.ReadArg(&this.SP)
-        this.ReadArg(&this.PC)
-        if this.mconf.FmtJson {
-            this.PointValue = this.nr_point.ParsePointJson(this.buf, config.EBPF_SYS_ENTER)
+        if err = this.ReadArgs(&this.LR, &this.SP, &this.PC); err != nil {
+            return err
+        }
+        if this.SkipReplay(config.EBPF_SYS_ENTER) {
+            return nil
+        }
+        arg_payloads, err := config.ReadArgPayloads(this.nr_point.EnterPointArgs, this.buf, config.EBPF_SYS_ENTER, this.mconf.DumpBuf)
+        if err != nil {
+            return fmt.Errorf("%s %v", this.PointName, err)
+        }
+        this.DumpArgPayloads(this.PointName, arg_payloads)
+        if arg_values, err = this.ReadArgValues(this.nr_point.EnterPointArgs, config.EBPF_SYS_ENTER); err != nil {
+            return fmt.Errorf("%s %v", this.PointName, err)
+        }
+        if this.mconf.Summary {
+            summary_helper.AddSyscallEvent(this, arg_values)
+        } else if this.mconf.FmtJson {
+            this.PointValue = this.nr_point.ParsePointJson(proc, this.buf, config.EBPF_SYS_ENTER)
         } else {
-            this.PointStr = this.nr_point.ParseEnterPoint(this.buf)
+            this.PointStr = this.nr_point.ParseEnterPoint(proc, this.buf)
         }
+        fd_helper.UpdateSyscallEnter(this, arg_values)
     } else if this.EventId == SYSCALL_EXIT {
-        if this.mconf.FmtJson {
-            this.PointValue = this.nr_point.ParsePointJson(this.buf, config.EBPF_SYS_EXIT)
+        if this.SkipReplay(config.EBPF_SYS_EXIT) {
+            return nil
+        }
+        arg_payloads, err := config.ReadArgPayloads(this.nr_point.ExitPointArgs, this.buf, config.EBPF_SYS_EXIT, this.mconf.DumpBuf)
+        if err != nil {
+            return fmt.Errorf("%s %v", this.PointName, err)
+        }
+        this.DumpArgPayloads(this.PointName+"_ret", arg_payloads)
+        if arg_values, err = this.ReadArgValues(this.nr_point.ExitPointArgs, config.EBPF_SYS_EXIT); err != nil {
+            return fmt.Errorf("%s %v", this.PointName, err)
+        }
+        fd_helper.UpdateSyscallExit(this, arg_values)
+        if this.mconf.Summary {
+            // 统计需要返回值
+            if arg_values == nil {
+                if arg_values, err = config.ReadArgValues(this.nr_point.ExitPointArgs, this.buf, config.EBPF_SYS_EXIT); err != nil {
+                    return fmt.Errorf("%s %v", this.PointName, err)
+                }
+            }
+            summary_helper.AddSyscallEvent(this, arg_values)
+        } else if this.mconf.FmtJson {
+            this.PointValue = this.nr_point.ParsePointJson(proc, this.buf, config.EBPF_SYS_EXIT)
         } else {
-            this.PointStr = this.nr_point.ParseExitPoint(this.buf)
+            this.PointStr = this.nr_point.ParseExitPoint(proc, this.buf)
         }
     } else {
-        panic(fmt.Sprintf("SyscallEvent.ParseContext() failed, EventId:%d", this.EventId))
+        return fmt.Errorf("SyscallEvent.ParseContext() failed, EventId:%d", this.EventId)
+    }
+    if err = this.ParsePadding(); err != nil {
+        return err
     }
-    this.ParsePadding()
     err = this.ParseContextStack()
     if err != nil {
-        panic(fmt.Sprintf("ParseContextStack err:%v", err))
+        return fmt.Errorf("ParseContextStack err:%v", err)
     }
-    if this.mconf.AutoResume {
+    if this.EventId == SYSCALL_ENTER && (this.mconf.FoldedFile != "" || this.mconf.PprofFile != "") {
+        flame_helper.AddStack(&this.ContextEvent, this.PointName)
+    }
+    // 堆栈解析完成之后再导出 这样可以带上堆栈信息
+    pcap_helper.AddSyscallEvent(this, arg_values)
+    trace_helper.AddSyscallEvent(this, arg_values)
+    if this.mconf.DbFile != "" {
+        db_helper.AddSyscallEvent(this, arg_values)
+    }
+    if this.EventId == SYSCALL_ENTER {
+        // 在进程恢复运行之前完成内存转储
+        this.DumpMemory(this.PointName, this.mconf.DumpMem)
+    }
+    if this.mconf.AutoResume && !this.mconf.IsReplay() {
         LetItResume(this.Pid)
     }
     return nil
 }
 
+func (this *SyscallEvent) SkipReplay(point_type uint32) bool {
+    // 解析 dump 文件时按命令行的过滤规则重新过滤 跳过的事件不参与统计和导出
+    if !this.mconf.IsReplay() {
+        return false
+    }
+    this.skip = !this.MatchReplay() || !this.mconf.MatchReplaySyscall(this.Tid, this.nr_point, point_type, this.buf)
+    return this.skip
+}
+
+func (this *SyscallEvent) ReadArgValues(point_args []*config.PointArg, point_type uint32) ([]config.ArgValue, error) {
+    // fd 表 pcap trace 和 db 导出需要参数的原始值 其他情况不必额外读取
+    watch := fd_helper.WatchSyscall(this.nr_point, point_type)
+    if this.mconf.PcapFile != "" && pcap_helper.WatchSyscall(this.PointName) {
+        watch = true
+    }
+    if this.mconf.TraceFile != "" || this.mconf.DbFile != "" {
+        // --json/--summary 下不会生成 PointStr 参数文本只能从这里取
+        watch = true
+    }
+    if !watch {
+        return nil, nil
+    }
+    return config.ReadArgValues(point_args, this.buf, point_type)
+}
+
 func (this *SyscallEvent) GetUUID() string {
     s := fmt.Sprintf("%d|%d|%s", this.Pid, this.Tid, util.B2STrim(this.Comm[:]))
     if this.mconf.ShowTime {
@@ -83,6 +171,9 @@ func (this *SyscallEvent) GetUUID() string {
     if this.mconf.ShowUid {
         s = fmt.Sprintf("%d|%s", this.Uid, s)
     }
+    if this.mconf.ShowPkg {
+        s = fmt.Sprintf("%s|%s", this.GetPackage(), s)
+    }
     return s
 }
 
@@ -91,8 +182,9 @@ func (this *SyscallEvent) MarshalJSON() ([]byte, error) {
     type SyscallAlias config.SyscallFields
     if this.EventId == SYSCALL_ENTER {
         return json.Marshal(&struct {
-            Event string `json:"event"`
-            Comm  string `json:"comm"`
+            Event   string `json:"event"`
+            Comm    string `json:"comm"`
+            Package string `json:"package,omitempty"`
             *ContextAlias
             LR string `json:"lr"`
             SP string `json:"sp"`
@@ -102,6 +194,7 @@ func (this *SyscallEvent) MarshalJSON() ([]byte, error) {
         }{
             Event:        "sys_enter",
             Comm:         util.B2STrim(this.Comm[:]),
+            Package:      this.GetPackageJson(),
             ContextAlias: (*ContextAlias)(&this.ContextFields),
             LR:           fmt.Sprintf("0x%x", this.LR),
             SP:           fmt.Sprintf("0x%x", this.SP),
@@ -111,14 +204,16 @@ func (this *SyscallEvent) MarshalJSON() ([]byte, error) {
         })
     }
     return json.Marshal(&struct {
-        Event string `json:"event"`
-        Comm  string `json:"comm"`
+        Event   string `json:"event"`
+        Comm    string `json:"comm"`
+        Package string `json:"package,omitempty"`
         *ContextAlias
         *SyscallAlias
         Stack_str string `json:"stack_str"`
     }{
         Event:        "sys_exit",
         Comm:         util.B2STrim(this.Comm[:]),
+        Package:      this.GetPackageJson(),
         ContextAlias: (*ContextAlias)(&this.ContextFields),
         SyscallAlias: (*SyscallAlias)(&this.SyscallFields),
         Stack_str:    this.Stack_str,
@@ -138,7 +233,7 @@ func (this *SyscallEvent) String() string {
         return string(data)
     }
     var base_str string
-    base_str = fmt.Sprintf("[%s] %s%s", this.GetUUID(), this.nr_point.Name, this.PointStr)
+    base_str = fmt.Sprintf("%s[%s] %s%s", this.GetScope(), this.GetUUID(), this.nr_point.Name, this.PointStr)
     if this.EventId == SYSCALL_ENTER {
         var lr_str string
         var pc_str string
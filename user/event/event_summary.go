@@ -0,0 +1,269 @@
+package event
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// 类似 strace -c 按 syscall 和 uprobe hook 点统计调用次数 错误次数和耗时
+
+type SummaryKey struct {
+    Kind  string
+    Group string
+    Name  string
+}
+
+type SummaryStat struct {
+    Calls  uint64
+    Errors uint64
+    // 能够计算耗时的调用次数 普通的 uprobe 没有返回事件
+    Timed  uint64
+    Total  uint64
+    Max    uint64
+    Errnos map[string]uint64
+}
+
+func (this *SummaryStat) AddError(name string) {
+    this.Errors += 1
+    this.Errnos[name] += 1
+}
+
+func (this *SummaryStat) AddLatency(latency uint64) {
+    this.Timed += 1
+    this.Total += latency
+    if latency > this.Max {
+        this.Max = latency
+    }
+}
+
+func (this *SummaryStat) Avg() uint64 {
+    if this.Timed == 0 {
+        return 0
+    }
+    return this.Total / this.Timed
+}
+
+func (this *SummaryStat) ErrnoStr() string {
+    var names []string
+    for name := range this.Errnos {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return this.Errnos[names[i]] > this.Errnos[names[j]] || (this.Errnos[names[i]] == this.Errnos[names[j]] && names[i] < names[j])
+    })
+    var items []string
+    for _, name := range names {
+        items = append(items, fmt.Sprintf("%s:%d", name, this.Errnos[name]))
+    }
+    return strings.Join(items, ",")
+}
+
+type PendingSyscall struct {
+    NR  uint32
+    Ts  uint64
+    Key SummaryKey
+}
+
+type SummaryHelper struct {
+    logger  *log.Logger
+    by      string
+    stats   map[SummaryKey]*SummaryStat
+    pending map[uint32]PendingSyscall
+    ticker  *time.Ticker
+}
+
+func NewSummaryHelper() *SummaryHelper {
+    helper := &SummaryHelper{}
+    helper.stats = make(map[SummaryKey]*SummaryStat)
+    helper.pending = make(map[uint32]PendingSyscall)
+    return helper
+}
+
+var summary_lock sync.Mutex
+var summary_helper = NewSummaryHelper()
+
+func SummaryClose() {
+    summary_lock.Lock()
+    defer summary_lock.Unlock()
+    if summary_helper.logger == nil {
+        return
+    }
+    if summary_helper.ticker != nil {
+        summary_helper.ticker.Stop()
+        summary_helper.ticker = nil
+    }
+    summary_helper.logger.Println(summary_helper.Report())
+}
+
+func ErrnoString(ret int64) string {
+    // 负数返回值按 -errno 处理 不认识的直接显示数值
+    if name := unix.ErrnoName(syscall.Errno(-ret)); name != "" {
+        return name
+    }
+    return fmt.Sprintf("%d", ret)
+}
+
+func (this *SummaryHelper) start(event *ContextEvent) {
+    // 首个事件到来时初始化 有设置间隔的话定时输出
+    if this.logger != nil {
+        return
+    }
+    this.logger = event.logger
+    this.by = event.mconf.SummaryBy
+    if event.mconf.SummaryTick == 0 {
+        return
+    }
+    this.ticker = time.NewTicker(time.Duration(event.mconf.SummaryTick) * time.Second)
+    go func(ticker *time.Ticker) {
+        for range ticker.C {
+            summary_lock.Lock()
+            if this.ticker == ticker {
+                this.logger.Println(this.Report())
+            }
+            summary_lock.Unlock()
+        }
+    }(this.ticker)
+}
+
+func (this *SummaryHelper) getKey(event *ContextEvent, kind, name string) SummaryKey {
+    key := SummaryKey{Kind: kind, Name: name}
+    switch this.by {
+    case "pid":
+        key.Group = fmt.Sprintf("%d", event.Pid)
+    case "tid":
+        key.Group = fmt.Sprintf("%d", event.Tid)
+    case "comm":
+        key.Group = util.B2STrim(event.Comm[:])
+    }
+    return key
+}
+
+func (this *SummaryHelper) getStat(key SummaryKey) *SummaryStat {
+    stat, ok := this.stats[key]
+    if !ok {
+        stat = &SummaryStat{Errnos: make(map[string]uint64)}
+        this.stats[key] = stat
+    }
+    return stat
+}
+
+func (this *SummaryHelper) AddSyscallEvent(event *SyscallEvent, arg_values []config.ArgValue) {
+    summary_lock.Lock()
+    defer summary_lock.Unlock()
+    this.start(&event.ContextEvent)
+    if event.EventId == SYSCALL_ENTER {
+        key := this.getKey(&event.ContextEvent, "syscall", event.PointName)
+        this.getStat(key).Calls += 1
+        this.pending[event.Tid] = PendingSyscall{event.NR, event.Ts, key}
+        return
+    }
+    // 进入和返回按 tid 配对 没有配对成功的返回事件不计入
+    enter, ok := this.pending[event.Tid]
+    if !ok || enter.NR != event.NR {
+        return
+    }
+    delete(this.pending, event.Tid)
+    stat := this.getStat(enter.Key)
+    if event.Ts >= enter.Ts {
+        stat.AddLatency(event.Ts - enter.Ts)
+    }
+    ret, ok := FindArgValue(arg_values, "ret")
+    if !ok {
+        return
+    }
+    // 和 strace 一样 只有 [-4095, -1] 范围内的返回值视为错误
+    if value := int64(ret); value < 0 && value > -4096 {
+        stat.AddError(ErrnoString(value))
+    }
+}
+
+func (this *SummaryHelper) AddUprobeEvent(event *UprobeEvent, ret uint64) {
+    summary_lock.Lock()
+    defer summary_lock.Unlock()
+    this.start(&event.ContextEvent)
+    stat := this.getStat(this.getKey(&event.ContextEvent, "uprobe", event.uprobe_point.Name))
+    stat.Calls += 1
+    if !event.uprobe_point.IsRet {
+        return
+    }
+    // 返回时触发的 hook 点由 ebpf 记录了进入时间
+    if event.EnterTs > 0 && event.Ts >= event.EnterTs {
+        stat.AddLatency(event.Ts - event.EnterTs)
+    }
+    // 函数的负数返回值不一定是 errno 直接按数值统计
+    if value := int32(ret); value < 0 {
+        stat.AddError(fmt.Sprintf("%d", value))
+    }
+}
+
+func (this *SummaryHelper) Report() string {
+    var lines []string
+    for _, kind := range []string{"syscall", "uprobe"} {
+        var keys []SummaryKey
+        var total SummaryStat
+        for key, stat := range this.stats {
+            if key.Kind != kind {
+                continue
+            }
+            keys = append(keys, key)
+            total.Calls += stat.Calls
+            total.Errors += stat.Errors
+            total.Total += stat.Total
+        }
+        if len(keys) == 0 {
+            continue
+        }
+        // 和 strace 一样按总耗时排序 其次是调用次数
+        sort.Slice(keys, func(i, j int) bool {
+            a, b := this.stats[keys[i]], this.stats[keys[j]]
+            if a.Total != b.Total {
+                return a.Total > b.Total
+            }
+            if a.Calls != b.Calls {
+                return a.Calls > b.Calls
+            }
+            if keys[i].Group != keys[j].Group {
+                return keys[i].Group < keys[j].Group
+            }
+            return keys[i].Name < keys[j].Name
+        })
+        name_title := kind
+        if this.by != "" {
+            name_title = this.by + " " + kind
+        }
+        separator := "------ ----------- ----------- ----------- --------- --------- ----------------"
+        lines = append(lines, fmt.Sprintf("%6s %11s %11s %11s %9s %9s %s", "% time", "seconds", "usecs/call", "max(us)", "calls", "errors", name_title))
+        lines = append(lines, separator)
+        for _, key := range keys {
+            stat := this.stats[key]
+            var percent float64
+            if total.Total > 0 {
+                percent = float64(stat.Total) * 100 / float64(total.Total)
+            }
+            name := key.Name
+            if this.by != "" {
+                name = key.Group + " " + name
+            }
+            line := fmt.Sprintf("%6.2f %11.6f %11d %11d %9d %9d %s", percent, float64(stat.Total)/1e9, stat.Avg()/1000, stat.Max/1000, stat.Calls, stat.Errors, name)
+            if stat.Errors > 0 {
+                line += " (" + stat.ErrnoStr() + ")"
+            }
+            lines = append(lines, line)
+        }
+        lines = append(lines, separator)
+        lines = append(lines, fmt.Sprintf("%6.2f %11.6f %11s %11s %9d %9d total", 100.0, float64(total.Total)/1e9, "", "", total.Calls, total.Errors))
+    }
+    if len(lines) == 0 {
+        return "[summary] no events"
+    }
+    return "[summary]\n" + strings.Join(lines, "\n")
+}
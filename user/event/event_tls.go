@@ -0,0 +1,224 @@
+package event
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "sync"
+)
+
+type TlsKey struct {
+    Pid uint32
+    Ssl uint64
+}
+
+type TlsConn struct {
+    Id         uint32
+    Pid        uint32
+    Comm       string
+    Ssl        uint64
+    Fd         int32
+    Peer       string
+    Lib        string
+    ReadBytes  uint64
+    WriteBytes uint64
+    // 超过单次读取上限的部分不会被保存
+    LostBytes  uint64
+    read_path  string
+    write_path string
+    read_file  *os.File
+    write_file *os.File
+}
+
+func (this *TlsConn) String() string {
+    return fmt.Sprintf("conn=%d pid=%d comm=%s ssl=0x%x fd=%d peer=%s lib=%s read=%d write=%d lost=%d read_file=%s write_file=%s", this.Id, this.Pid, this.Comm, this.Ssl, this.Fd, this.Peer, this.Lib, this.ReadBytes, this.WriteBytes, this.LostBytes, this.read_path, this.write_path)
+}
+
+func (this *TlsConn) Close() {
+    if this.read_file != nil {
+        this.read_file.Close()
+        this.read_file = nil
+    }
+    if this.write_file != nil {
+        this.write_file.Close()
+        this.write_file = nil
+    }
+}
+
+type TlsHelper struct {
+    conns   map[TlsKey]*TlsConn
+    next_id uint32
+    report  *os.File
+    // 文件出错后不再导出 只打印一次错误 不影响正常的追踪
+    disabled bool
+}
+
+func NewTlsHelper() *TlsHelper {
+    helper := &TlsHelper{}
+    helper.conns = make(map[TlsKey]*TlsConn)
+    helper.next_id = 1
+    return helper
+}
+
+var tls_helper = NewTlsHelper()
+var tls_lock sync.Mutex
+
+func TlsClose() {
+    tls_lock.Lock()
+    defer tls_lock.Unlock()
+    for key, conn := range tls_helper.conns {
+        tls_helper.closeConn(conn)
+        delete(tls_helper.conns, key)
+    }
+    if tls_helper.report != nil {
+        tls_helper.report.Close()
+        tls_helper.report = nil
+    }
+}
+
+func (this *TlsHelper) closeConn(conn *TlsConn) {
+    // 类似 keylog 每个连接一行 记录连接的信息和明文数据所在的文件
+    conn.Close()
+    if this.report != nil {
+        fmt.Fprintln(this.report, conn.String())
+    }
+}
+
+func (this *TlsHelper) disable(event *UprobeEvent, err error) {
+    event.logger.Printf("tls export disabled, err:%v", err)
+    this.disabled = true
+}
+
+func (this *TlsHelper) newConn(event *UprobeEvent, ssl uint64) *TlsConn {
+    if this.report == nil {
+        report, err := os.Create(event.mconf.GetDumpPath("tls_report.log"))
+        if err != nil {
+            this.disable(event, fmt.Errorf("create tls report failed, err:%v", err))
+            return nil
+        }
+        this.report = report
+    }
+    conn := &TlsConn{}
+    conn.Id = this.next_id
+    this.next_id += 1
+    conn.Pid = event.Pid
+    conn.Comm = util.B2STrim(event.Comm[:])
+    conn.Ssl = ssl
+    conn.Fd = -1
+    conn.Lib = event.uprobe_point.GetPath()
+    conn.read_path = event.mconf.GetDumpPath(fmt.Sprintf("tls_%d_%d_read.bin", conn.Id, conn.Pid))
+    conn.write_path = event.mconf.GetDumpPath(fmt.Sprintf("tls_%d_%d_write.bin", conn.Id, conn.Pid))
+    this.conns[TlsKey{event.Pid, ssl}] = conn
+    return conn
+}
+
+func (this *TlsHelper) getConn(event *UprobeEvent, ssl uint64) *TlsConn {
+    conn, ok := this.conns[TlsKey{event.Pid, ssl}]
+    if !ok {
+        conn = this.newConn(event, ssl)
+    }
+    return conn
+}
+
+func (this *TlsHelper) bindFd(event *UprobeEvent, ssl uint64, fd int32) *TlsConn {
+    conn := this.getConn(event, ssl)
+    if conn != nil && conn.Fd >= 0 && conn.Fd != fd {
+        // SSL 对象释放后地址被复用 认为是新的连接
+        this.closeConn(conn)
+        conn = this.newConn(event, ssl)
+    }
+    if conn == nil {
+        return nil
+    }
+    if conn.Fd != fd {
+        conn.Fd = fd
+        conn.Peer = fd_helper.GetPath(event.Pid, fd)
+    }
+    return conn
+}
+
+func (this *TlsHelper) writeData(event *UprobeEvent, conn *TlsConn, is_read bool, ret int32, payloads [][]byte) {
+    data := bytes.Join(payloads, []byte{})
+    if int32(len(data)) < ret {
+        conn.LostBytes += uint64(ret) - uint64(len(data))
+    }
+    var err error
+    if is_read {
+        conn.ReadBytes += uint64(ret)
+        if conn.read_file == nil {
+            conn.read_file, err = os.Create(conn.read_path)
+        }
+        if err == nil {
+            _, err = conn.read_file.Write(data)
+        }
+    } else {
+        conn.WriteBytes += uint64(ret)
+        if conn.write_file == nil {
+            conn.write_file, err = os.Create(conn.write_path)
+        }
+        if err == nil {
+            _, err = conn.write_file.Write(data)
+        }
+    }
+    if err != nil {
+        this.disable(event, fmt.Errorf("save tls data failed, err:%v", err))
+    }
+}
+
+func (this *TlsHelper) AddUprobeEvent(event *UprobeEvent, arg_values []config.ArgValue) string {
+    // 返回连接信息 附加在事件的参数后面
+    tls_lock.Lock()
+    defer tls_lock.Unlock()
+    if this.disabled || len(arg_values) == 0 {
+        return ""
+    }
+    ssl := arg_values[0].Value
+    if event.uprobe_point.Name == "SSL_free" {
+        // 连接结束 之后相同地址的 SSL 对象是新的连接
+        key := TlsKey{event.Pid, ssl}
+        conn, ok := this.conns[key]
+        if !ok {
+            return ""
+        }
+        this.closeConn(conn)
+        delete(this.conns, key)
+        return fmt.Sprintf(" conn=%d closed", conn.Id)
+    }
+    if len(arg_values) < 2 {
+        return ""
+    }
+    var conn *TlsConn
+    switch event.uprobe_point.Name {
+    case "SSL_set_fd":
+        conn = this.bindFd(event, ssl, int32(arg_values[1].Value))
+    case "SSL_get_fd":
+        fd := int32(arg_values[1].Value)
+        if fd < 0 {
+            return ""
+        }
+        conn = this.bindFd(event, ssl, fd)
+    case "SSL_read", "SSL_write":
+        if len(arg_values) < 3 {
+            return ""
+        }
+        conn = this.getConn(event, ssl)
+        ret := int32(arg_values[2].Value)
+        if conn != nil && ret > 0 {
+            this.writeData(event, conn, event.uprobe_point.Name == "SSL_read", ret, arg_values[1].Payloads)
+        }
+    default:
+        return ""
+    }
+    if conn == nil {
+        return ""
+    }
+    if conn.Fd < 0 {
+        return fmt.Sprintf(" conn=%d", conn.Id)
+    }
+    if conn.Peer == "" {
+        return fmt.Sprintf(" conn=%d fd=%d", conn.Id, conn.Fd)
+    }
+    return fmt.Sprintf(" conn=%d fd=%d<%s>", conn.Id, conn.Fd, conn.Peer)
+}
@@ -0,0 +1,227 @@
+package event
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "stackplz/user/config"
+    "stackplz/user/util"
+    "sync"
+)
+
+// 导出为 Chrome JSON trace 格式 可以直接在 ui.perfetto.dev 或者 chrome://tracing 中打开
+// 每个线程一条轨道 syscall 和返回时触发的 hook 点是有耗时的区间 其他 hook 点是瞬时事件
+
+type TraceEvent struct {
+    Name  string         `json:"name"`
+    Cat   string         `json:"cat,omitempty"`
+    Ph    string         `json:"ph"`
+    Ts    float64        `json:"ts"`
+    Dur   float64        `json:"dur,omitempty"`
+    Pid   uint32         `json:"pid"`
+    Tid   uint32         `json:"tid"`
+    Scope string         `json:"s,omitempty"`
+    Args  map[string]any `json:"args,omitempty"`
+}
+
+type TraceSlice struct {
+    Name string
+    NR   uint32
+    Ts   uint64
+    Pid  uint32
+    Args string
+}
+
+type TraceHelper struct {
+    file    *os.File
+    count   int
+    pids    map[uint32]bool
+    threads map[uint32]string
+    pending map[uint32]*TraceSlice
+    // 第一次出错后不再写入 退出时返回给调用方
+    err error
+}
+
+func NewTraceHelper() *TraceHelper {
+    helper := &TraceHelper{}
+    helper.pids = make(map[uint32]bool)
+    helper.threads = make(map[uint32]string)
+    helper.pending = make(map[uint32]*TraceSlice)
+    return helper
+}
+
+var trace_lock sync.Mutex
+var trace_helper = NewTraceHelper()
+
+func TraceClose() error {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    if trace_helper.file == nil {
+        return trace_helper.err
+    }
+    // 没有等到返回的 syscall 作为瞬时事件记录
+    for tid, slice := range trace_helper.pending {
+        trace_helper.write(&TraceEvent{Name: slice.Name, Cat: "syscall", Ph: "i", Ts: trace_us(slice.Ts), Pid: slice.Pid, Tid: tid, Scope: "t", Args: map[string]any{"args": slice.Args, "unfinished": true}})
+        delete(trace_helper.pending, tid)
+    }
+    if trace_helper.err == nil {
+        if _, err := trace_helper.file.WriteString("\n]\n"); err != nil {
+            trace_helper.err = fmt.Errorf("write trace failed, err:%v", err)
+        }
+    }
+    if err := trace_helper.file.Close(); err != nil && trace_helper.err == nil {
+        trace_helper.err = err
+    }
+    trace_helper.file = nil
+    return trace_helper.err
+}
+
+func trace_us(ts uint64) float64 {
+    // trace 中的时间单位是微秒
+    return float64(ts) / 1000
+}
+
+func (this *TraceHelper) open(file_path string) bool {
+    if file_path == "" || this.err != nil {
+        return false
+    }
+    if this.file != nil {
+        return true
+    }
+    f, err := os.Create(file_path)
+    if err != nil {
+        this.err = fmt.Errorf("create trace file failed, err:%v", err)
+        return false
+    }
+    this.file = f
+    if _, err := f.WriteString("["); err != nil {
+        this.err = fmt.Errorf("write trace failed, err:%v", err)
+        return false
+    }
+    return true
+}
+
+func (this *TraceHelper) write(event *TraceEvent) {
+    if this.err != nil {
+        return
+    }
+    data, err := json.Marshal(event)
+    if err != nil {
+        this.err = fmt.Errorf("marshal trace event %s failed, err:%v", event.Name, err)
+        return
+    }
+    sep := ",\n"
+    if this.count == 0 {
+        sep = "\n"
+    }
+    this.count += 1
+    if _, err := this.file.WriteString(sep + string(data)); err != nil {
+        this.err = fmt.Errorf("write trace failed, err:%v", err)
+    }
+}
+
+func (this *TraceHelper) setThreadName(pid, tid uint32, name string) {
+    if name == "" || this.threads[tid] == name {
+        return
+    }
+    this.threads[tid] = name
+    this.write(&TraceEvent{Name: "thread_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": name}})
+    if pid == tid {
+        this.write(&TraceEvent{Name: "process_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": name}})
+    }
+}
+
+func (this *TraceHelper) touch(pid, tid uint32, comm string) {
+    // 进程名和线程名都取事件自带的 comm 不读 /proc 进程可能已经退出 也可能是回放
+    this.pids[pid] = true
+    this.setThreadName(pid, tid, comm)
+}
+
+func (this *TraceHelper) AddSyscallEvent(event *SyscallEvent, arg_values []config.ArgValue) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    if !this.open(event.mconf.TraceFile) {
+        return
+    }
+    this.touch(event.Pid, event.Tid, util.B2STrim(event.Comm[:]))
+    // PointStr 在 --json/--summary 下为空 所以参数文本从 arg_values 格式化
+    args_str := config.FormatArgValues(arg_values)
+    if event.EventId == SYSCALL_ENTER {
+        this.pending[event.Tid] = &TraceSlice{event.PointName, event.NR, event.Ts, event.Pid, args_str}
+        return
+    }
+    enter, ok := this.pending[event.Tid]
+    if !ok || enter.NR != event.NR || event.Ts < enter.Ts {
+        // 没有配对的进入事件 只记录返回
+        this.write(&TraceEvent{Name: event.PointName, Cat: "syscall", Ph: "i", Ts: trace_us(event.Ts), Pid: event.Pid, Tid: event.Tid, Scope: "t", Args: map[string]any{"ret": args_str}})
+        return
+    }
+    delete(this.pending, event.Tid)
+    args := map[string]any{"args": enter.Args, "ret": args_str}
+    this.write(&TraceEvent{Name: event.PointName, Cat: "syscall", Ph: "X", Ts: trace_us(enter.Ts), Dur: trace_us(event.Ts - enter.Ts), Pid: event.Pid, Tid: event.Tid, Args: args})
+}
+
+func (this *TraceHelper) AddUprobeEvent(event *UprobeEvent) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    if !this.open(event.mconf.TraceFile) {
+        return
+    }
+    this.touch(event.Pid, event.Tid, util.B2STrim(event.Comm[:]))
+    args := map[string]any{"args": event.ArgStr, "lr": fmt.Sprintf("0x%x", event.LR), "pc": fmt.Sprintf("0x%x", event.PC)}
+    if event.uprobe_point.IsRet && event.EnterTs > 0 && event.Ts >= event.EnterTs {
+        this.write(&TraceEvent{Name: event.uprobe_point.Name, Cat: "uprobe", Ph: "X", Ts: trace_us(event.EnterTs), Dur: trace_us(event.Ts - event.EnterTs), Pid: event.Pid, Tid: event.Tid, Args: args})
+        return
+    }
+    this.write(&TraceEvent{Name: event.uprobe_point.Name, Cat: "uprobe", Ph: "i", Ts: trace_us(event.Ts), Pid: event.Pid, Tid: event.Tid, Scope: "t", Args: args})
+}
+
+func (this *TraceHelper) AddBrkEvent(event *BrkEvent) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    // 回放时断点事件没有时间 无法放到时间线上
+    if event.Ts == 0 || !this.open(event.mconf.TraceFile) {
+        return
+    }
+    this.touch(event.Pid, event.Tid, util.B2STrim(event.Comm[:]))
+    args := map[string]any{"addr": fmt.Sprintf("0x%x", event.EventAddr), "hit_count": hit_count}
+    this.write(&TraceEvent{Name: "breakpoint", Cat: "brk", Ph: "i", Ts: trace_us(event.Ts), Pid: event.Pid, Tid: event.Tid, Scope: "t", Args: args})
+}
+
+func (this *TraceHelper) AddForkEvent(event *ForkEvent) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    // 只关心已经出现在 trace 中的进程
+    if this.file == nil || !this.pids[event.Ppid] {
+        return
+    }
+    this.pids[event.Pid] = true
+    // 新线程先沿用父线程的名字 之后由 comm 事件更新
+    this.setThreadName(event.Pid, event.Tid, this.threads[event.Ptid])
+    args := map[string]any{"pid": event.Pid, "tid": event.Tid}
+    this.write(&TraceEvent{Name: "fork", Cat: "sched", Ph: "i", Ts: trace_us(event.Time), Pid: event.Ppid, Tid: event.Ptid, Scope: "t", Args: args})
+}
+
+func (this *TraceHelper) AddExitEvent(event *ExitEvent) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    if this.file == nil || !this.pids[event.Pid] {
+        return
+    }
+    if enter, ok := this.pending[event.Tid]; ok && event.Time >= enter.Ts {
+        // 比如 exit_group 不会返回 区间在线程退出时结束
+        args := map[string]any{"args": enter.Args, "unfinished": true}
+        this.write(&TraceEvent{Name: enter.Name, Cat: "syscall", Ph: "X", Ts: trace_us(enter.Ts), Dur: trace_us(event.Time - enter.Ts), Pid: event.Pid, Tid: event.Tid, Args: args})
+    }
+    delete(this.pending, event.Tid)
+    this.write(&TraceEvent{Name: "exit", Cat: "sched", Ph: "i", Ts: trace_us(event.Time), Pid: event.Pid, Tid: event.Tid, Scope: "t"})
+}
+
+func (this *TraceHelper) AddCommEvent(event *CommEvent) {
+    trace_lock.Lock()
+    defer trace_lock.Unlock()
+    if this.file == nil || !this.pids[event.Pid] {
+        return
+    }
+    this.setThreadName(event.Pid, event.Tid, event.Comm)
+}
@@ -16,6 +16,9 @@ type UprobeEvent struct {
     ContextEvent
     UUID         string
     uprobe_point *config.UprobeArgs
+    skip         bool
+    // 返回时触发的 hook 点 对应的函数进入时间
+    EnterTs uint64
     config.UprobeFields
     Stack_str string
 }
@@ -24,14 +27,19 @@ func (this *UprobeEvent) DumpRecord() bool {
     return this.mconf.DumpRecord(common.UPROBE_EVENT, &this.rec)
 }
 
-func (this *UprobeEvent) ParseEvent() (IEventStruct, error) {
-    data_e, err := this.ContextEvent.ParseEvent()
+func (this *UprobeEvent) ParseEvent() (data_e IEventStruct, err error) {
+    defer this.RecoverParse(&data_e, &err)
+    data_e, err = this.ContextEvent.ParseEvent()
     if err != nil {
-        panic("...")
+        return nil, err
     }
     if data_e == nil {
         if err := this.ParseContext(); err != nil {
-            panic(fmt.Sprintf("UprobeEvent.ParseContext() err:%v", err))
+            return nil, fmt.Errorf("UprobeEvent.ParseContext() err:%v", err)
+        }
+        if this.skip || this.mconf.SkipEventLog() {
+            // 统计和聚合堆栈的模式下不逐条输出 解析 dump 时不满足过滤规则的也不输出
+            return nil, nil
         }
         return this, nil
     }
@@ -40,41 +48,92 @@ func (this *UprobeEvent) ParseEvent() (IEventStruct, error) {
 
 func (this *UprobeEvent) ParseContext() (err error) {
     if this.EventId != UPROBE_ENTER {
-        panic(fmt.Sprintf("UprobeEvent.ParseContext() failed, EventId:%d", this.EventId))
+        return fmt.Errorf("UprobeEvent.ParseContext() failed, EventId:%d", this.EventId)
     }
 
     // this.logger.Printf("ParseContext EventId:%d RawSample:\n%s", this.EventId, util.HexDump(this.rec.RawSample, util.COLORRED))
 
-    this.ReadArg(&this.ProbeIndex)
-    this.ReadArg(&this.LR)
-    this.ReadArg(&this.SP)
-    this.ReadArg(&this.PC)
+    if err = this.ReadArgs(&this.ProbeIndex, &this.LR, &this.SP, &this.PC); err != nil {
+        return err
+    }
     // 根据预设索引解析参数
-    if (this.ProbeIndex + 1) > uint32(len(this.mconf.StackUprobeConf.Points)) {
-        panic(fmt.Sprintf("probe_index %d bigger than points", this.ProbeIndex))
+    if this.ProbeIndex >= uint32(len(this.mconf.StackUprobeConf.Points)) {
+        return fmt.Errorf("probe_index %d bigger than points", this.ProbeIndex)
     }
     this.uprobe_point = this.mconf.StackUprobeConf.Points[this.ProbeIndex]
     this.ArgName = this.uprobe_point.Name
-    if this.uprobe_point.KillSignal == uint32(syscall.SIGSTOP) && this.Pid != 0 {
+    if this.mconf.IsReplay() {
+        // 解析 dump 文件时按命令行的过滤规则重新过滤 跳过的事件不参与统计和导出
+        this.skip = !this.MatchReplay() || !this.mconf.MatchReplayUprobe(this.uprobe_point, this.buf)
+        if this.skip {
+            return nil
+        }
+    }
+    if this.uprobe_point.KillSignal == uint32(syscall.SIGSTOP) && this.Pid != 0 && !this.mconf.IsReplay() {
         AddStopped(this.Pid)
     }
 
+    arg_payloads, err := config.ReadArgPayloads(this.uprobe_point.PointArgs, this.buf, config.EBPF_UPROBE_ENTER, this.mconf.DumpBuf)
+    if err != nil {
+        return fmt.Errorf("%s %v", this.uprobe_point.Name, err)
+    }
+    this.DumpArgPayloads(this.uprobe_point.Name, arg_payloads)
+
+    fd_helper.InitFds(this.Pid, this.mconf.IsReplay())
+    proc := this.GetProcessContext()
+    var arg_values []config.ArgValue
+    if this.uprobe_point.Preset != "" || this.mconf.DbFile != "" {
+        if arg_values, err = config.ReadArgValues(this.uprobe_point.PointArgs, this.buf, config.EBPF_UPROBE_ENTER); err != nil {
+            return fmt.Errorf("%s %v", this.uprobe_point.Name, err)
+        }
+    }
     var results []string
+    var ret uint64
     for _, point_arg := range this.uprobe_point.PointArgs {
         var ptr argtype.Arg_reg
-        if err := binary.Read(this.buf, binary.LittleEndian, &ptr); err != nil {
-            panic(err)
+        if err = binary.Read(this.buf, binary.LittleEndian, &ptr); err != nil {
+            return fmt.Errorf("read %s failed, err:%v", point_arg.Name, err)
         }
-        arg_fmt := point_arg.Parse(ptr.Address, this.buf, config.EBPF_UPROBE_ENTER)
+        ret = ptr.Address
+        arg_fmt := point_arg.Parse(proc, ptr.Address, this.buf, config.EBPF_UPROBE_ENTER)
         results = append(results, fmt.Sprintf("%s=%s", point_arg.Name, arg_fmt))
     }
     this.ArgStr = "(" + strings.Join(results, ", ") + ")"
-    this.ParsePadding()
+    if this.uprobe_point.IsRet {
+        // 返回值之后是函数进入的时间
+        if err = this.ReadArg(&this.EnterTs); err != nil {
+            return err
+        }
+    }
+    if this.mconf.Summary {
+        summary_helper.AddUprobeEvent(this, ret)
+    }
+    switch this.uprobe_point.Preset {
+    case config.PRESET_TLS:
+        this.ArgStr += tls_helper.AddUprobeEvent(this, arg_values)
+    case config.PRESET_LINKER:
+        this.ArgStr += AnnotateLinkerEvent(this, arg_values)
+    case config.PRESET_JNI:
+        this.ArgStr += AnnotateJniEvent(this, arg_values)
+    }
+    if err = this.ParsePadding(); err != nil {
+        return err
+    }
     err = this.ParseContextStack()
     if err != nil {
-        panic(fmt.Sprintf("ParseContextStack err:%v", err))
+        return fmt.Errorf("ParseContextStack err:%v", err)
+    }
+    if this.mconf.FoldedFile != "" || this.mconf.PprofFile != "" {
+        flame_helper.AddStack(&this.ContextEvent, this.uprobe_point.Name)
     }
-    if this.mconf.AutoResume {
+    trace_helper.AddUprobeEvent(this)
+    if this.mconf.DbFile != "" {
+        db_helper.AddUprobeEvent(this, arg_values)
+    }
+    // 在进程恢复运行之前完成内存转储
+    this.DumpMemory(this.uprobe_point.Name, this.mconf.DumpMem)
+    this.DumpMemory(this.uprobe_point.Name, this.uprobe_point.DumpMem)
+    if this.mconf.AutoResume && !this.mconf.IsReplay() {
         LetItResume(this.Pid)
     }
     return nil
@@ -93,6 +152,9 @@ func (this *UprobeEvent) GetUUID() string {
     if this.mconf.ShowUid {
         s = fmt.Sprintf("%d|%s", this.Uid, s)
     }
+    if this.mconf.ShowPkg {
+        s = fmt.Sprintf("%s|%s", this.GetPackage(), s)
+    }
     return s
 }
 
@@ -100,11 +162,12 @@ func (this *UprobeEvent) MarshalJSON() ([]byte, error) {
     type ContextAlias config.ContextFields
     type UprobeAlias config.UprobeFields
     return json.Marshal(&struct {
-        Event string `json:"event"`
-        LR    string `json:"lr"`
-        SP    string `json:"sp"`
-        PC    string `json:"pc"`
-        Comm  string `json:"comm"`
+        Event   string `json:"event"`
+        LR      string `json:"lr"`
+        SP      string `json:"sp"`
+        PC      string `json:"pc"`
+        Comm    string `json:"comm"`
+        Package string `json:"package,omitempty"`
         *ContextAlias
         *UprobeAlias
         Stack_str string `json:"stack_str"`
@@ -114,6 +177,7 @@ func (this *UprobeEvent) MarshalJSON() ([]byte, error) {
         SP:           fmt.Sprintf("0x%x", this.SP),
         PC:           fmt.Sprintf("0x%x", this.PC),
         Comm:         util.B2STrim(this.Comm[:]),
+        Package:      this.GetPackageJson(),
         ContextAlias: (*ContextAlias)(&this.ContextFields),
         UprobeAlias:  (*UprobeAlias)(&this.UprobeFields),
         Stack_str:    this.Stack_str,
@@ -142,7 +206,7 @@ func (this *UprobeEvent) String() string {
     }
 
     var s string
-    s = fmt.Sprintf("[%s] %s%s %s %s SP:0x%x", this.GetUUID(), this.uprobe_point.Name, this.ArgStr, lr_str, pc_str, this.SP)
+    s = fmt.Sprintf("%s[%s] %s%s %s %s SP:0x%x", this.GetScope(), this.GetUUID(), this.uprobe_point.Name, this.ArgStr, lr_str, pc_str, this.SP)
 
     return s + this.Stack_str
 }
@@ -7,12 +7,13 @@ import (
     "fmt"
     "log"
     "os"
+    "runtime/debug"
     "stackplz/user/common"
     "stackplz/user/config"
     "stackplz/user/util"
+    "strings"
     "sync"
     "syscall"
-    "time"
 
     "github.com/cilium/ebpf/perf"
     "golang.org/x/sys/unix"
@@ -23,6 +24,10 @@ const (
     SYSCALL_EXIT
     UPROBE_ENTER
     HW_BREAKPOINT
+    // 与 ebpf 中的定义一致
+    PROCESS_EXEC
+    PROCESS_EXIT
+    SIGNAL_DELIVER
 )
 
 type IEventStruct interface {
@@ -34,6 +39,7 @@ type IEventStruct interface {
     DumpRecord() bool
     ParseEvent() (IEventStruct, error)
     ParseContext() error
+    PeekFlight() (FlightPeek, error)
     SetLogger(logger *log.Logger)
     SetConf(conf config.IConfig)
     SetRecord(rec perf.Record)
@@ -46,22 +52,18 @@ type CommonEvent struct {
     buf    *bytes.Buffer
 }
 
-func (this *CommonEvent) ParseArgStruct(buf *bytes.Buffer, arg config.ArgFormatter) string {
+func (this *CommonEvent) ParseArgStruct(buf *bytes.Buffer, arg config.ArgFormatter) (string, error) {
     if err := binary.Read(buf, binary.LittleEndian, arg); err != nil {
-        this.logger.Printf("CommonEvent RawSample:\n%s", util.HexDump(this.rec.RawSample, util.COLORRED))
-        time.Sleep(5 * 100 * time.Millisecond)
-        panic(err)
+        return "", fmt.Errorf("read %T failed, err:%v", arg, err)
     }
-    return arg.Format()
+    return arg.Format(), nil
 }
 
-func (this *CommonEvent) ParseArgStructHex(buf *bytes.Buffer, arg config.ArgHexFormatter) string {
+func (this *CommonEvent) ParseArgStructHex(buf *bytes.Buffer, arg config.ArgHexFormatter) (string, error) {
     if err := binary.Read(buf, binary.LittleEndian, arg); err != nil {
-        this.logger.Printf("CommonEvent RawSample:\n%s", util.HexDump(this.rec.RawSample, util.COLORRED))
-        time.Sleep(5 * 100 * time.Millisecond)
-        panic(err)
+        return "", fmt.Errorf("read %T failed, err:%v", arg, err)
     }
-    return arg.HexFormat()
+    return arg.HexFormat(), nil
 }
 
 func (this *CommonEvent) String() string {
@@ -81,24 +83,42 @@ func (this *CommonEvent) Clone() IEventStruct {
     return event
 }
 
-func (this *CommonEvent) ReadArg(field any) {
+func (this *CommonEvent) ReadArg(field any) error {
     // 读取常规的参数 save_index|value
-    var err error
     var index uint8
-    if err = binary.Read(this.buf, binary.LittleEndian, &index); err != nil {
-        panic(err)
+    if err := binary.Read(this.buf, binary.LittleEndian, &index); err != nil {
+        return fmt.Errorf("read arg index failed, err:%v", err)
     }
-    if err = binary.Read(this.buf, binary.LittleEndian, field); err != nil {
-        panic(err)
+    if err := binary.Read(this.buf, binary.LittleEndian, field); err != nil {
+        return fmt.Errorf("read arg %T failed, err:%v", field, err)
     }
+    return nil
+}
+
+func (this *CommonEvent) ReadArgs(fields ...any) error {
+    for _, field := range fields {
+        if err := this.ReadArg(field); err != nil {
+            return err
+        }
+    }
+    return nil
 }
 
-func (this *CommonEvent) ReadValue(value any) {
+func (this *CommonEvent) ReadValue(value any) error {
     // 读取单一的参数 适用于那些有潜在对齐问题的结构体
-    var err error
-    if err = binary.Read(this.buf, binary.LittleEndian, value); err != nil {
-        panic(err)
+    if err := binary.Read(this.buf, binary.LittleEndian, value); err != nil {
+        return fmt.Errorf("read value %T failed, err:%v", value, err)
+    }
+    return nil
+}
+
+func (this *CommonEvent) ReadValues(values ...any) error {
+    for _, value := range values {
+        if err := this.ReadValue(value); err != nil {
+            return err
+        }
     }
+    return nil
 }
 
 func (this *CommonEvent) ParseContext() (err error) {
@@ -110,43 +130,73 @@ func (this *CommonEvent) ParseContext() (err error) {
     return nil
 }
 
-func (this *CommonEvent) NewMmap2Event() IEventStruct {
+func (this *CommonEvent) NewMmap2Event() (IEventStruct, error) {
     event := &Mmap2Event{CommonEvent: *this}
     err := event.ParseContext()
     if err != nil {
-        panic(fmt.Sprintf("NewMmap2Event.ParseContext() err:%v", err))
+        return nil, fmt.Errorf("NewMmap2Event.ParseContext() err:%v", err)
     }
     if event.Pid == uint32(os.Getpid()) {
-        return nil
+        return nil, nil
     }
-    return event
+    // 只输出被追踪进程中可执行的文件映射 也就是库的加载
+    if !this.mconf.Lifecycle || this.mconf.SkipEventLog() || event.Prot&unix.PROT_EXEC == 0 || !strings.HasPrefix(event.Filename, "/") {
+        return nil, nil
+    }
+    info := lifecycle_helper.Lookup(this.mconf, event.Pid)
+    if !info.Traced {
+        return nil, nil
+    }
+    event.pkg = GetLifecyclePackage(this.mconf, info)
+    return event, nil
 }
 
-func (this *CommonEvent) NewCommEvent() IEventStruct {
+func (this *CommonEvent) NewCommEvent() (IEventStruct, error) {
     event := &CommEvent{CommonEvent: *this}
     err := event.ParseContext()
     if err != nil {
-        panic(fmt.Sprintf("NewCommEvent.ParseContext() err:%v", err))
+        return nil, fmt.Errorf("NewCommEvent.ParseContext() err:%v", err)
     }
-    return event
+    if !this.mconf.Lifecycle || this.mconf.SkipEventLog() || event.Pid == this.mconf.SelfPid {
+        return nil, nil
+    }
+    info := lifecycle_helper.Lookup(this.mconf, event.Pid)
+    if !info.Traced {
+        return nil, nil
+    }
+    event.pkg = GetLifecyclePackage(this.mconf, info)
+    return event, nil
 }
 
-func (this *CommonEvent) NewForkEvent() IEventStruct {
+func (this *CommonEvent) NewForkEvent() (IEventStruct, error) {
     event := &ForkEvent{CommonEvent: *this}
     err := event.ParseContext()
     if err != nil {
-        panic(fmt.Sprintf("NewForkEvent.ParseContext() err:%v", err))
+        return nil, fmt.Errorf("NewForkEvent.ParseContext() err:%v", err)
     }
-    return event
+    if !this.mconf.Lifecycle || this.mconf.SkipEventLog() || event.Pid == this.mconf.SelfPid {
+        return nil, nil
+    }
+    info := lifecycle_helper.AddFork(this.mconf, event.Ppid, event.Pid)
+    if !info.Traced {
+        return nil, nil
+    }
+    event.pkg = GetLifecyclePackage(this.mconf, info)
+    return event, nil
 }
 
-func (this *CommonEvent) NewExitEvent() IEventStruct {
+func (this *CommonEvent) NewExitEvent() (IEventStruct, error) {
     event := &ExitEvent{CommonEvent: *this}
     err := event.ParseContext()
     if err != nil {
-        panic(fmt.Sprintf("NewExitEvent.ParseContext() err:%v", err))
+        return nil, fmt.Errorf("NewExitEvent.ParseContext() err:%v", err)
     }
-    return event
+    if event.Pid == event.Tid {
+        lifecycle_helper.Remove(event.Pid)
+        fd_helper.RemovePid(event.Pid)
+    }
+    // 带退出码的由 ebpf 输出 这里不再输出
+    return nil, nil
 }
 
 func (this *CommonEvent) RecordType() uint32 {
@@ -157,16 +207,29 @@ func (this *CommonEvent) DumpRecord() bool {
     return this.mconf.DumpRecord(common.COMMON_EVENT, &this.rec)
 }
 
-func (this *CommonEvent) ParseEvent() (IEventStruct, error) {
+func (this *CommonEvent) RecoverParse(data_e *IEventStruct, err *error) {
+    // 数据不完整已经按错误返回 走到这里说明是代码的问题 打印 panic 和堆栈方便定位
+    if r := recover(); r != nil {
+        this.logger.Printf("parse RecordType:%d panic:%v\n%s", this.rec.RecordType, r, debug.Stack())
+        if this.mconf != nil && this.mconf.Debug {
+            this.logger.Printf("RecordType:%d RawSample:\n%s", this.rec.RecordType, util.HexDump(this.rec.RawSample, util.COLORRED))
+        }
+        *data_e = nil
+        *err = fmt.Errorf("parse RecordType:%d failed, err:%v", this.rec.RecordType, r)
+    }
+}
+
+func (this *CommonEvent) ParseEvent() (data_e IEventStruct, err error) {
+    defer this.RecoverParse(&data_e, &err)
     switch this.rec.RecordType {
     case unix.PERF_RECORD_COMM:
-        return this.NewCommEvent(), nil
+        return this.NewCommEvent()
     case unix.PERF_RECORD_MMAP2:
-        return this.NewMmap2Event(), nil
+        return this.NewMmap2Event()
     case unix.PERF_RECORD_EXIT:
-        return this.NewExitEvent(), nil
+        return this.NewExitEvent()
     case unix.PERF_RECORD_FORK:
-        return this.NewForkEvent(), nil
+        return this.NewForkEvent()
     default:
         return nil, errors.New(fmt.Sprintf("unsupported RecordType:%d", this.rec.RecordType))
     }
@@ -204,6 +267,13 @@ func DelStopped(pid uint32) {
     delete(stopped_pid_list, pid)
 }
 
+func IsStopped(pid uint32) bool {
+    stopped_lock.Lock()
+    defer stopped_lock.Unlock()
+    _, ok := stopped_pid_list[pid]
+    return ok
+}
+
 func LetItResume(stopped_pid uint32) {
     err := syscall.Kill(int(stopped_pid), syscall.SIGCONT)
     if err != nil {
@@ -1,7 +1,7 @@
 package event_parser
 
 import (
-	"encoding/binary"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -30,79 +30,94 @@ func (this *EventParser) SetConf(mconf *config.ModuleConfig) {
 	this.mconf = mconf
 }
 
+func (this *EventParser) ParseRecord(record *DumpRecord) (event.IEventStruct, error) {
+	rec := perf.Record{}
+	rec.RawSample = record.RecRaw
+	rec.RecordType = record.RecType
+
+	rec.ExtraOptions = &perf.ExtraPerfOptions{
+		UnwindStack:       this.mconf.UnwindStack,
+		ShowRegs:          this.mconf.ShowRegs,
+		BrkAddr:           this.mconf.BrkAddr,
+		BrkLen:            this.mconf.BrkLen,
+		BrkType:           this.mconf.BrkType,
+		Sample_stack_user: this.mconf.StackSize,
+	}
+
+	var te event.IEventStruct
+	switch record.EventIndex {
+	case common.COMMON_EVENT:
+		te = &event.CommonEvent{}
+	case common.BRK_EVENT:
+		te = &event.BrkEvent{}
+	case common.UPROBE_EVENT:
+		te = &event.UprobeEvent{}
+	case common.SYSCALL_EVENT:
+		te = &event.SyscallEvent{}
+	default:
+		return nil, fmt.Errorf("unknown event_index:%d", record.EventIndex)
+	}
+	te.SetLogger(this.logger)
+	te.SetConf(this.mconf)
+	te.SetRecord(rec)
+	return te.ParseEvent()
+}
+
 func (this *EventParser) ParseDump(dump_name string) {
 	if dump_name == "" {
 		return
 	}
 	dir, _ := os.Getwd()
 	dump_path := dir + "/" + dump_name
-	// 提前打开文件
-	f, err := os.Open(dump_path)
+	// 文件不存在时按 --dump-size/--dump-period 切分的分段依次读取
+	paths, err := FindDumpSegments(dump_path)
 	if err != nil {
-		panic("open dump file failed...")
+		this.logger.Fatalf("open dump file failed, err:%v", err)
 	}
+	segments := NewSegmentReader(paths)
+	defer segments.Close()
 
+	// 单条记录损坏时跳过并重新同步 不影响后面的数据
+	reader := NewDumpReader(segments)
 	for {
-		var total_len uint32
-		var event_index uint8
-		var rec_type uint32
-		var rec_len uint32
-		if err = binary.Read(f, binary.LittleEndian, &total_len); err != nil {
-			// 理想情况下应该在这里退出
-			if err == io.EOF {
-				break
+		record, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				this.logger.Printf("read dump file failed, err:%v", err)
 			}
-			panic(err)
-		}
-		if err = binary.Read(f, binary.LittleEndian, &event_index); err != nil {
-			panic(err)
-		}
-		if err = binary.Read(f, binary.LittleEndian, &rec_type); err != nil {
-			panic(err)
-		}
-		if err = binary.Read(f, binary.LittleEndian, &rec_len); err != nil {
-			panic(err)
-		}
-		// this.logger.Printf("len:%d event:%d type:%d rec_len:%d\n", total_len, event_index, rec_type, rec_len)
-		rec_raw := make([]byte, rec_len)
-		if err = binary.Read(f, binary.LittleEndian, &rec_raw); err != nil {
-			panic(err)
+			break
 		}
-		// this.logger.Printf("rec_raw:\n%s", util.HexDumpGreen(rec_raw))
-		rec := perf.Record{}
-		rec.RawSample = rec_raw
-		rec.RecordType = rec_type
-
-		rec.ExtraOptions = &perf.ExtraPerfOptions{
-			UnwindStack:       this.mconf.UnwindStack,
-			ShowRegs:          this.mconf.ShowRegs,
-			BrkAddr:           this.mconf.BrkAddr,
-			BrkLen:            this.mconf.BrkLen,
-			BrkType:           this.mconf.BrkType,
-			Sample_stack_user: this.mconf.StackSize,
-		}
-
-		var te event.IEventStruct
-		switch event_index {
-		case common.COMMON_EVENT:
-			te = &event.CommonEvent{}
-		case common.BRK_EVENT:
-			te = &event.BrkEvent{}
-		case common.UPROBE_EVENT:
-			te = &event.UprobeEvent{}
-		case common.SYSCALL_EVENT:
-			te = &event.SyscallEvent{}
-		default:
-			panic("unknown event...")
-		}
-		te.SetLogger(this.logger)
-		te.SetConf(this.mconf)
-		te.SetRecord(rec)
-		data_e, err := te.ParseEvent()
+		data_e, err := this.ParseRecord(record)
 		if err != nil {
-			panic(err)
+			reader.Stats.Failed += 1
+			this.logger.Printf("skip record at offset 0x%x, err:%v", record.Offset, err)
+			continue
+		}
+		if data_e == nil {
+			// 统计和聚合堆栈的模式下不逐条输出
+			continue
 		}
 		this.logger.Println(data_e.String())
 	}
+	for _, err := range segments.Errors {
+		this.logger.Printf("read dump segment failed, err:%v", err)
+	}
+	if reader.Stats.Failed > 0 || reader.Stats.Corrupt > 0 || reader.Stats.Truncated > 0 {
+		this.logger.Printf("parse %s done, %s", dump_name, reader.Stats.String())
+	}
+	if err := event.PcapClose(); err != nil {
+		this.logger.Printf("save %s failed, err:%v", this.mconf.PcapFile, err)
+	}
+	event.TlsClose()
+	event.SummaryClose()
+	if err := event.FlameClose(); err != nil {
+		this.logger.Printf("FlameClose failed, err:%v", err)
+	}
+	if err := event.TraceClose(); err != nil {
+		this.logger.Printf("save %s failed, err:%v", this.mconf.TraceFile, err)
+	}
+	if err := event.DbClose(); err != nil {
+		this.logger.Printf("save %s failed, err:%v", this.mconf.DbFile, err)
+	}
 	os.Exit(0)
 }
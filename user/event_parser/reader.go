@@ -0,0 +1,143 @@
+package event_parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"stackplz/user/common"
+
+	"golang.org/x/sys/unix"
+)
+
+// total_len|event_index|rec_type|rec_len|rec_raw 见 ModuleConfig.DumpRecord
+const RECORD_HEADER_LEN = 4 + 1 + 4 + 4
+
+// 单条记录的上限 栈数据最大 65528 字节 再加上参数数据也远小于这个值
+const MAX_RECORD_LEN = 1 << 20
+
+type DumpRecord struct {
+	Offset     int64
+	EventIndex uint8
+	RecType    uint32
+	RecRaw     []byte
+}
+
+type DumpStats struct {
+	Records      uint64
+	Failed       uint64
+	Corrupt      uint64
+	SkippedBytes uint64
+	Truncated    uint64
+}
+
+func (this *DumpStats) String() string {
+	return fmt.Sprintf("records:%d failed:%d corrupt:%d(%d bytes skipped) truncated:%d", this.Records, this.Failed, this.Corrupt, this.SkippedBytes, this.Truncated)
+}
+
+type DumpReader struct {
+	r         *bufio.Reader
+	offset    int64
+	resyncing bool
+	Stats     DumpStats
+}
+
+func NewDumpReader(r io.Reader) *DumpReader {
+	reader := &DumpReader{}
+	// 至少要能同时看到一条完整的记录和下一条记录的头部
+	reader.r = bufio.NewReaderSize(r, RECORD_HEADER_LEN+MAX_RECORD_LEN+RECORD_HEADER_LEN)
+	return reader
+}
+
+func ParseRecordHeader(header []byte) (uint8, uint32, uint32, bool) {
+	total_len := binary.LittleEndian.Uint32(header[0:])
+	event_index := header[4]
+	rec_type := binary.LittleEndian.Uint32(header[5:])
+	rec_len := binary.LittleEndian.Uint32(header[9:])
+	if rec_len == 0 || rec_len > MAX_RECORD_LEN || total_len != 1+4+4+rec_len {
+		return 0, 0, 0, false
+	}
+	switch event_index {
+	case common.COMMON_EVENT, common.BRK_EVENT, common.UPROBE_EVENT, common.SYSCALL_EVENT:
+	default:
+		return 0, 0, 0, false
+	}
+	switch rec_type {
+	case unix.PERF_RECORD_SAMPLE, unix.PERF_RECORD_COMM, unix.PERF_RECORD_MMAP2, unix.PERF_RECORD_EXIT, unix.PERF_RECORD_FORK:
+	default:
+		return 0, 0, 0, false
+	}
+	return event_index, rec_type, rec_len, true
+}
+
+func (this *DumpReader) skip(n int) {
+	// 跳过损坏的数据 连续跳过的部分算作一处损坏
+	if !this.resyncing {
+		this.Stats.Corrupt += 1
+		this.resyncing = true
+	}
+	this.r.Discard(n)
+	this.offset += int64(n)
+	this.Stats.SkippedBytes += uint64(n)
+}
+
+func (this *DumpReader) Next() (*DumpRecord, error) {
+	for {
+		header, err := this.r.Peek(RECORD_HEADER_LEN)
+		if err != nil {
+			if len(header) > 0 {
+				// 采集中途断电之类的情况 末尾只写了一部分
+				this.Stats.Truncated += 1
+				this.offset += int64(len(header))
+			}
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		event_index, rec_type, rec_len, ok := ParseRecordHeader(header)
+		if !ok {
+			// 逐字节向后查找下一个合法的头部
+			this.skip(1)
+			continue
+		}
+		record_len := RECORD_HEADER_LEN + int(rec_len)
+		data, err := this.r.Peek(record_len + RECORD_HEADER_LEN)
+		if len(data) < record_len {
+			if err != io.EOF {
+				return nil, err
+			}
+			if this.resyncing {
+				// 损坏数据中碰巧合法的头部 rec_len 可能很大 继续向后查找
+				this.skip(1)
+				continue
+			}
+			this.Stats.Truncated += 1
+			this.offset += int64(len(data))
+			return nil, io.EOF
+		}
+		if this.resyncing {
+			// 在损坏的数据中找到的头部 可能只是碰巧合法 要求紧接着的也是合法头部才认为已经同步
+			// 到了文件末尾则要求记录恰好在末尾结束
+			next_ok := len(data) == record_len
+			if len(data) == record_len+RECORD_HEADER_LEN {
+				_, _, _, next_ok = ParseRecordHeader(data[record_len:])
+			}
+			if !next_ok {
+				this.skip(1)
+				continue
+			}
+		}
+		record := &DumpRecord{}
+		record.Offset = this.offset
+		record.EventIndex = event_index
+		record.RecType = rec_type
+		record.RecRaw = make([]byte, rec_len)
+		copy(record.RecRaw, data[RECORD_HEADER_LEN:record_len])
+		this.r.Discard(record_len)
+		this.offset += int64(record_len)
+		this.resyncing = false
+		this.Stats.Records += 1
+		return record, nil
+	}
+}
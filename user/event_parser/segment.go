@@ -0,0 +1,108 @@
+package event_parser
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"stackplz/user/config"
+)
+
+// 按顺序读取 --dump 的全部分段 压缩过的分段根据 gzip 头自动解压
+// 对 DumpReader 来说就是一个连续的数据流
+type SegmentReader struct {
+	paths  []string
+	index  int
+	file   *os.File
+	reader io.Reader
+	// 分段末尾没写完整的 gzip 数据 读到哪算哪 不影响后面的分段
+	Errors []error
+}
+
+func FindDumpSegments(dump_path string) ([]string, error) {
+	if _, err := os.Stat(dump_path); err == nil {
+		return []string{dump_path}, nil
+	}
+	var paths []string
+	for index := 0; ; index++ {
+		segment_path := config.DumpSegmentPath(dump_path, index)
+		if _, err := os.Stat(segment_path); err != nil {
+			break
+		}
+		paths = append(paths, segment_path)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("can not find %s or %s", dump_path, config.DumpSegmentPath(dump_path, 0))
+	}
+	return paths, nil
+}
+
+func NewSegmentReader(paths []string) *SegmentReader {
+	reader := &SegmentReader{}
+	reader.paths = paths
+	return reader
+}
+
+func (this *SegmentReader) open() error {
+	path := this.paths[this.index]
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	this.file = f
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		this.reader = gr
+	} else {
+		this.reader = br
+	}
+	return nil
+}
+
+func (this *SegmentReader) closeSegment() {
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+	this.reader = nil
+	this.index += 1
+}
+
+func (this *SegmentReader) Read(p []byte) (int, error) {
+	for this.index < len(this.paths) {
+		if this.reader == nil {
+			if err := this.open(); err != nil {
+				this.Errors = append(this.Errors, err)
+				this.closeSegment()
+				continue
+			}
+		}
+		n, err := this.reader.Read(p)
+		if err == io.EOF {
+			this.closeSegment()
+			err = nil
+		} else if err != nil {
+			// 压缩数据被截断或损坏 放弃这个分段剩下的部分
+			this.Errors = append(this.Errors, fmt.Errorf("%s: %v", this.paths[this.index], err))
+			this.closeSegment()
+			err = nil
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+func (this *SegmentReader) Close() {
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+}
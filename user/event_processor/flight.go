@@ -0,0 +1,185 @@
+package event_processor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"stackplz/user/config"
+	"stackplz/user/event"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 飞行记录的解析只在事件分发的 goroutine 上进行 外部的输出请求通过 channel 交过去
+const FLIGHT_FLUSH_TIMEOUT = 5 * time.Second
+
+type flushRequest struct {
+	reason string
+	result chan int
+}
+
+type flightEntry struct {
+	e    event.IEventStruct
+	pid  uint32
+	size uint64
+	time time.Time
+}
+
+// 飞行记录 样本原样保存在内存中 超出大小或者时间的部分丢弃 触发时再按正常流程输出
+// comm mmap2 fork exit 这些记录关系到后续的解析 仍然立即处理
+type FlightRecorder struct {
+	sync.Mutex
+	conf    *config.FlightConfig
+	logger  *log.Logger
+	entries []flightEntry
+	head    int
+	size    uint64
+	pids    map[uint32]int
+	// 来自控制台和 rpc 的输出请求
+	requests chan flushRequest
+	// 保证同一时间只有一次输出
+	flush_lock sync.Mutex
+}
+
+var flight_recorder *FlightRecorder
+
+func SetupFlight(conf *config.FlightConfig, logger *log.Logger) {
+	flight_recorder = &FlightRecorder{}
+	flight_recorder.conf = conf
+	flight_recorder.logger = logger
+	flight_recorder.pids = make(map[uint32]int)
+	flight_recorder.requests = make(chan flushRequest)
+	logger.Printf("flight recorder enabled, %s", conf.String())
+}
+
+func FlightFlush(reason string) (int, error) {
+	if flight_recorder == nil {
+		return 0, errors.New("flight recorder is not enabled, use --flight")
+	}
+	req := flushRequest{reason, make(chan int, 1)}
+	select {
+	case flight_recorder.requests <- req:
+	case <-time.After(FLIGHT_FLUSH_TIMEOUT):
+		return 0, errors.New("flight recorder is not running")
+	}
+	return <-req.result, nil
+}
+
+func flightRequests() chan flushRequest {
+	// 没有开启飞行记录时返回 nil 在 select 中永远不会就绪
+	if flight_recorder == nil {
+		return nil
+	}
+	return flight_recorder.requests
+}
+
+func (this *FlightRecorder) evict(now time.Time) {
+	for this.head < len(this.entries) {
+		entry := &this.entries[this.head]
+		over_size := this.conf.MaxSize > 0 && this.size > this.conf.MaxSize
+		over_age := this.conf.MaxAge > 0 && now.Sub(entry.time) > this.conf.MaxAge
+		if !over_size && !over_age {
+			break
+		}
+		this.size -= entry.size
+		this.pids[entry.pid] -= 1
+		if this.pids[entry.pid] <= 0 {
+			delete(this.pids, entry.pid)
+		}
+		entry.e = nil
+		this.head += 1
+	}
+	// 丢弃的部分超过一半时再整体前移 避免每次都复制
+	if this.head > 0 && this.head*2 >= len(this.entries) {
+		n := copy(this.entries, this.entries[this.head:])
+		for i := n; i < len(this.entries); i++ {
+			this.entries[i].e = nil
+		}
+		this.entries = this.entries[:n]
+		this.head = 0
+	}
+}
+
+func (this *FlightRecorder) trigger(peek *event.FlightPeek) (string, bool) {
+	if peek.Exit {
+		// 只关心缓冲中有记录的进程退出
+		this.Lock()
+		_, traced := this.pids[peek.Pid]
+		this.Unlock()
+		if this.conf.OnExit && traced {
+			return fmt.Sprintf("exit of pid %d", peek.Pid), true
+		}
+		return "", false
+	}
+	if peek.Signal != 0 && this.conf.MatchSignal(peek.Signal) {
+		return fmt.Sprintf("signal %d in pid %d", peek.Signal, peek.Pid), true
+	}
+	if peek.Point != "" && this.conf.MatchPoint(peek.Point) {
+		return fmt.Sprintf("%s in pid %d", peek.Point, peek.Pid), true
+	}
+	return "", false
+}
+
+// 返回 true 表示事件已经由飞行记录接管
+func (this *FlightRecorder) Add(e event.IEventStruct) bool {
+	peek, err := e.PeekFlight()
+	if err != nil {
+		this.logger.Printf("flight recorder peek failed, err:%v", err)
+	}
+	reason, triggered := this.trigger(&peek)
+	if e.RecordType() != unix.PERF_RECORD_SAMPLE {
+		if triggered {
+			this.Flush(reason)
+		}
+		return false
+	}
+	entry := flightEntry{}
+	entry.e = e
+	entry.pid = peek.Pid
+	entry.size = peek.Size
+	entry.time = time.Now()
+	this.Lock()
+	this.entries = append(this.entries, entry)
+	this.size += entry.size
+	this.pids[entry.pid] += 1
+	this.evict(entry.time)
+	this.Unlock()
+	if triggered {
+		this.Flush(reason)
+	}
+	return true
+}
+
+// 只能在事件分发的 goroutine 上调用 其他地方使用 FlightFlush
+func (this *FlightRecorder) Flush(reason string) int {
+	this.flush_lock.Lock()
+	defer this.flush_lock.Unlock()
+	this.Lock()
+	entries := this.entries[this.head:]
+	this.entries = nil
+	this.head = 0
+	this.size = 0
+	this.pids = make(map[uint32]int)
+	this.Unlock()
+
+	this.logger.Printf("flight recorder triggered by %s, flush %d records", reason, len(entries))
+	for _, entry := range entries {
+		e := entry.e
+		if e.DumpRecord() {
+			continue
+		}
+		data_e, err := e.ParseEvent()
+		if err != nil {
+			this.logger.Printf("ParseEvent faild, err:%v", err)
+			continue
+		}
+		if data_e == nil {
+			continue
+		}
+		this.logger.Println(data_e.String())
+	}
+	this.logger.Printf("flight recorder flush done")
+	return len(entries)
+}
@@ -3,8 +3,6 @@ package event_processor
 import (
 	"stackplz/user/event"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
 type IWorker interface {
@@ -64,22 +62,9 @@ func (this *eventWorker) Display() {
 
 // 解析类型，输出
 func (this *eventWorker) parserEvent(e event.IEventStruct) {
+	// fork comm mmap2 只有开启 --lifecycle 时才会解析出事件 其他情况在解析时就已经丢弃
 	logger := this.processor.GetLogger()
-	switch e.RecordType() {
-	case unix.PERF_RECORD_COMM:
-	case unix.PERF_RECORD_MMAP2:
-	case unix.PERF_RECORD_EXIT:
-	case unix.PERF_RECORD_FORK:
-		{
-			// 这几种暂时不需要输出
-			break
-		}
-	default:
-		{
-			logger.Println(e.String())
-		}
-	}
-
+	logger.Println(e.String())
 }
 
 func (this *eventWorker) Run() {
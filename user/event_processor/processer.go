@@ -35,15 +35,22 @@ func (this *EventProcessor) init() {
 
 // Write event 处理器读取事件
 func (this *EventProcessor) Serve() {
+	requests := flightRequests()
 	for {
 		select {
 		case e := <-this.incoming:
 			this.dispatch(e)
+		case req := <-requests:
+			req.result <- flight_recorder.Flush(req.reason)
 		}
 	}
 }
 
 func (this *EventProcessor) dispatch(map_e event.IEventStruct) {
+	// 飞行记录模式下样本先放入内存 触发时才输出
+	if flight_recorder != nil && flight_recorder.Add(map_e) {
+		return
+	}
 	// 如果需要dump那就直接写到文件中去
 	if map_e.DumpRecord() {
 		return
@@ -0,0 +1,145 @@
+package module
+
+import (
+	"fmt"
+	"stackplz/user/config"
+	"sync"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+)
+
+// syscall 和 uprobe 同时运行时需要共用的状态 比如 --start-on/--stop-on 的启用状态
+// 以及 --scope 的调用深度 --spawn 停下的进程 所以由用户态创建 再通过 MapEditors 替换各个 ebpf 程序中的同名 map
+var shared_maps = make(map[string]*ebpf.Map)
+var shared_lock sync.Mutex
+
+// syscall 和 stack 模块都带有的 exec/exit tracepoint 以及 --scope 的 hook 只需要挂载一次
+// 由最先启动的模块挂载 否则同一次 exec 会输出多次 调用深度也会重复计算
+var claimed_probes = make(map[string]bool)
+
+func claimProbe(name string) bool {
+	shared_lock.Lock()
+	defer shared_lock.Unlock()
+	if claimed_probes[name] {
+		return false
+	}
+	claimed_probes[name] = true
+	return true
+}
+
+func getSharedMap(spec *ebpf.MapSpec) (*ebpf.Map, error) {
+	shared_lock.Lock()
+	defer shared_lock.Unlock()
+	if bpf_map, ok := shared_maps[spec.Name]; ok {
+		return bpf_map, nil
+	}
+	bpf_map, err := ebpf.NewMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("create %s map failed, err:%v", spec.Name, err)
+	}
+	shared_maps[spec.Name] = bpf_map
+	return bpf_map, nil
+}
+
+func getTriggerState() (*ebpf.Map, error) {
+	return getSharedMap(&ebpf.MapSpec{
+		Name:       "trigger_state",
+		Type:       ebpf.LRUHash,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1024,
+	})
+}
+
+func getScopeState() (*ebpf.Map, error) {
+	// key 为 host tid value 为 scope_state_t
+	return getSharedMap(&ebpf.MapSpec{
+		Name:       "scope_state",
+		Type:       ebpf.LRUHash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1024,
+	})
+}
+
+// --spawn 时由 syscall 模块写入停下的 pid 用户态恢复运行之后写入这个值
+// 不为 0 所以同 uid 的其他进程不会再被停下
+const SPAWN_STATE_DONE uint32 = 0xffffffff
+
+func getSpawnState() (*ebpf.Map, error) {
+	return getSharedMap(&ebpf.MapSpec{
+		Name:       "spawn_state",
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+}
+
+func ReadSpawnState() (uint32, error) {
+	// 返回 0 表示还没有进程停下
+	bpf_map, err := getSpawnState()
+	if err != nil {
+		return 0, err
+	}
+	var key uint32 = 0
+	var pid uint32 = 0
+	if err := bpf_map.Lookup(unsafe.Pointer(&key), unsafe.Pointer(&pid)); err != nil {
+		return 0, err
+	}
+	if pid == SPAWN_STATE_DONE {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+func ClearSpawnState() error {
+	bpf_map, err := getSpawnState()
+	if err != nil {
+		return err
+	}
+	var key uint32 = 0
+	var value uint32 = SPAWN_STATE_DONE
+	return bpf_map.Update(unsafe.Pointer(&key), unsafe.Pointer(&value), ebpf.UpdateAny)
+}
+
+func updateTriggerState(state_key uint32, armed bool) error {
+	bpf_map, err := getTriggerState()
+	if err != nil {
+		return err
+	}
+	var value uint32 = 0
+	if armed {
+		value = 1
+	}
+	return bpf_map.Update(unsafe.Pointer(&state_key), unsafe.Pointer(&value), ebpf.UpdateAny)
+}
+
+func setupSharedMaps(mconf *config.ModuleConfig) (map[string]*ebpf.Map, error) {
+	map_editors := make(map[string]*ebpf.Map)
+	if mconf.TriggerConf != nil {
+		bpf_map, err := getTriggerState()
+		if err != nil {
+			return nil, err
+		}
+		// brk 触发点由用户态更新状态
+		mconf.TriggerConf.UpdateState = updateTriggerState
+		map_editors["trigger_state"] = bpf_map
+	}
+	if mconf.ScopeConf != nil {
+		bpf_map, err := getScopeState()
+		if err != nil {
+			return nil, err
+		}
+		map_editors["scope_state"] = bpf_map
+	}
+	if mconf.SpawnConf != nil {
+		bpf_map, err := getSpawnState()
+		if err != nil {
+			return nil, err
+		}
+		map_editors["spawn_state"] = bpf_map
+	}
+	return map_editors, nil
+}
@@ -60,36 +60,47 @@ func (this *MStack) setupManager() error {
     }
     probes = append(probes, fork_probe)
 
+    if claimProbe("lifecycle") {
+        // exec 之后重新读取 maps 以及挂载新程序的 hook
+        exec_probe := &manager.Probe{
+            Section:      "raw_tracepoint/sched_process_exec",
+            EbpfFuncName: "tracepoint__sched__sched_process_exec",
+        }
+        // --lifecycle 输出退出码
+        exit_probe := &manager.Probe{
+            Section:      "raw_tracepoint/sched_process_exit",
+            EbpfFuncName: "tracepoint__sched__sched_process_exit",
+        }
+        probes = append(probes, exec_probe, exit_probe)
+    }
+    if claimProbe("signal") {
+        // --flight-trigger 按进程收到的信号触发
+        signal_probe := &manager.Probe{
+            Section:      "raw_tracepoint/signal_deliver",
+            EbpfFuncName: "tracepoint__signal__signal_deliver",
+        }
+        probes = append(probes, signal_probe)
+    }
+
     for i, uprobe_point := range this.mconf.StackUprobeConf.Points {
-        // stack hook 配置
-        sym := uprobe_point.Symbol
-        var stack_probe *manager.Probe
-        if sym == "" {
-            sym = util.RandStringBytes(8)
-            stack_probe = &manager.Probe{
-                Section:          fmt.Sprintf("uprobe/stack_%d", i),
-                EbpfFuncName:     fmt.Sprintf("probe_stack_%d", i),
-                AttachToFuncName: sym,
-                RealFilePath:     uprobe_point.RealFilePath,
-                BinaryPath:       uprobe_point.LibPath,
-                NonElfOffset:     uprobe_point.NonElfOffset,
-                // 这个是相对于库文件基址的偏移
-                UAddress: uprobe_point.Offset,
-            }
+        if uprobe_point.IsRet {
+            // 返回时读取参数 需要在进入时先保存寄存器
+            save_probe := this.newStackProbe(fmt.Sprintf("uprobe/stack_save_%d", i), fmt.Sprintf("probe_stack_save_%d", i), uprobe_point)
+            ret_probe := this.newStackProbe(fmt.Sprintf("uretprobe/stack_ret_%d", i), fmt.Sprintf("probe_stack_ret_%d", i), uprobe_point)
+            probes = append(probes, save_probe, ret_probe)
         } else {
-            stack_probe = &manager.Probe{
-                Section:          fmt.Sprintf("uprobe/stack_%d", i),
-                EbpfFuncName:     fmt.Sprintf("probe_stack_%d", i),
-                AttachToFuncName: sym,
-                RealFilePath:     uprobe_point.RealFilePath,
-                BinaryPath:       uprobe_point.LibPath,
-                NonElfOffset:     uprobe_point.NonElfOffset,
-                // 这个是相对于符号的偏移
-                UprobeOffset: uprobe_point.Offset,
-            }
+            stack_probe := this.newStackProbe(fmt.Sprintf("uprobe/stack_%d", i), fmt.Sprintf("probe_stack_%d", i), uprobe_point)
+            probes = append(probes, stack_probe)
         }
         this.logger.Printf("idx:%d %s", i, uprobe_point.String())
-        probes = append(probes, stack_probe)
+    }
+    if this.mconf.ScopeConf != nil && claimProbe("scope") {
+        // 进入和返回时更新调用深度
+        scope_point := this.mconf.ScopeConf.Point
+        enter_probe := this.newStackProbe("uprobe/scope_enter", "probe_scope_enter", scope_point)
+        exit_probe := this.newStackProbe("uretprobe/scope_exit", "probe_scope_exit", scope_point)
+        probes = append(probes, enter_probe, exit_probe)
+        this.logger.Printf("%s", this.mconf.ScopeConf.String())
     }
 
     this.bpfManager = &manager.Manager{
@@ -99,6 +110,37 @@ func (this *MStack) setupManager() error {
     return nil
 }
 
+func (this *MStack) newStackProbe(section, func_name string, uprobe_point *config.UprobeArgs) *manager.Probe {
+    // stack hook 配置
+    sym := uprobe_point.Symbol
+    var stack_probe *manager.Probe
+    if sym == "" {
+        sym = util.RandStringBytes(8)
+        stack_probe = &manager.Probe{
+            Section:          section,
+            EbpfFuncName:     func_name,
+            AttachToFuncName: sym,
+            RealFilePath:     uprobe_point.RealFilePath,
+            BinaryPath:       uprobe_point.LibPath,
+            NonElfOffset:     uprobe_point.NonElfOffset,
+            // 这个是相对于库文件基址的偏移
+            UAddress: uprobe_point.Offset,
+        }
+    } else {
+        stack_probe = &manager.Probe{
+            Section:          section,
+            EbpfFuncName:     func_name,
+            AttachToFuncName: sym,
+            RealFilePath:     uprobe_point.RealFilePath,
+            BinaryPath:       uprobe_point.LibPath,
+            NonElfOffset:     uprobe_point.NonElfOffset,
+            // 这个是相对于符号的偏移
+            UprobeOffset: uprobe_point.Offset,
+        }
+    }
+    return stack_probe
+}
+
 func (this *MStack) setupManagerOptions() {
     // 对于没有开启 CONFIG_DEBUG_INFO_BTF 的加载额外的 btf.Spec
     if this.mconf.ExternalBTF != "" {
@@ -136,6 +178,15 @@ func (this *MStack) setupManagerOptions() {
             },
         }
     }
+    if this.mconf.TriggerConf != nil || this.mconf.ScopeConf != nil || this.mconf.SpawnConf != nil {
+        // 和其他模块共用触发 scope 以及 spawn 状态
+        map_editors, err := setupSharedMaps(this.mconf)
+        if err != nil {
+            this.logger.Fatalf("[setupManagerOptions] failed, err:%v", err)
+            return
+        }
+        this.bpfManagerOptions.MapEditors = map_editors
+    }
 }
 
 func (this *MStack) Start() error {
@@ -252,6 +303,11 @@ func (this *MStack) update_common_filter() {
     this.logger.Printf("uid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.UidWhitelist), this.list2string(this.mconf.UidBlacklist))
     this.logger.Printf("pid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.PidWhitelist), this.list2string(this.mconf.PidBlacklist))
     this.logger.Printf("tid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.TidWhitelist), this.list2string(this.mconf.TidBlacklist))
+    if this.mconf.TriggerConf != nil {
+        this.update_common_list(this.mconf.TriggerConf.StartKeys, util.TRIGGER_START_START)
+        this.update_common_list(this.mconf.TriggerConf.StopKeys, util.TRIGGER_STOP_START)
+        this.logger.Printf("trigger => %s", this.mconf.TriggerConf.String())
+    }
     var filter_key uint32 = 0
     map_name := "common_filter"
     filter_value := this.mconf.GetCommonFilter()
@@ -266,6 +322,27 @@ func (this *MStack) update_child_parent() {
     }
 }
 
+func (this *MStack) update_exec_filter() {
+    // exec 到这些文件名的进程先停下 挂载 hook 之后再恢复
+    map_name := "exec_stop_filter"
+    bpf_map, err := this.FindMap(map_name)
+    if err != nil {
+        panic(fmt.Sprintf("find [%s] failed, err:%v", map_name, err))
+    }
+    for _, name := range this.mconf.ExecStopNames() {
+        var filter_value uint32 = 1
+        filter_key := config.ExecFilter{}
+        copy(filter_key.Name[:], name)
+        err = bpf_map.Update(unsafe.Pointer(&filter_key), unsafe.Pointer(&filter_value), ebpf.UpdateAny)
+        if err != nil {
+            panic(fmt.Sprintf("update [%s] failed, err:%v", map_name, err))
+        }
+    }
+    if this.mconf.Debug {
+        this.logger.Printf("update %s success", map_name)
+    }
+}
+
 func (this *MStack) update_thread_filter() {
     map_name := "thread_filter"
     bpf_map, err := this.FindMap(map_name)
@@ -405,6 +482,7 @@ func (this *MStack) updateFilter() (err error) {
     this.update_common_filter()
     this.update_child_parent()
     this.update_thread_filter()
+    this.update_exec_filter()
     this.update_stack_config()
     this.update_arg_filter()
     this.update_op_list()
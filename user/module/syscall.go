@@ -61,6 +61,28 @@ func (this *MSyscall) setupManager() error {
     }
     probes = append(probes, fork_probe)
 
+    if claimProbe("lifecycle") {
+        // exec 之后重新读取 maps 以及挂载新程序的 hook
+        exec_probe := &manager.Probe{
+            Section:      "raw_tracepoint/sched_process_exec",
+            EbpfFuncName: "tracepoint__sched__sched_process_exec",
+        }
+        // --lifecycle 输出退出码
+        exit_probe := &manager.Probe{
+            Section:      "raw_tracepoint/sched_process_exit",
+            EbpfFuncName: "tracepoint__sched__sched_process_exit",
+        }
+        probes = append(probes, exec_probe, exit_probe)
+    }
+    if claimProbe("signal") {
+        // --flight-trigger 按进程收到的信号触发
+        signal_probe := &manager.Probe{
+            Section:      "raw_tracepoint/signal_deliver",
+            EbpfFuncName: "tracepoint__signal__signal_deliver",
+        }
+        probes = append(probes, signal_probe)
+    }
+
     // syscall hook 配置
     sys_enter_probe := &manager.Probe{
         Section:      "raw_tracepoint/sys_enter",
@@ -117,6 +139,15 @@ func (this *MSyscall) setupManagerOptions() {
             },
         }
     }
+    if this.mconf.TriggerConf != nil || this.mconf.ScopeConf != nil || this.mconf.SpawnConf != nil {
+        // 和其他模块共用触发 scope 以及 spawn 状态
+        map_editors, err := setupSharedMaps(this.mconf)
+        if err != nil {
+            this.logger.Fatalf("[setupManagerOptions] failed, err:%v", err)
+            return
+        }
+        this.bpfManagerOptions.MapEditors = map_editors
+    }
 }
 
 func (this *MSyscall) Start() error {
@@ -233,6 +264,11 @@ func (this *MSyscall) update_common_filter() {
     this.logger.Printf("uid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.UidWhitelist), this.list2string(this.mconf.UidBlacklist))
     this.logger.Printf("pid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.PidWhitelist), this.list2string(this.mconf.PidBlacklist))
     this.logger.Printf("tid => whitelist:[%s];blacklist:[%s]", this.list2string(this.mconf.TidWhitelist), this.list2string(this.mconf.TidBlacklist))
+    if this.mconf.TriggerConf != nil {
+        this.update_common_list(this.mconf.TriggerConf.StartKeys, util.TRIGGER_START_START)
+        this.update_common_list(this.mconf.TriggerConf.StopKeys, util.TRIGGER_STOP_START)
+        this.logger.Printf("trigger => %s", this.mconf.TriggerConf.String())
+    }
     var filter_key uint32 = 0
     map_name := "common_filter"
     filter_value := this.mconf.GetCommonFilter()
@@ -247,6 +283,27 @@ func (this *MSyscall) update_child_parent() {
     }
 }
 
+func (this *MSyscall) update_exec_filter() {
+    // exec 到这些文件名的进程先停下 挂载 hook 之后再恢复
+    map_name := "exec_stop_filter"
+    bpf_map, err := this.FindMap(map_name)
+    if err != nil {
+        panic(fmt.Sprintf("find [%s] failed, err:%v", map_name, err))
+    }
+    for _, name := range this.mconf.ExecStopNames() {
+        var filter_value uint32 = 1
+        filter_key := config.ExecFilter{}
+        copy(filter_key.Name[:], name)
+        err = bpf_map.Update(unsafe.Pointer(&filter_key), unsafe.Pointer(&filter_value), ebpf.UpdateAny)
+        if err != nil {
+            panic(fmt.Sprintf("update [%s] failed, err:%v", map_name, err))
+        }
+    }
+    if this.mconf.Debug {
+        this.logger.Printf("update %s success", map_name)
+    }
+}
+
 func (this *MSyscall) update_thread_filter() {
     map_name := "thread_filter"
     bpf_map, err := this.FindMap(map_name)
@@ -404,6 +461,7 @@ func (this *MSyscall) updateFilter() (err error) {
     this.update_common_filter()
     this.update_child_parent()
     this.update_thread_filter()
+    this.update_exec_filter()
     this.update_arg_filter()
     this.update_sysenter_point_args()
     this.update_sysexit_point_args()
@@ -11,6 +11,7 @@ import (
 	"os"
 	"stackplz/user/config"
 	"stackplz/user/event"
+	"stackplz/user/event_processor"
 	"stackplz/user/module"
 	"stackplz/user/util"
 	"strconv"
@@ -32,6 +33,31 @@ type RespMsg struct {
 	Msg    string `json:"msg"`
 }
 
+// {"cmd":"flush"}
+type CmdMsg struct {
+	Cmd string `json:"cmd"`
+}
+
+func HandleCmd(cmd string) RespMsg {
+	msg := RespMsg{}
+	switch cmd {
+	case "flush":
+		// 输出飞行记录中的事件
+		count, err := event_processor.FlightFlush("rpc")
+		if err != nil {
+			msg.Status = "error"
+			msg.Msg = err.Error()
+		} else {
+			msg.Status = "ok"
+			msg.Msg = fmt.Sprintf("flush %d records", count)
+		}
+	default:
+		msg.Status = "error"
+		msg.Msg = fmt.Sprintf("unknown cmd:%s", cmd)
+	}
+	return msg
+}
+
 // {"brk_pid":3695,"brk_len":4,"brk_type":"x","brk_addr":"0x79e16b0890"}
 
 type BrkOptions struct {
@@ -158,8 +184,11 @@ func handleConnection(conn net.Conn) {
 
 		msg := RespMsg{}
 
-		brk_options, err := ParseMsg(buffer)
-		if err != nil {
+		cmd_msg := CmdMsg{}
+		if json.Unmarshal(buffer, &cmd_msg) == nil && cmd_msg.Cmd != "" {
+			Logger.Println("Received cmd:", cmd_msg.Cmd)
+			msg = HandleCmd(cmd_msg.Cmd)
+		} else if brk_options, err := ParseMsg(buffer); err != nil {
 			msg.Status = "error"
 			msg.Msg = fmt.Sprintf("ParseMsg failed, err:%v", err)
 		} else {
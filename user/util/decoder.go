@@ -0,0 +1,264 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// buf 类型的解码链 比如 gzip|json 按顺序解码 auto 表示按特征自动识别
+const (
+	DECODER_AUTO     = "auto"
+	DECODER_GZIP     = "gzip"
+	DECODER_ZLIB     = "zlib"
+	DECODER_BASE64   = "base64"
+	DECODER_JSON     = "json"
+	DECODER_PROTOBUF = "protobuf"
+)
+
+// auto 模式下最多连续解码的次数
+const MAX_AUTO_DECODE = 4
+
+// 解压后数据的上限 避免异常数据占用过多内存
+const MAX_DECODE_SIZE = 0x10000
+
+func IsDecoderChain(format string) bool {
+	return CheckDecoderChain(format) == nil
+}
+
+func CheckDecoderChain(format string) error {
+	if format == "" {
+		return errors.New("empty decoder chain")
+	}
+	for _, name := range strings.Split(format, "|") {
+		switch name {
+		case DECODER_AUTO, DECODER_GZIP, DECODER_ZLIB, DECODER_BASE64, DECODER_JSON, DECODER_PROTOBUF:
+		default:
+			return errors.New(fmt.Sprintf("unsupported decoder:%s", name))
+		}
+	}
+	return nil
+}
+
+func read_all_limit(r io.Reader) ([]byte, error) {
+	// 数据可能因为读取大小的限制被截断 那么保留已经解压的部分
+	data, err := io.ReadAll(io.LimitReader(r, MAX_DECODE_SIZE))
+	if err == io.ErrUnexpectedEOF && len(data) > 0 {
+		return data, nil
+	}
+	return data, err
+}
+
+func decode_gzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return read_all_limit(r)
+}
+
+func decode_zlib(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return read_all_limit(r)
+}
+
+func decode_base64(data []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "eyJ") && strings.Count(text, ".") == 2 {
+		// JWT 只解码 header 和 payload
+		parts := strings.Split(text, ".")
+		header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+		if err != nil {
+			return nil, err
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(string(header) + "." + string(payload)), nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if result, err := encoding.DecodeString(text); err == nil {
+			return result, nil
+		}
+	}
+	return nil, errors.New("bad base64")
+}
+
+func decode_json(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(data), "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func is_gzip(data []byte) bool {
+	return len(data) > 10 && data[0] == 0x1f && data[1] == 0x8b && data[2] == 0x08
+}
+
+func is_zlib(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x78 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+func is_base64(data []byte) bool {
+	text := strings.TrimSpace(string(data))
+	if len(text) < 16 {
+		return false
+	}
+	for _, c := range text {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("+/-_=.", c)) {
+			return false
+		}
+	}
+	// 纯字母数字的短文本很可能只是普通字符串
+	return strings.ContainsAny(text, "+/-_=.0123456789")
+}
+
+func is_json(data []byte) bool {
+	text := bytes.TrimSpace(data)
+	return len(text) > 0 && (text[0] == '{' || text[0] == '[') && json.Valid(text)
+}
+
+func detect_decoder(data []byte) string {
+	// 按魔数识别 protobuf 没有明显特征 不参与自动识别
+	switch {
+	case is_gzip(data):
+		return DECODER_GZIP
+	case is_zlib(data):
+		return DECODER_ZLIB
+	case is_json(data):
+		return DECODER_JSON
+	case is_base64(data):
+		return DECODER_BASE64
+	}
+	return ""
+}
+
+func run_decoder(name string, data []byte) ([]byte, error) {
+	switch name {
+	case DECODER_GZIP:
+		return decode_gzip(data)
+	case DECODER_ZLIB:
+		return decode_zlib(data)
+	case DECODER_BASE64:
+		return decode_base64(data)
+	case DECODER_JSON:
+		return decode_json(data)
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported decoder:%s", name))
+}
+
+func dechunk(body []byte) []byte {
+	// Transfer-Encoding: chunked 截断的时候保留已有的部分
+	var out []byte
+	for len(body) > 0 {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			break
+		}
+		size_str := strings.TrimSpace(strings.SplitN(string(body[:i]), ";", 2)[0])
+		size, err := strconv.ParseUint(size_str, 16, 32)
+		if err != nil || size == 0 {
+			break
+		}
+		body = body[i+2:]
+		if uint64(len(body)) < size {
+			out = append(out, body...)
+			break
+		}
+		out = append(out, body[:size]...)
+		body = bytes.TrimPrefix(body[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func split_http(data []byte) ([]byte, []byte, bool) {
+	// HTTP 报文只解码 body 部分
+	if !bytes.HasPrefix(data, []byte("HTTP/1.")) {
+		line_end := bytes.Index(data, []byte("\r\n"))
+		if line_end < 0 || !bytes.Contains(data[:line_end], []byte(" HTTP/1.")) {
+			return nil, nil, false
+		}
+	}
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, false
+	}
+	header := data[:i+4]
+	body := data[i+4:]
+	if bytes.Contains(bytes.ToLower(header), []byte("transfer-encoding: chunked")) {
+		body = dechunk(body)
+	}
+	return header, body, true
+}
+
+func decode_chain(data []byte, format string, msg *PbMessage) (string, []string, bool) {
+	var steps []string
+	for _, name := range strings.Split(format, "|") {
+		if name == DECODER_PROTOBUF {
+			result, ok := FormatProtobuf(data, msg)
+			if !ok {
+				return "", nil, false
+			}
+			return result, append(steps, name), true
+		}
+		if name != DECODER_AUTO {
+			result, err := run_decoder(name, data)
+			if err != nil {
+				return "", nil, false
+			}
+			data = result
+			steps = append(steps, name)
+			continue
+		}
+		for i := 0; i < MAX_AUTO_DECODE; i++ {
+			detected := detect_decoder(data)
+			if detected == "" {
+				break
+			}
+			result, err := run_decoder(detected, data)
+			if err != nil {
+				break
+			}
+			data = result
+			steps = append(steps, detected)
+			if detected == DECODER_JSON {
+				break
+			}
+		}
+	}
+	if len(steps) == 0 {
+		return "", nil, false
+	}
+	if steps[len(steps)-1] == DECODER_JSON {
+		return string(data), steps, true
+	}
+	return PrettyByteSlice(data), steps, true
+}
+
+func DecodeBuffer(data []byte, format string, msg *PbMessage) (string, string, bool) {
+	// 返回解码后的内容 以及实际使用的解码器 比如 gzip|json
+	if header, body, ok := split_http(data); ok {
+		result, steps, ok := decode_chain(body, format, msg)
+		if !ok {
+			return "", "", false
+		}
+		return PrettyByteSlice(header) + result, strings.Join(steps, "|"), true
+	}
+	result, steps, ok := decode_chain(data, format, msg)
+	if !ok {
+		return "", "", false
+	}
+	return result, strings.Join(steps, "|"), true
+}
@@ -12,6 +12,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -258,6 +260,50 @@ func ParseReg(pid uint32, value uint64) (string, error) {
 	return info, err
 }
 
+func FindMapByAddr(pid uint32, addr uint64) (uint64, uint64, string, error) {
+	// 找到地址所在的内存段 返回起止地址和路径 匿名段路径为空
+	content, err := ReadMapsByPid(pid)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	for _, line := range strings.Split(content, "\n") {
+		var (
+			seg_start  uint64
+			seg_end    uint64
+			permission string
+			seg_offset uint64
+			device     string
+			inode      uint64
+			seg_path   string
+		)
+		reader := strings.NewReader(line)
+		n, _ := fmt.Fscanf(reader, "%x-%x %s %x %s %d %s", &seg_start, &seg_end, &permission, &seg_offset, &device, &inode, &seg_path)
+		if n < 6 {
+			continue
+		}
+		if addr >= seg_start && addr < seg_end {
+			return seg_start, seg_end, seg_path, nil
+		}
+	}
+	return 0, 0, "", fmt.Errorf("addr 0x%x not found in maps of pid %d", addr, pid)
+}
+
+func ReadProcessMemory(pid uint32, addr, size uint64) ([]byte, error) {
+	// 通过 process_vm_readv 读取目标进程内存 进程最好处于暂停状态 否则内容可能在读取过程中发生变化
+	if size == 0 {
+		return []byte{}, nil
+	}
+	data := make([]byte, size)
+	local_iov := []unix.Iovec{{Base: &data[0]}}
+	local_iov[0].SetLen(int(size))
+	remote_iov := []unix.RemoteIovec{{Base: uintptr(addr), Len: int(size)}}
+	n, err := unix.ProcessVMReadv(int(pid), local_iov, remote_iov, 0)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
 func B2STrim(src []byte) string {
 	return string(bytes.TrimSpace(bytes.Trim(src, "\x00")))
 }
@@ -296,6 +342,8 @@ const (
 	PID_BLACKLIST_START uint32 = PID_WHITELIST_START + 0x400
 	TID_WHITELIST_START uint32 = PID_BLACKLIST_START + 0x400
 	TID_BLACKLIST_START uint32 = TID_WHITELIST_START + 0x400
+	TRIGGER_START_START uint32 = TID_BLACKLIST_START + 0x400
+	TRIGGER_STOP_START  uint32 = TRIGGER_START_START + 0x400
 )
 
 var START_OFFSETS map[uint32]string = map[uint32]string{
@@ -307,6 +355,8 @@ var START_OFFSETS map[uint32]string = map[uint32]string{
 	PID_BLACKLIST_START: "PID_BLACKLIST_START",
 	TID_WHITELIST_START: "TID_WHITELIST_START",
 	TID_BLACKLIST_START: "TID_BLACKLIST_START",
+	TRIGGER_START_START: "TRIGGER_START_START",
+	TRIGGER_STOP_START:  "TRIGGER_STOP_START",
 }
 
 // 格式化输出相关
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"sort"
+)
+
+// 按 wire format 编码 protobuf 只实现 profile.proto 用得到的部分
+type PbEncoder struct {
+	buf []byte
+}
+
+func (this *PbEncoder) varint(value uint64) {
+	for value >= 0x80 {
+		this.buf = append(this.buf, byte(value)|0x80)
+		value >>= 7
+	}
+	this.buf = append(this.buf, byte(value))
+}
+
+func (this *PbEncoder) Varint(num uint64, value uint64) {
+	// 默认值不需要编码
+	if value == 0 {
+		return
+	}
+	this.varint(num<<3 | PB_WIRE_VARINT)
+	this.varint(value)
+}
+
+func (this *PbEncoder) Bytes(num uint64, data []byte) {
+	this.varint(num<<3 | PB_WIRE_BYTES)
+	this.varint(uint64(len(data)))
+	this.buf = append(this.buf, data...)
+}
+
+func (this *PbEncoder) Packed(num uint64, values []uint64) {
+	if len(values) == 0 {
+		return
+	}
+	sub := &PbEncoder{}
+	for _, value := range values {
+		sub.varint(value)
+	}
+	this.Bytes(num, sub.buf)
+}
+
+func (this *PbEncoder) Message(num uint64, sub *PbEncoder) {
+	this.Bytes(num, sub.buf)
+}
+
+func (this *PbEncoder) Data() []byte {
+	return this.buf
+}
+
+type PprofMapping struct {
+	Id     uint64
+	Start  uint64
+	Limit  uint64
+	Offset uint64
+	File   string
+}
+
+type PprofFunction struct {
+	Id   uint64
+	Name string
+	File string
+}
+
+type PprofLocation struct {
+	Id         uint64
+	MappingId  uint64
+	Address    uint64
+	FunctionId uint64
+}
+
+type PprofSample struct {
+	// 第一个是叶子节点
+	LocationIds []uint64
+	Values      []int64
+	Labels      map[string]string
+}
+
+// 对应 github.com/google/pprof/proto/profile.proto
+type PprofProfile struct {
+	SampleTypes   [][2]string
+	Mappings      []*PprofMapping
+	Functions     []*PprofFunction
+	Locations     []*PprofLocation
+	Samples       []*PprofSample
+	TimeNanos     int64
+	DurationNanos int64
+	strings       []string
+	string_index  map[string]uint64
+}
+
+func NewPprofProfile(sample_type, unit string) *PprofProfile {
+	profile := &PprofProfile{}
+	profile.SampleTypes = [][2]string{{sample_type, unit}}
+	// string_table 的第一项必须是空字符串
+	profile.strings = []string{""}
+	profile.string_index = map[string]uint64{"": 0}
+	return profile
+}
+
+func (this *PprofProfile) str(s string) uint64 {
+	index, ok := this.string_index[s]
+	if !ok {
+		index = uint64(len(this.strings))
+		this.strings = append(this.strings, s)
+		this.string_index[s] = index
+	}
+	return index
+}
+
+func (this *PprofProfile) AddMapping(start, limit, offset uint64, file string) *PprofMapping {
+	mapping := &PprofMapping{uint64(len(this.Mappings) + 1), start, limit, offset, file}
+	this.Mappings = append(this.Mappings, mapping)
+	return mapping
+}
+
+func (this *PprofProfile) AddFunction(name, file string) *PprofFunction {
+	function := &PprofFunction{uint64(len(this.Functions) + 1), name, file}
+	this.Functions = append(this.Functions, function)
+	return function
+}
+
+func (this *PprofProfile) AddLocation(mapping_id, address, function_id uint64) *PprofLocation {
+	location := &PprofLocation{uint64(len(this.Locations) + 1), mapping_id, address, function_id}
+	this.Locations = append(this.Locations, location)
+	return location
+}
+
+func (this *PprofProfile) AddSample(location_ids []uint64, value int64, labels map[string]string) {
+	this.Samples = append(this.Samples, &PprofSample{location_ids, []int64{value}, labels})
+}
+
+func (this *PprofProfile) Encode() []byte {
+	profile := &PbEncoder{}
+	for _, sample_type := range this.SampleTypes {
+		value_type := &PbEncoder{}
+		value_type.Varint(1, this.str(sample_type[0]))
+		value_type.Varint(2, this.str(sample_type[1]))
+		profile.Message(1, value_type)
+	}
+	for _, sample := range this.Samples {
+		msg := &PbEncoder{}
+		msg.Packed(1, sample.LocationIds)
+		var values []uint64
+		for _, value := range sample.Values {
+			values = append(values, uint64(value))
+		}
+		msg.Packed(2, values)
+		var keys []string
+		for key := range sample.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			label := &PbEncoder{}
+			label.Varint(1, this.str(key))
+			label.Varint(2, this.str(sample.Labels[key]))
+			msg.Message(3, label)
+		}
+		profile.Message(2, msg)
+	}
+	for _, mapping := range this.Mappings {
+		msg := &PbEncoder{}
+		msg.Varint(1, mapping.Id)
+		msg.Varint(2, mapping.Start)
+		msg.Varint(3, mapping.Limit)
+		msg.Varint(4, mapping.Offset)
+		msg.Varint(5, this.str(mapping.File))
+		// has_functions 函数名由栈回溯提供
+		msg.Varint(7, 1)
+		profile.Message(3, msg)
+	}
+	for _, location := range this.Locations {
+		msg := &PbEncoder{}
+		msg.Varint(1, location.Id)
+		msg.Varint(2, location.MappingId)
+		msg.Varint(3, location.Address)
+		line := &PbEncoder{}
+		line.Varint(1, location.FunctionId)
+		msg.Message(4, line)
+		profile.Message(4, msg)
+	}
+	for _, function := range this.Functions {
+		msg := &PbEncoder{}
+		msg.Varint(1, function.Id)
+		msg.Varint(2, this.str(function.Name))
+		msg.Varint(3, this.str(function.Name))
+		msg.Varint(4, this.str(function.File))
+		profile.Message(5, msg)
+	}
+	// 字符串在上面编码的过程中收集 所以放在最后
+	for _, s := range this.strings {
+		profile.Bytes(6, []byte(s))
+	}
+	profile.Varint(9, uint64(this.TimeNanos))
+	profile.Varint(10, uint64(this.DurationNanos))
+	return profile.Data()
+}
+
+func (this *PprofProfile) Write(path string) error {
+	// pprof 默认读取 gzip 压缩的数据 未压缩的也能识别
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(this.Encode()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
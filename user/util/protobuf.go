@@ -0,0 +1,379 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// protobuf 的 wire type
+const (
+	PB_WIRE_VARINT  = 0
+	PB_WIRE_FIXED64 = 1
+	PB_WIRE_BYTES   = 2
+	PB_WIRE_FIXED32 = 5
+)
+
+// 没有 schema 时 嵌套消息的最大猜测层数
+const PB_MAX_DEPTH = 8
+
+// FieldDescriptorProto.Type
+const (
+	PB_TYPE_DOUBLE   = 1
+	PB_TYPE_FLOAT    = 2
+	PB_TYPE_INT64    = 3
+	PB_TYPE_UINT64   = 4
+	PB_TYPE_INT32    = 5
+	PB_TYPE_FIXED64  = 6
+	PB_TYPE_FIXED32  = 7
+	PB_TYPE_BOOL     = 8
+	PB_TYPE_STRING   = 9
+	PB_TYPE_GROUP    = 10
+	PB_TYPE_MESSAGE  = 11
+	PB_TYPE_BYTES    = 12
+	PB_TYPE_UINT32   = 13
+	PB_TYPE_ENUM     = 14
+	PB_TYPE_SFIXED32 = 15
+	PB_TYPE_SFIXED64 = 16
+	PB_TYPE_SINT32   = 17
+	PB_TYPE_SINT64   = 18
+)
+
+type PbField struct {
+	Number   uint64
+	WireType uint64
+	Value    uint64
+	Bytes    []byte
+}
+
+func read_varint(data []byte) (uint64, int, error) {
+	var value uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("bad varint")
+}
+
+func DecodePbFields(data []byte) ([]PbField, error) {
+	// 按 wire format 拆分字段 出错时返回已经解析的部分
+	var fields []PbField
+	for off := 0; off < len(data); {
+		key, n, err := read_varint(data[off:])
+		if err != nil {
+			return fields, err
+		}
+		off += n
+		field := PbField{Number: key >> 3, WireType: key & 7}
+		if field.Number == 0 || field.Number > 0x1fffffff {
+			return fields, errors.New(fmt.Sprintf("bad field number:%d", field.Number))
+		}
+		switch field.WireType {
+		case PB_WIRE_VARINT:
+			field.Value, n, err = read_varint(data[off:])
+			if err != nil {
+				return fields, err
+			}
+			off += n
+		case PB_WIRE_FIXED64:
+			if off+8 > len(data) {
+				return fields, errors.New("truncated fixed64")
+			}
+			field.Value = binary.LittleEndian.Uint64(data[off:])
+			off += 8
+		case PB_WIRE_FIXED32:
+			if off+4 > len(data) {
+				return fields, errors.New("truncated fixed32")
+			}
+			field.Value = uint64(binary.LittleEndian.Uint32(data[off:]))
+			off += 4
+		case PB_WIRE_BYTES:
+			size, n, err := read_varint(data[off:])
+			if err != nil {
+				return fields, err
+			}
+			off += n
+			if size > uint64(len(data)-off) {
+				return fields, errors.New("truncated bytes")
+			}
+			field.Bytes = data[off : off+int(size)]
+			off += int(size)
+		default:
+			// group 已经废弃 这里不支持
+			return fields, errors.New(fmt.Sprintf("unsupported wire type:%d", field.WireType))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func is_printable_text(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+type PbFieldDesc struct {
+	Name     string
+	Number   uint64
+	Type     uint64
+	TypeName string
+	Repeated bool
+}
+
+type PbMessage struct {
+	Name   string
+	Fields map[uint64]*PbFieldDesc
+	schema *PbSchema
+}
+
+// 由 FileDescriptorSet 得到的消息定义 即 protoc --descriptor_set_out 的输出
+type PbSchema struct {
+	Messages map[string]*PbMessage
+}
+
+func (this *PbSchema) parse_field(data []byte) (*PbFieldDesc, error) {
+	// FieldDescriptorProto name=1 number=3 label=4 type=5 type_name=6
+	fields, err := DecodePbFields(data)
+	if err != nil {
+		return nil, err
+	}
+	desc := &PbFieldDesc{}
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			desc.Name = string(f.Bytes)
+		case 3:
+			desc.Number = f.Value
+		case 4:
+			desc.Repeated = f.Value == 3
+		case 5:
+			desc.Type = f.Value
+		case 6:
+			desc.TypeName = strings.TrimPrefix(string(f.Bytes), ".")
+		}
+	}
+	return desc, nil
+}
+
+func (this *PbSchema) parse_message(data []byte, scope string) error {
+	// DescriptorProto name=1 field=2 nested_type=3
+	fields, err := DecodePbFields(data)
+	if err != nil {
+		return err
+	}
+	msg := &PbMessage{Fields: make(map[uint64]*PbFieldDesc), schema: this}
+	var nested [][]byte
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			msg.Name = string(f.Bytes)
+		case 2:
+			desc, err := this.parse_field(f.Bytes)
+			if err != nil {
+				return err
+			}
+			msg.Fields[desc.Number] = desc
+		case 3:
+			nested = append(nested, f.Bytes)
+		}
+	}
+	if scope != "" {
+		msg.Name = scope + "." + msg.Name
+	}
+	this.Messages[msg.Name] = msg
+	for _, nested_data := range nested {
+		if err := this.parse_message(nested_data, msg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LoadPbSchema(path string) (*PbSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// FileDescriptorSet file=1 -> FileDescriptorProto package=2 message_type=4
+	files, err := DecodePbFields(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("parse descriptor set %s failed, err:%v", path, err))
+	}
+	schema := &PbSchema{Messages: make(map[string]*PbMessage)}
+	for _, file := range files {
+		if file.Number != 1 {
+			continue
+		}
+		items, err := DecodePbFields(file.Bytes)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("parse descriptor set %s failed, err:%v", path, err))
+		}
+		pkg := ""
+		for _, item := range items {
+			if item.Number == 2 {
+				pkg = string(item.Bytes)
+			}
+		}
+		for _, item := range items {
+			if item.Number != 4 {
+				continue
+			}
+			if err := schema.parse_message(item.Bytes, pkg); err != nil {
+				return nil, errors.New(fmt.Sprintf("parse descriptor set %s failed, err:%v", path, err))
+			}
+		}
+	}
+	return schema, nil
+}
+
+func (this *PbSchema) FindMessage(name string) (*PbMessage, error) {
+	msg, ok := this.Messages[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("can not find message:%s", name))
+	}
+	return msg, nil
+}
+
+func format_pb_scalar(desc *PbFieldDesc, value uint64) string {
+	switch desc.Type {
+	case PB_TYPE_INT32:
+		return strconv.FormatInt(int64(int32(value)), 10)
+	case PB_TYPE_INT64, PB_TYPE_SFIXED64:
+		return strconv.FormatInt(int64(value), 10)
+	case PB_TYPE_SFIXED32:
+		return strconv.FormatInt(int64(int32(value)), 10)
+	case PB_TYPE_SINT32, PB_TYPE_SINT64:
+		return strconv.FormatInt(int64(value>>1)^-int64(value&1), 10)
+	case PB_TYPE_BOOL:
+		return strconv.FormatBool(value != 0)
+	case PB_TYPE_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(value), 'g', -1, 64)
+	case PB_TYPE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(value))), 'g', -1, 32)
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+func format_pb_packed(desc *PbFieldDesc, data []byte) (string, bool) {
+	// repeated 的数值类型默认是 packed 编码
+	var items []string
+	for off := 0; off < len(data); {
+		var value uint64
+		switch desc.Type {
+		case PB_TYPE_DOUBLE, PB_TYPE_FIXED64, PB_TYPE_SFIXED64:
+			if off+8 > len(data) {
+				return "", false
+			}
+			value = binary.LittleEndian.Uint64(data[off:])
+			off += 8
+		case PB_TYPE_FLOAT, PB_TYPE_FIXED32, PB_TYPE_SFIXED32:
+			if off+4 > len(data) {
+				return "", false
+			}
+			value = uint64(binary.LittleEndian.Uint32(data[off:]))
+			off += 4
+		default:
+			v, n, err := read_varint(data[off:])
+			if err != nil {
+				return "", false
+			}
+			value = v
+			off += n
+		}
+		items = append(items, format_pb_scalar(desc, value))
+	}
+	return "[" + strings.Join(items, ", ") + "]", true
+}
+
+func format_pb_bytes(data []byte, depth int) string {
+	// 没有 schema 时 先按字符串判断 再尝试按嵌套消息解析 都不行就当作 bytes
+	if len(data) == 0 {
+		return `""`
+	}
+	if is_printable_text(data) {
+		return strconv.Quote(string(data))
+	}
+	if depth < PB_MAX_DEPTH {
+		fields, err := DecodePbFields(data)
+		if err == nil && len(fields) > 0 {
+			return format_pb_fields(fields, nil, depth+1)
+		}
+	}
+	return `"` + PrettyByteSlice(data) + `"`
+}
+
+func format_pb_fields(fields []PbField, msg *PbMessage, depth int) string {
+	var items []string
+	for _, field := range fields {
+		var desc *PbFieldDesc
+		if msg != nil {
+			desc = msg.Fields[field.Number]
+		}
+		name := strconv.FormatUint(field.Number, 10)
+		if desc != nil {
+			name = desc.Name
+		}
+		var value string
+		switch {
+		case field.WireType == PB_WIRE_BYTES && desc != nil:
+			switch desc.Type {
+			case PB_TYPE_STRING:
+				value = strconv.Quote(string(field.Bytes))
+			case PB_TYPE_BYTES:
+				value = `"` + PrettyByteSlice(field.Bytes) + `"`
+			case PB_TYPE_MESSAGE:
+				value = format_pb_bytes(field.Bytes, depth)
+				if sub_msg, err := msg.schema.FindMessage(desc.TypeName); err == nil {
+					if sub_fields, err := DecodePbFields(field.Bytes); err == nil {
+						value = format_pb_fields(sub_fields, sub_msg, depth+1)
+					}
+				}
+			default:
+				packed, ok := format_pb_packed(desc, field.Bytes)
+				if !ok {
+					packed = format_pb_bytes(field.Bytes, depth)
+				}
+				value = packed
+			}
+		case field.WireType == PB_WIRE_BYTES:
+			value = format_pb_bytes(field.Bytes, depth)
+		case desc != nil:
+			value = format_pb_scalar(desc, field.Value)
+		case field.WireType == PB_WIRE_VARINT:
+			value = strconv.FormatUint(field.Value, 10)
+		default:
+			value = fmt.Sprintf("0x%x", field.Value)
+		}
+		items = append(items, name+": "+value)
+	}
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+func FormatProtobuf(data []byte, msg *PbMessage) (string, bool) {
+	// 返回形如 {1: 150, 2: "abc", 3: {1: 1}} 的结果 有 schema 时使用字段名
+	// 数据可能因为读取大小的限制被截断 那么尽量解析前面的部分
+	fields, err := DecodePbFields(data)
+	if len(fields) == 0 {
+		return "", false
+	}
+	result := format_pb_fields(fields, msg, 0)
+	if err != nil {
+		result = result[:len(result)-1] + ", ...}"
+	}
+	return result, true
+}